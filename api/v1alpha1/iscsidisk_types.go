@@ -0,0 +1,113 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IscsidiskSpec defines the desired state of Iscsidisk
+type IscsidiskSpec struct {
+	// Pool is the ceph pool the RBD image lives in.
+	Pool string `json:"pool"`
+	// Image is the name of the RBD image. Defaults to the name of the
+	// Iscsidisk.
+	// +optional
+	Image string `json:"image,omitempty"`
+	// Size of the RBD image. The image is grown when the size increases
+	// and is never shrunk.
+	Size resource.Quantity `json:"size"`
+	// Features enabled when the RBD image is created, such as layering or
+	// exclusive-lock. They do not affect an existing image.
+	// +optional
+	Features []string `json:"features,omitempty"`
+	// ReclaimPolicy decides whether the RBD image is deleted along with
+	// the Iscsidisk.
+	// +kubebuilder:default=Retain
+	// +optional
+	ReclaimPolicy DiskDeletionPolicy `json:"reclaimPolicy,omitempty"`
+	// GatewayRef is the Iscsigateway exporting the disk. Hosts of the
+	// gateway map LUNs to the disk as pool/image.
+	// +optional
+	GatewayRef *corev1.LocalObjectReference `json:"gatewayRef,omitempty"`
+	// CephConfig is the name of the ConfigMap holding the ceph
+	// configuration. Defaults to the one of the gateway.
+	// +optional
+	CephConfig string `json:"cephconfig,omitempty"`
+}
+
+// IscsidiskStatus defines the observed state of Iscsidisk
+type IscsidiskStatus struct {
+	// ObservedGeneration is the most recent generation observed by the
+	// operator.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Size is the actual size of the RBD image.
+	// +optional
+	Size *resource.Quantity `json:"size,omitempty"`
+	// Watchers are the addresses of the clients with the image open.
+	// +optional
+	Watchers []string `json:"watchers,omitempty"`
+	// LastSyncTime is when the RBD image was last inspected.
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+	// Conditions describe the current state of the disk.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Pool",type=string,JSONPath=`.spec.pool`
+//+kubebuilder:printcolumn:name="Size",type=string,JSONPath=`.status.size`
+//+kubebuilder:printcolumn:name="Gateway",type=string,JSONPath=`.spec.gatewayRef.name`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// Iscsidisk is the Schema for the iscsidisks API
+type Iscsidisk struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IscsidiskSpec   `json:"spec,omitempty"`
+	Status IscsidiskStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IscsidiskList contains a list of Iscsidisk
+type IscsidiskList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Iscsidisk `json:"items"`
+}
+
+// ImageName returns the name of the RBD image of the disk.
+func (d *Iscsidisk) ImageName() string {
+	if d.Spec.Image != "" {
+		return d.Spec.Image
+	}
+	return d.Name
+}
+
+func init() {
+	SchemeBuilder.Register(&Iscsidisk{}, &IscsidiskList{})
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"regexp"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// rbdFeatureRegexp matches the name of an RBD image feature.
+var rbdFeatureRegexp = regexp.MustCompile(`^[a-z][a-z-]*$`)
+
+// log is for logging in this package.
+var iscsidisklog = logf.Log.WithName("iscsidisk-resource")
+
+func (r *Iscsidisk) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-iscsi-ruohwai-v1alpha1-iscsidisk,mutating=false,failurePolicy=fail,sideEffects=None,groups=iscsi.ruohwai,resources=iscsidisks,verbs=create;update,versions=v1alpha1,name=viscsidisk.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &Iscsidisk{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Iscsidisk) ValidateCreate() error {
+	iscsidisklog.Info("validate create", "name", r.Name)
+
+	return r.toError(r.validateSpec())
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Iscsidisk) ValidateUpdate(old runtime.Object) error {
+	iscsidisklog.Info("validate update", "name", r.Name)
+
+	oldDisk, ok := old.(*Iscsidisk)
+	if !ok {
+		return apierrors.NewBadRequest(
+			fmt.Sprintf("expected an Iscsidisk but got a %T", old))
+	}
+	allErrs := r.validateSpec()
+	allErrs = append(allErrs, r.validateImmutable(oldDisk)...)
+	return r.toError(allErrs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Iscsidisk) ValidateDelete() error {
+	return nil
+}
+
+func (r *Iscsidisk) toError(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(
+		GroupVersion.WithKind("Iscsidisk").GroupKind(), r.Name, allErrs)
+}
+
+func (r *Iscsidisk) validateSpec() field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if r.Spec.Pool == "" {
+		allErrs = append(allErrs, field.Required(
+			specPath.Child("pool"), "pool name is required"))
+	}
+	if r.Spec.Size.Sign() <= 0 {
+		allErrs = append(allErrs, field.Invalid(
+			specPath.Child("size"), r.Spec.Size.String(),
+			"must be greater than 0"))
+	}
+	for i, f := range r.Spec.Features {
+		if !rbdFeatureRegexp.MatchString(f) {
+			allErrs = append(allErrs, field.Invalid(
+				specPath.Child("features").Index(i), f,
+				"must be the name of an RBD image feature, such as layering"))
+		}
+	}
+	if r.Spec.GatewayRef == nil && r.Spec.CephConfig == "" {
+		allErrs = append(allErrs, field.Required(
+			specPath.Child("cephconfig"),
+			"the name of the ceph ConfigMap is required without a gatewayRef"))
+	}
+	if r.Spec.GatewayRef != nil && r.Spec.GatewayRef.Name == "" {
+		allErrs = append(allErrs, field.Required(
+			specPath.Child("gatewayRef", "name"), "gateway name is required"))
+	}
+	return allErrs
+}
+
+// validateImmutable rejects changes that would point the disk at another
+// RBD image or gateway, or shrink it.
+func (r *Iscsidisk) validateImmutable(old *Iscsidisk) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if r.Spec.Pool != old.Spec.Pool {
+		allErrs = append(allErrs, field.Forbidden(
+			specPath.Child("pool"), "pool is immutable"))
+	}
+	if r.ImageName() != old.ImageName() {
+		allErrs = append(allErrs, field.Forbidden(
+			specPath.Child("image"), "image is immutable"))
+	}
+	// the gateway exporting the disk would not notice it moved away
+	if old.Spec.GatewayRef != nil &&
+		(r.Spec.GatewayRef == nil || r.Spec.GatewayRef.Name != old.Spec.GatewayRef.Name) {
+		allErrs = append(allErrs, field.Forbidden(
+			specPath.Child("gatewayRef"), "gatewayRef can not change once set"))
+	}
+	if r.Spec.Size.Cmp(old.Spec.Size) < 0 {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("size"),
+			fmt.Sprintf("disk can not shrink from %s to %s",
+				old.Spec.Size.String(), r.Spec.Size.String())))
+	}
+	return allErrs
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -28,14 +29,188 @@ type IscsigatewaySpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// GatewayName is an optional string that lets you define an ISCSI gateway
-	// name. If unset, the name will be defived automatically.
+	// TargetName is an optional iSCSI name (iqn., eui. or naa.) for the
+	// target. If unset, an IQN is derived from the naming authority of the
+	// operator and kept for the life of the gateway. A name that is not an
+	// iSCSI name, as accepted by older versions of the operator, keeps
+	// naming the resources of the gateway while the target is served under
+	// a derived IQN.
 	// +optional
 	TargetName string             `json:"targetname"`
 	Storage    []IscsiStorageSpec `json:"storage"`
 	Hosts      []IscsiHostSpec    `json:"hosts"`
-	Scale      int                `json:"scale"`
-	CephConfig string             `json:"cephconfig"`
+	// HostGroups map the same LUNs, with the same LUN ids, to every
+	// member, such as the nodes of a hypervisor cluster.
+	// +optional
+	HostGroups []IscsiHostGroupSpec `json:"hostGroups,omitempty"`
+	Scale      int                  `json:"scale"`
+	// CephConfig names the ConfigMap holding the ceph.conf and keyring of
+	// the gateways. The iscsi-gateway.cfg is generated by the operator, one
+	// found in the ConfigMap is ignored. Exactly one of CephConfig and
+	// Ceph must be set.
+	// +optional
+	CephConfig string `json:"cephconfig,omitempty"`
+	// Ceph has the operator generate the ceph.conf and keyring of the
+	// gateways from a keyring Secret or a Rook cluster.
+	// +optional
+	Ceph *IscsiCephSpec `json:"ceph,omitempty"`
+	// Api configures the rbd-target-api service the gateways are managed
+	// through.
+	// +optional
+	Api *IscsiApiSpec `json:"api,omitempty"`
+	// Auth configures the authentication enforced by the target.
+	// +optional
+	Auth *IscsiTargetAuthSpec `json:"auth,omitempty"`
+	// NodeSelector restricts the gateway pods, and the tcmu-runner pods
+	// backing them, to the matching nodes.
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Tolerations allow the gateway pods, and the tcmu-runner pods
+	// backing them, to run on tainted nodes.
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// Portals exposes every gateway through a Service of its own so that
+	// initiators outside the cluster can reach each portal. If unset, the
+	// portals are the addresses of the gateway pods.
+	// +optional
+	Portals *IscsiPortalSpec `json:"portals,omitempty"`
+	// InitiatorNamespaceSelector selects the namespaces Iscsiinitiators
+	// are granted access to the gateway from. If unset, only initiators of
+	// the namespace of the gateway are; an empty selector matches every
+	// namespace.
+	// +optional
+	InitiatorNamespaceSelector *metav1.LabelSelector `json:"initiatorNamespaceSelector,omitempty"`
+	// Targets are served by the gateways in addition to the default target
+	// described by the fields above. Iscsidisks and Iscsiinitiators only
+	// attach to the default target.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Targets []IscsiTargetSpec `json:"targets,omitempty"`
+	// Drift configures how differences between the container config and
+	// the configuration reported by the running gateways are handled.
+	// +optional
+	Drift *IscsiDriftSpec `json:"drift,omitempty"`
+	// Paused stops the operator from changing the container config and
+	// the resources of the gateway. The changes the spec calls for are
+	// only computed and published in the plan of the status, so that they
+	// can be reviewed before the gateway is resumed. Deleting a paused
+	// gateway still tears it down.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+}
+
+// IscsiDriftSpec configures drift detection on the running gateways.
+type IscsiDriftSpec struct {
+	// Reapply restores the targets, LUNs and ACLs found to differ from the
+	// container config through the API of the gateway. If unset, the
+	// differences are only reported.
+	// +optional
+	Reapply bool `json:"reapply,omitempty"`
+	// ResyncInterval is how often the running gateways are checked when
+	// nothing else triggers a reconcile. Defaults to the drift resync
+	// interval of the operator.
+	// +optional
+	ResyncInterval *metav1.Duration `json:"resyncInterval,omitempty"`
+}
+
+// IscsiTargetSpec describes an additional target with its own disks, hosts
+// and authentication.
+type IscsiTargetSpec struct {
+	// Name identifies the target within the gateway.
+	Name string `json:"name"`
+	// TargetName is an optional iSCSI name for the target. If unset, an IQN
+	// is derived from the naming authority of the operator and kept for
+	// the life of the target.
+	// +optional
+	TargetName string `json:"targetname,omitempty"`
+	// +optional
+	Storage []IscsiStorageSpec `json:"storage,omitempty"`
+	// +optional
+	Hosts []IscsiHostSpec `json:"hosts,omitempty"`
+	// +optional
+	HostGroups []IscsiHostGroupSpec `json:"hostGroups,omitempty"`
+	// Auth configures the authentication enforced by the target. Discovery
+	// sessions are shared by every target and can only be configured on
+	// the gateway.
+	// +optional
+	Auth *IscsiTargetAuthSpec `json:"auth,omitempty"`
+}
+
+// IscsiCephSpec configures the ceph cluster the gateways connect to.
+// Exactly one of KeyringSecretRef and Rook must be set.
+type IscsiCephSpec struct {
+	// ClientName is the ceph client, without the "client." prefix, the
+	// gateways authenticate as. With Rook, it names a CephClient of the
+	// cluster. Defaults to admin.
+	// +optional
+	ClientName string `json:"clientName,omitempty"`
+	// KeyringSecretRef names a Secret, in the same namespace, holding the
+	// key of the client under "key" or its keyring under "keyring".
+	// +optional
+	KeyringSecretRef *corev1.LocalObjectReference `json:"keyringSecretRef,omitempty"`
+	// Monitors are the addresses of the ceph monitors, required along
+	// with KeyringSecretRef.
+	// +optional
+	Monitors []string `json:"monitors,omitempty"`
+	// FSID of the ceph cluster, optional along with KeyringSecretRef.
+	// +optional
+	FSID string `json:"fsid,omitempty"`
+	// Rook takes the monitors and the key of the client from a Rook
+	// CephCluster.
+	// +optional
+	Rook *IscsiRookSpec `json:"rook,omitempty"`
+}
+
+// IscsiRookSpec references a Rook CephCluster.
+type IscsiRookSpec struct {
+	// ClusterName is the name of the CephCluster.
+	ClusterName string `json:"clusterName"`
+	// Namespace of the CephCluster. Defaults to the namespace of the
+	// gateway; other namespaces must be allowed by the operator config.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// IscsiApiSpec configures the rbd-target-api service of the gateways.
+type IscsiApiSpec struct {
+	// SecretRef names a Secret, in the same namespace, holding the
+	// "username" and "password" of the API. If unset, credentials are
+	// generated once and kept for the life of the gateway.
+	// +optional
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+	// TLSSecretRef names a kubernetes.io/tls Secret, in the same
+	// namespace, holding the certificate the API is served with. If set,
+	// the API only accepts https.
+	// +optional
+	TLSSecretRef *corev1.LocalObjectReference `json:"tlsSecretRef,omitempty"`
+	// TrustedIPs are the addresses, besides the gateways themselves,
+	// allowed to call the API, such as the ceph dashboard.
+	// +optional
+	TrustedIPs []string `json:"trustedIPs,omitempty"`
+}
+
+// IscsiPortalSpec configures the Services exposing the gateway portals.
+type IscsiPortalSpec struct {
+	// Type of the Service created for every gateway.
+	// +kubebuilder:validation:Enum=NodePort;LoadBalancer
+	Type corev1.ServiceType `json:"type"`
+	// Annotations added to the Service of every gateway, for example to
+	// configure the load balancer.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// IscsiTargetAuthSpec configures the authentication enforced by a target.
+type IscsiTargetAuthSpec struct {
+	// RequireMutualChap rejects any host that is not configured for
+	// mutual CHAP.
+	// +optional
+	RequireMutualChap bool `json:"requireMutualChap,omitempty"`
+	// Discovery configures CHAP on SendTargets discovery sessions. If
+	// unset, discovery is not authenticated.
+	// +optional
+	Discovery *IscsiChapSpec `json:"discovery,omitempty"`
 }
 
 type IscsiStorageSpec struct {
@@ -46,30 +221,243 @@ type IscsiStorageSpec struct {
 type IscsiDiskSpec struct {
 	DiskName string `json:"diskname"`
 	DiskSize string `json:"disksize"`
+	// DeletionPolicy decides what happens to the RBD image once the disk
+	// is removed from the spec. A disk can not be removed until it is set.
+	// +optional
+	DeletionPolicy DiskDeletionPolicy `json:"deletionPolicy,omitempty"`
 }
 
+// DiskDeletionPolicy describes what happens to the RBD image of a removed
+// disk.
+// +kubebuilder:validation:Enum=Retain;Delete
+type DiskDeletionPolicy string
+
+const (
+	// DiskDeletionRetain keeps the RBD image once the disk is no longer
+	// exported.
+	DiskDeletionRetain DiskDeletionPolicy = "Retain"
+	// DiskDeletionDelete deletes the RBD image once the disk is no longer
+	// exported.
+	DiskDeletionDelete DiskDeletionPolicy = "Delete"
+)
+
 type IscsiHostSpec struct {
 	HostName string `json:"hostName"`
 
-	Username string         `json:"userName"`
-	Password string         `json:"password"`
-	Luns     []IscsiLunSpec `json:"luns"`
+	// Chap configures CHAP authentication for the host. If unset, the
+	// default credentials of the operator are used.
+	// +optional
+	Chap *IscsiChapSpec `json:"chap,omitempty"`
+	Luns []IscsiLunSpec `json:"luns"`
+}
+
+// IscsiHostGroupSpec is a group of hosts sharing their LUNs and CHAP
+// settings.
+type IscsiHostGroupSpec struct {
+	// Name of the group.
+	Name string `json:"name"`
+	// Members are the host names, the iSCSI names of the initiators, of
+	// the group. A host can only belong to one group and can not also be
+	// listed in the hosts of the gateway.
+	Members []string `json:"members"`
+	// Chap configures CHAP authentication for every member. If unset, the
+	// default credentials of the operator are used.
+	// +optional
+	Chap *IscsiChapSpec `json:"chap,omitempty"`
+	// Luns are mapped to every member at the same LUN ids.
+	Luns []IscsiLunSpec `json:"luns"`
+}
+
+// IscsiChapSpec references the CHAP credentials of an initiator.
+type IscsiChapSpec struct {
+	// SecretRef names a Secret, in the namespace of the referencing
+	// object, that holds the "username" and "password" keys.
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+	// MutualSecretRef names a Secret, in the same namespace, holding the
+	// "username" and "password" the target answers with for mutual CHAP.
+	// +optional
+	MutualSecretRef *corev1.LocalObjectReference `json:"mutualSecretRef,omitempty"`
 }
 
 type IscsiLunSpec struct {
 	PoolName string `json:"poolname"`
 	DiskName string `json:"diskname"`
+	// LunID is the LUN number the host sees the disk at. If unset, the
+	// lowest free number is assigned and kept for as long as the disk is
+	// mapped to the host.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=255
+	// +optional
+	LunID *int32 `json:"lunId,omitempty"`
+}
+
+// MaxLunID is the highest LUN number a disk can be mapped at.
+const MaxLunID = 255
+
+// ForceRemoveHostsAnnotation lists, separated by commas, the hosts that
+// are removed from the target right away instead of after the removal
+// grace period. "*" matches every host. The annotation is removed once
+// applied, it does not carry over to the hosts removed afterwards.
+const ForceRemoveHostsAnnotation = "iscsi.ruohwai/force-remove-hosts"
+
+// SkipTargetRemovalAnnotation, set to "true" on a deleting gateway, lets
+// the teardown go on without a ready gateway to remove the targets and
+// disks through. They are then left in the gateway configuration stored
+// in ceph.
+const SkipTargetRemovalAnnotation = "iscsi.ruohwai/skip-target-removal"
+
+// Condition types reported in IscsigatewayStatus.Conditions.
+const (
+	// ConditionReady is true when the gateway is fully configured and
+	// every replica is serving.
+	ConditionReady = "Ready"
+	// ConditionConfigReady is true when the container config stored in the
+	// gateway ConfigMap matches the spec.
+	ConditionConfigReady = "ConfigReady"
+	// ConditionTcmuRunnerReady is true when the tcmu-runner DaemonSet is
+	// available on every scheduled node.
+	ConditionTcmuRunnerReady = "TcmuRunnerReady"
+	// ConditionGatewaysReady is true when all desired gateway replicas
+	// are ready.
+	ConditionGatewaysReady = "GatewaysReady"
+	// ConditionDegraded is true when the gateway is serving with fewer
+	// replicas than desired or the last reconcile failed.
+	ConditionDegraded = "Degraded"
+	// ConditionScaling is true while gateways are being added to or
+	// removed from the target portal group.
+	ConditionScaling = "Scaling"
+	// ConditionPathRedundant is true when more than one gateway serves the
+	// target, so that the initiators can fail over between paths.
+	ConditionPathRedundant = "PathRedundant"
+	// ConditionDrifted is true when a running gateway reports targets,
+	// LUNs or ACLs that differ from the container config.
+	ConditionDrifted = "Drifted"
+)
+
+// IscsiGatewayState describes a single gateway replica.
+type IscsiGatewayState struct {
+	// Name of the gateway pod.
+	Name string `json:"name"`
+	// NodeName is the node the gateway pod is scheduled on.
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+	// PodIP is the address of the gateway pod.
+	// +optional
+	PodIP string `json:"podIP,omitempty"`
+	// Ready is true when the gateway pod passes its readiness probe.
+	Ready bool `json:"ready"`
+	// Portal is the address, as host:port, initiators log in to the
+	// gateway at.
+	// +optional
+	Portal string `json:"portal,omitempty"`
+}
+
+// IscsiTargetState describes an additional target served by the gateways.
+type IscsiTargetState struct {
+	// Name of the target in the spec.
+	Name string `json:"name"`
+	// TargetName is the effective IQN of the target.
+	TargetName string `json:"targetName"`
+}
+
+// IscsiHostRemovalState describes a host removed from the spec that is
+// still exported, as it may have sessions to the target.
+type IscsiHostRemovalState struct {
+	// HostName of the initiator.
+	HostName string `json:"hostName"`
+	// Target is the name of the additional target the host is removed
+	// from, empty for the default target.
+	// +optional
+	Target string `json:"target,omitempty"`
+	// RemoveAfter is the time the host is removed from the target at.
+	RemoveAfter metav1.Time `json:"removeAfter"`
+}
+
+// IscsiPlanState is the list of changes made, or to be made while the
+// gateway is paused, to the container config.
+type IscsiPlanState struct {
+	// ObservedGeneration is the generation of the spec the plan was
+	// computed for.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Applied is true once the changes were written to the container
+	// config, false while the gateway is paused.
+	Applied bool `json:"applied"`
+	// Changes lists the changes in the order they are applied.
+	// +optional
+	Changes []IscsiPlannedChange `json:"changes,omitempty"`
+	// Omitted counts the changes left out of Changes for brevity.
+	// +optional
+	Omitted int32 `json:"omitted,omitempty"`
+}
+
+// IscsiPlannedChange is a single change to the container config.
+type IscsiPlannedChange struct {
+	// Kind of change, such as AddPool, ResizeDisk, MapLun or RemoveHost.
+	Kind string `json:"kind"`
+	// Target is the name of the additional target changed, empty for the
+	// default target and for changes shared by the targets.
+	// +optional
+	Target string `json:"target,omitempty"`
+	// Object is what the change applies to: an option, pool, disk as
+	// pool/disk, host group, host or gateway.
+	// +optional
+	Object string `json:"object,omitempty"`
+	// Host is the host a LUN is mapped to, for LUN changes.
+	// +optional
+	Host string `json:"host,omitempty"`
+	// +optional
+	From string `json:"from,omitempty"`
+	// +optional
+	To string `json:"to,omitempty"`
+	// Description of the change.
+	Description string `json:"description"`
 }
 
 // IscsigatewayStatus defines the observed state of Iscsigateway
 type IscsigatewayStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
-	ServerGroup string `json:"serverGroup"`
+	// ObservedGeneration is the most recent generation observed by the
+	// operator.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// TargetName is the effective target IQN served by the gateways.
+	// +optional
+	TargetName string `json:"targetName,omitempty"`
+	// Targets lists the effective IQN of the additional targets.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Targets []IscsiTargetState `json:"targets,omitempty"`
+	// Replicas is the desired number of gateway replicas.
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+	// ReadyReplicas is the number of gateway replicas that are ready.
+	// +optional
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+	// Gateways lists the state of each gateway replica.
+	// +optional
+	Gateways []IscsiGatewayState `json:"gateways,omitempty"`
+	// PendingHostRemovals lists the hosts removed from the spec that are
+	// still exported during the removal grace period.
+	// +optional
+	PendingHostRemovals []IscsiHostRemovalState `json:"pendingHostRemovals,omitempty"`
+	// Plan lists the last changes made to the container config or, while
+	// the gateway is paused, the changes pending.
+	// +optional
+	Plan *IscsiPlanState `json:"plan,omitempty"`
+	// Conditions describe the current state of the gateway.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Target",type=string,JSONPath=`.status.targetName`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Replicas",type=integer,JSONPath=`.status.readyReplicas`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Iscsigateway is the Schema for the iscsigateways API
 type Iscsigateway struct {
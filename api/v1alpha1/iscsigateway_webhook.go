@@ -0,0 +1,634 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+
+	"github.com/Erichorng/iscsi-operator/internal/iqn"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var iscsigatewaylog = logf.Log.WithName("iscsigateway-resource")
+
+// iscsigatewayClient looks up the Iscsidisks the LUNs of the hosts may
+// refer to. Without it only the disks of the spec are known.
+var iscsigatewayClient client.Reader
+
+func (r *Iscsigateway) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	iscsigatewayClient = mgr.GetClient()
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-iscsi-ruohwai-v1alpha1-iscsigateway,mutating=false,failurePolicy=fail,sideEffects=None,groups=iscsi.ruohwai,resources=iscsigateways,verbs=create;update,versions=v1alpha1,name=viscsigateway.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &Iscsigateway{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Iscsigateway) ValidateCreate() error {
+	iscsigatewaylog.Info("validate create", "name", r.Name)
+
+	return r.toError(r.validateSpec())
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Iscsigateway) ValidateUpdate(old runtime.Object) error {
+	iscsigatewaylog.Info("validate update", "name", r.Name)
+
+	oldGateway, ok := old.(*Iscsigateway)
+	if !ok {
+		return apierrors.NewBadRequest(
+			fmt.Sprintf("expected an Iscsigateway but got a %T", old))
+	}
+	// the operator adds and removes its finalizer with updates, they must
+	// go through even if the spec fails rules added since it was created
+	if r.GetDeletionTimestamp() != nil ||
+		equality.Semantic.DeepEqual(r.Spec, oldGateway.Spec) {
+		return nil
+	}
+	allErrs := newErrors(r.validateSpec(), oldGateway.validateSpec())
+	allErrs = append(allErrs, r.validateImmutable(oldGateway)...)
+	return r.toError(allErrs)
+}
+
+// newErrors returns the errors not already found in the old spec, so that
+// only what an update changes is held against it.
+func newErrors(errs, oldErrs field.ErrorList) field.ErrorList {
+	var allErrs field.ErrorList
+	for _, err := range errs {
+		found := false
+		for _, oldErr := range oldErrs {
+			if err.Type == oldErr.Type && err.Field == oldErr.Field &&
+				reflect.DeepEqual(err.BadValue, oldErr.BadValue) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			allErrs = append(allErrs, err)
+		}
+	}
+	return allErrs
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Iscsigateway) ValidateDelete() error {
+	return nil
+}
+
+func (r *Iscsigateway) toError(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(
+		GroupVersion.WithKind("Iscsigateway").GroupKind(), r.Name, allErrs)
+}
+
+func (r *Iscsigateway) validateSpec() field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if r.Spec.TargetName != "" {
+		if err := iqn.Validate(r.Spec.TargetName); err != nil {
+			allErrs = append(allErrs, field.Invalid(
+				specPath.Child("targetname"), r.Spec.TargetName, err.Error()))
+		}
+	}
+	if r.Spec.Scale < 0 {
+		allErrs = append(allErrs, field.Invalid(
+			specPath.Child("scale"), r.Spec.Scale,
+			"must be greater than or equal to 0"))
+	}
+	switch {
+	case r.Spec.CephConfig == "" && r.Spec.Ceph == nil:
+		allErrs = append(allErrs, field.Required(
+			specPath.Child("cephconfig"),
+			"either a ceph ConfigMap or a ceph spec is required"))
+	case r.Spec.CephConfig != "" && r.Spec.Ceph != nil:
+		allErrs = append(allErrs, field.Forbidden(
+			specPath.Child("ceph"),
+			"may not be set together with cephconfig"))
+	case r.Spec.Ceph != nil:
+		allErrs = append(allErrs, validateCeph(
+			r.Spec.Ceph, specPath.Child("ceph"))...)
+	}
+	if r.Spec.Auth != nil && r.Spec.Auth.Discovery != nil {
+		allErrs = append(allErrs, validateChap(
+			r.Spec.Auth.Discovery, specPath.Child("auth", "discovery"))...)
+	}
+	if r.Spec.Api != nil {
+		allErrs = append(allErrs, validateApi(
+			r.Spec.Api, specPath.Child("api"))...)
+	}
+	if d := r.Spec.Drift; d != nil && d.ResyncInterval != nil &&
+		d.ResyncInterval.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(
+			specPath.Child("drift", "resyncInterval"), d.ResyncInterval.String(),
+			"must be greater than 0"))
+	}
+	if sel := r.Spec.InitiatorNamespaceSelector; sel != nil {
+		if _, err := metav1.LabelSelectorAsSelector(sel); err != nil {
+			allErrs = append(allErrs, field.Invalid(
+				specPath.Child("initiatorNamespaceSelector"), sel, err.Error()))
+		}
+	}
+	allErrs = append(allErrs, validateStorage(
+		r.Spec.Storage, specPath.Child("storage"))...)
+	disks, err := r.diskKeys()
+	if err != nil {
+		return append(allErrs, field.InternalError(specPath.Child("hosts"), err))
+	}
+	allErrs = append(allErrs, validateHosts(
+		r.Spec.Hosts, disks, specPath.Child("hosts"))...)
+	allErrs = append(allErrs, validateHostGroups(
+		r.Spec.HostGroups, r.Spec.Hosts, disks, specPath.Child("hostGroups"))...)
+	allErrs = append(allErrs, r.validateTargets(specPath.Child("targets"))...)
+	return allErrs
+}
+
+// diskKeys returns the disks, as pool/disk, the hosts of the default
+// target may map: those of the spec and those of the Iscsidisks exported
+// by the gateway. Iscsidisks being deleted are left out, only the hosts
+// already mapping them may keep doing so.
+func (r *Iscsigateway) diskKeys() (map[string]bool, error) {
+	disks := storageKeys(r.Spec.Storage)
+	if iscsigatewayClient == nil {
+		return disks, nil
+	}
+	list := &IscsidiskList{}
+	err := iscsigatewayClient.List(
+		context.Background(), list, client.InNamespace(r.Namespace))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Iscsidisks: %w", err)
+	}
+	for _, d := range list.Items {
+		if d.Spec.GatewayRef == nil || d.Spec.GatewayRef.Name != r.Name ||
+			d.GetDeletionTimestamp() != nil {
+			continue
+		}
+		disks[d.Spec.Pool+"/"+d.ImageName()] = true
+	}
+	return disks, nil
+}
+
+// storageKeys returns the disks of the storage, as pool/disk.
+func storageKeys(storage []IscsiStorageSpec) map[string]bool {
+	disks := map[string]bool{}
+	for _, pool := range storage {
+		for _, disk := range pool.Disks {
+			disks[pool.PoolName+"/"+disk.DiskName] = true
+		}
+	}
+	return disks
+}
+
+// validateTargets checks the additional targets the same way as the
+// default one, and rejects disks exported by more than one target.
+func (r *Iscsigateway) validateTargets(fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	disks := storageKeys(r.Spec.Storage)
+	names := map[string]bool{}
+	for i, target := range r.Spec.Targets {
+		targetPath := fldPath.Index(i)
+		if target.Name == "" {
+			allErrs = append(allErrs, field.Required(
+				targetPath.Child("name"), "target name is required"))
+		} else if names[target.Name] {
+			allErrs = append(allErrs, field.Duplicate(
+				targetPath.Child("name"), target.Name))
+		}
+		names[target.Name] = true
+
+		if target.TargetName != "" {
+			if err := iqn.Validate(target.TargetName); err != nil {
+				allErrs = append(allErrs, field.Invalid(
+					targetPath.Child("targetname"), target.TargetName, err.Error()))
+			} else if target.TargetName == r.Spec.TargetName {
+				allErrs = append(allErrs, field.Duplicate(
+					targetPath.Child("targetname"), target.TargetName))
+			}
+		}
+		if target.Auth != nil && target.Auth.Discovery != nil {
+			allErrs = append(allErrs, field.Forbidden(
+				targetPath.Child("auth", "discovery"),
+				"discovery authentication is configured on the gateway"))
+		}
+		allErrs = append(allErrs, validateStorage(
+			target.Storage, targetPath.Child("storage"))...)
+		for j, pool := range target.Storage {
+			for k, disk := range pool.Disks {
+				key := pool.PoolName + "/" + disk.DiskName
+				if disks[key] {
+					allErrs = append(allErrs, field.Duplicate(
+						targetPath.Child("storage").Index(j).Child("disks").Index(k),
+						key))
+				}
+				disks[key] = true
+			}
+		}
+		targetDisks := storageKeys(target.Storage)
+		allErrs = append(allErrs, validateHosts(
+			target.Hosts, targetDisks, targetPath.Child("hosts"))...)
+		allErrs = append(allErrs, validateHostGroups(
+			target.HostGroups, target.Hosts, targetDisks,
+			targetPath.Child("hostGroups"))...)
+	}
+	return allErrs
+}
+
+func validateStorage(
+	storage []IscsiStorageSpec, fldPath *field.Path) field.ErrorList {
+
+	var allErrs field.ErrorList
+	pools := map[string]bool{}
+	for i, pool := range storage {
+		poolPath := fldPath.Index(i)
+		if pool.PoolName == "" {
+			allErrs = append(allErrs, field.Required(
+				poolPath.Child("poolname"), "pool name is required"))
+		} else if pools[pool.PoolName] {
+			allErrs = append(allErrs, field.Duplicate(
+				poolPath.Child("poolname"), pool.PoolName))
+		}
+		pools[pool.PoolName] = true
+
+		disks := map[string]bool{}
+		for j, disk := range pool.Disks {
+			diskPath := poolPath.Child("disks").Index(j)
+			if disk.DiskName == "" {
+				allErrs = append(allErrs, field.Required(
+					diskPath.Child("diskname"), "disk name is required"))
+			} else if disks[disk.DiskName] {
+				allErrs = append(allErrs, field.Duplicate(
+					diskPath.Child("diskname"), disk.DiskName))
+			}
+			disks[disk.DiskName] = true
+
+			size, err := resource.ParseQuantity(disk.DiskSize)
+			if err != nil {
+				allErrs = append(allErrs, field.Invalid(
+					diskPath.Child("disksize"), disk.DiskSize,
+					"must be a quantity such as 10Gi"))
+			} else if size.Sign() <= 0 {
+				allErrs = append(allErrs, field.Invalid(
+					diskPath.Child("disksize"), disk.DiskSize,
+					"must be greater than 0"))
+			}
+		}
+	}
+	return allErrs
+}
+
+func validateHosts(
+	hosts []IscsiHostSpec,
+	disks map[string]bool,
+	fldPath *field.Path) field.ErrorList {
+
+	var allErrs field.ErrorList
+	names := map[string]bool{}
+	for i, host := range hosts {
+		hostPath := fldPath.Index(i)
+		if host.HostName == "" {
+			allErrs = append(allErrs, field.Required(
+				hostPath.Child("hostName"), "host name is required"))
+		} else if names[host.HostName] {
+			allErrs = append(allErrs, field.Duplicate(
+				hostPath.Child("hostName"), host.HostName))
+		}
+		names[host.HostName] = true
+
+		if host.Chap != nil {
+			allErrs = append(allErrs, validateChap(
+				host.Chap, hostPath.Child("chap"))...)
+		}
+
+		allErrs = append(allErrs, validateLuns(
+			host.Luns, disks, hostPath.Child("luns"))...)
+	}
+	return allErrs
+}
+
+// validateHostGroups rejects groups without a name or members, and hosts
+// that are members of more than one group or also hosts of the spec.
+func validateHostGroups(
+	groups []IscsiHostGroupSpec,
+	hosts []IscsiHostSpec,
+	disks map[string]bool,
+	fldPath *field.Path) field.ErrorList {
+
+	var allErrs field.ErrorList
+	members := map[string]bool{}
+	for _, host := range hosts {
+		members[host.HostName] = true
+	}
+	names := map[string]bool{}
+	for i, group := range groups {
+		groupPath := fldPath.Index(i)
+		if group.Name == "" {
+			allErrs = append(allErrs, field.Required(
+				groupPath.Child("name"), "group name is required"))
+		} else if names[group.Name] {
+			allErrs = append(allErrs, field.Duplicate(
+				groupPath.Child("name"), group.Name))
+		}
+		names[group.Name] = true
+
+		if len(group.Members) == 0 {
+			allErrs = append(allErrs, field.Required(
+				groupPath.Child("members"), "at least one member is required"))
+		}
+		for j, member := range group.Members {
+			memberPath := groupPath.Child("members").Index(j)
+			if member == "" {
+				allErrs = append(allErrs, field.Required(
+					memberPath, "host name is required"))
+			} else if members[member] {
+				allErrs = append(allErrs, field.Duplicate(memberPath, member))
+			}
+			members[member] = true
+		}
+
+		if group.Chap != nil {
+			allErrs = append(allErrs, validateChap(
+				group.Chap, groupPath.Child("chap"))...)
+		}
+		allErrs = append(allErrs, validateLuns(
+			group.Luns, disks, groupPath.Child("luns"))...)
+	}
+	return allErrs
+}
+
+// validateLuns rejects LUNs without a pool or disk name, not among the
+// disks, listed twice or with invalid LUN ids. Nil disks leave the
+// existence of the disks to the operator.
+func validateLuns(
+	luns []IscsiLunSpec,
+	disks map[string]bool,
+	fldPath *field.Path) field.ErrorList {
+
+	var allErrs field.ErrorList
+	keys := map[string]bool{}
+	for i, lun := range luns {
+		lunPath := fldPath.Index(i)
+		key := lun.PoolName + "/" + lun.DiskName
+		if lun.PoolName == "" || lun.DiskName == "" {
+			allErrs = append(allErrs, field.Invalid(lunPath, key,
+				"pool and disk names are required"))
+		} else if disks != nil && !disks[key] {
+			allErrs = append(allErrs, field.NotFound(lunPath, key))
+		} else if keys[key] {
+			allErrs = append(allErrs, field.Duplicate(lunPath, key))
+		}
+		keys[key] = true
+	}
+	return append(allErrs, validateLunIDs(luns, fldPath)...)
+}
+
+// validateLunIDs rejects LUN ids out of range or set on more than one LUN
+// of a host.
+func validateLunIDs(luns []IscsiLunSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	ids := map[int32]bool{}
+	for i, lun := range luns {
+		if lun.LunID == nil {
+			continue
+		}
+		idPath := fldPath.Index(i).Child("lunId")
+		id := *lun.LunID
+		switch {
+		case id < 0 || id > MaxLunID:
+			allErrs = append(allErrs, field.Invalid(idPath, id,
+				fmt.Sprintf("must be between 0 and %d", MaxLunID)))
+		case ids[id]:
+			allErrs = append(allErrs, field.Duplicate(idPath, id))
+		}
+		ids[id] = true
+	}
+	return allErrs
+}
+
+func validateCeph(ceph *IscsiCephSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if ceph.ClientName != "" {
+		if strings.HasPrefix(ceph.ClientName, "client.") {
+			allErrs = append(allErrs, field.Invalid(
+				fldPath.Child("clientName"), ceph.ClientName,
+				"must not include the client. prefix"))
+		} else if strings.ContainsAny(ceph.ClientName, " \t\n[]=") {
+			allErrs = append(allErrs, field.Invalid(
+				fldPath.Child("clientName"), ceph.ClientName,
+				"must not contain white space, brackets or '='"))
+		}
+	}
+	switch {
+	case ceph.KeyringSecretRef == nil && ceph.Rook == nil:
+		allErrs = append(allErrs, field.Required(
+			fldPath.Child("keyringSecretRef"),
+			"either a keyring secret or a Rook cluster is required"))
+	case ceph.KeyringSecretRef != nil && ceph.Rook != nil:
+		allErrs = append(allErrs, field.Forbidden(
+			fldPath.Child("rook"),
+			"may not be set together with keyringSecretRef"))
+	case ceph.KeyringSecretRef != nil:
+		if ceph.KeyringSecretRef.Name == "" {
+			allErrs = append(allErrs, field.Required(
+				fldPath.Child("keyringSecretRef", "name"),
+				"secret name is required"))
+		}
+		if len(ceph.Monitors) == 0 {
+			allErrs = append(allErrs, field.Required(
+				fldPath.Child("monitors"),
+				"the monitors are required with a keyring secret"))
+		}
+	case ceph.Rook != nil:
+		if ceph.Rook.ClusterName == "" {
+			allErrs = append(allErrs, field.Required(
+				fldPath.Child("rook", "clusterName"),
+				"the name of the CephCluster is required"))
+		}
+		if len(ceph.Monitors) != 0 {
+			allErrs = append(allErrs, field.Forbidden(
+				fldPath.Child("monitors"),
+				"the monitors are taken from the Rook cluster"))
+		}
+	}
+	return allErrs
+}
+
+func validateApi(api *IscsiApiSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if api.SecretRef != nil && api.SecretRef.Name == "" {
+		allErrs = append(allErrs, field.Required(
+			fldPath.Child("secretRef", "name"), "secret name is required"))
+	}
+	if api.TLSSecretRef != nil && api.TLSSecretRef.Name == "" {
+		allErrs = append(allErrs, field.Required(
+			fldPath.Child("tlsSecretRef", "name"), "secret name is required"))
+	}
+	for i, ip := range api.TrustedIPs {
+		if net.ParseIP(ip) == nil {
+			allErrs = append(allErrs, field.Invalid(
+				fldPath.Child("trustedIPs").Index(i), ip, "must be an IP address"))
+		}
+	}
+	return allErrs
+}
+
+func validateChap(chap *IscsiChapSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if chap.SecretRef.Name == "" {
+		allErrs = append(allErrs, field.Required(
+			fldPath.Child("secretRef", "name"), "secret name is required"))
+	}
+	if chap.MutualSecretRef != nil && chap.MutualSecretRef.Name == "" {
+		allErrs = append(allErrs, field.Required(
+			fldPath.Child("mutualSecretRef", "name"), "secret name is required"))
+	}
+	return allErrs
+}
+
+// validateImmutable rejects changes to fields that can not safely change
+// once the gateway is serving.
+func (r *Iscsigateway) validateImmutable(old *Iscsigateway) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	switch {
+	case old.Spec.TargetName != "" && r.Spec.TargetName != old.Spec.TargetName:
+		allErrs = append(allErrs, field.Forbidden(
+			specPath.Child("targetname"), "target name is immutable"))
+	case old.Spec.TargetName == "" && r.Spec.TargetName != "" &&
+		old.Status.TargetName != "" && r.Spec.TargetName != old.Status.TargetName:
+		allErrs = append(allErrs, field.Forbidden(
+			specPath.Child("targetname"),
+			fmt.Sprintf("target name is immutable, the gateway already serves %s",
+				old.Status.TargetName)))
+	}
+
+	for i, target := range r.Spec.Targets {
+		effective := old.targetName(target.Name)
+		if target.TargetName != "" && effective != "" &&
+			target.TargetName != effective {
+			allErrs = append(allErrs, field.Forbidden(
+				specPath.Child("targets").Index(i).Child("targetname"),
+				fmt.Sprintf("target name is immutable, the target already serves %s",
+					effective)))
+		}
+	}
+
+	oldPools := map[string]map[string]bool{}
+	for _, pool := range old.Spec.Storage {
+		oldPools[pool.PoolName] = map[string]bool{}
+		for _, disk := range pool.Disks {
+			oldPools[pool.PoolName][disk.DiskName] = true
+		}
+	}
+	newPools := map[string]map[string]bool{}
+	for _, pool := range r.Spec.Storage {
+		newPools[pool.PoolName] = map[string]bool{}
+		for _, disk := range pool.Disks {
+			newPools[pool.PoolName][disk.DiskName] = true
+		}
+	}
+	for i, pool := range r.Spec.Storage {
+		for j, disk := range pool.Disks {
+			if oldPools[pool.PoolName][disk.DiskName] {
+				allErrs = append(allErrs, validateResize(
+					old.diskSize(pool.PoolName, disk.DiskName), disk.DiskSize,
+					specPath.Child("storage").Index(i).Child("disks").Index(j).Child("disksize"))...)
+				continue
+			}
+			// the disk is new to this pool, make sure it was not moved
+			// from a pool it is still expected in.
+			for oldPool, oldDisks := range oldPools {
+				if oldDisks[disk.DiskName] && !newPools[oldPool][disk.DiskName] {
+					allErrs = append(allErrs, field.Forbidden(
+						specPath.Child("storage").Index(i).Child("disks").Index(j),
+						fmt.Sprintf("disk %s can not be moved from pool %s to pool %s",
+							disk.DiskName, oldPool, pool.PoolName)))
+				}
+			}
+		}
+	}
+	return allErrs
+}
+
+// validateResize rejects shrinking a disk, which would lose data.
+func validateResize(oldSize, newSize string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	oldQuantity, err := resource.ParseQuantity(oldSize)
+	if err != nil {
+		// nothing to compare against
+		return allErrs
+	}
+	newQuantity, err := resource.ParseQuantity(newSize)
+	if err != nil {
+		// reported by validateStorage
+		return allErrs
+	}
+	if newQuantity.Cmp(oldQuantity) < 0 {
+		allErrs = append(allErrs, field.Forbidden(fldPath,
+			fmt.Sprintf("disk can not shrink from %s to %s", oldSize, newSize)))
+	}
+	return allErrs
+}
+
+func (r *Iscsigateway) diskSize(poolName, diskName string) string {
+	for _, pool := range r.Spec.Storage {
+		if pool.PoolName != poolName {
+			continue
+		}
+		for _, disk := range pool.Disks {
+			if disk.DiskName == diskName {
+				return disk.DiskSize
+			}
+		}
+	}
+	return ""
+}
+
+// targetName returns the IQN of the named additional target, as set in the
+// spec or reported in the status, empty if it is unknown.
+func (r *Iscsigateway) targetName(name string) string {
+	for _, t := range r.Spec.Targets {
+		if t.Name == name && t.TargetName != "" {
+			return t.TargetName
+		}
+	}
+	for _, t := range r.Status.Targets {
+		if t.Name == name {
+			return t.TargetName
+		}
+	}
+	return ""
+}
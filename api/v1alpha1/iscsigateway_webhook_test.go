@@ -0,0 +1,340 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// validGateway returns a gateway accepted by the webhook.
+func validGateway() *Iscsigateway {
+	return &Iscsigateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "gw", Namespace: "storage"},
+		Spec: IscsigatewaySpec{
+			Scale:      2,
+			CephConfig: "ceph-config",
+			Storage: []IscsiStorageSpec{{
+				PoolName: "rbd",
+				Disks:    []IscsiDiskSpec{{DiskName: "d1", DiskSize: "10Gi"}},
+			}},
+			Hosts: []IscsiHostSpec{{
+				HostName: "iqn.2023-01.com.example:h1",
+				Luns:     []IscsiLunSpec{{PoolName: "rbd", DiskName: "d1"}},
+			}},
+		},
+	}
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+// expectInvalid expects err to reject the fields.
+func expectInvalid(err error, fields ...string) {
+	Expect(apierrors.IsInvalid(err)).To(BeTrue(), "error: %v", err)
+	causes := []string{}
+	for _, c := range err.(apierrors.APIStatus).Status().Details.Causes {
+		causes = append(causes, c.Field)
+	}
+	Expect(causes).To(ConsistOf(fields))
+}
+
+var _ = Describe("Iscsigateway webhook", func() {
+	It("accepts a valid gateway", func() {
+		Expect(validGateway().ValidateCreate()).To(Succeed())
+	})
+
+	DescribeTable("rejecting invalid gateways on create",
+		func(mutate func(*Iscsigateway), fields ...string) {
+			ig := validGateway()
+			mutate(ig)
+			expectInvalid(ig.ValidateCreate(), fields...)
+		},
+		Entry("with a target name that is not an iSCSI name", func(ig *Iscsigateway) {
+			ig.Spec.TargetName = "target"
+		}, "spec.targetname"),
+		Entry("with a negative scale", func(ig *Iscsigateway) {
+			ig.Spec.Scale = -1
+		}, "spec.scale"),
+		Entry("without a ceph config", func(ig *Iscsigateway) {
+			ig.Spec.CephConfig = ""
+		}, "spec.cephconfig"),
+		Entry("with both a ceph config and a ceph spec", func(ig *Iscsigateway) {
+			ig.Spec.Ceph = &IscsiCephSpec{Rook: &IscsiRookSpec{ClusterName: "ceph"}}
+		}, "spec.ceph"),
+		Entry("with a keyring secret but no monitors", func(ig *Iscsigateway) {
+			ig.Spec.CephConfig = ""
+			ig.Spec.Ceph = &IscsiCephSpec{
+				KeyringSecretRef: &corev1.LocalObjectReference{Name: "keyring"},
+			}
+		}, "spec.ceph.monitors"),
+		Entry("with a client name including its prefix", func(ig *Iscsigateway) {
+			ig.Spec.CephConfig = ""
+			ig.Spec.Ceph = &IscsiCephSpec{
+				ClientName: "client.iscsi",
+				Rook:       &IscsiRookSpec{ClusterName: "ceph"},
+			}
+		}, "spec.ceph.clientName"),
+		Entry("with an invalid trusted IP", func(ig *Iscsigateway) {
+			ig.Spec.Api = &IscsiApiSpec{TrustedIPs: []string{"10.0.0.300"}}
+		}, "spec.api.trustedIPs[0]"),
+		Entry("with a non positive resync interval", func(ig *Iscsigateway) {
+			ig.Spec.Drift = &IscsiDriftSpec{
+				ResyncInterval: &metav1.Duration{Duration: -time.Second},
+			}
+		}, "spec.drift.resyncInterval"),
+		Entry("with a pool listed twice", func(ig *Iscsigateway) {
+			ig.Spec.Storage = append(ig.Spec.Storage, IscsiStorageSpec{PoolName: "rbd"})
+		}, "spec.storage[1].poolname"),
+		Entry("with a disk listed twice", func(ig *Iscsigateway) {
+			ig.Spec.Storage[0].Disks = append(ig.Spec.Storage[0].Disks,
+				IscsiDiskSpec{DiskName: "d1", DiskSize: "1Gi"})
+		}, "spec.storage[0].disks[1].diskname"),
+		Entry("with an invalid disk size", func(ig *Iscsigateway) {
+			ig.Spec.Storage[0].Disks[0].DiskSize = "0"
+		}, "spec.storage[0].disks[0].disksize"),
+		Entry("with a disk size that is not a quantity", func(ig *Iscsigateway) {
+			ig.Spec.Storage[0].Disks[0].DiskSize = "ten gigs"
+		}, "spec.storage[0].disks[0].disksize"),
+		Entry("with a host listed twice", func(ig *Iscsigateway) {
+			ig.Spec.Hosts = append(ig.Spec.Hosts, ig.Spec.Hosts[0])
+		}, "spec.hosts[1].hostName"),
+		Entry("with a LUN without a disk name", func(ig *Iscsigateway) {
+			ig.Spec.Hosts[0].Luns[0].DiskName = ""
+		}, "spec.hosts[0].luns[0]"),
+		Entry("with a LUN on a disk the gateway does not have", func(ig *Iscsigateway) {
+			ig.Spec.Hosts[0].Luns[0].DiskName = "d2"
+		}, "spec.hosts[0].luns[0]"),
+		Entry("with a group LUN on a disk the gateway does not have", func(ig *Iscsigateway) {
+			ig.Spec.HostGroups = []IscsiHostGroupSpec{{
+				Name:    "cluster",
+				Members: []string{"iqn.2023-01.com.example:h2"},
+				Luns:    []IscsiLunSpec{{PoolName: "rbd", DiskName: "d2"}},
+			}}
+		}, "spec.hostGroups[0].luns[0]"),
+		Entry("with a target LUN on a disk of another target", func(ig *Iscsigateway) {
+			ig.Spec.Targets = []IscsiTargetSpec{{
+				Name: "second",
+				Hosts: []IscsiHostSpec{{
+					HostName: "iqn.2023-01.com.example:h2",
+					Luns:     []IscsiLunSpec{{PoolName: "rbd", DiskName: "d1"}},
+				}},
+			}}
+		}, "spec.targets[0].hosts[0].luns[0]"),
+		Entry("with a LUN listed twice", func(ig *Iscsigateway) {
+			ig.Spec.Hosts[0].Luns = append(ig.Spec.Hosts[0].Luns,
+				ig.Spec.Hosts[0].Luns[0])
+		}, "spec.hosts[0].luns[1]"),
+		Entry("with a LUN id out of range", func(ig *Iscsigateway) {
+			ig.Spec.Hosts[0].Luns[0].LunID = int32Ptr(MaxLunID + 1)
+		}, "spec.hosts[0].luns[0].lunId"),
+		Entry("with two LUNs pinned at the same id", func(ig *Iscsigateway) {
+			ig.Spec.Storage[0].Disks = append(ig.Spec.Storage[0].Disks,
+				IscsiDiskSpec{DiskName: "d2", DiskSize: "10Gi"})
+			ig.Spec.Hosts[0].Luns = []IscsiLunSpec{
+				{PoolName: "rbd", DiskName: "d1", LunID: int32Ptr(1)},
+				{PoolName: "rbd", DiskName: "d2", LunID: int32Ptr(1)},
+			}
+		}, "spec.hosts[0].luns[1].lunId"),
+		Entry("with a host also member of a group", func(ig *Iscsigateway) {
+			ig.Spec.HostGroups = []IscsiHostGroupSpec{{
+				Name:    "cluster",
+				Members: []string{"iqn.2023-01.com.example:h1"},
+			}}
+		}, "spec.hostGroups[0].members[0]"),
+		Entry("with a group without members", func(ig *Iscsigateway) {
+			ig.Spec.HostGroups = []IscsiHostGroupSpec{{Name: "cluster"}}
+		}, "spec.hostGroups[0].members"),
+		Entry("with a disk exported by two targets", func(ig *Iscsigateway) {
+			ig.Spec.Targets = []IscsiTargetSpec{{
+				Name:    "second",
+				Storage: ig.Spec.Storage,
+			}}
+		}, "spec.targets[0].storage[0].disks[0]"),
+		Entry("with discovery authentication on a target", func(ig *Iscsigateway) {
+			ig.Spec.Targets = []IscsiTargetSpec{{
+				Name: "second",
+				Auth: &IscsiTargetAuthSpec{
+					Discovery: &IscsiChapSpec{
+						SecretRef: corev1.LocalObjectReference{Name: "chap"},
+					},
+				},
+			}}
+		}, "spec.targets[0].auth.discovery"),
+		Entry("with a CHAP secret without a name", func(ig *Iscsigateway) {
+			ig.Spec.Hosts[0].Chap = &IscsiChapSpec{}
+		}, "spec.hosts[0].chap.secretRef.name"),
+		Entry("with a mutual CHAP secret without a name", func(ig *Iscsigateway) {
+			ig.Spec.Hosts[0].Chap = &IscsiChapSpec{
+				SecretRef:       corev1.LocalObjectReference{Name: "chap"},
+				MutualSecretRef: &corev1.LocalObjectReference{},
+			}
+		}, "spec.hosts[0].chap.mutualSecretRef.name"),
+		Entry("with a discovery CHAP secret without a name", func(ig *Iscsigateway) {
+			ig.Spec.Auth = &IscsiTargetAuthSpec{Discovery: &IscsiChapSpec{}}
+		}, "spec.auth.discovery.secretRef.name"),
+	)
+
+	Describe("with Iscsidisks", func() {
+		// iscsidisk returns an Iscsidisk of the rbd pool exported by the
+		// gateway.
+		iscsidisk := func(name string) *Iscsidisk {
+			return &Iscsidisk{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "storage"},
+				Spec: IscsidiskSpec{
+					Pool:       "rbd",
+					Size:       resource.MustParse("1Gi"),
+					GatewayRef: &corev1.LocalObjectReference{Name: "gw"},
+				},
+			}
+		}
+
+		// useDisks lets the webhook look the Iscsidisks up.
+		useDisks := func(disks ...*Iscsidisk) {
+			scheme := runtime.NewScheme()
+			Expect(AddToScheme(scheme)).To(Succeed())
+			b := fake.NewClientBuilder().WithScheme(scheme)
+			for _, d := range disks {
+				b = b.WithObjects(d)
+			}
+			iscsigatewayClient = b.Build()
+		}
+
+		// mapping returns a valid gateway with a host mapping a LUN to
+		// disk d2.
+		mapping := func() *Iscsigateway {
+			ig := validGateway()
+			ig.Spec.Hosts[0].Luns = append(ig.Spec.Hosts[0].Luns,
+				IscsiLunSpec{PoolName: "rbd", DiskName: "d2"})
+			return ig
+		}
+
+		AfterEach(func() {
+			iscsigatewayClient = nil
+		})
+
+		It("accepts LUNs on the Iscsidisks of the gateway", func() {
+			useDisks(iscsidisk("d2"))
+			Expect(mapping().ValidateCreate()).To(Succeed())
+		})
+
+		It("rejects LUNs on the Iscsidisks of another gateway", func() {
+			d := iscsidisk("d2")
+			d.Spec.GatewayRef.Name = "other"
+			useDisks(d)
+			expectInvalid(mapping().ValidateCreate(), "spec.hosts[0].luns[1]")
+		})
+
+		It("only lets the hosts mapping a deleted Iscsidisk keep it", func() {
+			d := iscsidisk("d2")
+			now := metav1.Now()
+			d.DeletionTimestamp = &now
+			d.Finalizers = []string{"diskFinalizer"}
+			useDisks(d)
+			expectInvalid(mapping().ValidateCreate(), "spec.hosts[0].luns[1]")
+
+			old := mapping()
+			ig := old.DeepCopy()
+			ig.Spec.Scale = 3
+			Expect(ig.ValidateUpdate(old)).To(Succeed())
+		})
+	})
+
+	Describe("on update", func() {
+		var old *Iscsigateway
+
+		BeforeEach(func() {
+			old = validGateway()
+		})
+
+		DescribeTable("rejecting changes",
+			func(mutate func(*Iscsigateway), fields ...string) {
+				ig := old.DeepCopy()
+				mutate(ig)
+				expectInvalid(ig.ValidateUpdate(old), fields...)
+			},
+			Entry("shrinking a disk", func(ig *Iscsigateway) {
+				ig.Spec.Storage[0].Disks[0].DiskSize = "5Gi"
+			}, "spec.storage[0].disks[0].disksize"),
+			Entry("moving a disk to another pool", func(ig *Iscsigateway) {
+				ig.Spec.Storage[0].PoolName = "other"
+				ig.Spec.Hosts[0].Luns[0].PoolName = "other"
+			}, "spec.storage[0].disks[0]"),
+			Entry("changing the target name", func(ig *Iscsigateway) {
+				old.Spec.TargetName = "iqn.2023-01.com.example:target"
+				ig.Spec.TargetName = "iqn.2023-01.com.example:other"
+			}, "spec.targetname"),
+			Entry("setting a target name other than the one served", func(ig *Iscsigateway) {
+				old.Status.TargetName = "iqn.2003-01.com.redhat.iscsi-gw:storage.gw"
+				ig.Spec.TargetName = "iqn.2023-01.com.example:other"
+			}, "spec.targetname"),
+		)
+
+		It("accepts growing a disk", func() {
+			ig := old.DeepCopy()
+			ig.Spec.Storage[0].Disks[0].DiskSize = "20Gi"
+			Expect(ig.ValidateUpdate(old)).To(Succeed())
+		})
+
+		It("accepts naming the target it already serves", func() {
+			old.Status.TargetName = "iqn.2023-01.com.example:target"
+			ig := old.DeepCopy()
+			ig.Spec.TargetName = old.Status.TargetName
+			Expect(ig.ValidateUpdate(old)).To(Succeed())
+		})
+
+		It("only holds what the update changes against a legacy gateway", func() {
+			old.Spec.TargetName = "legacy"
+			ig := old.DeepCopy()
+			ig.Spec.Scale = 3
+			Expect(ig.ValidateUpdate(old)).To(Succeed())
+
+			ig.Spec.Scale = -1
+			expectInvalid(ig.ValidateUpdate(old), "spec.scale")
+		})
+
+		It("lets metadata updates of an invalid gateway through", func() {
+			old.Spec.Scale = -1
+			ig := old.DeepCopy()
+			ig.Finalizers = []string{"iscsi.ruohwai/finalizer"}
+			Expect(ig.ValidateUpdate(old)).To(Succeed())
+		})
+
+		It("lets a deleting gateway through", func() {
+			ig := old.DeepCopy()
+			now := metav1.Now()
+			ig.DeletionTimestamp = &now
+			ig.Spec.Storage[0].Disks[0].DiskSize = "5Gi"
+			Expect(ig.ValidateUpdate(old)).To(Succeed())
+		})
+
+		It("rejects another kind of object", func() {
+			err := validGateway().ValidateUpdate(&IscsigatewayList{})
+			Expect(apierrors.IsBadRequest(err)).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,134 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// IscsiinitiatorSpec defines the desired state of Iscsiinitiator
+type IscsiinitiatorSpec struct {
+	// InitiatorName is the iSCSI name of the initiator, such as
+	// iqn.1994-05.com.redhat:client. It is added as a host of the selected
+	// gateways.
+	InitiatorName string `json:"initiatorName"`
+	// Chap configures CHAP authentication for the initiator. The secrets
+	// are taken from the namespace of the Iscsiinitiator. If unset, the
+	// default credentials of the operator are used.
+	// +optional
+	Chap *IscsiChapSpec `json:"chap,omitempty"`
+	// Luns are the disks of the gateways, as pool and disk names, the
+	// initiator is granted access to.
+	Luns []IscsiLunSpec `json:"luns"`
+	// GatewaySelector selects the Iscsigateways the initiator is granted
+	// access to.
+	GatewaySelector IscsiGatewaySelector `json:"gatewaySelector"`
+}
+
+// IscsiGatewaySelector selects Iscsigateways by namespace and labels.
+type IscsiGatewaySelector struct {
+	// Namespace of the gateways. Defaults to the namespace of the
+	// Iscsiinitiator.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// LabelSelector matches the labels of the gateways. An empty selector
+	// matches every gateway of the namespace.
+	metav1.LabelSelector `json:",inline"`
+}
+
+// IscsiInitiatorGatewayState describes how a gateway handled the
+// initiator.
+type IscsiInitiatorGatewayState struct {
+	// Namespace of the gateway.
+	Namespace string `json:"namespace"`
+	// Name of the gateway.
+	Name string `json:"name"`
+	// TargetName is the target the initiator logs in to.
+	// +optional
+	TargetName string `json:"targetName,omitempty"`
+	// Accepted is true when the gateway exports the LUNs of the initiator.
+	Accepted bool `json:"accepted"`
+	// Message explains why the initiator was not accepted.
+	// +optional
+	Message string `json:"message,omitempty"`
+	// ObservedGeneration is the generation of the initiator the gateway
+	// last handled.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+// IscsiinitiatorStatus defines the observed state of Iscsiinitiator
+type IscsiinitiatorStatus struct {
+	// Gateways lists the gateways selected by the initiator, as reported
+	// by each gateway.
+	// +optional
+	// +listType=map
+	// +listMapKey=namespace
+	// +listMapKey=name
+	Gateways []IscsiInitiatorGatewayState `json:"gateways,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Initiator",type=string,JSONPath=`.spec.initiatorName`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// Iscsiinitiator is the Schema for the iscsiinitiators API
+type Iscsiinitiator struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IscsiinitiatorSpec   `json:"spec,omitempty"`
+	Status IscsiinitiatorStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IscsiinitiatorList contains a list of Iscsiinitiator
+type IscsiinitiatorList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Iscsiinitiator `json:"items"`
+}
+
+// GatewayNamespace returns the namespace of the gateways selected by the
+// initiator.
+func (r *Iscsiinitiator) GatewayNamespace() string {
+	if r.Spec.GatewaySelector.Namespace != "" {
+		return r.Spec.GatewaySelector.Namespace
+	}
+	return r.Namespace
+}
+
+// SelectsGateway returns true if the initiator requests access to the
+// gateway.
+func (r *Iscsiinitiator) SelectsGateway(ig *Iscsigateway) bool {
+	if ig.Namespace != r.GatewayNamespace() {
+		return false
+	}
+	selector, err := metav1.LabelSelectorAsSelector(
+		&r.Spec.GatewaySelector.LabelSelector)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(ig.Labels))
+}
+
+func init() {
+	SchemeBuilder.Register(&Iscsiinitiator{}, &IscsiinitiatorList{})
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	"github.com/Erichorng/iscsi-operator/internal/iqn"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var iscsiinitiatorlog = logf.Log.WithName("iscsiinitiator-resource")
+
+func (r *Iscsiinitiator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-iscsi-ruohwai-v1alpha1-iscsiinitiator,mutating=false,failurePolicy=fail,sideEffects=None,groups=iscsi.ruohwai,resources=iscsiinitiators,verbs=create;update,versions=v1alpha1,name=viscsiinitiator.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &Iscsiinitiator{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Iscsiinitiator) ValidateCreate() error {
+	iscsiinitiatorlog.Info("validate create", "name", r.Name)
+
+	return r.toError(r.validateSpec())
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Iscsiinitiator) ValidateUpdate(old runtime.Object) error {
+	iscsiinitiatorlog.Info("validate update", "name", r.Name)
+
+	if _, ok := old.(*Iscsiinitiator); !ok {
+		return apierrors.NewBadRequest(
+			fmt.Sprintf("expected an Iscsiinitiator but got a %T", old))
+	}
+	return r.toError(r.validateSpec())
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Iscsiinitiator) ValidateDelete() error {
+	return nil
+}
+
+func (r *Iscsiinitiator) toError(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(
+		GroupVersion.WithKind("Iscsiinitiator").GroupKind(), r.Name, allErrs)
+}
+
+func (r *Iscsiinitiator) validateSpec() field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if err := iqn.Validate(r.Spec.InitiatorName); err != nil {
+		allErrs = append(allErrs, field.Invalid(
+			specPath.Child("initiatorName"), r.Spec.InitiatorName, err.Error()))
+	}
+	if r.Spec.Chap != nil {
+		allErrs = append(allErrs, validateChap(
+			r.Spec.Chap, specPath.Child("chap"))...)
+	}
+	// the disks of the gateways are only known once they select the
+	// initiator
+	allErrs = append(allErrs, validateLuns(
+		r.Spec.Luns, nil, specPath.Child("luns"))...)
+	_, err := metav1.LabelSelectorAsSelector(&r.Spec.GatewaySelector.LabelSelector)
+	if err != nil {
+		allErrs = append(allErrs, field.Invalid(
+			specPath.Child("gatewaySelector"), r.Spec.GatewaySelector, err.Error()))
+	}
+	return allErrs
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// validInitiator returns an initiator accepted by the webhook.
+func validInitiator() *Iscsiinitiator {
+	return &Iscsiinitiator{
+		ObjectMeta: metav1.ObjectMeta{Name: "client", Namespace: "apps"},
+		Spec: IscsiinitiatorSpec{
+			InitiatorName: "iqn.2023-01.com.example:client",
+			Luns:          []IscsiLunSpec{{PoolName: "rbd", DiskName: "d1"}},
+			GatewaySelector: IscsiGatewaySelector{
+				Namespace: "storage",
+			},
+		},
+	}
+}
+
+var _ = Describe("Iscsiinitiator webhook", func() {
+	It("accepts a valid initiator", func() {
+		Expect(validInitiator().ValidateCreate()).To(Succeed())
+	})
+
+	DescribeTable("rejecting invalid initiators",
+		func(mutate func(*Iscsiinitiator), fields ...string) {
+			ini := validInitiator()
+			mutate(ini)
+			expectInvalid(ini.ValidateCreate(), fields...)
+			expectInvalid(ini.ValidateUpdate(validInitiator()), fields...)
+		},
+		Entry("with an initiator name that is not an iSCSI name", func(ini *Iscsiinitiator) {
+			ini.Spec.InitiatorName = "client"
+		}, "spec.initiatorName"),
+		Entry("with a CHAP secret without a name", func(ini *Iscsiinitiator) {
+			ini.Spec.Chap = &IscsiChapSpec{}
+		}, "spec.chap.secretRef.name"),
+		Entry("with a LUN without a pool", func(ini *Iscsiinitiator) {
+			ini.Spec.Luns[0].PoolName = ""
+		}, "spec.luns[0]"),
+		Entry("with a LUN listed twice", func(ini *Iscsiinitiator) {
+			ini.Spec.Luns = append(ini.Spec.Luns, ini.Spec.Luns[0])
+		}, "spec.luns[1]"),
+		Entry("with a negative LUN id", func(ini *Iscsiinitiator) {
+			ini.Spec.Luns[0].LunID = int32Ptr(-1)
+		}, "spec.luns[0].lunId"),
+		Entry("with an invalid gateway selector", func(ini *Iscsiinitiator) {
+			ini.Spec.GatewaySelector.MatchExpressions = []metav1.LabelSelectorRequirement{{
+				Key:      "tier",
+				Operator: "Near",
+			}}
+		}, "spec.gatewaySelector"),
+	)
+
+	It("rejects another kind of object on update", func() {
+		Expect(validInitiator().ValidateUpdate(&IscsigatewayList{})).NotTo(Succeed())
+	})
+
+	Describe("selecting gateways", func() {
+		var ig *Iscsigateway
+
+		BeforeEach(func() {
+			ig = validGateway()
+			ig.Labels = map[string]string{"tier": "gold"}
+		})
+
+		It("selects every gateway of the namespace by default", func() {
+			Expect(validInitiator().SelectsGateway(ig)).To(BeTrue())
+		})
+
+		It("defaults to its own namespace", func() {
+			ini := validInitiator()
+			ini.Spec.GatewaySelector.Namespace = ""
+			Expect(ini.GatewayNamespace()).To(Equal("apps"))
+			Expect(ini.SelectsGateway(ig)).To(BeFalse())
+			ini.Namespace = "storage"
+			Expect(ini.SelectsGateway(ig)).To(BeTrue())
+		})
+
+		It("matches the labels of the gateway", func() {
+			ini := validInitiator()
+			ini.Spec.GatewaySelector.MatchLabels = map[string]string{"tier": "gold"}
+			Expect(ini.SelectsGateway(ig)).To(BeTrue())
+			ini.Spec.GatewaySelector.MatchLabels = map[string]string{"tier": "bronze"}
+			Expect(ini.SelectsGateway(ig)).To(BeFalse())
+		})
+	})
+
+	It("accepts a CHAP secret", func() {
+		ini := validInitiator()
+		ini.Spec.Chap = &IscsiChapSpec{
+			SecretRef: corev1.LocalObjectReference{Name: "client-chap"},
+		}
+		Expect(ini.ValidateCreate()).To(Succeed())
+	})
+})
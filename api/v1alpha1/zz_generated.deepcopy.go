@@ -22,9 +22,92 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsiApiSpec) DeepCopyInto(out *IscsiApiSpec) {
+	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+	if in.TLSSecretRef != nil {
+		in, out := &in.TLSSecretRef, &out.TLSSecretRef
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+	if in.TrustedIPs != nil {
+		in, out := &in.TrustedIPs, &out.TrustedIPs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsiApiSpec.
+func (in *IscsiApiSpec) DeepCopy() *IscsiApiSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsiApiSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsiCephSpec) DeepCopyInto(out *IscsiCephSpec) {
+	*out = *in
+	if in.KeyringSecretRef != nil {
+		in, out := &in.KeyringSecretRef, &out.KeyringSecretRef
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+	if in.Monitors != nil {
+		in, out := &in.Monitors, &out.Monitors
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Rook != nil {
+		in, out := &in.Rook, &out.Rook
+		*out = new(IscsiRookSpec)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsiCephSpec.
+func (in *IscsiCephSpec) DeepCopy() *IscsiCephSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsiCephSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsiChapSpec) DeepCopyInto(out *IscsiChapSpec) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+	if in.MutualSecretRef != nil {
+		in, out := &in.MutualSecretRef, &out.MutualSecretRef
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsiChapSpec.
+func (in *IscsiChapSpec) DeepCopy() *IscsiChapSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsiChapSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IscsiDiskSpec) DeepCopyInto(out *IscsiDiskSpec) {
 	*out = *in
@@ -40,13 +123,119 @@ func (in *IscsiDiskSpec) DeepCopy() *IscsiDiskSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsiDriftSpec) DeepCopyInto(out *IscsiDriftSpec) {
+	*out = *in
+	if in.ResyncInterval != nil {
+		in, out := &in.ResyncInterval, &out.ResyncInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsiDriftSpec.
+func (in *IscsiDriftSpec) DeepCopy() *IscsiDriftSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsiDriftSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsiGatewaySelector) DeepCopyInto(out *IscsiGatewaySelector) {
+	*out = *in
+	in.LabelSelector.DeepCopyInto(&out.LabelSelector)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsiGatewaySelector.
+func (in *IscsiGatewaySelector) DeepCopy() *IscsiGatewaySelector {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsiGatewaySelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsiGatewayState) DeepCopyInto(out *IscsiGatewayState) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsiGatewayState.
+func (in *IscsiGatewayState) DeepCopy() *IscsiGatewayState {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsiGatewayState)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsiHostGroupSpec) DeepCopyInto(out *IscsiHostGroupSpec) {
+	*out = *in
+	if in.Members != nil {
+		in, out := &in.Members, &out.Members
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Chap != nil {
+		in, out := &in.Chap, &out.Chap
+		*out = new(IscsiChapSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Luns != nil {
+		in, out := &in.Luns, &out.Luns
+		*out = make([]IscsiLunSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsiHostGroupSpec.
+func (in *IscsiHostGroupSpec) DeepCopy() *IscsiHostGroupSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsiHostGroupSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsiHostRemovalState) DeepCopyInto(out *IscsiHostRemovalState) {
+	*out = *in
+	in.RemoveAfter.DeepCopyInto(&out.RemoveAfter)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsiHostRemovalState.
+func (in *IscsiHostRemovalState) DeepCopy() *IscsiHostRemovalState {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsiHostRemovalState)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IscsiHostSpec) DeepCopyInto(out *IscsiHostSpec) {
 	*out = *in
+	if in.Chap != nil {
+		in, out := &in.Chap, &out.Chap
+		*out = new(IscsiChapSpec)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Luns != nil {
 		in, out := &in.Luns, &out.Luns
 		*out = make([]IscsiLunSpec, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 }
 
@@ -60,9 +249,29 @@ func (in *IscsiHostSpec) DeepCopy() *IscsiHostSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsiInitiatorGatewayState) DeepCopyInto(out *IscsiInitiatorGatewayState) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsiInitiatorGatewayState.
+func (in *IscsiInitiatorGatewayState) DeepCopy() *IscsiInitiatorGatewayState {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsiInitiatorGatewayState)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IscsiLunSpec) DeepCopyInto(out *IscsiLunSpec) {
 	*out = *in
+	if in.LunID != nil {
+		in, out := &in.LunID, &out.LunID
+		*out = new(int32)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsiLunSpec.
@@ -75,6 +284,78 @@ func (in *IscsiLunSpec) DeepCopy() *IscsiLunSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsiPlanState) DeepCopyInto(out *IscsiPlanState) {
+	*out = *in
+	if in.Changes != nil {
+		in, out := &in.Changes, &out.Changes
+		*out = make([]IscsiPlannedChange, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsiPlanState.
+func (in *IscsiPlanState) DeepCopy() *IscsiPlanState {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsiPlanState)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsiPlannedChange) DeepCopyInto(out *IscsiPlannedChange) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsiPlannedChange.
+func (in *IscsiPlannedChange) DeepCopy() *IscsiPlannedChange {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsiPlannedChange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsiPortalSpec) DeepCopyInto(out *IscsiPortalSpec) {
+	*out = *in
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsiPortalSpec.
+func (in *IscsiPortalSpec) DeepCopy() *IscsiPortalSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsiPortalSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsiRookSpec) DeepCopyInto(out *IscsiRookSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsiRookSpec.
+func (in *IscsiRookSpec) DeepCopy() *IscsiRookSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsiRookSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IscsiStorageSpec) DeepCopyInto(out *IscsiStorageSpec) {
 	*out = *in
@@ -95,13 +376,210 @@ func (in *IscsiStorageSpec) DeepCopy() *IscsiStorageSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsiTargetAuthSpec) DeepCopyInto(out *IscsiTargetAuthSpec) {
+	*out = *in
+	if in.Discovery != nil {
+		in, out := &in.Discovery, &out.Discovery
+		*out = new(IscsiChapSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsiTargetAuthSpec.
+func (in *IscsiTargetAuthSpec) DeepCopy() *IscsiTargetAuthSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsiTargetAuthSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsiTargetSpec) DeepCopyInto(out *IscsiTargetSpec) {
+	*out = *in
+	if in.Storage != nil {
+		in, out := &in.Storage, &out.Storage
+		*out = make([]IscsiStorageSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Hosts != nil {
+		in, out := &in.Hosts, &out.Hosts
+		*out = make([]IscsiHostSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.HostGroups != nil {
+		in, out := &in.HostGroups, &out.HostGroups
+		*out = make([]IscsiHostGroupSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Auth != nil {
+		in, out := &in.Auth, &out.Auth
+		*out = new(IscsiTargetAuthSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsiTargetSpec.
+func (in *IscsiTargetSpec) DeepCopy() *IscsiTargetSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsiTargetSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsiTargetState) DeepCopyInto(out *IscsiTargetState) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsiTargetState.
+func (in *IscsiTargetState) DeepCopy() *IscsiTargetState {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsiTargetState)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Iscsidisk) DeepCopyInto(out *Iscsidisk) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Iscsidisk.
+func (in *Iscsidisk) DeepCopy() *Iscsidisk {
+	if in == nil {
+		return nil
+	}
+	out := new(Iscsidisk)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *Iscsidisk) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsidiskList) DeepCopyInto(out *IscsidiskList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]Iscsidisk, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsidiskList.
+func (in *IscsidiskList) DeepCopy() *IscsidiskList {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsidiskList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *IscsidiskList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsidiskSpec) DeepCopyInto(out *IscsidiskSpec) {
+	*out = *in
+	out.Size = in.Size.DeepCopy()
+	if in.Features != nil {
+		in, out := &in.Features, &out.Features
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.GatewayRef != nil {
+		in, out := &in.GatewayRef, &out.GatewayRef
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsidiskSpec.
+func (in *IscsidiskSpec) DeepCopy() *IscsidiskSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsidiskSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsidiskStatus) DeepCopyInto(out *IscsidiskStatus) {
+	*out = *in
+	if in.Size != nil {
+		in, out := &in.Size, &out.Size
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.Watchers != nil {
+		in, out := &in.Watchers, &out.Watchers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastSyncTime != nil {
+		in, out := &in.LastSyncTime, &out.LastSyncTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsidiskStatus.
+func (in *IscsidiskStatus) DeepCopy() *IscsidiskStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsidiskStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Iscsigateway) DeepCopyInto(out *Iscsigateway) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Iscsigateway.
@@ -171,6 +649,64 @@ func (in *IscsigatewaySpec) DeepCopyInto(out *IscsigatewaySpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.HostGroups != nil {
+		in, out := &in.HostGroups, &out.HostGroups
+		*out = make([]IscsiHostGroupSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Ceph != nil {
+		in, out := &in.Ceph, &out.Ceph
+		*out = new(IscsiCephSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Api != nil {
+		in, out := &in.Api, &out.Api
+		*out = new(IscsiApiSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Auth != nil {
+		in, out := &in.Auth, &out.Auth
+		*out = new(IscsiTargetAuthSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]corev1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Portals != nil {
+		in, out := &in.Portals, &out.Portals
+		*out = new(IscsiPortalSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.InitiatorNamespaceSelector != nil {
+		in, out := &in.InitiatorNamespaceSelector, &out.InitiatorNamespaceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Targets != nil {
+		in, out := &in.Targets, &out.Targets
+		*out = make([]IscsiTargetSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Drift != nil {
+		in, out := &in.Drift, &out.Drift
+		*out = new(IscsiDriftSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsigatewaySpec.
@@ -186,6 +722,35 @@ func (in *IscsigatewaySpec) DeepCopy() *IscsigatewaySpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IscsigatewayStatus) DeepCopyInto(out *IscsigatewayStatus) {
 	*out = *in
+	if in.Targets != nil {
+		in, out := &in.Targets, &out.Targets
+		*out = make([]IscsiTargetState, len(*in))
+		copy(*out, *in)
+	}
+	if in.Gateways != nil {
+		in, out := &in.Gateways, &out.Gateways
+		*out = make([]IscsiGatewayState, len(*in))
+		copy(*out, *in)
+	}
+	if in.PendingHostRemovals != nil {
+		in, out := &in.PendingHostRemovals, &out.PendingHostRemovals
+		*out = make([]IscsiHostRemovalState, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Plan != nil {
+		in, out := &in.Plan, &out.Plan
+		*out = new(IscsiPlanState)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsigatewayStatus.
@@ -197,3 +762,110 @@ func (in *IscsigatewayStatus) DeepCopy() *IscsigatewayStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Iscsiinitiator) DeepCopyInto(out *Iscsiinitiator) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Iscsiinitiator.
+func (in *Iscsiinitiator) DeepCopy() *Iscsiinitiator {
+	if in == nil {
+		return nil
+	}
+	out := new(Iscsiinitiator)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *Iscsiinitiator) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsiinitiatorList) DeepCopyInto(out *IscsiinitiatorList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]Iscsiinitiator, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsiinitiatorList.
+func (in *IscsiinitiatorList) DeepCopy() *IscsiinitiatorList {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsiinitiatorList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *IscsiinitiatorList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsiinitiatorSpec) DeepCopyInto(out *IscsiinitiatorSpec) {
+	*out = *in
+	if in.Chap != nil {
+		in, out := &in.Chap, &out.Chap
+		*out = new(IscsiChapSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Luns != nil {
+		in, out := &in.Luns, &out.Luns
+		*out = make([]IscsiLunSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	in.GatewaySelector.DeepCopyInto(&out.GatewaySelector)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsiinitiatorSpec.
+func (in *IscsiinitiatorSpec) DeepCopy() *IscsiinitiatorSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsiinitiatorSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IscsiinitiatorStatus) DeepCopyInto(out *IscsiinitiatorStatus) {
+	*out = *in
+	if in.Gateways != nil {
+		in, out := &in.Gateways, &out.Gateways
+		*out = make([]IscsiInitiatorGatewayState, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IscsiinitiatorStatus.
+func (in *IscsiinitiatorStatus) DeepCopy() *IscsiinitiatorStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(IscsiinitiatorStatus)
+	in.DeepCopyInto(out)
+	return out
+}
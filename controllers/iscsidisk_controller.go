@@ -0,0 +1,68 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+
+	iscsiv1alpha1 "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/resource"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// IscsidiskReconciler reconciles a Iscsidisk object
+type IscsidiskReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=iscsi.ruohwai,resources=iscsidisks,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=iscsi.ruohwai,resources=iscsidisks/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=iscsi.ruohwai,resources=iscsidisks/finalizers,verbs=update
+
+// Reconcile creates, grows and deletes the RBD image of an Iscsidisk and
+// reports the state of the image in its status.
+func (r *IscsidiskReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+
+	reqLogger := r.Log.WithValues("iscsidisk", req.NamespacedName)
+	reqLogger.Info("Reconciling Iscsidisk")
+
+	IscsiDiskManager := resource.NewIscsiDiskManager(
+		r, r.Scheme(), reqLogger, r.Recorder)
+
+	res := IscsiDiskManager.Process(ctx, req.NamespacedName)
+	err := res.Err()
+	if res.Requeue() {
+		return ctrl.Result{Requeue: true, RequeueAfter: res.RequeueAfter()}, err
+	}
+
+	return ctrl.Result{}, err
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *IscsidiskReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&iscsiv1alpha1.Iscsidisk{}).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
@@ -25,9 +25,13 @@ import (
 	"github.com/Erichorng/iscsi-operator/internal/resource"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 // IscsigatewayReconciler reconciles a Iscsigateway object
@@ -40,15 +44,22 @@ type IscsigatewayReconciler struct {
 //+kubebuilder:rbac:groups=iscsi.ruohwai,resources=iscsigateways,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=iscsi.ruohwai,resources=iscsigateways/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=iscsi.ruohwai,resources=iscsigateways/finalizers,verbs=update
+//+kubebuilder:rbac:groups=iscsi.ruohwai,resources=iscsidisks,verbs=get;list;watch
+//+kubebuilder:rbac:groups=iscsi.ruohwai,resources=iscsiinitiators,verbs=get;list;watch
+//+kubebuilder:rbac:groups=iscsi.ruohwai,resources=iscsiinitiators/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
 // +kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=events,verbs=create
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
 // +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=ceph.rook.io,resources=cephclusters;cephclients,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -70,7 +81,7 @@ func (r *IscsigatewayReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	res := IscsiGatewayManager.Process(ctx, req.NamespacedName)
 	err := res.Err()
 	if res.Requeue() {
-		return ctrl.Result{Requeue: true}, err
+		return ctrl.Result{Requeue: true, RequeueAfter: res.RequeueAfter()}, err
 	}
 
 	return ctrl.Result{}, err
@@ -82,8 +93,176 @@ func (r *IscsigatewayReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		For(&iscsiv1alpha1.Iscsigateway{}).
 		Owns(&corev1.PersistentVolumeClaim{}).
 		Owns(&corev1.ConfigMap{}).
-		Owns(&appsv1.DaemonSet{}).
 		Owns(&appsv1.StatefulSet{}).
+		Owns(&corev1.Secret{}).
+		Owns(&corev1.Service{}).
+		// the tcmu-runner daemon set is shared, every gateway using it
+		// holds a non-controller owner reference.
+		Watches(
+			&source.Kind{Type: &appsv1.DaemonSet{}},
+			&handler.EnqueueRequestForOwner{
+				OwnerType:    &iscsiv1alpha1.Iscsigateway{},
+				IsController: false,
+			}).
+		Watches(
+			&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(r.gatewaysForSecret)).
+		Watches(
+			&source.Kind{Type: &corev1.ConfigMap{}},
+			handler.EnqueueRequestsFromMapFunc(r.gatewaysForCephConfig)).
+		Watches(
+			&source.Kind{Type: &iscsiv1alpha1.Iscsidisk{}},
+			handler.EnqueueRequestsFromMapFunc(r.gatewayForDisk)).
+		Watches(
+			&source.Kind{Type: &iscsiv1alpha1.Iscsiinitiator{}},
+			handler.EnqueueRequestsFromMapFunc(r.gatewaysForInitiator)).
 		Complete(r)
 	// TODO: add Owns
 }
+
+// gatewaysForSecret maps a secret to the iscsigateways that take their
+// CHAP, API or ceph credentials from it.
+func (r *IscsigatewayReconciler) gatewaysForSecret(
+	obj client.Object) []reconcile.Request {
+
+	gateways := &iscsiv1alpha1.IscsigatewayList{}
+	err := r.List(context.Background(), gateways,
+		client.InNamespace(obj.GetNamespace()))
+	if err != nil {
+		r.Log.Error(err, "Failed to list Iscsigateways",
+			"Namespace", obj.GetNamespace())
+		return nil
+	}
+	requests := r.gatewaysForRookObject(obj)
+	for i := range gateways.Items {
+		ig := &gateways.Items[i]
+		if resource.UsesSecret(ig, obj.GetName()) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: ig.Namespace,
+					Name:      ig.Name,
+				},
+			})
+		}
+	}
+
+	// the secret may hold the credentials of an initiator
+	initiators := &iscsiv1alpha1.IscsiinitiatorList{}
+	err = r.List(context.Background(), initiators,
+		client.InNamespace(obj.GetNamespace()))
+	if err != nil {
+		r.Log.Error(err, "Failed to list Iscsiinitiators",
+			"Namespace", obj.GetNamespace())
+		return requests
+	}
+	for i := range initiators.Items {
+		ini := &initiators.Items[i]
+		if resource.InitiatorUsesSecret(ini, obj.GetName()) {
+			requests = append(requests, r.gatewaysForInitiator(ini)...)
+		}
+	}
+	return requests
+}
+
+// gatewaysForCephConfig maps a ConfigMap to the iscsigateways that take
+// their ceph configuration from it.
+func (r *IscsigatewayReconciler) gatewaysForCephConfig(
+	obj client.Object) []reconcile.Request {
+
+	gateways := &iscsiv1alpha1.IscsigatewayList{}
+	err := r.List(context.Background(), gateways,
+		client.InNamespace(obj.GetNamespace()))
+	if err != nil {
+		r.Log.Error(err, "Failed to list Iscsigateways",
+			"Namespace", obj.GetNamespace())
+		return nil
+	}
+	requests := r.gatewaysForRookObject(obj)
+	for i := range gateways.Items {
+		ig := &gateways.Items[i]
+		if ig.Spec.CephConfig == obj.GetName() {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: ig.Namespace,
+					Name:      ig.Name,
+				},
+			})
+		}
+	}
+	return requests
+}
+
+// gatewaysForRookObject maps a ConfigMap or Secret of a Rook cluster to
+// the iscsigateways generating their ceph configuration from it. The Rook
+// cluster may live in a namespace of its own, every gateway is checked.
+func (r *IscsigatewayReconciler) gatewaysForRookObject(
+	obj client.Object) []reconcile.Request {
+
+	requests := []reconcile.Request{}
+	gateways := &iscsiv1alpha1.IscsigatewayList{}
+	if err := r.List(context.Background(), gateways); err != nil {
+		r.Log.Error(err, "Failed to list Iscsigateways")
+		return requests
+	}
+	for i := range gateways.Items {
+		ig := &gateways.Items[i]
+		if resource.UsesRookObject(ig, obj.GetNamespace(), obj.GetName()) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: ig.Namespace,
+					Name:      ig.Name,
+				},
+			})
+		}
+	}
+	return requests
+}
+
+// gatewayForDisk maps an Iscsidisk to the iscsigateway exporting it.
+func (r *IscsigatewayReconciler) gatewayForDisk(
+	obj client.Object) []reconcile.Request {
+
+	disk, ok := obj.(*iscsiv1alpha1.Iscsidisk)
+	if !ok || disk.Spec.GatewayRef == nil {
+		return nil
+	}
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{
+			Namespace: disk.Namespace,
+			Name:      disk.Spec.GatewayRef.Name,
+		},
+	}}
+}
+
+// gatewaysForInitiator maps an Iscsiinitiator to the iscsigateways it
+// selects, and to the ones that reported on it before.
+func (r *IscsigatewayReconciler) gatewaysForInitiator(
+	obj client.Object) []reconcile.Request {
+
+	ini, ok := obj.(*iscsiv1alpha1.Iscsiinitiator)
+	if !ok {
+		return nil
+	}
+	names := map[types.NamespacedName]bool{}
+	for _, s := range ini.Status.Gateways {
+		names[types.NamespacedName{Namespace: s.Namespace, Name: s.Name}] = true
+	}
+	gateways := &iscsiv1alpha1.IscsigatewayList{}
+	err := r.List(context.Background(), gateways,
+		client.InNamespace(ini.GatewayNamespace()))
+	if err != nil {
+		r.Log.Error(err, "Failed to list Iscsigateways",
+			"Namespace", ini.GatewayNamespace())
+	}
+	for i := range gateways.Items {
+		ig := &gateways.Items[i]
+		if ini.SelectsGateway(ig) {
+			names[types.NamespacedName{Namespace: ig.Namespace, Name: ig.Name}] = true
+		}
+	}
+	requests := []reconcile.Request{}
+	for name := range names {
+		requests = append(requests, reconcile.Request{NamespacedName: name})
+	}
+	return requests
+}
@@ -4,7 +4,9 @@ package conf
 import (
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/Erichorng/iscsi-operator/internal/iqn"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
@@ -17,11 +19,17 @@ var DefaultOperatorConfig = OperatorConfig{
 	ImagePullPolicy:     "IfNotPresent",
 	PoolName:            "rbd",
 	Hostname:            "iqn.0000.default:client",
-	User:                "IscsiUser",
-	Password:            "1234",
+	NamingAuthority:     "iqn.2003-01.com.redhat.iscsi-gw",
+	ChapSecret:          "",
+	MutualChapSecret:    "",
 	StatePVCSize:        "1G",
+	DrainTimeout:        "30s",
+	HostRemovalGrace:    "5m",
 	ApiPort:             5001,
 	IscsiPort:           3260,
+	RookNamespaces:      "",
+	DriftResync:         "5m",
+	TeardownTimeout:     "10m",
 }
 
 type OperatorConfig struct {
@@ -30,13 +38,27 @@ type OperatorConfig struct {
 	TcmuRunnerImage     string `mapstructure:"tcmu-runner-image"`
 	TcmuRunnerName      string `mapstructure:"tcmu-runner-name"`
 	ImagePullPolicy     string `mapstructure:"image-pull-policy"`
-	User                string `mapstructure:"iscsi-username"`
-	Password            string `mapstructure:"iscsi-password"`
+	ChapSecret          string `mapstructure:"chap-secret"`
+	MutualChapSecret    string `mapstructure:"mutual-chap-secret"`
 	Hostname            string `mapstructure:"iscsi-host"`
+	NamingAuthority     string `mapstructure:"iqn-naming-authority"`
 	PoolName            string `mapstructure:"iscsi-pool-name"`
 	StatePVCSize        string `mapstructure:"state-pvc-size"`
+	DrainTimeout        string `mapstructure:"drain-timeout"`
+	HostRemovalGrace    string `mapstructure:"host-removal-grace"`
 	ApiPort             int    `mapstructure:"api-port"`
 	IscsiPort           int    `mapstructure:"iscsi-port"`
+	// RookNamespaces is a comma separated list of the namespaces gateways
+	// of any namespace may use Rook clusters from. A gateway can always
+	// use the Rook clusters of its own namespace.
+	RookNamespaces string `mapstructure:"rook-namespaces"`
+	// DriftResync is how often the running gateways are checked for drift
+	// from their container config when nothing else triggers a reconcile.
+	DriftResync string `mapstructure:"drift-resync"`
+	// TeardownTimeout is how long the teardown of a deleted gateway waits
+	// for a ready gateway to remove the targets through before leaving
+	// them in the gateway configuration.
+	TeardownTimeout string `mapstructure:"teardown-timeout"`
 }
 
 func (oc *OperatorConfig) Validate() error {
@@ -44,9 +66,40 @@ func (oc *OperatorConfig) Validate() error {
 		return fmt.Errorf(
 			"IscsiContainerImage value [%s] imvalid", oc.IscsiContainerImage)
 	}
+	if _, err := time.ParseDuration(oc.DrainTimeout); err != nil {
+		return fmt.Errorf(
+			"DrainTimeout value [%s] invalid: %w", oc.DrainTimeout, err)
+	}
+	if _, err := time.ParseDuration(oc.HostRemovalGrace); err != nil {
+		return fmt.Errorf(
+			"HostRemovalGrace value [%s] invalid: %w", oc.HostRemovalGrace, err)
+	}
+	if _, err := time.ParseDuration(oc.DriftResync); err != nil {
+		return fmt.Errorf(
+			"DriftResync value [%s] invalid: %w", oc.DriftResync, err)
+	}
+	if _, err := time.ParseDuration(oc.TeardownTimeout); err != nil {
+		return fmt.Errorf(
+			"TeardownTimeout value [%s] invalid: %w", oc.TeardownTimeout, err)
+	}
+	if err := iqn.ValidateAuthority(oc.NamingAuthority); err != nil {
+		return fmt.Errorf(
+			"NamingAuthority value [%s] invalid: %w", oc.NamingAuthority, err)
+	}
 	return nil
 }
 
+// AllowsRookNamespace returns true if gateways of other namespaces may use
+// the Rook clusters of the namespace.
+func (oc *OperatorConfig) AllowsRookNamespace(namespace string) bool {
+	for _, ns := range strings.Split(oc.RookNamespaces, ",") {
+		if strings.TrimSpace(ns) == namespace {
+			return true
+		}
+	}
+	return false
+}
+
 type Source struct {
 	v    *viper.Viper
 	fset *pflag.FlagSet
@@ -60,13 +113,19 @@ func NewSource() *Source {
 	v.SetDefault("iscsi-container-name", d.IscsiContainerName)
 	v.SetDefault("tcmu-runner-name", d.TcmuRunnerName)
 	v.SetDefault("iscsi-pool-name", d.PoolName)
-	v.SetDefault("iscsi-username", d.User)
-	v.SetDefault("iscsi-password", d.Password)
+	v.SetDefault("chap-secret", d.ChapSecret)
+	v.SetDefault("mutual-chap-secret", d.MutualChapSecret)
 	v.SetDefault("iscsi-host", d.Hostname)
+	v.SetDefault("iqn-naming-authority", d.NamingAuthority)
 	v.SetDefault("state-pvc-size", d.StatePVCSize)
+	v.SetDefault("drain-timeout", d.DrainTimeout)
+	v.SetDefault("host-removal-grace", d.HostRemovalGrace)
 	v.SetDefault("image-pull-policy", d.ImagePullPolicy)
 	v.SetDefault("api-port", d.ApiPort)
 	v.SetDefault("iscsi-port", d.IscsiPort)
+	v.SetDefault("rook-namespaces", d.RookNamespaces)
+	v.SetDefault("drift-resync", d.DriftResync)
+	v.SetDefault("teardown-timeout", d.TeardownTimeout)
 	return &Source{v: v}
 }
 
@@ -0,0 +1,142 @@
+// Package iqn validates and generates iSCSI names as described in
+// RFC 3720 section 3.2.6 and RFC 3980.
+package iqn
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MaxLength is the maximum length, in bytes, of an iSCSI name.
+const MaxLength = 223
+
+const (
+	iqnPrefix = "iqn."
+	euiPrefix = "eui."
+	naaPrefix = "naa."
+)
+
+var (
+	dateRegexp  = regexp.MustCompile(`^(\d{4})-(\d{2})$`)
+	labelRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+	// the unique part of an iqn name may use any character allowed in
+	// an iSCSI name once normalized to lower case.
+	uniqueRegexp = regexp.MustCompile(`^[a-z0-9.:-]+$`)
+	hexRegexp    = regexp.MustCompile(`^[0-9A-Fa-f]+$`)
+)
+
+// Validate returns an error describing why name is not a valid iSCSI
+// name in the iqn., eui. or naa. format.
+func Validate(name string) error {
+	if len(name) > MaxLength {
+		return fmt.Errorf(
+			"iSCSI name is longer than %d bytes", MaxLength)
+	}
+	switch {
+	case strings.HasPrefix(name, iqnPrefix):
+		return validateIqn(name)
+	case strings.HasPrefix(name, euiPrefix):
+		return validateHex(name, euiPrefix, 16)
+	case strings.HasPrefix(name, naaPrefix):
+		return validateHex(name, naaPrefix, 16, 32)
+	default:
+		return fmt.Errorf(
+			"iSCSI name %q must start with %q, %q or %q",
+			name, iqnPrefix, euiPrefix, naaPrefix)
+	}
+}
+
+// ValidateAuthority returns an error if authority is not the iqn prefix of
+// a naming authority, such as iqn.2003-01.com.redhat.iscsi-gw.
+func ValidateAuthority(authority string) error {
+	if strings.Contains(authority, ":") {
+		return fmt.Errorf(
+			"naming authority %q must not contain a unique name", authority)
+	}
+	if !strings.HasPrefix(authority, iqnPrefix) {
+		return fmt.Errorf(
+			"naming authority %q must start with %q", authority, iqnPrefix)
+	}
+	return validateIqn(authority)
+}
+
+// Generate builds an iqn name for the unique string id under the naming
+// authority. The id is normalized to the characters allowed in a name.
+func Generate(authority, id string) (string, error) {
+	if err := ValidateAuthority(authority); err != nil {
+		return "", err
+	}
+	name := authority + ":" + normalize(id)
+	if err := Validate(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func validateIqn(name string) error {
+	rest := strings.TrimPrefix(name, iqnPrefix)
+	authority, unique, hasUnique := strings.Cut(rest, ":")
+	date, domain, found := strings.Cut(authority, ".")
+	if !found {
+		return fmt.Errorf(
+			"iqn name %q must be of the form iqn.yyyy-mm.reversed.domain[:name]",
+			name)
+	}
+	if err := validateDate(date); err != nil {
+		return fmt.Errorf("iqn name %q: %w", name, err)
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if !labelRegexp.MatchString(label) {
+			return fmt.Errorf(
+				"iqn name %q: invalid naming authority label %q", name, label)
+		}
+	}
+	if hasUnique && !uniqueRegexp.MatchString(unique) {
+		return fmt.Errorf(
+			"iqn name %q: unique name may only contain lower case letters, digits, '.', ':' and '-'",
+			name)
+	}
+	return nil
+}
+
+func validateDate(date string) error {
+	m := dateRegexp.FindStringSubmatch(date)
+	if m == nil {
+		return fmt.Errorf("date %q must be of the form yyyy-mm", date)
+	}
+	month, _ := strconv.Atoi(m[2])
+	if month < 1 || month > 12 {
+		return fmt.Errorf("date %q has an invalid month", date)
+	}
+	return nil
+}
+
+func validateHex(name, prefix string, lengths ...int) error {
+	digits := strings.TrimPrefix(name, prefix)
+	if !hexRegexp.MatchString(digits) {
+		return fmt.Errorf(
+			"iSCSI name %q must be followed by hexadecimal digits", name)
+	}
+	for _, l := range lengths {
+		if len(digits) == l {
+			return nil
+		}
+	}
+	return fmt.Errorf(
+		"iSCSI name %q must have %v hexadecimal digits", name, lengths)
+}
+
+func normalize(id string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(id) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-', r == ':':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+	return b.String()
+}
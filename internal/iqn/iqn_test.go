@@ -0,0 +1,78 @@
+package iqn
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IQN", func() {
+	DescribeTable("validating iSCSI names",
+		func(name string, valid bool) {
+			if valid {
+				Expect(Validate(name)).To(Succeed())
+			} else {
+				Expect(Validate(name)).NotTo(Succeed())
+			}
+		},
+		Entry("accepts an iqn name", "iqn.2003-01.com.redhat.iscsi-gw:target", true),
+		Entry("accepts an iqn name without a unique part", "iqn.2003-01.com.redhat", true),
+		Entry("accepts colons in the unique part", "iqn.2003-01.org.linux-iscsi:a:b.c-1", true),
+		Entry("accepts an eui name", "eui.02004567A425678D", true),
+		Entry("accepts a 16 digit naa name", "naa.52004567BA64678D", true),
+		Entry("accepts a 32 digit naa name", "naa.62004567BA64678D0123456789ABCDEF", true),
+		Entry("rejects an unknown prefix", "target", false),
+		Entry("rejects an upper case prefix", "IQN.2003-01.com.redhat", false),
+		Entry("rejects a name without a naming authority", "iqn.2003-01", false),
+		Entry("rejects an invalid date", "iqn.03-01.com.redhat", false),
+		Entry("rejects an invalid month", "iqn.2003-13.com.redhat", false),
+		Entry("rejects an upper case domain", "iqn.2003-01.com.RedHat", false),
+		Entry("rejects an empty domain label", "iqn.2003-01.com..redhat", false),
+		Entry("rejects a label ending with a dash", "iqn.2003-01.com.redhat-", false),
+		Entry("rejects upper case in the unique part", "iqn.2003-01.com.redhat:Target", false),
+		Entry("rejects spaces in the unique part", "iqn.2003-01.com.redhat:a b", false),
+		Entry("rejects an eui name of the wrong length", "eui.02004567A425678", false),
+		Entry("rejects an eui name with non hex digits", "eui.02004567A425678G", false),
+		Entry("rejects a naa name of the wrong length", "naa.52004567BA64678D01", false),
+		Entry("rejects a name too long",
+			"iqn.2003-01.com.redhat:"+strings.Repeat("a", MaxLength), false),
+	)
+
+	DescribeTable("validating naming authorities",
+		func(authority string, valid bool) {
+			if valid {
+				Expect(ValidateAuthority(authority)).To(Succeed())
+			} else {
+				Expect(ValidateAuthority(authority)).NotTo(Succeed())
+			}
+		},
+		Entry("accepts an authority", "iqn.2003-01.com.redhat.iscsi-gw", true),
+		Entry("rejects a unique part", "iqn.2003-01.com.redhat:target", false),
+		Entry("rejects an eui name", "eui.02004567A425678D", false),
+		Entry("rejects an invalid date", "iqn.2003.com.redhat", false),
+	)
+
+	DescribeTable("generating iqn names",
+		func(authority, id, want string, fails bool) {
+			name, err := Generate(authority, id)
+			if fails {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(name).To(Equal(want))
+			Expect(Validate(name)).To(Succeed())
+		},
+		Entry("appends the id",
+			"iqn.2003-01.com.redhat.iscsi-gw", "storage.gw",
+			"iqn.2003-01.com.redhat.iscsi-gw:storage.gw", false),
+		Entry("normalizes the id",
+			"iqn.2003-01.com.redhat.iscsi-gw", "Storage/GW_1",
+			"iqn.2003-01.com.redhat.iscsi-gw:storage-gw-1", false),
+		Entry("rejects an invalid authority",
+			"iqn.2003-01.com.redhat:x", "gw", "", true),
+		Entry("rejects a name too long",
+			"iqn.2003-01.com.redhat", strings.Repeat("a", MaxLength), "", true),
+	)
+})
@@ -0,0 +1,14 @@
+package iqn
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestIqn(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "IQN Suite")
+}
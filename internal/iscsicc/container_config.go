@@ -11,26 +11,122 @@ const (
 	Storage = Key("storage")
 
 	Glob_Host = "hostname"
+	Glob_LUN  = "lun"
+
+	// Authentication settings of the target.
+	Glob_DiscoveryAuth     = "discovery_auth"
+	Glob_MutualChapRequire = "mutual_chap_required"
+
+	// Legacy global options that used to carry plaintext credentials,
+	// dropped when migrating unversioned configs.
 	Glob_User = "username"
 	Glob_PWD  = "password"
-	Glob_LUN  = "lun"
 )
 
 type IscsiContainerConfig struct {
-	TargetName string               `json:"targetname,omitempty"`
-	Storage    PoolConfig           `json:"storage,omitempty"`
-	Hosts      HostConfig           `json:"hosts,omitempty"`
-	Globals    map[Key]GlobalConfig `json:"globals,omitempty"`
+	// Version of the layout of the config, see CurrentVersion.
+	Version    int        `json:"version"`
+	TargetName string     `json:"targetname,omitempty"`
+	Storage    PoolConfig `json:"storage,omitempty"`
+	Hosts      HostConfig `json:"hosts,omitempty"`
+	// HostGroups maps the name of a host group to its members and LUNs.
+	// The members are also listed in Hosts.
+	HostGroups map[string]HostGroupInfo `json:"hostgroups,omitempty"`
+	Globals    map[Key]GlobalConfig     `json:"globals,omitempty"`
+	// Purge lists the RBD images, as pool/disk, to delete. The operator
+	// deletes them through the API of the gateways once they are no longer
+	// exported, and drops them from the list.
+	Purge []string `json:"purge,omitempty"`
+	// Gateways lists the host names of the gateways in the target portal
+	// group.
+	Gateways []string `json:"gateways,omitempty"`
+	// Portals maps the host name of a gateway to the address, as
+	// host:port, initiators reach it at.
+	Portals map[string]string `json:"portals,omitempty"`
+	// Targets maps the name of an additional target, as given in the
+	// spec, to its configuration.
+	Targets map[string]TargetConfig `json:"targets,omitempty"`
+}
+
+// TargetConfig describes an additional target served by the gateways. The
+// disks it exports are purged through the Purge list of the container
+// config.
+type TargetConfig struct {
+	TargetName string                   `json:"targetname,omitempty"`
+	Storage    PoolConfig               `json:"storage,omitempty"`
+	Hosts      HostConfig               `json:"hosts,omitempty"`
+	HostGroups map[string]HostGroupInfo `json:"hostgroups,omitempty"`
+	// MutualChapRequired rejects any host of the target that is not
+	// configured for mutual CHAP.
+	MutualChapRequired bool `json:"mutualchaprequired,omitempty"`
 }
 
 /* type HostConfig struct {
 	Host map[Key]HostInformation `json:"host,omitempty"`
 } */
 
+// Authentication modes of a host or of discovery sessions.
+const (
+	AuthNone       = "none"
+	AuthChap       = "chap"
+	AuthMutualChap = "mutual_chap"
+)
+
 type HostInfo struct {
-	User     string   `json:"user,omitempty"`
-	Password string   `json:"password,omitempty"`
-	Lun      []string `json:"lun,omitempty"`
+	Auth string `json:"auth,omitempty"`
+	// Lun lists the disks, as pool/disk, mapped to the host in the order
+	// of their LUN ids.
+	Lun []string `json:"lun,omitempty"`
+	// LunIDs maps the disks of Lun to the LUN id the host sees them at.
+	LunIDs map[string]int32 `json:"lunids,omitempty"`
+	// Group is the host group the host is a member of.
+	Group string `json:"group,omitempty"`
+	// RemovalRequested is the time, in RFC 3339 format, the host was
+	// removed from the spec. The host is still exported until the removal
+	// grace period has passed.
+	RemovalRequested string `json:"removalrequested,omitempty"`
+}
+
+// HostGroupInfo describes a group of hosts sharing the same LUNs at the
+// same LUN ids.
+type HostGroupInfo struct {
+	Members []string `json:"members,omitempty"`
+	// Lun lists the disks, as pool/disk, mapped to the members in the
+	// order of their LUN ids.
+	Lun    []string         `json:"lun,omitempty"`
+	LunIDs map[string]int32 `json:"lunids,omitempty"`
+}
+
+// Credentials is a CHAP user name and password pair, optionally followed
+// by the pair the target answers with for mutual CHAP.
+type Credentials struct {
+	User           string `json:"user,omitempty"`
+	Password       string `json:"password,omitempty"`
+	MutualUser     string `json:"mutualuser,omitempty"`
+	MutualPassword string `json:"mutualpassword,omitempty"`
+}
+
+// CredentialConfig holds the CHAP credentials of the gateway. It is kept
+// apart from IscsiContainerConfig so that it can be stored in a Secret.
+type CredentialConfig struct {
+	Globals   Credentials            `json:"globals,omitempty"`
+	Discovery Credentials            `json:"discovery,omitempty"`
+	Hosts     map[string]Credentials `json:"hosts,omitempty"`
+	// Targets maps the name of an additional target to the credentials
+	// of its hosts.
+	Targets map[string]map[string]Credentials `json:"targets,omitempty"`
+}
+
+// Mode returns the authentication mode the credentials allow for.
+func (c Credentials) Mode() string {
+	switch {
+	case c.MutualUser != "":
+		return AuthMutualChap
+	case c.User != "":
+		return AuthChap
+	default:
+		return AuthNone
+	}
 }
 
 type GlobalConfig struct {
@@ -39,14 +135,18 @@ type GlobalConfig struct {
 
 type GlobalOptions struct {
 	DefaultHostName string
-	DefaultUser     string
-	DefaultPassword string
 }
 
 type IscsiOptions map[string]string
 
-// (diskname, disksize)
-type DiskConfig map[string]string
+// DiskInfo describes an RBD image exported by the gateway.
+type DiskInfo struct {
+	Size           string `json:"size"`
+	DeletionPolicy string `json:"deletionpolicy,omitempty"`
+}
+
+// (diskname, diskInfo)
+type DiskConfig map[string]DiskInfo
 
 // (poolname, diskConfig)
 type PoolConfig map[string]DiskConfig
@@ -55,6 +155,7 @@ type HostConfig map[string]HostInfo
 
 func New() *IscsiContainerConfig {
 	return &IscsiContainerConfig{
+		Version:    CurrentVersion,
 		TargetName: "",
 		Storage:    PoolConfig{},
 		Hosts:      HostConfig{},
@@ -70,8 +171,6 @@ func NewGlobals(globalopt GlobalOptions) GlobalConfig {
 	return GlobalConfig{
 		Options: IscsiOptions{
 			Glob_Host: globalopt.DefaultHostName,
-			Glob_User: globalopt.DefaultUser,
-			Glob_PWD:  globalopt.DefaultPassword,
 		},
 	}
 }
@@ -80,20 +179,36 @@ func NewEmptyDisk() DiskConfig {
 	return DiskConfig{}
 }
 
-func NewHostInfo(user, pwd string, luns []string) HostInfo {
+func NewDiskInfo(size, deletionPolicy string) DiskInfo {
+	return DiskInfo{
+		Size:           size,
+		DeletionPolicy: deletionPolicy,
+	}
+}
+
+// DiskKey returns the pool/disk form used to refer to a disk.
+func DiskKey(poolName, diskName string) string {
+	return poolName + "/" + diskName
+}
+
+func NewHostInfo(auth string, luns []string, ids map[string]int32) HostInfo {
 	return HostInfo{
-		User:     user,
-		Password: pwd,
-		Lun:      luns,
+		Auth:   auth,
+		Lun:    luns,
+		LunIDs: ids,
+	}
+}
+
+func NewCredentialConfig() *CredentialConfig {
+	return &CredentialConfig{
+		Hosts: map[string]Credentials{},
 	}
 }
 
 func GetLuns(luns []api.IscsiLunSpec) []string {
 	l := []string{}
 	for i := 0; i < len(luns); i++ {
-		poolName := luns[i].PoolName
-		diskName := luns[i].DiskName
-		lun := poolName + "/" + diskName
+		lun := DiskKey(luns[i].PoolName, luns[i].DiskName)
 		l = append(l, lun)
 	}
 	return l
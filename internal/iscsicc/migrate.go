@@ -0,0 +1,147 @@
+package iscsicc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// CurrentVersion is the version of the layout of the container config
+// written by the operator. Bump it along with a migration from the
+// previous layout whenever the layout changes in a way older configs can
+// not be decoded into.
+const CurrentVersion = 1
+
+// Migration converts a container config, decoded as generic JSON, from
+// the layout of version From to the layout of the next version.
+type Migration struct {
+	From        int
+	Description string
+	Migrate     func(doc map[string]interface{}) error
+}
+
+// migrations lists the migrations by the version they start from, the
+// configs written before the version field was added being version 0.
+var migrations = []Migration{
+	{
+		From:        0,
+		Description: "store disks as objects and drop plaintext credentials",
+		Migrate:     migrateUnversioned,
+	},
+}
+
+// Decode parses the JSON form of the container config. Configs written in
+// an older layout are migrated to the current one, fields unknown to the
+// current layout are rejected.
+func Decode(data []byte) (*IscsiContainerConfig, error) {
+	doc := map[string]interface{}{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+	version, err := docVersion(doc)
+	if err != nil {
+		return nil, err
+	}
+	if version > CurrentVersion {
+		return nil, fmt.Errorf(
+			"version %d is newer than the supported version %d",
+			version, CurrentVersion)
+	}
+	for v := version; v < CurrentVersion; v++ {
+		m, found := findMigration(v)
+		if !found {
+			return nil, fmt.Errorf("no migration from version %d", v)
+		}
+		if err := m.Migrate(doc); err != nil {
+			return nil, fmt.Errorf(
+				"unable to %s (version %d): %w", m.Description, v, err)
+		}
+	}
+	doc["version"] = CurrentVersion
+
+	migrated, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	cc := New()
+	dec = json.NewDecoder(bytes.NewReader(migrated))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cc); err != nil {
+		return nil, err
+	}
+	return cc, nil
+}
+
+func findMigration(from int) (Migration, bool) {
+	for _, m := range migrations {
+		if m.From == from {
+			return m, true
+		}
+	}
+	return Migration{}, false
+}
+
+func docVersion(doc map[string]interface{}) (int, error) {
+	v, found := doc["version"]
+	if !found {
+		return 0, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("invalid version %v", v)
+	}
+	version, err := n.Int64()
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("invalid version %s", n)
+	}
+	return int(version), nil
+}
+
+// migrateUnversioned converts the plain size string older configs stored
+// for each disk, and drops the credentials they kept in the global
+// options and in every host, now stored in a Secret.
+func migrateUnversioned(doc map[string]interface{}) error {
+	storages := []interface{}{doc["storage"]}
+	hostConfigs := []interface{}{doc["hosts"]}
+	if targets, ok := doc["targets"].(map[string]interface{}); ok {
+		for _, t := range targets {
+			if target, ok := t.(map[string]interface{}); ok {
+				storages = append(storages, target["storage"])
+				hostConfigs = append(hostConfigs, target["hosts"])
+			}
+		}
+	}
+	for _, storage := range storages {
+		pools, _ := storage.(map[string]interface{})
+		for _, p := range pools {
+			disks, _ := p.(map[string]interface{})
+			for name, d := range disks {
+				if size, ok := d.(string); ok {
+					disks[name] = map[string]interface{}{"size": size}
+				}
+			}
+		}
+	}
+
+	globals, _ := doc["globals"].(map[string]interface{})
+	for _, g := range globals {
+		global, _ := g.(map[string]interface{})
+		options, _ := global["options"].(map[string]interface{})
+		delete(options, Glob_User)
+		delete(options, Glob_PWD)
+	}
+	for _, hc := range hostConfigs {
+		hosts, _ := hc.(map[string]interface{})
+		for _, h := range hosts {
+			host, _ := h.(map[string]interface{})
+			delete(host, "user")
+			delete(host, "password")
+		}
+	}
+	return nil
+}
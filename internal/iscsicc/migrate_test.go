@@ -0,0 +1,99 @@
+package iscsicc
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Decode", func() {
+	It("migrates an unversioned config", func() {
+		cc, err := Decode([]byte(`{
+			"targetname": "iqn.2003-01.com.redhat.iscsi-gw:gw",
+			"storage": {"rbd": {"d1": "10G", "d2": {"size": "1G"}}},
+			"targets": {
+				"second": {"storage": {"rbd": {"d3": "2G"}}}
+			},
+			"globals": {"globals": {"options": {
+				"hostname": "iqn.0000.default:client",
+				"username": "user",
+				"password": "secret"
+			}}}
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cc.Version).To(Equal(CurrentVersion))
+		Expect(cc.Storage["rbd"]).To(Equal(DiskConfig{
+			"d1": {Size: "10G"},
+			"d2": {Size: "1G"},
+		}))
+		Expect(cc.Targets["second"].Storage["rbd"]["d3"].Size).To(Equal("2G"))
+		Expect(cc.Globals[Globals].Options).To(Equal(IscsiOptions{
+			Glob_Host: "iqn.0000.default:client",
+		}))
+	})
+
+	It("drops the credentials of the hosts of an unversioned config", func() {
+		cc, err := Decode([]byte(`{
+			"targetname": "iqn.2003-01.com.redhat.iscsi-gw:gw",
+			"storage": {"rbd": {"d1": "10G"}},
+			"hosts": {"iqn.2023-01.com.example:h1": {
+				"user": "h1",
+				"password": "secret1",
+				"lun": ["rbd/d1"]
+			}},
+			"targets": {
+				"second": {"hosts": {"iqn.2023-01.com.example:h2": {
+					"user": "h2",
+					"password": "secret2"
+				}}}
+			}
+		}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cc.Hosts).To(Equal(HostConfig{
+			"iqn.2023-01.com.example:h1": {Lun: []string{"rbd/d1"}},
+		}))
+		Expect(cc.Targets["second"].Hosts).To(Equal(HostConfig{
+			"iqn.2023-01.com.example:h2": {},
+		}))
+	})
+
+	It("decodes a config it encoded", func() {
+		cc := New()
+		cc.TargetName = "iqn.2003-01.com.redhat.iscsi-gw:gw"
+		cc.Storage["rbd"] = DiskConfig{"d1": NewDiskInfo("10G", "Delete")}
+		cc.Hosts["iqn.2023-01.com.example:h1"] = NewHostInfo(
+			AuthChap, []string{"rbd/d1"}, map[string]int32{"rbd/d1": 3})
+		cc.Purge = []string{"rbd/d0"}
+		data, err := json.Marshal(cc)
+		Expect(err).NotTo(HaveOccurred())
+
+		decoded, err := Decode(data)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decoded).To(Equal(cc))
+	})
+
+	DescribeTable("rejecting configs",
+		func(data string, msg string) {
+			_, err := Decode([]byte(data))
+			Expect(err).To(MatchError(ContainSubstring(msg)))
+		},
+		Entry("that are not JSON", `{"storage":`, "unexpected EOF"),
+		Entry("with an unknown field",
+			`{"version": 1, "bogus": true}`, `unknown field "bogus"`),
+		Entry("with a disk left as a string in the current layout",
+			`{"version": 1, "storage": {"rbd": {"d1": "10G"}}}`, "cannot unmarshal string"),
+		Entry("of a newer version",
+			`{"version": 2}`, "newer than the supported version"),
+		Entry("with an invalid version",
+			`{"version": "one"}`, "invalid version"),
+		Entry("with a negative version",
+			`{"version": -1}`, "invalid version"),
+	)
+
+	It("decodes an empty config", func() {
+		cc, err := Decode([]byte(`null`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cc.Version).To(Equal(CurrentVersion))
+	})
+})
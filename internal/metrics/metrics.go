@@ -0,0 +1,161 @@
+// Package metrics defines the Prometheus metrics of the operator. They are
+// registered with the registry of controller-runtime and served by the
+// metrics endpoint of the manager.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	metricNamespace  = "iscsi_operator"
+	gatewaySubsystem = "gateway"
+)
+
+// Results of a reconcile or of a phase of it.
+const (
+	ResultSuccess = "success"
+	ResultRequeue = "requeue"
+	ResultError   = "error"
+)
+
+var (
+	phaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricNamespace,
+		Subsystem: gatewaySubsystem,
+		Name:      "reconcile_phase_duration_seconds",
+		Help:      "Duration of the phases of the reconcile of the gateways, by result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"phase", "result"})
+
+	reconciles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: gatewaySubsystem,
+		Name:      "reconciles_total",
+		Help:      "Number of reconciles of the gateway, by result.",
+	}, []string{"namespace", "gateway", "result"})
+
+	requeues = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: gatewaySubsystem,
+		Name:      "requeues_total",
+		Help:      "Number of reconciles of the gateway requeued, by the phase asking for it.",
+	}, []string{"namespace", "gateway", "phase"})
+
+	configChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: gatewaySubsystem,
+		Name:      "config_changes_total",
+		Help:      "Number of changes made to the container config of the gateway, by kind.",
+	}, []string{"namespace", "gateway", "kind"})
+
+	pendingChanges = newGatewayGauge("pending_changes",
+		"Number of changes to the container config held back while the gateway is paused.")
+	targets = newGatewayGauge("targets",
+		"Number of targets served by the gateway.")
+	disks = newGatewayGauge("disks",
+		"Number of disks exported by the gateway.")
+	provisionedBytes = newGatewayGauge("provisioned_bytes",
+		"Total size of the disks exported by the gateway.")
+	mappedHosts = newGatewayGauge("mapped_hosts",
+		"Number of hosts with at least one LUN mapped.")
+	readyReplicas = newGatewayGauge("ready_replicas",
+		"Number of gateway replicas that are ready.")
+
+	gatewayVecs = []*prometheus.MetricVec{
+		reconciles.MetricVec,
+		requeues.MetricVec,
+		configChanges.MetricVec,
+		pendingChanges.MetricVec,
+		targets.MetricVec,
+		disks.MetricVec,
+		provisionedBytes.MetricVec,
+		mappedHosts.MetricVec,
+		readyReplicas.MetricVec,
+	}
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		phaseDuration,
+		reconciles,
+		requeues,
+		configChanges,
+		pendingChanges,
+		targets,
+		disks,
+		provisionedBytes,
+		mappedHosts,
+		readyReplicas,
+	)
+}
+
+func newGatewayGauge(name, help string) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricNamespace,
+		Subsystem: gatewaySubsystem,
+		Name:      name,
+		Help:      help,
+	}, []string{"namespace", "gateway"})
+}
+
+// Inventory is what the container config of a gateway exports.
+type Inventory struct {
+	Targets          int
+	Disks            int
+	ProvisionedBytes int64
+	MappedHosts      int
+}
+
+// ObservePhase records the duration and the result of a phase of the
+// reconcile of a gateway.
+func ObservePhase(phase, result string, d time.Duration) {
+	phaseDuration.WithLabelValues(phase, result).Observe(d.Seconds())
+}
+
+// CountReconcile counts a reconcile of the gateway.
+func CountReconcile(namespace, gateway, result string) {
+	reconciles.WithLabelValues(namespace, gateway, result).Inc()
+}
+
+// CountRequeue counts a reconcile of the gateway requeued by the phase.
+func CountRequeue(namespace, gateway, phase string) {
+	requeues.WithLabelValues(namespace, gateway, phase).Inc()
+}
+
+// CountChange counts a change of the kind made to the container config of
+// the gateway.
+func CountChange(namespace, gateway, kind string) {
+	configChanges.WithLabelValues(namespace, gateway, kind).Inc()
+}
+
+// SetPendingChanges records the changes held back while the gateway is
+// paused.
+func SetPendingChanges(namespace, gateway string, n int) {
+	pendingChanges.WithLabelValues(namespace, gateway).Set(float64(n))
+}
+
+// SetInventory records what the container config of the gateway exports.
+func SetInventory(namespace, gateway string, inv Inventory) {
+	targets.WithLabelValues(namespace, gateway).Set(float64(inv.Targets))
+	disks.WithLabelValues(namespace, gateway).Set(float64(inv.Disks))
+	provisionedBytes.WithLabelValues(namespace, gateway).Set(
+		float64(inv.ProvisionedBytes))
+	mappedHosts.WithLabelValues(namespace, gateway).Set(float64(inv.MappedHosts))
+}
+
+// SetReadyReplicas records the gateway replicas that are ready.
+func SetReadyReplicas(namespace, gateway string, n int32) {
+	readyReplicas.WithLabelValues(namespace, gateway).Set(float64(n))
+}
+
+// ForgetGateway drops the metrics of a deleted gateway.
+func ForgetGateway(namespace, gateway string) {
+	labels := prometheus.Labels{"namespace": namespace, "gateway": gateway}
+	for _, v := range gatewayVecs {
+		v.DeletePartialMatch(labels)
+	}
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("Gateway metrics", func() {
+	BeforeEach(func() {
+		DeferCleanup(func() {
+			ForgetGateway("storage", "gw")
+			ForgetGateway("storage", "other")
+		})
+	})
+
+	It("records the inventory of the gateway", func() {
+		SetInventory("storage", "gw", Inventory{
+			Targets:          2,
+			Disks:            3,
+			ProvisionedBytes: 1 << 30,
+			MappedHosts:      1,
+		})
+		Expect(testutil.ToFloat64(targets.WithLabelValues("storage", "gw"))).To(Equal(2.0))
+		Expect(testutil.ToFloat64(disks.WithLabelValues("storage", "gw"))).To(Equal(3.0))
+		Expect(testutil.ToFloat64(
+			provisionedBytes.WithLabelValues("storage", "gw"))).To(Equal(float64(1 << 30)))
+		Expect(testutil.ToFloat64(mappedHosts.WithLabelValues("storage", "gw"))).To(Equal(1.0))
+	})
+
+	It("counts reconciles, requeues and changes", func() {
+		CountReconcile("storage", "gw", ResultSuccess)
+		CountReconcile("storage", "gw", ResultSuccess)
+		CountReconcile("storage", "gw", ResultError)
+		CountRequeue("storage", "gw", "Drift")
+		CountChange("storage", "gw", "host")
+		Expect(testutil.ToFloat64(
+			reconciles.WithLabelValues("storage", "gw", ResultSuccess))).To(Equal(2.0))
+		Expect(testutil.ToFloat64(
+			reconciles.WithLabelValues("storage", "gw", ResultError))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(
+			requeues.WithLabelValues("storage", "gw", "Drift"))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(
+			configChanges.WithLabelValues("storage", "gw", "host"))).To(Equal(1.0))
+	})
+
+	It("observes the duration of the phases", func() {
+		// phaseDuration is shared by the gateways, so it is not forgotten
+		sampleCount := func() uint64 {
+			m := &dto.Metric{}
+			h := phaseDuration.WithLabelValues("Status", ResultRequeue)
+			Expect(h.(prometheus.Histogram).Write(m)).To(Succeed())
+			return m.GetHistogram().GetSampleCount()
+		}
+		before := sampleCount()
+		ObservePhase("Status", ResultRequeue, time.Second)
+		Expect(sampleCount()).To(Equal(before + 1))
+	})
+
+	It("forgets only the metrics of a deleted gateway", func() {
+		SetReadyReplicas("storage", "gw", 2)
+		SetPendingChanges("storage", "gw", 1)
+		CountReconcile("storage", "gw", ResultSuccess)
+		SetReadyReplicas("storage", "other", 1)
+
+		ForgetGateway("storage", "gw")
+		Expect(testutil.CollectAndCount(readyReplicas)).To(Equal(1))
+		Expect(testutil.CollectAndCount(pendingChanges)).To(Equal(0))
+		Expect(testutil.CollectAndCount(reconciles)).To(Equal(0))
+		Expect(testutil.ToFloat64(
+			readyReplicas.WithLabelValues("storage", "other"))).To(Equal(1.0))
+	})
+})
@@ -0,0 +1,101 @@
+package planner
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	// CephConfFile and CephKeyringFile are the names of the ceph
+	// configuration and keyring generated from a keyring Secret or a Rook
+	// cluster.
+	CephConfFile    = "ceph.conf"
+	CephKeyringFile = "keyring"
+
+	defaultCephClient = "admin"
+)
+
+// CephSecretName returns the name of the secret holding the ceph.conf and
+// keyring generated for the gateways of the named instance.
+func CephSecretName(instanceName string) string {
+	return instanceName + "-ceph"
+}
+
+// CephGenerated returns true if the operator generates the ceph.conf and
+// keyring of the gateways, rather than taking them from a ConfigMap.
+func (pl *Planner) CephGenerated() bool {
+	return pl.Iscsigateway.Spec.Ceph != nil
+}
+
+// CephSecretName returns the name of the secret holding the generated
+// ceph.conf and keyring.
+func (pl *Planner) CephSecretName() string {
+	return CephSecretName(pl.InstanceName())
+}
+
+// CephClientName returns the name, without the "client." prefix, of the
+// ceph client the gateways authenticate as.
+func (pl *Planner) CephClientName() string {
+	ceph := pl.Iscsigateway.Spec.Ceph
+	if ceph == nil || ceph.ClientName == "" {
+		return defaultCephClient
+	}
+	return ceph.ClientName
+}
+
+// RookNamespace returns the namespace of the Rook cluster of the gateway,
+// empty if it does not use Rook.
+func (pl *Planner) RookNamespace() string {
+	ceph := pl.Iscsigateway.Spec.Ceph
+	if ceph == nil || ceph.Rook == nil {
+		return ""
+	}
+	if ceph.Rook.Namespace != "" {
+		return ceph.Rook.Namespace
+	}
+	return pl.Iscsigateway.Namespace
+}
+
+// CephConf renders the ceph.conf of the gateways.
+func (pl *Planner) CephConf(fsid string, monitors []string) string {
+	var b strings.Builder
+	b.WriteString("# generated by iscsi-operator, do not edit\n")
+	b.WriteString("[global]\n")
+	if fsid != "" {
+		fmt.Fprintf(&b, "fsid = %s\n", fsid)
+	}
+	fmt.Fprintf(&b, "mon_host = %s\n", strings.Join(monitors, ","))
+	fmt.Fprintf(&b, "\n[client.%s]\n", pl.CephClientName())
+	fmt.Fprintf(&b, "keyring = %s\n",
+		path.Join(pl.CephMountPath(), CephKeyringFile))
+	return b.String()
+}
+
+// CephKeyringData renders the keyring of the client of the gateways.
+func (pl *Planner) CephKeyringData(key string) string {
+	return fmt.Sprintf("[client.%s]\n\tkey = %s\n", pl.CephClientName(), key)
+}
+
+// cephKeys returns the keys of the generated ceph secret, or of the ceph
+// ConfigMap if the ceph configuration is not generated.
+func (pl *Planner) cephKeys() []string {
+	keys := []string{}
+	if pl.CephGenerated() {
+		if pl.CephSecret != nil {
+			for k := range pl.CephSecret.Data {
+				keys = append(keys, k)
+			}
+		}
+		return keys
+	}
+	if pl.CephConfig != nil {
+		for k := range pl.CephConfig.Data {
+			keys = append(keys, k)
+		}
+		for k := range pl.CephConfig.BinaryData {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
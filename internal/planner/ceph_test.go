@@ -0,0 +1,104 @@
+package planner
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	api "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/conf"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Ceph config", func() {
+	var pl *Planner
+
+	BeforeEach(func() {
+		pl = newPlanner(newGateway(api.IscsigatewaySpec{
+			Ceph: &api.IscsiCephSpec{
+				KeyringSecretRef: &corev1.LocalObjectReference{Name: "keyring"},
+				Monitors:         []string{"10.0.0.1:6789"},
+			},
+		}), iscsicc.New())
+	})
+
+	It("is only generated with a ceph spec", func() {
+		Expect(pl.CephGenerated()).To(BeTrue())
+		Expect(pl.CephSecretName()).To(Equal("gw-ceph"))
+		pl.Iscsigateway.Spec.Ceph = nil
+		Expect(pl.CephGenerated()).To(BeFalse())
+	})
+
+	It("authenticates as the admin client by default", func() {
+		Expect(pl.CephClientName()).To(Equal("admin"))
+		pl.Iscsigateway.Spec.Ceph.ClientName = "iscsi"
+		Expect(pl.CephClientName()).To(Equal("iscsi"))
+		Expect(pl.CephKeyringData("AQB=")).To(Equal(
+			"[client.iscsi]\n\tkey = AQB=\n"))
+	})
+
+	It("renders the monitors and the keyring of the client", func() {
+		conf := pl.CephConf("fsid-1", []string{"10.0.0.1:6789", "10.0.0.2:6789"})
+		Expect(conf).To(ContainSubstring("fsid = fsid-1\n"))
+		Expect(conf).To(ContainSubstring("mon_host = 10.0.0.1:6789,10.0.0.2:6789\n"))
+		Expect(conf).To(ContainSubstring(
+			"[client.admin]\nkeyring = /etc/ceph/keyring\n"))
+		Expect(pl.CephConf("", nil)).NotTo(ContainSubstring("fsid"))
+	})
+
+	It("takes the Rook cluster from the namespace of the gateway by default", func() {
+		Expect(pl.RookNamespace()).To(BeEmpty())
+		pl.Iscsigateway.Spec.Ceph = &api.IscsiCephSpec{
+			Rook: &api.IscsiRookSpec{ClusterName: "ceph"},
+		}
+		Expect(pl.RookNamespace()).To(Equal("storage"))
+		pl.Iscsigateway.Spec.Ceph.Rook.Namespace = "rook-ceph"
+		Expect(pl.RookNamespace()).To(Equal("rook-ceph"))
+	})
+
+	It("tells the gateways the client and keyring to use", func() {
+		pl.CephSecret = &corev1.Secret{
+			Data: map[string][]byte{
+				CephConfFile:    []byte("[global]"),
+				CephKeyringFile: []byte("[client.admin]"),
+			},
+		}
+		pl.ApiSecret = &corev1.Secret{
+			Data: map[string][]byte{
+				corev1.BasicAuthUsernameKey: []byte("admin"),
+				corev1.BasicAuthPasswordKey: []byte("secret"),
+			},
+		}
+		cfg, err := pl.GatewayConfig(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg).To(ContainSubstring("gateway_keyring = keyring\n"))
+		Expect(cfg).To(ContainSubstring("cluster_client_name = client.admin\n"))
+	})
+
+	It("reaches the image of a disk with the ceph config of its gateway", func() {
+		cfg := conf.DefaultOperatorConfig
+		d := iscsidisk("d1", "1Gi")
+		dp := NewDiskPlanner(&d, pl.Iscsigateway, &cfg)
+		Expect(dp.CephSecretName()).To(Equal("gw-ceph"))
+		Expect(dp.HasCephConfig()).To(BeTrue())
+
+		d.Spec.CephConfig = "ceph-config"
+		Expect(dp.CephSecretName()).To(BeEmpty())
+		Expect(dp.HasCephConfig()).To(BeTrue())
+
+		d.Spec.CephConfig = ""
+		dp = NewDiskPlanner(&d, newGateway(api.IscsigatewaySpec{}), &cfg)
+		Expect(dp.HasCephConfig()).To(BeFalse())
+	})
+
+	It("takes the keyring from the ConfigMap without a ceph spec", func() {
+		pl.Iscsigateway.Spec.Ceph = nil
+		Expect(pl.CephKeyring()).To(BeEmpty())
+		pl.CephConfig = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "ceph-config"},
+			BinaryData: map[string][]byte{CephKeyringFile: []byte("key")},
+		}
+		Expect(pl.CephKeyring()).To(Equal(CephKeyringFile))
+	})
+})
@@ -1,9 +1,10 @@
 package planner
 
 import (
+	"fmt"
 	"strconv"
-	"time"
 
+	"github.com/Erichorng/iscsi-operator/internal/iqn"
 	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
 )
 
@@ -30,6 +31,20 @@ func sameStringSlice(x, y []string) bool {
 	return len(diff) == 0
 }
 
+// equalStringSlice returns true if x and y hold the same strings in the
+// same order.
+func equalStringSlice(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func exist(ss string, l []string) bool {
 	for _, s := range l {
 		if s == ss {
@@ -39,23 +54,51 @@ func exist(ss string, l []string) bool {
 	return false
 }
 
-func (pl *Planner) targetName() string {
-	year := strconv.Itoa(time.Now().Year())
-	day := strconv.Itoa(time.Now().Day())
-	prefix := "iqn." + year + "-" + day + ".com.redhat.iscsi-gw:"
-	if pl.Iscsigateway.Spec.TargetName == "" {
-		return prefix + pl.Iscsigateway.Name // Or default name?
-	} else if checkValidTargetName(pl.Iscsigateway.Spec.TargetName) {
-		return pl.Iscsigateway.Spec.TargetName
-	} else {
-		return prefix + pl.Iscsigateway.Name // Or default name?
+func remove(ss string, l []string) []string {
+	out := []string{}
+	for _, s := range l {
+		if s != ss {
+			out = append(out, s)
+		}
 	}
+	return out
+}
+
+// targetName returns the IQN of the target. A name given in the spec is
+// used as is, otherwise a name is generated once under the naming
+// authority of the operator and kept, through the status and the
+// container config, for the life of the gateway. A legacy target name,
+// which is not an iSCSI name, is treated as unset.
+func (pl *Planner) targetName() (string, error) {
+	ig := pl.Iscsigateway
+	_, legacy := pl.legacyTargetName()
+	if ig.Spec.TargetName != "" && !legacy {
+		if !checkValidTargetName(ig.Spec.TargetName) {
+			return "", fmt.Errorf(
+				"invalid target name %q", ig.Spec.TargetName)
+		}
+		return ig.Spec.TargetName, nil
+	}
+	if checkValidTargetName(ig.Status.TargetName) {
+		return ig.Status.TargetName, nil
+	}
+	if checkValidTargetName(pl.ConfigState.TargetName) {
+		return pl.ConfigState.TargetName, nil
+	}
+	id := ig.Namespace + "." + ig.Name
+	if pl.target != "" {
+		id += "." + pl.target
+	}
+	return iqn.Generate(pl.GlobalConfig.NamingAuthority, id)
 }
 
 func (pl *Planner) Update() (changed bool, err error) {
-	// set target name
-	targetName := pl.targetName()
-	pl.ConfigState.TargetName = targetName
+	before := copyConfig(pl.ConfigState)
+	pl.changes = nil
+	changed, err = pl.updateTarget()
+	if err != nil {
+		return false, err
+	}
 
 	// set global config
 	// if the global section in the container config not found,
@@ -64,8 +107,6 @@ func (pl *Planner) Update() (changed bool, err error) {
 	if !found {
 		globalOptions := iscsicc.NewGlobalOptions()
 		globalOptions.DefaultHostName = pl.GlobalConfig.Hostname
-		globalOptions.DefaultPassword = pl.GlobalConfig.Password
-		globalOptions.DefaultUser = pl.GlobalConfig.User
 		globals = iscsicc.NewGlobals(globalOptions)
 		pl.ConfigState.Globals[iscsicc.Globals] = globals
 		changed = true
@@ -78,131 +119,82 @@ func (pl *Planner) Update() (changed bool, err error) {
 		pl.ConfigState.Globals[iscsicc.Globals] = globals
 		changed = true
 	}
-	if globals.Options[iscsicc.Glob_User] != pl.GlobalConfig.User {
-		globals.Options[iscsicc.Glob_User] = pl.GlobalConfig.User
-		pl.ConfigState.Globals[iscsicc.Globals] = globals
-		changed = true
-	}
-	if globals.Options[iscsicc.Glob_PWD] != pl.GlobalConfig.Password {
-		globals.Options[iscsicc.Glob_PWD] = pl.GlobalConfig.Password
-		pl.ConfigState.Globals[iscsicc.Globals] = globals
-		changed = true
+	// authentication settings of the target
+	authOptions := map[string]string{
+		iscsicc.Glob_DiscoveryAuth:     pl.discoveryAuth(),
+		iscsicc.Glob_MutualChapRequire: strconv.FormatBool(pl.mutualChapRequired()),
 	}
-
-	// Storage section
-
-	for i := 0; i < len(pl.Iscsigateway.Spec.Storage); i++ {
-		goalPoolName := pl.Iscsigateway.Spec.Storage[i].PoolName
-		_, found := pl.ConfigState.Storage[goalPoolName]
-		// if we have new pool, add it to container config with all it's disk config
-		if !found {
-			// add pool with no disk
-			pl.ConfigState.Storage[goalPoolName] = iscsicc.NewEmptyDisk()
-			// add disks
-			disks := pl.Iscsigateway.Spec.Storage[i].Disks
-			for d := 0; d < len(disks); d++ {
-				diskName := disks[d].DiskName
-				diskSize := disks[d].DiskSize
-				pl.ConfigState.Storage[goalPoolName][diskName] = diskSize
-			}
+	for k, v := range authOptions {
+		if globals.Options[k] != v {
+			globals.Options[k] = v
+			pl.ConfigState.Globals[iscsicc.Globals] = globals
 			changed = true
-			continue
 		}
+	}
 
-		//if it is an old pool, see if we have new disk or disk resize
-		for j := 0; j < len(pl.Iscsigateway.Spec.Storage[i].Disks); j++ {
-			goalDiskName := pl.Iscsigateway.Spec.Storage[i].Disks[j].DiskName
-			goalDiskSize := pl.Iscsigateway.Spec.Storage[i].Disks[j].DiskSize
-			_, found := pl.ConfigState.Storage[goalPoolName][goalDiskName]
-
-			if !found {
-				pl.ConfigState.Storage[goalPoolName][goalDiskName] = goalDiskSize
-				changed = true
-				continue
-			}
-			// if the disk exist but the size have changed
-
-			if pl.ConfigState.Storage[goalPoolName][goalDiskName] != goalDiskSize {
-				pl.ConfigState.Storage[goalPoolName][goalDiskName] = goalDiskSize
-				changed = true
-			}
-		}
-		// if disk removed
-		allDisk := pl.ConfigState.Storage[goalPoolName]
-		new_disk_list := make([]string, len(pl.Iscsigateway.Spec.Storage[i].Disks))
-		for idx, w := range pl.Iscsigateway.Spec.Storage[i].Disks {
-			new_disk_list[idx] = w.DiskName
-		}
-		for k := range allDisk {
-			if !exist(k, new_disk_list) {
-				delete(pl.ConfigState.Storage[goalPoolName], k)
-				changed = true
-			}
-		}
+	// additional targets
+	targetsChanged, err := pl.updateTargets()
+	if err != nil {
+		return false, err
 	}
+	changed = changed || targetsChanged
 
-	//check if there are pools removed
-	new_pool_list := make([]string, len(pl.Iscsigateway.Spec.Storage))
-	for i := 0; i < len(pl.Iscsigateway.Spec.Storage); i++ {
-		new_pool_list[i] = pl.Iscsigateway.Spec.Storage[i].PoolName
+	// gateways of the target portal group
+	if pl.updateGateways() {
+		changed = true
 	}
-	for k := range pl.ConfigState.Storage {
-		if !exist(k, new_pool_list) {
-			// remove the pool from configstate
-			delete(pl.ConfigState.Storage, k)
-			changed = true
-		}
+	if pl.updatePortals() {
+		changed = true
 	}
+	pl.changes = diffConfig(before, pl.ConfigState)
 
-	// host section
-
-	// if new host
-	for i := 0; i < len(pl.Iscsigateway.Spec.Hosts); i++ {
-		goalHostname := pl.Iscsigateway.Spec.Hosts[i].HostName
-		goalUser := pl.Iscsigateway.Spec.Hosts[i].Username
-		goalPwd := pl.Iscsigateway.Spec.Hosts[i].Password
-		goallun := iscsicc.GetLuns(pl.Iscsigateway.Spec.Hosts[i].Luns)
+	return
+}
 
-		_, found := pl.ConfigState.Hosts[goalHostname]
-		if !found {
-			pl.ConfigState.Hosts[goalHostname] = iscsicc.NewHostInfo(goalUser, goalPwd, goallun)
-			changed = true
-		}
+// updateTarget brings the target name, hosts and storage of the container
+// config in line with the spec.
+func (pl *Planner) updateTarget() (changed bool, err error) {
+	if err = pl.validateAuth(); err != nil {
+		return false, err
+	}
+	if err = pl.validateStorage(); err != nil {
+		return false, err
+	}
+	if err = pl.validateLunIDs(); err != nil {
+		return false, err
+	}
+	if err = pl.validateHostGroups(); err != nil {
+		return false, err
+	}
 
-		// if found but lun change (can user, pwd change? seems like yes)
-
-		if host, found := pl.ConfigState.Hosts[goalHostname]; found {
-			if host.User != goalUser {
-				host.User = goalUser
-				//pl.ConfigState.Hosts[goalHostname] = host
-				changed = true
-			}
-			if host.Password != goalPwd {
-				host.Password = goalPwd
-				//pl.ConfigState.Hosts[goalHostname] = host
-				changed = true
-			}
-			if !sameStringSlice(host.Lun, goallun) {
-				host.Lun = goallun
-				//pl.ConfigState.Hosts[goalHostname] = host
-				changed = true
-			}
-			pl.ConfigState.Hosts[goalHostname] = host
-		}
+	// set target name
+	targetName, err := pl.targetName()
+	if err != nil {
+		return false, err
+	}
+	if pl.ConfigState.TargetName != targetName {
+		pl.ConfigState.TargetName = targetName
+		changed = true
 	}
 
-	new_host_list := make([]string, len(pl.Iscsigateway.Spec.Hosts))
-	for k := range pl.ConfigState.Hosts {
-		if !exist(k, new_host_list) {
-			delete(pl.ConfigState.Hosts, k)
-			changed = true
-		}
+	// host section, ahead of the storage so that the LUNs of hosts
+	// pending removal are kept. The groups go first as their members take
+	// the LUN ids of the group.
+	if pl.updateHostGroups() {
+		changed = true
+	}
+	if pl.updateHosts() {
+		changed = true
 	}
 
-	return
+	// Storage section
+	storageChanged, err := pl.updateStorage()
+	if err != nil {
+		return false, err
+	}
+	return changed || storageChanged, nil
 }
 
-func checkValidTargetName(string) bool {
-	// TODO
-	return true
+func checkValidTargetName(name string) bool {
+	return iqn.Validate(name) == nil
 }
@@ -0,0 +1,205 @@
+package planner
+
+import (
+	"fmt"
+	"regexp"
+
+	api "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// CHAP credentials accepted by the gateway. The limits match the ones
+// enforced by ceph-iscsi.
+var (
+	chapUserRegexp     = regexp.MustCompile(`^[\w.:@_-]{8,64}$`)
+	chapPasswordRegexp = regexp.MustCompile(`^[\w@\-_/]{12,16}$`)
+)
+
+// ChapSecretNames returns the names of all the secrets holding CHAP
+// credentials used by the gateway and its additional targets. The secrets
+// of Iscsiinitiators are named by their InitiatorSecretKey.
+func (pl *Planner) ChapSecretNames() []string {
+	names := []string{}
+	add := func(name string) {
+		if name != "" && !exist(name, names) {
+			names = append(names, name)
+		}
+	}
+	addSpec := func(chap *api.IscsiChapSpec) {
+		if chap == nil {
+			return
+		}
+		add(chap.SecretRef.Name)
+		if chap.MutualSecretRef != nil {
+			add(chap.MutualSecretRef.Name)
+		}
+	}
+
+	add(pl.GlobalConfig.ChapSecret)
+	add(pl.GlobalConfig.MutualChapSecret)
+	if auth := pl.Iscsigateway.Spec.Auth; auth != nil {
+		addSpec(auth.Discovery)
+	}
+	for _, h := range pl.Iscsigateway.Spec.Hosts {
+		addSpec(h.Chap)
+	}
+	for _, g := range pl.Iscsigateway.Spec.HostGroups {
+		addSpec(g.Chap)
+	}
+	for i := range pl.Initiators {
+		addSpec(initiatorHostSpec(&pl.Initiators[i]).Chap)
+	}
+	for _, t := range pl.Iscsigateway.Spec.Targets {
+		for _, name := range pl.targetPlanner(t).ChapSecretNames() {
+			add(name)
+		}
+	}
+	return names
+}
+
+// Credentials returns the CHAP credentials of the gateway, resolved from
+// the secrets referenced by the spec and the operator config.
+func (pl *Planner) Credentials() *iscsicc.CredentialConfig {
+	cred := iscsicc.NewCredentialConfig()
+	cred.Globals = pl.globalCredentials()
+	if auth := pl.Iscsigateway.Spec.Auth; auth != nil && auth.Discovery != nil {
+		cred.Discovery = pl.specCredentials(auth.Discovery)
+	}
+	for _, h := range pl.hostSpecs() {
+		if h.Chap == nil {
+			continue
+		}
+		cred.Hosts[h.HostName] = pl.specCredentials(h.Chap)
+	}
+	for _, t := range pl.Iscsigateway.Spec.Targets {
+		hosts := pl.targetPlanner(t).Credentials().Hosts
+		if len(hosts) == 0 {
+			continue
+		}
+		if cred.Targets == nil {
+			cred.Targets = map[string]map[string]iscsicc.Credentials{}
+		}
+		cred.Targets[t.Name] = hosts
+	}
+	return cred
+}
+
+// hostCredentials returns the credentials the host authenticates with,
+// falling back to the defaults of the operator.
+func (pl *Planner) hostCredentials(h api.IscsiHostSpec) iscsicc.Credentials {
+	if h.Chap == nil {
+		return pl.globalCredentials()
+	}
+	return pl.specCredentials(h.Chap)
+}
+
+func (pl *Planner) globalCredentials() iscsicc.Credentials {
+	cred := pl.chapCredentials(pl.GlobalConfig.ChapSecret)
+	if cred.User != "" && pl.GlobalConfig.MutualChapSecret != "" {
+		mutual := pl.chapCredentials(pl.GlobalConfig.MutualChapSecret)
+		cred.MutualUser = mutual.User
+		cred.MutualPassword = mutual.Password
+	}
+	return cred
+}
+
+func (pl *Planner) specCredentials(chap *api.IscsiChapSpec) iscsicc.Credentials {
+	cred := pl.chapCredentials(chap.SecretRef.Name)
+	if chap.MutualSecretRef != nil {
+		mutual := pl.chapCredentials(chap.MutualSecretRef.Name)
+		cred.MutualUser = mutual.User
+		cred.MutualPassword = mutual.Password
+	}
+	return cred
+}
+
+func (pl *Planner) chapCredentials(secretName string) iscsicc.Credentials {
+	secret, found := pl.ChapSecrets[secretName]
+	if !found {
+		return iscsicc.Credentials{}
+	}
+	return iscsicc.Credentials{
+		User:     string(secret.Data[corev1.BasicAuthUsernameKey]),
+		Password: string(secret.Data[corev1.BasicAuthPasswordKey]),
+	}
+}
+
+// discoveryAuth returns the authentication mode of discovery sessions.
+func (pl *Planner) discoveryAuth() string {
+	auth := pl.Iscsigateway.Spec.Auth
+	if auth == nil || auth.Discovery == nil {
+		return iscsicc.AuthNone
+	}
+	return pl.specCredentials(auth.Discovery).Mode()
+}
+
+func (pl *Planner) mutualChapRequired() bool {
+	auth := pl.Iscsigateway.Spec.Auth
+	return auth != nil && auth.RequireMutualChap
+}
+
+// validateAuth checks the CHAP settings of the target and every host.
+func (pl *Planner) validateAuth() error {
+	if pl.GlobalConfig.ChapSecret != "" {
+		if err := checkCredentials(pl.globalCredentials()); err != nil {
+			return fmt.Errorf("default credentials: %w", err)
+		}
+	}
+	if auth := pl.Iscsigateway.Spec.Auth; auth != nil && auth.Discovery != nil {
+		if err := checkCredentials(pl.specCredentials(auth.Discovery)); err != nil {
+			return fmt.Errorf("discovery credentials: %w", err)
+		}
+	}
+	for _, h := range pl.Iscsigateway.Spec.Hosts {
+		if err := pl.validateHostAuth(h); err != nil {
+			return fmt.Errorf("host %s: %w", h.HostName, err)
+		}
+	}
+	for _, g := range pl.Iscsigateway.Spec.HostGroups {
+		err := pl.validateHostAuth(api.IscsiHostSpec{Chap: g.Chap})
+		if err != nil {
+			return fmt.Errorf("host group %s: %w", g.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateHostAuth checks the CHAP settings of a host.
+func (pl *Planner) validateHostAuth(h api.IscsiHostSpec) error {
+	cred := pl.hostCredentials(h)
+	if pl.mutualChapRequired() && cred.Mode() != iscsicc.AuthMutualChap {
+		return fmt.Errorf("mutual CHAP is required by the target")
+	}
+	if h.Chap == nil {
+		return nil
+	}
+	return checkCredentials(cred)
+}
+
+func checkCredentials(cred iscsicc.Credentials) error {
+	if !chapUserRegexp.MatchString(cred.User) {
+		return fmt.Errorf(
+			"CHAP user name must be 8 to 64 characters of [A-Za-z0-9_.:@-]")
+	}
+	if !chapPasswordRegexp.MatchString(cred.Password) {
+		return fmt.Errorf(
+			"CHAP password must be 12 to 16 characters of [A-Za-z0-9_@/-]")
+	}
+	if cred.Mode() != iscsicc.AuthMutualChap {
+		return nil
+	}
+	if !chapUserRegexp.MatchString(cred.MutualUser) {
+		return fmt.Errorf(
+			"mutual CHAP user name must be 8 to 64 characters of [A-Za-z0-9_.:@-]")
+	}
+	if !chapPasswordRegexp.MatchString(cred.MutualPassword) {
+		return fmt.Errorf(
+			"mutual CHAP password must be 12 to 16 characters of [A-Za-z0-9_@/-]")
+	}
+	if cred.MutualPassword == cred.Password {
+		return fmt.Errorf(
+			"mutual CHAP password must differ from the initiator password")
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package planner
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	api "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func chapSecret(name, user, password string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "storage"},
+		Type:       corev1.SecretTypeBasicAuth,
+		Data: map[string][]byte{
+			corev1.BasicAuthUsernameKey: []byte(user),
+			corev1.BasicAuthPasswordKey: []byte(password),
+		},
+	}
+}
+
+func chapSpec(secret, mutual string) *api.IscsiChapSpec {
+	chap := &api.IscsiChapSpec{
+		SecretRef: corev1.LocalObjectReference{Name: secret},
+	}
+	if mutual != "" {
+		chap.MutualSecretRef = &corev1.LocalObjectReference{Name: mutual}
+	}
+	return chap
+}
+
+func chapHost(name, secret, mutual string) api.IscsiHostSpec {
+	h := api.IscsiHostSpec{HostName: name}
+	if secret != "" {
+		h.Chap = chapSpec(secret, mutual)
+	}
+	return h
+}
+
+var _ = Describe("Credentials", func() {
+	var pl *Planner
+
+	BeforeEach(func() {
+		pl = newPlanner(newGateway(api.IscsigatewaySpec{
+			Hosts: []api.IscsiHostSpec{
+				chapHost("iqn.2023-01.com.example:h1", "h1-chap", "h1-mutual"),
+				chapHost("iqn.2023-01.com.example:h2", "shared-chap", ""),
+				chapHost("iqn.2023-01.com.example:h3", "shared-chap", ""),
+				chapHost("iqn.2023-01.com.example:h4", "", ""),
+			},
+		}), iscsicc.New())
+		pl.GlobalConfig.ChapSecret = "default-chap"
+		pl.ChapSecrets = map[string]*corev1.Secret{
+			"default-chap": chapSecret("default-chap", "gateway-admin", "default-pass0"),
+			"h1-chap":      chapSecret("h1-chap", "initiator-h1", "h1-password0"),
+			"h1-mutual":    chapSecret("h1-mutual", "target-h1", "h1-password1"),
+			"shared-chap":  chapSecret("shared-chap", "initiator-shared", "shared-pass0"),
+			"discovery":    chapSecret("discovery", "discovery-user", "discovery-p0"),
+		}
+	})
+
+	It("lists each referenced secret once", func() {
+		Expect(pl.ChapSecretNames()).To(Equal(
+			[]string{"default-chap", "h1-chap", "h1-mutual", "shared-chap"}))
+	})
+
+	It("resolves the credentials from the secrets", func() {
+		cred := pl.Credentials()
+		Expect(cred.Globals).To(Equal(iscsicc.Credentials{
+			User: "gateway-admin", Password: "default-pass0"}))
+		Expect(cred.Hosts).To(Equal(map[string]iscsicc.Credentials{
+			"iqn.2023-01.com.example:h1": {
+				User:           "initiator-h1",
+				Password:       "h1-password0",
+				MutualUser:     "target-h1",
+				MutualPassword: "h1-password1",
+			},
+			"iqn.2023-01.com.example:h2": {
+				User: "initiator-shared", Password: "shared-pass0"},
+			"iqn.2023-01.com.example:h3": {
+				User: "initiator-shared", Password: "shared-pass0"},
+		}))
+	})
+
+	It("leaves the credentials of a missing secret empty", func() {
+		delete(pl.ChapSecrets, "h1-chap")
+		Expect(pl.Credentials().Hosts["iqn.2023-01.com.example:h1"].User).To(
+			BeEmpty())
+	})
+
+	DescribeTable("choosing the authentication mode of a host",
+		func(index int, mode string) {
+			h := pl.Iscsigateway.Spec.Hosts[index]
+			Expect(pl.hostCredentials(h).Mode()).To(Equal(mode))
+		},
+		Entry("uses mutual CHAP with a mutual secret", 0, iscsicc.AuthMutualChap),
+		Entry("uses CHAP with a secret", 1, iscsicc.AuthChap),
+		Entry("falls back to the default credentials", 3, iscsicc.AuthChap),
+	)
+
+	It("leaves a host without credentials unauthenticated", func() {
+		pl.GlobalConfig.ChapSecret = ""
+		h := pl.Iscsigateway.Spec.Hosts[3]
+		Expect(pl.hostCredentials(h).Mode()).To(Equal(iscsicc.AuthNone))
+	})
+
+	It("renders the discovery authentication of the target", func() {
+		pl.Iscsigateway.Spec.Auth = &api.IscsiTargetAuthSpec{
+			Discovery: chapSpec("discovery", ""),
+		}
+		Expect(pl.ChapSecretNames()).To(ContainElement("discovery"))
+		Expect(pl.Credentials().Discovery.User).To(Equal("discovery-user"))
+
+		_, err := pl.Update()
+		Expect(err).NotTo(HaveOccurred())
+		options := pl.ConfigState.Globals[iscsicc.Globals].Options
+		Expect(options).To(HaveKeyWithValue(
+			iscsicc.Glob_DiscoveryAuth, iscsicc.AuthChap))
+		Expect(options).To(HaveKeyWithValue(
+			iscsicc.Glob_MutualChapRequire, "false"))
+	})
+
+	DescribeTable("validating credentials",
+		func(setup func(pl *Planner), msg string) {
+			setup(pl)
+			_, err := pl.Update()
+			Expect(err).To(MatchError(ContainSubstring(msg)))
+		},
+		Entry("rejects a short user name", func(pl *Planner) {
+			pl.ChapSecrets["h1-chap"] = chapSecret("h1-chap", "h1", "h1-password0")
+		}, "host iqn.2023-01.com.example:h1: CHAP user name"),
+		Entry("rejects a short password", func(pl *Planner) {
+			pl.ChapSecrets["shared-chap"] = chapSecret(
+				"shared-chap", "initiator-shared", "secret")
+		}, "host iqn.2023-01.com.example:h2: CHAP password"),
+		Entry("rejects a mutual password equal to the initiator one", func(pl *Planner) {
+			pl.ChapSecrets["h1-mutual"] = chapSecret(
+				"h1-mutual", "target-h1", "h1-password0")
+		}, "mutual CHAP password must differ"),
+		Entry("rejects invalid default credentials", func(pl *Planner) {
+			pl.ChapSecrets["default-chap"] = chapSecret(
+				"default-chap", "gateway-admin", "1234")
+		}, "default credentials: CHAP password"),
+		Entry("rejects invalid discovery credentials", func(pl *Planner) {
+			pl.ChapSecrets["discovery"] = chapSecret("discovery", "d", "discovery-p0")
+			pl.Iscsigateway.Spec.Auth = &api.IscsiTargetAuthSpec{
+				Discovery: chapSpec("discovery", ""),
+			}
+		}, "discovery credentials: CHAP user name"),
+		Entry("rejects a one way host when mutual CHAP is required", func(pl *Planner) {
+			pl.Iscsigateway.Spec.Auth = &api.IscsiTargetAuthSpec{
+				RequireMutualChap: true,
+			}
+		}, "host iqn.2023-01.com.example:h2: mutual CHAP is required"),
+	)
+})
@@ -0,0 +1,139 @@
+package planner
+
+import (
+	"strconv"
+	"strings"
+
+	api "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/conf"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const mebibyte = int64(1024 * 1024)
+
+// rbdSyncScript creates the RBD image if it is missing, grows it when
+// asked to, and reports its state through the termination message of the
+// container.
+const rbdSyncScript = `set -e
+if ! rbd info "$RBD_IMAGE" >/dev/null 2>&1; then
+  rbd create "$RBD_IMAGE" --size "$RBD_SIZE" $RBD_FEATURES
+elif [ -n "$RBD_RESIZE" ]; then
+  rbd resize "$RBD_IMAGE" --size "$RBD_SIZE"
+fi
+rbd info --format json "$RBD_IMAGE" > /dev/termination-log
+echo >> /dev/termination-log
+rbd status --format json "$RBD_IMAGE" >> /dev/termination-log
+`
+
+// rbdRemoveScript deletes the RBD image, succeeding if it is already gone.
+const rbdRemoveScript = `set -e
+if rbd info "$RBD_IMAGE" >/dev/null 2>&1; then
+  rbd rm --no-progress "$RBD_IMAGE"
+fi
+`
+
+// DiskPlanner plans the RBD image operations of an Iscsidisk.
+type DiskPlanner struct {
+	Iscsidisk *api.Iscsidisk
+	// Gateway is the gateway referenced by the disk, nil if it has none.
+	Gateway      *api.Iscsigateway
+	GlobalConfig *conf.OperatorConfig
+}
+
+func NewDiskPlanner(
+	disk *api.Iscsidisk,
+	gateway *api.Iscsigateway,
+	cfg *conf.OperatorConfig) *DiskPlanner {
+	return &DiskPlanner{
+		Iscsidisk:    disk,
+		Gateway:      gateway,
+		GlobalConfig: cfg,
+	}
+}
+
+// CephConfigName returns the name of the ConfigMap with the ceph
+// configuration used to reach the image.
+func (dp *DiskPlanner) CephConfigName() string {
+	if dp.Iscsidisk.Spec.CephConfig != "" {
+		return dp.Iscsidisk.Spec.CephConfig
+	}
+	if dp.Gateway != nil {
+		return dp.Gateway.Spec.CephConfig
+	}
+	return ""
+}
+
+// CephSecretName returns the name of the Secret with the ceph
+// configuration generated for the gateway of the disk, empty if the disk
+// uses a ConfigMap or its gateway does not generate one.
+func (dp *DiskPlanner) CephSecretName() string {
+	if dp.Iscsidisk.Spec.CephConfig != "" || dp.Gateway == nil ||
+		dp.Gateway.Spec.Ceph == nil {
+		return ""
+	}
+	return CephSecretName(dp.Gateway.Name)
+}
+
+// HasCephConfig returns true if a ceph configuration is known to reach the
+// image with.
+func (dp *DiskPlanner) HasCephConfig() bool {
+	return dp.CephConfigName() != "" || dp.CephSecretName() != ""
+}
+
+func (dp *DiskPlanner) CephMountPath() string {
+	return "/etc/ceph"
+}
+
+// ImageSpec returns the pool/image form the rbd tool refers to the image
+// by. It is also the LUN a gateway host maps the disk with.
+func (dp *DiskPlanner) ImageSpec() string {
+	return iscsicc.DiskKey(dp.Iscsidisk.Spec.Pool, dp.Iscsidisk.ImageName())
+}
+
+// SizeMiB returns the size of the image in MiB, the unit of the rbd tool,
+// rounded up.
+func (dp *DiskPlanner) SizeMiB() int64 {
+	size := dp.Iscsidisk.Spec.Size.Value()
+	return (size + mebibyte - 1) / mebibyte
+}
+
+// NeedsResize returns true if the image is known to be smaller than the
+// spec.
+func (dp *DiskPlanner) NeedsResize() bool {
+	actual := dp.Iscsidisk.Status.Size
+	return actual != nil && actual.Value() < dp.SizeMiB()*mebibyte
+}
+
+// ReclaimImage returns true if the image is deleted with the disk.
+func (dp *DiskPlanner) ReclaimImage() bool {
+	return dp.Iscsidisk.Spec.ReclaimPolicy == api.DiskDeletionDelete
+}
+
+// SyncCommand returns the command creating or growing the image and
+// reporting its state.
+func (dp *DiskPlanner) SyncCommand() []string {
+	return []string{"/bin/sh", "-c", rbdSyncScript}
+}
+
+// RemoveCommand returns the command deleting the image.
+func (dp *DiskPlanner) RemoveCommand() []string {
+	return []string{"/bin/sh", "-c", rbdRemoveScript}
+}
+
+// Env returns the environment of the rbd commands.
+func (dp *DiskPlanner) Env(resize bool) []corev1.EnvVar {
+	features := []string{}
+	for _, f := range dp.Iscsidisk.Spec.Features {
+		features = append(features, "--image-feature="+f)
+	}
+	env := []corev1.EnvVar{
+		{Name: "RBD_IMAGE", Value: dp.ImageSpec()},
+		{Name: "RBD_SIZE", Value: strconv.FormatInt(dp.SizeMiB(), 10)},
+		{Name: "RBD_FEATURES", Value: strings.Join(features, " ")},
+	}
+	if resize {
+		env = append(env, corev1.EnvVar{Name: "RBD_RESIZE", Value: "1"})
+	}
+	return env
+}
@@ -0,0 +1,187 @@
+package planner
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	api "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/conf"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// iscsidisk returns an Iscsidisk of the rbd pool exported by gateway gw.
+func iscsidisk(name, size string) api.Iscsidisk {
+	return api.Iscsidisk{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "storage"},
+		Spec: api.IscsidiskSpec{
+			Pool:       "rbd",
+			Size:       resource.MustParse(size),
+			GatewayRef: &corev1.LocalObjectReference{Name: "gw"},
+		},
+	}
+}
+
+// planDisks runs the planner of the gateway, exporting the Iscsidisks,
+// against cc.
+func planDisks(
+	ig *api.Iscsigateway,
+	cc *iscsicc.IscsiContainerConfig,
+	disks ...api.Iscsidisk) error {
+
+	pl := newPlanner(ig, cc)
+	pl.Disks = disks
+	_, err := pl.Update()
+	return err
+}
+
+var _ = Describe("Iscsidisk", func() {
+	var cfg conf.OperatorConfig
+
+	BeforeEach(func() {
+		cfg = conf.DefaultOperatorConfig
+	})
+
+	Describe("planning the image", func() {
+		It("names the image after the disk by default", func() {
+			d := iscsidisk("d1", "1Gi")
+			Expect(NewDiskPlanner(&d, nil, &cfg).ImageSpec()).To(Equal("rbd/d1"))
+			d.Spec.Image = "img"
+			Expect(NewDiskPlanner(&d, nil, &cfg).ImageSpec()).To(Equal("rbd/img"))
+		})
+
+		It("uses the ceph config of the gateway unless set", func() {
+			d := iscsidisk("d1", "1Gi")
+			Expect(NewDiskPlanner(&d, nil, &cfg).CephConfigName()).To(BeEmpty())
+			gw := newGateway(api.IscsigatewaySpec{CephConfig: "ceph-config"})
+			Expect(NewDiskPlanner(&d, gw, &cfg).CephConfigName()).To(Equal("ceph-config"))
+			d.Spec.CephConfig = "other"
+			Expect(NewDiskPlanner(&d, gw, &cfg).CephConfigName()).To(Equal("other"))
+		})
+
+		It("rounds the size up to MiB", func() {
+			d := iscsidisk("d1", "1Gi")
+			Expect(NewDiskPlanner(&d, nil, &cfg).SizeMiB()).To(Equal(int64(1024)))
+			d.Spec.Size = resource.MustParse("1048577")
+			Expect(NewDiskPlanner(&d, nil, &cfg).SizeMiB()).To(Equal(int64(2)))
+		})
+
+		DescribeTable("resizing the image",
+			func(actual string, resize bool) {
+				d := iscsidisk("d1", "2Gi")
+				if actual != "" {
+					size := resource.MustParse(actual)
+					d.Status.Size = &size
+				}
+				Expect(NewDiskPlanner(&d, nil, &cfg).NeedsResize()).To(Equal(resize))
+			},
+			Entry("is not needed before the image is known", "", false),
+			Entry("is needed for a smaller image", "1Gi", true),
+			Entry("is not needed for an image of the size", "2Gi", false),
+			Entry("is not needed for a larger image", "3Gi", false),
+		)
+
+		It("passes the image, size and features to rbd", func() {
+			d := iscsidisk("d1", "1Gi")
+			d.Spec.Features = []string{"layering", "exclusive-lock"}
+			env := NewDiskPlanner(&d, nil, &cfg).Env(false)
+			Expect(env).To(ConsistOf(
+				corev1.EnvVar{Name: "RBD_IMAGE", Value: "rbd/d1"},
+				corev1.EnvVar{Name: "RBD_SIZE", Value: "1024"},
+				corev1.EnvVar{
+					Name:  "RBD_FEATURES",
+					Value: "--image-feature=layering --image-feature=exclusive-lock",
+				},
+			))
+			env = NewDiskPlanner(&d, nil, &cfg).Env(true)
+			Expect(env).To(ContainElement(corev1.EnvVar{Name: "RBD_RESIZE", Value: "1"}))
+		})
+
+		It("only reclaims the image with the Delete policy", func() {
+			d := iscsidisk("d1", "1Gi")
+			Expect(NewDiskPlanner(&d, nil, &cfg).ReclaimImage()).To(BeFalse())
+			d.Spec.ReclaimPolicy = api.DiskDeletionDelete
+			Expect(NewDiskPlanner(&d, nil, &cfg).ReclaimImage()).To(BeTrue())
+		})
+	})
+
+	Describe("exported by a gateway", func() {
+		var cc *iscsicc.IscsiContainerConfig
+
+		BeforeEach(func() {
+			cc = iscsicc.New()
+		})
+
+		It("adds the disk to the storage and retains it", func() {
+			ig := newGateway(api.IscsigatewaySpec{})
+			Expect(planDisks(ig, cc, iscsidisk("d1", "1Gi"))).To(Succeed())
+			Expect(cc.Storage["rbd"]["d1"]).To(Equal(
+				iscsicc.NewDiskInfo("1Gi", string(api.DiskDeletionRetain))))
+
+			Expect(planDisks(ig, cc)).To(Succeed())
+			Expect(cc.Storage).NotTo(HaveKey("rbd"))
+			Expect(cc.Purge).To(BeEmpty())
+		})
+
+		It("lets hosts map LUNs to the disk", func() {
+			ig := newGateway(api.IscsigatewaySpec{
+				Hosts: []api.IscsiHostSpec{
+					host("iqn.2023-01.com.example:h1", lun("rbd", "d1")),
+				},
+			})
+			Expect(planDisks(ig, cc, iscsidisk("d1", "1Gi"))).To(Succeed())
+			Expect(planDisks(ig, cc)).To(MatchError(
+				ContainSubstring("LUN rbd/d1 is not a disk of the gateway")))
+		})
+
+		Describe("being deleted", func() {
+			var deleting api.Iscsidisk
+
+			BeforeEach(func() {
+				deleting = iscsidisk("d1", "1Gi")
+				now := metav1.Now()
+				deleting.DeletionTimestamp = &now
+			})
+
+			It("stays exported while a host maps it", func() {
+				ig := newGateway(api.IscsigatewaySpec{
+					Hosts: []api.IscsiHostSpec{
+						host("iqn.2023-01.com.example:h1", lun("rbd", "d1")),
+					},
+				})
+				Expect(planDisks(ig, cc, iscsidisk("d1", "1Gi"))).To(Succeed())
+				Expect(planDisks(ig, cc, deleting)).To(Succeed())
+				Expect(cc.Storage["rbd"]).To(HaveKey("d1"))
+				Expect(cc.Hosts["iqn.2023-01.com.example:h1"].Lun).To(ConsistOf("rbd/d1"))
+			})
+
+			It("stays exported while an initiator requests it", func() {
+				ig := newGateway(api.IscsigatewaySpec{})
+				pl := newPlanner(ig, cc)
+				pl.Disks = []api.Iscsidisk{deleting}
+				pl.Initiators = []api.Iscsiinitiator{
+					initiator("client", "iqn.2023-01.com.example:client", lun("rbd", "d1")),
+				}
+				_, err := pl.Update()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cc.Storage["rbd"]).To(HaveKey("d1"))
+			})
+
+			It("is dropped once no host maps it", func() {
+				ig := newGateway(api.IscsigatewaySpec{})
+				Expect(planDisks(ig, cc, deleting)).To(Succeed())
+				Expect(cc.Storage).NotTo(HaveKey("rbd"))
+			})
+		})
+
+		It("rejects a disk also declared in the spec", func() {
+			ig := newGateway(api.IscsigatewaySpec{
+				Storage: []api.IscsiStorageSpec{pool("rbd", disk("d1", "1Gi"))},
+			})
+			Expect(planDisks(ig, cc, iscsidisk("d1", "1Gi"))).To(MatchError(
+				ContainSubstring("declared in the spec and by Iscsidisk d1")))
+		})
+	})
+})
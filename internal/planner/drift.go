@@ -0,0 +1,312 @@
+package planner
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+	"github.com/Erichorng/iscsi-operator/internal/rbdapi"
+)
+
+// Kinds of difference between the container config and the configuration
+// of a running gateway.
+const (
+	DriftMissingTarget     = "MissingTarget"
+	DriftMissingPortal     = "MissingPortal"
+	DriftMissingLun        = "MissingLun"
+	DriftUnexpectedLun     = "UnexpectedLun"
+	DriftMissingHost       = "MissingHost"
+	DriftUnexpectedHost    = "UnexpectedHost"
+	DriftHostAuth          = "HostAuth"
+	DriftMissingHostLun    = "MissingHostLun"
+	DriftUnexpectedHostLun = "UnexpectedHostLun"
+	DriftHostLunID         = "HostLunID"
+)
+
+// Drift is a difference between the container config and the
+// configuration reported by a gateway.
+type Drift struct {
+	Kind string
+	// Target is the name of the additional target in the spec, empty for
+	// the default target.
+	Target string
+	// TargetName is the IQN of the target.
+	TargetName string
+	// Host is the IQN of the host, for host drifts.
+	Host string
+	// Disk is the disk, as pool/disk, for LUN drifts.
+	Disk string
+	// Gateway is the host name of the gateway, for portal drifts.
+	Gateway  string
+	Expected string
+	Observed string
+}
+
+func (d Drift) String() string {
+	s := d.Kind + " " + d.TargetName
+	for _, v := range []string{d.Gateway, d.Host, d.Disk} {
+		if v != "" {
+			s += " " + v
+		}
+	}
+	if d.Expected != "" || d.Observed != "" {
+		s += fmt.Sprintf(" (expected %q, found %q)", d.Expected, d.Observed)
+	}
+	return s
+}
+
+// targetState pairs the configuration of a target of the container config
+// with its name in the spec.
+type targetState struct {
+	name string
+	cfg  iscsicc.TargetConfig
+}
+
+// targetConfigs returns the targets of the container config, the default
+// target first.
+func (pl *Planner) targetConfigs() []targetState {
+	cc := pl.ConfigState
+	targets := []targetState{{
+		cfg: iscsicc.TargetConfig{
+			TargetName: cc.TargetName,
+			Storage:    cc.Storage,
+			Hosts:      cc.Hosts,
+			HostGroups: cc.HostGroups,
+		},
+	}}
+	names := []string{}
+	for name := range cc.Targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		targets = append(targets, targetState{name: name, cfg: cc.Targets[name]})
+	}
+	return targets
+}
+
+// Drift compares the container config with the configuration reported by
+// the gateway and returns the differences, sorted by target.
+func (pl *Planner) Drift(gateway string, cfg *rbdapi.Config) []Drift {
+	drifts := []Drift{}
+	if pl.ConfigState == nil {
+		return drifts
+	}
+	for _, t := range pl.targetConfigs() {
+		if t.cfg.TargetName == "" {
+			continue
+		}
+		drifts = append(drifts, pl.targetDrift(gateway, t, cfg)...)
+	}
+	return drifts
+}
+
+func (pl *Planner) targetDrift(
+	gateway string, t targetState, cfg *rbdapi.Config) []Drift {
+
+	drift := func(kind string) Drift {
+		return Drift{Kind: kind, Target: t.name, TargetName: t.cfg.TargetName}
+	}
+	observed, found := cfg.Targets[t.cfg.TargetName]
+	if !found {
+		return []Drift{drift(DriftMissingTarget)}
+	}
+
+	drifts := []Drift{}
+	if exist(gateway, pl.ConfigState.Gateways) {
+		if _, found := observed.Portals[gateway]; !found {
+			d := drift(DriftMissingPortal)
+			d.Gateway = gateway
+			drifts = append(drifts, d)
+		}
+	}
+
+	disks := map[string]bool{}
+	for poolName, pool := range t.cfg.Storage {
+		for diskName := range pool {
+			disks[iscsicc.DiskKey(poolName, diskName)] = true
+		}
+	}
+	for _, key := range sortedKeys(disks) {
+		if _, found := observed.Disks[key]; !found {
+			d := drift(DriftMissingLun)
+			d.Disk = key
+			drifts = append(drifts, d)
+		}
+	}
+	for _, key := range sortedKeys(observed.Disks) {
+		if !disks[key] {
+			d := drift(DriftUnexpectedLun)
+			d.Disk = key
+			drifts = append(drifts, d)
+		}
+	}
+
+	for _, name := range sortedKeys(t.cfg.Hosts) {
+		host := t.cfg.Hosts[name]
+		client, found := observed.Clients[name]
+		if !found {
+			d := drift(DriftMissingHost)
+			d.Host = name
+			drifts = append(drifts, d)
+			continue
+		}
+		drifts = append(drifts, hostDrift(drift, name, host, client)...)
+	}
+	for _, name := range sortedKeys(observed.Clients) {
+		if _, found := t.cfg.Hosts[name]; !found {
+			d := drift(DriftUnexpectedHost)
+			d.Host = name
+			drifts = append(drifts, d)
+		}
+	}
+	return drifts
+}
+
+func hostDrift(
+	drift func(string) Drift,
+	name string,
+	host iscsicc.HostInfo,
+	client rbdapi.ClientConfig) []Drift {
+
+	drifts := []Drift{}
+	expectedAuth := host.Auth
+	if expectedAuth == "" {
+		expectedAuth = iscsicc.AuthNone
+	}
+	observedAuth := iscsicc.Credentials{
+		User:       client.Auth.Username,
+		MutualUser: client.Auth.MutualUsername,
+	}.Mode()
+	if expectedAuth != observedAuth {
+		d := drift(DriftHostAuth)
+		d.Host = name
+		d.Expected = expectedAuth
+		d.Observed = observedAuth
+		drifts = append(drifts, d)
+	}
+
+	for _, key := range host.Lun {
+		lun, found := client.Luns[key]
+		if !found {
+			d := drift(DriftMissingHostLun)
+			d.Host, d.Disk = name, key
+			drifts = append(drifts, d)
+			continue
+		}
+		if id, found := host.LunIDs[key]; found && id != lun.LunID {
+			d := drift(DriftHostLunID)
+			d.Host, d.Disk = name, key
+			d.Expected = fmt.Sprint(id)
+			d.Observed = fmt.Sprint(lun.LunID)
+			drifts = append(drifts, d)
+		}
+	}
+	for _, key := range sortedKeys(client.Luns) {
+		if !exist(key, host.Lun) {
+			d := drift(DriftUnexpectedHostLun)
+			d.Host, d.Disk = name, key
+			drifts = append(drifts, d)
+		}
+	}
+	return drifts
+}
+
+// DriftResyncInterval returns how often the running gateways are checked
+// for drift.
+func (pl *Planner) DriftResyncInterval() time.Duration {
+	if d := pl.Iscsigateway.Spec.Drift; d != nil && d.ResyncInterval != nil {
+		return d.ResyncInterval.Duration
+	}
+	d, err := time.ParseDuration(pl.GlobalConfig.DriftResync)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// DriftReapply returns true if drift is to be repaired through the API of
+// the gateways rather than only reported.
+func (pl *Planner) DriftReapply() bool {
+	d := pl.Iscsigateway.Spec.Drift
+	return d != nil && d.Reapply
+}
+
+// TargetLunID returns the LUN the disk of the target is to be exported
+// at: the one it is mapped at to its hosts, or the lowest one free in the
+// observed target.
+func (pl *Planner) TargetLunID(
+	d Drift, observed rbdapi.Target) int32 {
+
+	for _, t := range pl.targetConfigs() {
+		if t.name != d.Target {
+			continue
+		}
+		for _, host := range t.cfg.Hosts {
+			if id, found := host.LunIDs[d.Disk]; found {
+				return id
+			}
+		}
+	}
+	used := map[int32]bool{}
+	for _, lun := range observed.Disks {
+		used[lun.LunID] = true
+	}
+	id := int32(0)
+	for used[id] {
+		id++
+	}
+	return id
+}
+
+// DriftHost returns the container config of the host of the drift.
+func (pl *Planner) DriftHost(d Drift) (iscsicc.HostInfo, bool) {
+	for _, t := range pl.targetConfigs() {
+		if t.name == d.Target {
+			host, found := t.cfg.Hosts[d.Host]
+			return host, found
+		}
+	}
+	return iscsicc.HostInfo{}, false
+}
+
+// DriftDiskSize returns the size of the disk of the drift.
+func (pl *Planner) DriftDiskSize(d Drift) string {
+	for _, t := range pl.targetConfigs() {
+		if t.name != d.Target {
+			continue
+		}
+		for poolName, pool := range t.cfg.Storage {
+			for diskName, info := range pool {
+				if iscsicc.DiskKey(poolName, diskName) == d.Disk {
+					return info.Size
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// HostCredentials returns the credentials the host of the target
+// authenticates with.
+func (pl *Planner) HostCredentials(target, host string) iscsicc.Credentials {
+	cred := pl.Credentials()
+	hosts := cred.Hosts
+	if target != "" {
+		hosts = cred.Targets[target]
+	}
+	if c, found := hosts[host]; found {
+		return c
+	}
+	return cred.Globals
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,125 @@
+package planner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// GatewayConfigFile is the name of the ceph-iscsi configuration file
+	// in the ceph configuration directory of the gateways.
+	GatewayConfigFile = "iscsi-gateway.cfg"
+	// GatewayCertFile and GatewayKeyFile are the names ceph-iscsi loads
+	// the certificate of the API from in secure mode.
+	GatewayCertFile = "iscsi-gateway.crt"
+	GatewayKeyFile  = "iscsi-gateway.key"
+)
+
+// GatewayConfigSecretName returns the name of the secret holding the
+// generated iscsi-gateway.cfg.
+func (pl *Planner) GatewayConfigSecretName() string {
+	return pl.InstanceName() + "-gateway-cfg"
+}
+
+// ApiSecretName returns the name of the secret holding the credentials of
+// the API, given in the spec or generated by the operator along with the
+// iscsi-gateway.cfg.
+func (pl *Planner) ApiSecretName() string {
+	if api := pl.Iscsigateway.Spec.Api; api != nil && api.SecretRef != nil {
+		return api.SecretRef.Name
+	}
+	return pl.GatewayConfigSecretName()
+}
+
+// ApiSecretGenerated returns true if the operator generates the
+// credentials of the API.
+func (pl *Planner) ApiSecretGenerated() bool {
+	api := pl.Iscsigateway.Spec.Api
+	return api == nil || api.SecretRef == nil
+}
+
+// ApiTLSSecretName returns the name of the secret holding the certificate
+// of the API, empty if the API is not served over https.
+func (pl *Planner) ApiTLSSecretName() string {
+	if api := pl.Iscsigateway.Spec.Api; api != nil && api.TLSSecretRef != nil {
+		return api.TLSSecretRef.Name
+	}
+	return ""
+}
+
+// ApiSecure returns true if the API is served over https.
+func (pl *Planner) ApiSecure() bool {
+	return pl.ApiTLSSecretName() != ""
+}
+
+// TrustedIPs returns the addresses allowed to call the API, the gateway
+// pods followed by the addresses of the spec.
+func (pl *Planner) TrustedIPs() []string {
+	ips := []string{}
+	for _, ip := range pl.GatewayIPs {
+		if !exist(ip, ips) {
+			ips = append(ips, ip)
+		}
+	}
+	sort.Strings(ips)
+	if api := pl.Iscsigateway.Spec.Api; api != nil {
+		for _, ip := range api.TrustedIPs {
+			if !exist(ip, ips) {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
+
+// CephKeyring returns the name of the keyring found in the ceph
+// configuration, a "keyring" key or one ending in ".keyring", empty if
+// there is none.
+func (pl *Planner) CephKeyring() string {
+	keys := pl.cephKeys()
+	sort.Strings(keys)
+	for _, k := range keys {
+		if k == CephKeyringFile || strings.HasSuffix(k, ".keyring") {
+			return k
+		}
+	}
+	return ""
+}
+
+// GatewayConfig renders the iscsi-gateway.cfg of the gateways, trusting
+// the given addresses.
+func (pl *Planner) GatewayConfig(trustedIPs []string) (string, error) {
+	if pl.ApiSecret == nil {
+		return "", fmt.Errorf("API credentials are not available yet")
+	}
+	user := string(pl.ApiSecret.Data[corev1.BasicAuthUsernameKey])
+	password := string(pl.ApiSecret.Data[corev1.BasicAuthPasswordKey])
+	if user == "" || password == "" {
+		return "", fmt.Errorf(
+			"API secret %s is missing the %q or %q key", pl.ApiSecret.Name,
+			corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey)
+	}
+
+	var b strings.Builder
+	b.WriteString("# generated by iscsi-operator, do not edit\n")
+	b.WriteString("[config]\n")
+	fmt.Fprintf(&b, "cluster_name = %s\n", "ceph")
+	if keyring := pl.CephKeyring(); keyring != "" {
+		fmt.Fprintf(&b, "gateway_keyring = %s\n", keyring)
+	}
+	if pl.CephGenerated() {
+		fmt.Fprintf(&b, "cluster_client_name = client.%s\n", pl.CephClientName())
+	}
+	fmt.Fprintf(&b, "pool = %s\n", pl.GlobalConfig.PoolName)
+	fmt.Fprintf(&b, "api_secure = %t\n", pl.ApiSecure())
+	fmt.Fprintf(&b, "api_port = %d\n", pl.GetApiPort())
+	fmt.Fprintf(&b, "api_user = %s\n", user)
+	fmt.Fprintf(&b, "api_password = %s\n", password)
+	if len(trustedIPs) > 0 {
+		fmt.Fprintf(&b, "trusted_ip_list = %s\n", strings.Join(trustedIPs, ","))
+	}
+	return b.String(), nil
+}
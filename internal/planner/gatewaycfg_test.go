@@ -0,0 +1,94 @@
+package planner
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	api "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Gateway config", func() {
+	var pl *Planner
+
+	BeforeEach(func() {
+		pl = newPlanner(newGateway(api.IscsigatewaySpec{}), iscsicc.New())
+		pl.ApiSecret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "gw-gateway-cfg"},
+			Data: map[string][]byte{
+				corev1.BasicAuthUsernameKey: []byte("admin"),
+				corev1.BasicAuthPasswordKey: []byte("secret"),
+			},
+		}
+	})
+
+	It("generates the API credentials unless given a secret", func() {
+		Expect(pl.ApiSecretGenerated()).To(BeTrue())
+		Expect(pl.ApiSecretName()).To(Equal("gw-gateway-cfg"))
+
+		pl.Iscsigateway.Spec.Api = &api.IscsiApiSpec{
+			SecretRef: &corev1.LocalObjectReference{Name: "api-creds"},
+		}
+		Expect(pl.ApiSecretGenerated()).To(BeFalse())
+		Expect(pl.ApiSecretName()).To(Equal("api-creds"))
+	})
+
+	It("trusts the gateways first, then the addresses of the spec", func() {
+		pl.GatewayIPs = map[string]string{
+			"gw-1": "10.0.0.2",
+			"gw-0": "10.0.0.1",
+		}
+		pl.Iscsigateway.Spec.Api = &api.IscsiApiSpec{
+			TrustedIPs: []string{"10.0.1.1", "10.0.0.1"},
+		}
+		Expect(pl.TrustedIPs()).To(Equal(
+			[]string{"10.0.0.1", "10.0.0.2", "10.0.1.1"}))
+	})
+
+	It("renders the settings of the API", func() {
+		cfg, err := pl.GatewayConfig([]string{"10.0.0.1", "10.0.0.2"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg).To(ContainSubstring("api_secure = false\n"))
+		Expect(cfg).To(ContainSubstring("api_port = 5001\n"))
+		Expect(cfg).To(ContainSubstring("api_user = admin\n"))
+		Expect(cfg).To(ContainSubstring("api_password = secret\n"))
+		Expect(cfg).To(ContainSubstring("trusted_ip_list = 10.0.0.1,10.0.0.2\n"))
+		Expect(cfg).NotTo(ContainSubstring("gateway_keyring"))
+
+		pl.Iscsigateway.Spec.Api = &api.IscsiApiSpec{
+			TLSSecretRef: &corev1.LocalObjectReference{Name: "api-tls"},
+		}
+		cfg, err = pl.GatewayConfig(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg).To(ContainSubstring("api_secure = true\n"))
+		Expect(cfg).NotTo(ContainSubstring("trusted_ip_list"))
+	})
+
+	It("names the keyring of the ceph config", func() {
+		pl.CephConfig = &corev1.ConfigMap{
+			Data: map[string]string{
+				"ceph.conf":                 "[global]",
+				"ceph.client.admin.keyring": "[client.admin]",
+			},
+		}
+		Expect(pl.CephKeyring()).To(Equal("ceph.client.admin.keyring"))
+		cfg, err := pl.GatewayConfig(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg).To(ContainSubstring(
+			"gateway_keyring = ceph.client.admin.keyring\n"))
+	})
+
+	It("needs the credentials of the API", func() {
+		pl.ApiSecret.Data = map[string][]byte{
+			corev1.BasicAuthUsernameKey: []byte("admin"),
+		}
+		_, err := pl.GatewayConfig(nil)
+		Expect(err).To(MatchError(ContainSubstring("missing")))
+
+		pl.ApiSecret = nil
+		_, err = pl.GatewayConfig(nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,61 @@
+package planner
+
+import (
+	"fmt"
+)
+
+// GatewayName returns the host name of the i-th gateway replica, the name
+// of its stateful set pod.
+func (pl *Planner) GatewayName(i int32) string {
+	return fmt.Sprintf("%s-%d", pl.InstanceName(), i)
+}
+
+// GatewayNames returns the host names of the gateways serving the target
+// portal group.
+func (pl *Planner) GatewayNames() []string {
+	names := []string{}
+	for i := int32(0); i < pl.Scale(); i++ {
+		names = append(names, pl.GatewayName(i))
+	}
+	return names
+}
+
+// updateGateways sets the gateways of the target portal group. Gateways
+// being scaled down are removed here, ahead of their pods, so that the
+// initiators move their sessions to the remaining gateways.
+func (pl *Planner) updateGateways() bool {
+	names := pl.GatewayNames()
+	if sameStringSlice(pl.ConfigState.Gateways, names) {
+		return false
+	}
+	pl.ConfigState.Gateways = names
+	return true
+}
+
+// updatePortals sets the portal of every gateway of the target portal
+// group that has an address.
+func (pl *Planner) updatePortals() bool {
+	portals := map[string]string{}
+	for _, name := range pl.GatewayNames() {
+		if portal, found := pl.Portals[name]; found {
+			portals[name] = portal
+		}
+	}
+	if len(portals) == len(pl.ConfigState.Portals) {
+		same := true
+		for k, v := range portals {
+			if pl.ConfigState.Portals[k] != v {
+				same = false
+				break
+			}
+		}
+		if same {
+			return false
+		}
+	}
+	if len(portals) == 0 {
+		portals = nil
+	}
+	pl.ConfigState.Portals = portals
+	return true
+}
@@ -0,0 +1,135 @@
+package planner
+
+import (
+	"fmt"
+	"sort"
+
+	api "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+)
+
+// groupLuns returns the LUNs of the group along with the LUN ids every
+// member sees them at.
+func (pl *Planner) groupLuns(g api.IscsiHostGroupSpec) []api.IscsiLunSpec {
+	ids, err := assignLunIDs(
+		api.IscsiHostSpec{Luns: g.Luns}, pl.currentGroupLunIDs(g.Name))
+	luns := make([]api.IscsiLunSpec, 0, len(g.Luns))
+	for _, lun := range g.Luns {
+		if err == nil {
+			id := ids[iscsicc.DiskKey(lun.PoolName, lun.DiskName)]
+			lun.LunID = &id
+		}
+		luns = append(luns, lun)
+	}
+	return luns
+}
+
+// currentGroupLunIDs returns the LUN ids of the group in the container
+// config.
+func (pl *Planner) currentGroupLunIDs(name string) map[string]int32 {
+	if pl.ConfigState == nil {
+		return nil
+	}
+	return pl.ConfigState.HostGroups[name].LunIDs
+}
+
+// groupMemberSpecs returns the members of the host groups as hosts
+// inheriting the LUNs and CHAP settings of their group.
+func (pl *Planner) groupMemberSpecs() []api.IscsiHostSpec {
+	hosts := []api.IscsiHostSpec{}
+	for _, g := range pl.Iscsigateway.Spec.HostGroups {
+		luns := pl.groupLuns(g)
+		for _, m := range g.Members {
+			hosts = append(hosts, api.IscsiHostSpec{
+				HostName: m,
+				Chap:     g.Chap,
+				Luns:     luns,
+			})
+		}
+	}
+	return hosts
+}
+
+// hostGroup returns the name of the group the host is a member of, empty
+// if it is not in a group.
+func (pl *Planner) hostGroup(hostName string) string {
+	for _, g := range pl.Iscsigateway.Spec.HostGroups {
+		if exist(hostName, g.Members) {
+			return g.Name
+		}
+	}
+	return ""
+}
+
+// validateHostGroups makes sure every host belongs to a single group, is
+// not also a host of the spec, and that the LUN ids of the groups can be
+// assigned.
+func (pl *Planner) validateHostGroups() error {
+	owners := map[string]string{}
+	for _, h := range pl.Iscsigateway.Spec.Hosts {
+		owners[h.HostName] = "the hosts of the gateway"
+	}
+	groups := map[string]bool{}
+	for _, g := range pl.Iscsigateway.Spec.HostGroups {
+		if groups[g.Name] {
+			return fmt.Errorf("host group %s is defined twice", g.Name)
+		}
+		groups[g.Name] = true
+		for _, m := range g.Members {
+			if owner, found := owners[m]; found {
+				return fmt.Errorf(
+					"host group %s: host %s is already in %s", g.Name, m, owner)
+			}
+			owners[m] = "host group " + g.Name
+		}
+		_, err := assignLunIDs(
+			api.IscsiHostSpec{Luns: g.Luns}, pl.currentGroupLunIDs(g.Name))
+		if err != nil {
+			return fmt.Errorf("host group %s: %w", g.Name, err)
+		}
+	}
+	return nil
+}
+
+// updateHostGroups brings the host groups of the container config in line
+// with the spec. The members themselves are updated along with the other
+// hosts.
+func (pl *Planner) updateHostGroups() bool {
+	changed := false
+	if pl.ConfigState.HostGroups == nil {
+		pl.ConfigState.HostGroups = map[string]iscsicc.HostGroupInfo{}
+	}
+	names := []string{}
+	for _, g := range pl.Iscsigateway.Spec.HostGroups {
+		names = append(names, g.Name)
+		ids := map[string]int32{}
+		for _, lun := range pl.groupLuns(g) {
+			if lun.LunID != nil {
+				ids[iscsicc.DiskKey(lun.PoolName, lun.DiskName)] = *lun.LunID
+			}
+		}
+		members := append([]string{}, g.Members...)
+		sort.Strings(members)
+		goal := iscsicc.HostGroupInfo{
+			Members: members,
+			Lun:     lunsByID(ids),
+			LunIDs:  ids,
+		}
+		current, found := pl.ConfigState.HostGroups[g.Name]
+		if found &&
+			equalStringSlice(current.Members, goal.Members) &&
+			equalStringSlice(current.Lun, goal.Lun) &&
+			sameLunIDs(current.LunIDs, goal.LunIDs) {
+			continue
+		}
+		pl.ConfigState.HostGroups[g.Name] = goal
+		changed = true
+	}
+	for name := range pl.ConfigState.HostGroups {
+		if !exist(name, names) {
+			delete(pl.ConfigState.HostGroups, name)
+			changed = true
+		}
+	}
+	return changed
+}
@@ -0,0 +1,99 @@
+package planner
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	api "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+)
+
+var _ = Describe("Host groups", func() {
+	storage := []api.IscsiStorageSpec{
+		pool("rbd", disk("a", "1Gi"), disk("b", "1Gi")),
+	}
+	group := func(name string, members []string, luns ...api.IscsiLunSpec) api.IscsiHostGroupSpec {
+		return api.IscsiHostGroupSpec{Name: name, Members: members, Luns: luns}
+	}
+
+	It("maps the LUNs of the group to every member at the same ids", func() {
+		cc, err := plan(newGateway(api.IscsigatewaySpec{
+			Storage: storage,
+			HostGroups: []api.IscsiHostGroupSpec{
+				group("cluster", []string{host2, host1},
+					lunAt("rbd", "b", 4), lun("rbd", "a")),
+			},
+		}), iscsicc.New())
+		Expect(err).NotTo(HaveOccurred())
+
+		ids := map[string]int32{"rbd/a": 0, "rbd/b": 4}
+		Expect(cc.HostGroups).To(HaveKeyWithValue("cluster", iscsicc.HostGroupInfo{
+			Members: []string{host1, host2},
+			Lun:     []string{"rbd/a", "rbd/b"},
+			LunIDs:  ids,
+		}))
+		for _, h := range []string{host1, host2} {
+			Expect(cc.Hosts[h].Group).To(Equal("cluster"))
+			Expect(cc.Hosts[h].LunIDs).To(Equal(ids))
+		}
+	})
+
+	It("takes a host leaving the group out of it", func() {
+		cc, err := plan(newGateway(api.IscsigatewaySpec{
+			Storage: storage,
+			HostGroups: []api.IscsiHostGroupSpec{
+				group("cluster", []string{host1, host2}, lun("rbd", "a")),
+			},
+		}), iscsicc.New())
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = plan(newGateway(api.IscsigatewaySpec{
+			Storage: storage,
+			Hosts:   []api.IscsiHostSpec{host(host2, lun("rbd", "b"))},
+			HostGroups: []api.IscsiHostGroupSpec{
+				group("cluster", []string{host1}, lun("rbd", "a")),
+			},
+		}), cc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cc.HostGroups["cluster"].Members).To(Equal([]string{host1}))
+		Expect(cc.Hosts[host2].Group).To(BeEmpty())
+		Expect(cc.Hosts[host2].Lun).To(Equal([]string{"rbd/b"}))
+	})
+
+	DescribeTable("validating host groups",
+		func(spec api.IscsigatewaySpec, msg string) {
+			spec.Storage = storage
+			_, err := plan(newGateway(spec), iscsicc.New())
+			Expect(err).To(MatchError(ContainSubstring(msg)))
+		},
+		Entry("rejects a group defined twice", api.IscsigatewaySpec{
+			HostGroups: []api.IscsiHostGroupSpec{
+				group("cluster", []string{host1}),
+				group("cluster", []string{host2}),
+			},
+		}, "defined twice"),
+		Entry("rejects a host in two groups", api.IscsigatewaySpec{
+			HostGroups: []api.IscsiHostGroupSpec{
+				group("one", []string{host1}),
+				group("two", []string{host1}),
+			},
+		}, "already in host group one"),
+		Entry("rejects a member also listed in the hosts", api.IscsigatewaySpec{
+			Hosts: []api.IscsiHostSpec{host(host1)},
+			HostGroups: []api.IscsiHostGroupSpec{
+				group("cluster", []string{host1}),
+			},
+		}, "already in the hosts of the gateway"),
+		Entry("rejects a LUN on an unknown disk", api.IscsigatewaySpec{
+			HostGroups: []api.IscsiHostGroupSpec{
+				group("cluster", []string{host1}, lun("rbd", "c")),
+			},
+		}, "is not a disk of the gateway"),
+		Entry("rejects two LUNs pinned at the same id", api.IscsigatewaySpec{
+			HostGroups: []api.IscsiHostGroupSpec{
+				group("cluster", []string{host1},
+					lunAt("rbd", "a", 1), lunAt("rbd", "b", 1)),
+			},
+		}, "LUN id 1"),
+	)
+})
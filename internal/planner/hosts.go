@@ -0,0 +1,332 @@
+package planner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	api "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+)
+
+// timeNow is replaced to control the clock of the planner.
+var timeNow = time.Now
+
+// HostRemoval is a host removed from the spec that is still exported.
+type HostRemoval struct {
+	HostName string
+	// Target is the name of the additional target the host is removed
+	// from, empty for the default target.
+	Target      string
+	RemoveAfter time.Time
+}
+
+// Session identifies the host, by its initiator name, logged in to the
+// target, by its IQN.
+type Session struct {
+	TargetName string
+	HostName   string
+}
+
+// HostRemovalGrace returns how long a host removed from the spec is still
+// exported, giving its initiator time to log out.
+func (pl *Planner) HostRemovalGrace() time.Duration {
+	d, err := time.ParseDuration(pl.GlobalConfig.HostRemovalGrace)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// updateHosts adds or updates the hosts of the spec in the container
+// config. Hosts no longer in the spec are removed right away if they are
+// known not to be logged in. Otherwise they may still have sessions to
+// the target, they are only removed once the removal grace period has
+// passed, their sessions end, or when forced through the
+// ForceRemoveHostsAnnotation.
+func (pl *Planner) updateHosts() bool {
+	changed := false
+	specHosts := []string{}
+	for _, h := range pl.hostSpecs() {
+		specHosts = append(specHosts, h.HostName)
+		goalAuth := pl.hostCredentials(h).Mode()
+		host, found := pl.ConfigState.Hosts[h.HostName]
+		goalIDs, err := assignLunIDs(h, host.LunIDs)
+		if err != nil {
+			// rejected by validateLunIDs, leave the host as it is
+			continue
+		}
+		goalLun := lunsByID(goalIDs)
+
+		if !found {
+			host = iscsicc.NewHostInfo(goalAuth, goalLun, goalIDs)
+			host.Group = pl.hostGroup(h.HostName)
+			pl.ConfigState.Hosts[h.HostName] = host
+			changed = true
+			continue
+		}
+		if host.Auth != goalAuth {
+			host.Auth = goalAuth
+			changed = true
+		}
+		if !equalStringSlice(host.Lun, goalLun) {
+			host.Lun = goalLun
+			changed = true
+		}
+		if !sameLunIDs(host.LunIDs, goalIDs) {
+			host.LunIDs = goalIDs
+			changed = true
+		}
+		if group := pl.hostGroup(h.HostName); host.Group != group {
+			host.Group = group
+			changed = true
+		}
+		if host.RemovalRequested != "" {
+			// the host was added back before it was removed
+			host.RemovalRequested = ""
+			changed = true
+		}
+		pl.ConfigState.Hosts[h.HostName] = host
+	}
+
+	now := timeNow().UTC()
+	for name, host := range pl.ConfigState.Hosts {
+		if exist(name, specHosts) {
+			continue
+		}
+		loggedIn, known := pl.sessions[Session{
+			TargetName: pl.ConfigState.TargetName,
+			HostName:   name,
+		}]
+		if pl.forceRemoveHost(name) || (known && !loggedIn) {
+			delete(pl.ConfigState.Hosts, name)
+			changed = true
+			continue
+		}
+		requested, err := time.Parse(time.RFC3339, host.RemovalRequested)
+		if err != nil {
+			host.RemovalRequested = now.Format(time.RFC3339)
+			pl.ConfigState.Hosts[name] = host
+			changed = true
+			continue
+		}
+		if !now.Before(requested.Add(pl.HostRemovalGrace())) {
+			delete(pl.ConfigState.Hosts, name)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// RemovedHosts returns the hosts of every target of the container config
+// that are no longer in the spec, whether their removal is pending or not.
+func (pl *Planner) RemovedHosts() []Session {
+	removed := []Session{}
+	if pl.ConfigState == nil {
+		return removed
+	}
+	specHosts := []string{}
+	for _, h := range pl.hostSpecs() {
+		specHosts = append(specHosts, h.HostName)
+	}
+	for name := range pl.ConfigState.Hosts {
+		if !exist(name, specHosts) {
+			removed = append(removed, Session{
+				TargetName: pl.ConfigState.TargetName,
+				HostName:   name,
+			})
+		}
+	}
+	if pl.target == "" {
+		for name := range pl.ConfigState.Targets {
+			spec := pl.targetSpec(name)
+			if spec == nil {
+				spec = &api.IscsiTargetSpec{Name: name}
+			}
+			removed = append(removed, pl.targetPlanner(*spec).RemovedHosts()...)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool {
+		if removed[i].TargetName != removed[j].TargetName {
+			return removed[i].TargetName < removed[j].TargetName
+		}
+		return removed[i].HostName < removed[j].HostName
+	})
+	return removed
+}
+
+// SetSessions tells which of the RemovedHosts are logged in. A removed
+// host known not to be logged in is removed without waiting for the
+// removal grace period; hosts left out are assumed to be logged in.
+func (pl *Planner) SetSessions(sessions map[Session]bool) {
+	pl.sessions = sessions
+}
+
+func (pl *Planner) forceRemoveHost(name string) bool {
+	value := pl.Iscsigateway.GetAnnotations()[api.ForceRemoveHostsAnnotation]
+	for _, h := range strings.Split(value, ",") {
+		h = strings.TrimSpace(h)
+		if h == "*" || h == name {
+			return true
+		}
+	}
+	return false
+}
+
+// PendingHostRemovals returns the hosts removed from the spec that are
+// still exported, sorted by target and name.
+func (pl *Planner) PendingHostRemovals() []HostRemoval {
+	removals := []HostRemoval{}
+	if pl.ConfigState == nil {
+		return removals
+	}
+	for name, host := range pl.ConfigState.Hosts {
+		if host.RemovalRequested == "" {
+			continue
+		}
+		requested, err := time.Parse(time.RFC3339, host.RemovalRequested)
+		if err != nil {
+			continue
+		}
+		removals = append(removals, HostRemoval{
+			HostName:    name,
+			Target:      pl.target,
+			RemoveAfter: requested.Add(pl.HostRemovalGrace()),
+		})
+	}
+	if pl.target == "" {
+		for name := range pl.ConfigState.Targets {
+			tp := pl.targetPlanner(api.IscsiTargetSpec{Name: name})
+			removals = append(removals, tp.PendingHostRemovals()...)
+		}
+	}
+	sort.Slice(removals, func(i, j int) bool {
+		if removals[i].Target != removals[j].Target {
+			return removals[i].Target < removals[j].Target
+		}
+		return removals[i].HostName < removals[j].HostName
+	})
+	return removals
+}
+
+// NextHostRemoval returns how long until the next pending host removal is
+// due, and false if there is none.
+func (pl *Planner) NextHostRemoval() (time.Duration, bool) {
+	removals := pl.PendingHostRemovals()
+	if len(removals) == 0 {
+		return 0, false
+	}
+	next := removals[0].RemoveAfter
+	for _, r := range removals[1:] {
+		if r.RemoveAfter.Before(next) {
+			next = r.RemoveAfter
+		}
+	}
+	d := next.Sub(timeNow())
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
+// validateLunIDs makes sure the LUN ids of every host of the spec can be
+// assigned. The LUN ids of Iscsiinitiators are checked by CheckInitiators.
+func (pl *Planner) validateLunIDs() error {
+	for _, h := range pl.Iscsigateway.Spec.Hosts {
+		if _, err := assignLunIDs(h, pl.currentLunIDs(h.HostName)); err != nil {
+			return fmt.Errorf("host %s: %w", h.HostName, err)
+		}
+	}
+	return nil
+}
+
+// currentLunIDs returns the LUN ids of the host in the container config.
+func (pl *Planner) currentLunIDs(hostName string) map[string]int32 {
+	if pl.ConfigState == nil {
+		return nil
+	}
+	return pl.ConfigState.Hosts[hostName].LunIDs
+}
+
+// assignLunIDs returns the LUN id of every LUN of the host, keyed by
+// pool/disk. Ids set in the spec are used as is; the other LUNs keep the
+// id they were assigned before, or get the lowest free one. Two LUNs of a
+// host can not share an id.
+func assignLunIDs(
+	h api.IscsiHostSpec, current map[string]int32) (map[string]int32, error) {
+
+	ids := map[string]int32{}
+	owners := map[int32]string{}
+	claim := func(key string, id int32) error {
+		if other, taken := owners[id]; taken {
+			return fmt.Errorf(
+				"LUN id %d of %s is already assigned to %s", id, key, other)
+		}
+		ids[key] = id
+		owners[id] = key
+		return nil
+	}
+
+	for _, lun := range h.Luns {
+		if lun.LunID == nil {
+			continue
+		}
+		key := iscsicc.DiskKey(lun.PoolName, lun.DiskName)
+		if err := claim(key, *lun.LunID); err != nil {
+			return nil, err
+		}
+	}
+	for _, lun := range h.Luns {
+		key := iscsicc.DiskKey(lun.PoolName, lun.DiskName)
+		if lun.LunID != nil {
+			continue
+		}
+		if id, found := current[key]; found {
+			if err := claim(key, id); err != nil {
+				return nil, err
+			}
+		}
+	}
+	next := int32(0)
+	for _, lun := range h.Luns {
+		key := iscsicc.DiskKey(lun.PoolName, lun.DiskName)
+		if _, found := ids[key]; found {
+			continue
+		}
+		for _, taken := owners[next]; taken; _, taken = owners[next] {
+			next++
+		}
+		if next > api.MaxLunID {
+			return nil, fmt.Errorf("no LUN id left for %s", key)
+		}
+		if err := claim(key, next); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// lunsByID returns the LUNs sorted by id.
+func lunsByID(ids map[string]int32) []string {
+	luns := make([]string, 0, len(ids))
+	for key := range ids {
+		luns = append(luns, key)
+	}
+	sort.Slice(luns, func(i, j int) bool {
+		return ids[luns[i]] < ids[luns[j]]
+	})
+	return luns
+}
+
+func sameLunIDs(x, y map[string]int32) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for k, v := range x {
+		if id, found := y[k]; !found || id != v {
+			return false
+		}
+	}
+	return true
+}
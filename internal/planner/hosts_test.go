@@ -0,0 +1,268 @@
+package planner
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	api "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+)
+
+const (
+	host1 = "iqn.2023-01.com.example:h1"
+	host2 = "iqn.2023-01.com.example:h2"
+)
+
+var _ = Describe("LUN ids", func() {
+	DescribeTable("assigning LUN ids",
+		func(luns []api.IscsiLunSpec, current map[string]int32, want map[string]int32, fails bool) {
+			ids, err := assignLunIDs(host(host1, luns...), current)
+			if fails {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ids).To(Equal(want))
+		},
+		Entry("assigns the lowest free ids in order",
+			[]api.IscsiLunSpec{lun("rbd", "a"), lun("rbd", "b")}, nil,
+			map[string]int32{"rbd/a": 0, "rbd/b": 1}, false),
+		Entry("keeps the current ids",
+			[]api.IscsiLunSpec{lun("rbd", "a"), lun("rbd", "b")},
+			map[string]int32{"rbd/a": 3, "rbd/b": 0},
+			map[string]int32{"rbd/a": 3, "rbd/b": 0}, false),
+		Entry("fills the gap left by a removed LUN",
+			[]api.IscsiLunSpec{lun("rbd", "b"), lun("rbd", "c")},
+			map[string]int32{"rbd/a": 0, "rbd/b": 1},
+			map[string]int32{"rbd/b": 1, "rbd/c": 0}, false),
+		Entry("uses pinned ids over current ones",
+			[]api.IscsiLunSpec{lunAt("rbd", "a", 7), lun("rbd", "b")},
+			map[string]int32{"rbd/a": 0},
+			map[string]int32{"rbd/a": 7, "rbd/b": 0}, false),
+		Entry("refuses to keep a current id pinned by another LUN",
+			[]api.IscsiLunSpec{lun("rbd", "a"), lunAt("rbd", "b", 0)},
+			map[string]int32{"rbd/a": 0},
+			nil, true),
+		Entry("rejects two LUNs pinned at the same id",
+			[]api.IscsiLunSpec{lunAt("rbd", "a", 1), lunAt("rbd", "b", 1)},
+			nil, nil, true),
+	)
+
+	It("keeps the ids of the remaining LUNs when one is unmapped", func() {
+		storage := []api.IscsiStorageSpec{
+			pool("rbd", disk("a", "1Gi"), disk("b", "1Gi"), disk("c", "1Gi")),
+		}
+		cc, err := plan(newGateway(api.IscsigatewaySpec{
+			Storage: storage,
+			Hosts: []api.IscsiHostSpec{
+				host(host1, lun("rbd", "a"), lun("rbd", "b"), lun("rbd", "c")),
+			},
+		}), iscsicc.New())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cc.Hosts[host1].LunIDs).To(Equal(
+			map[string]int32{"rbd/a": 0, "rbd/b": 1, "rbd/c": 2}))
+
+		_, err = plan(newGateway(api.IscsigatewaySpec{
+			Storage: storage,
+			Hosts: []api.IscsiHostSpec{
+				host(host1, lun("rbd", "c"), lun("rbd", "a")),
+			},
+		}), cc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cc.Hosts[host1].LunIDs).To(Equal(
+			map[string]int32{"rbd/a": 0, "rbd/c": 2}))
+		Expect(cc.Hosts[host1].Lun).To(Equal([]string{"rbd/a", "rbd/c"}))
+	})
+})
+
+var _ = Describe("Hosts", func() {
+	It("keeps the hosts of the spec from one pass to the next", func() {
+		ig := newGateway(api.IscsigatewaySpec{
+			Storage: []api.IscsiStorageSpec{pool("rbd", disk("a", "1Gi"))},
+			Hosts:   []api.IscsiHostSpec{host(host1, lun("rbd", "a")), host(host2)},
+		})
+		pl := newPlanner(ig, iscsicc.New())
+		changed, err := pl.Update()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(pl.ConfigState.Hosts).To(HaveLen(2))
+		Expect(pl.ConfigState.Hosts[host1].Lun).To(Equal([]string{"rbd/a"}))
+
+		changed, err = pl.Update()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeFalse())
+		Expect(pl.ConfigState.Hosts).To(HaveLen(2))
+	})
+})
+
+var _ = Describe("Host removal", func() {
+	var (
+		cc  *iscsicc.IscsiContainerConfig
+		now time.Time
+	)
+	storage := []api.IscsiStorageSpec{pool("rbd", disk("a", "1Gi"))}
+
+	BeforeEach(func() {
+		now = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+		timeNow = func() time.Time { return now }
+		DeferCleanup(func() { timeNow = time.Now })
+
+		var err error
+		cc, err = plan(newGateway(api.IscsigatewaySpec{
+			Storage: storage,
+			Hosts:   []api.IscsiHostSpec{host(host1, lun("rbd", "a")), host(host2)},
+		}), iscsicc.New())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cc.Hosts).To(HaveLen(2))
+	})
+
+	// removeHost2 plans the removal of host2 with the given sessions.
+	removeHost2 := func(
+		ig *api.Iscsigateway, sessions map[Session]bool) *Planner {
+
+		pl := newPlanner(ig, cc)
+		pl.SetSessions(sessions)
+		_, err := pl.Update()
+		Expect(err).NotTo(HaveOccurred())
+		return pl
+	}
+	// nextRemoval returns how long until the next host removal is due.
+	nextRemoval := func(pl *Planner) time.Duration {
+		d, found := pl.NextHostRemoval()
+		Expect(found).To(BeTrue())
+		return d
+	}
+	spec := func() *api.Iscsigateway {
+		return newGateway(api.IscsigatewaySpec{
+			Storage: storage,
+			Hosts:   []api.IscsiHostSpec{host(host1, lun("rbd", "a"))},
+		})
+	}
+
+	It("defers removing a host for the grace period", func() {
+		pl := removeHost2(spec(), nil)
+		Expect(cc.Hosts[host2].RemovalRequested).To(Equal(now.Format(time.RFC3339)))
+		Expect(pl.PendingHostRemovals()).To(ConsistOf(HostRemoval{
+			HostName:    host2,
+			RemoveAfter: now.Add(5 * time.Minute),
+		}))
+		Expect(nextRemoval(pl)).To(Equal(5 * time.Minute))
+
+		now = now.Add(4 * time.Minute)
+		pl = removeHost2(spec(), nil)
+		Expect(cc.Hosts).To(HaveKey(host2))
+		Expect(nextRemoval(pl)).To(Equal(time.Minute))
+
+		now = now.Add(time.Minute)
+		pl = removeHost2(spec(), nil)
+		Expect(cc.Hosts).NotTo(HaveKey(host2))
+		_, found := pl.NextHostRemoval()
+		Expect(found).To(BeFalse())
+	})
+
+	DescribeTable("removing a host",
+		func(loggedIn *bool, removed bool) {
+			var sessions map[Session]bool
+			if loggedIn != nil {
+				sessions = map[Session]bool{
+					{TargetName: cc.TargetName, HostName: host2}: *loggedIn,
+				}
+			}
+			pl := removeHost2(spec(), sessions)
+			if removed {
+				Expect(cc.Hosts).NotTo(HaveKey(host2))
+				Expect(pl.PendingHostRemovals()).To(BeEmpty())
+				return
+			}
+			Expect(cc.Hosts[host2].RemovalRequested).To(Equal(now.Format(time.RFC3339)))
+		},
+		Entry("defers a host whose sessions are unknown", nil, false),
+		Entry("defers a logged in host", boolPtr(true), false),
+		Entry("removes a logged out host right away", boolPtr(false), true),
+	)
+
+	It("removes a deferred host once it logs out", func() {
+		removeHost2(spec(), nil)
+		Expect(cc.Hosts).To(HaveKey(host2))
+
+		removeHost2(spec(), map[Session]bool{
+			{TargetName: cc.TargetName, HostName: host2}: false,
+		})
+		Expect(cc.Hosts).NotTo(HaveKey(host2))
+	})
+
+	It("cancels the removal of a host added back", func() {
+		removeHost2(spec(), nil)
+		Expect(cc.Hosts[host2].RemovalRequested).NotTo(BeEmpty())
+
+		_, err := plan(newGateway(api.IscsigatewaySpec{
+			Storage: storage,
+			Hosts:   []api.IscsiHostSpec{host(host1, lun("rbd", "a")), host(host2)},
+		}), cc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cc.Hosts[host2].RemovalRequested).To(BeEmpty())
+	})
+
+	It("keeps the disks of a host pending removal", func() {
+		cc.Storage["rbd"]["a"] = iscsicc.NewDiskInfo("1Gi", string(api.DiskDeletionDelete))
+		removeHost := func() {
+			_, err := plan(newGateway(api.IscsigatewaySpec{
+				Hosts: []api.IscsiHostSpec{host(host2)},
+			}), cc)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		removeHost()
+		Expect(cc.Hosts[host1].RemovalRequested).NotTo(BeEmpty())
+		Expect(cc.Storage["rbd"]).To(HaveKey("a"))
+		Expect(cc.Purge).To(BeEmpty())
+
+		now = now.Add(5 * time.Minute)
+		removeHost()
+		Expect(cc.Hosts).NotTo(HaveKey(host1))
+		removeHost()
+		Expect(cc.Storage).NotTo(HaveKey("rbd"))
+		Expect(cc.Purge).To(ConsistOf("rbd/a"))
+	})
+
+	DescribeTable("forcing the removal of a host",
+		func(annotation string, removed bool) {
+			ig := spec()
+			ig.Annotations = map[string]string{
+				api.ForceRemoveHostsAnnotation: annotation,
+			}
+			removeHost2(ig, nil)
+			if removed {
+				Expect(cc.Hosts).NotTo(HaveKey(host2))
+			} else {
+				Expect(cc.Hosts).To(HaveKey(host2))
+			}
+		},
+		Entry("by name", host2, true),
+		Entry("in a list", host1+", "+host2, true),
+		Entry("with a wildcard", "*", true),
+		Entry("naming another host", "iqn.2023-01.com.example:h3", false),
+	)
+
+	It("lists the removed hosts of every target", func() {
+		ig := spec()
+		ig.Spec.Targets = []api.IscsiTargetSpec{{
+			Name:  "second",
+			Hosts: []api.IscsiHostSpec{host(host1)},
+		}}
+		_, err := plan(ig, cc)
+		Expect(err).NotTo(HaveOccurred())
+		second := cc.Targets["second"].TargetName
+
+		ig.Spec.Targets[0].Hosts = nil
+		Expect(newPlanner(ig, cc).RemovedHosts()).To(ConsistOf(
+			Session{TargetName: cc.TargetName, HostName: host2},
+			Session{TargetName: second, HostName: host1},
+		))
+	})
+})
+
+func boolPtr(b bool) *bool {
+	return &b
+}
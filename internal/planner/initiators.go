@@ -0,0 +1,124 @@
+package planner
+
+import (
+	"fmt"
+
+	api "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// InitiatorCheck is the outcome of checking an Iscsiinitiator against the
+// gateway.
+type InitiatorCheck struct {
+	Iscsiinitiator *api.Iscsiinitiator
+	// Err is the reason the initiator was rejected, nil if it was
+	// accepted.
+	Err error
+}
+
+// InitiatorSecretKey returns the name the CHAP secret of an Iscsiinitiator
+// is known by in ChapSecrets. Secret names can not contain a slash, so it
+// does not clash with the secrets of the gateway.
+func InitiatorSecretKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// initiatorHostSpec returns the host the initiator is added to the gateway
+// as.
+func initiatorHostSpec(ini *api.Iscsiinitiator) api.IscsiHostSpec {
+	h := api.IscsiHostSpec{
+		HostName: ini.Spec.InitiatorName,
+		Luns:     ini.Spec.Luns,
+	}
+	if chap := ini.Spec.Chap; chap != nil {
+		h.Chap = &api.IscsiChapSpec{
+			SecretRef: corev1.LocalObjectReference{
+				Name: InitiatorSecretKey(ini.Namespace, chap.SecretRef.Name),
+			},
+		}
+		if chap.MutualSecretRef != nil {
+			h.Chap.MutualSecretRef = &corev1.LocalObjectReference{
+				Name: InitiatorSecretKey(ini.Namespace, chap.MutualSecretRef.Name),
+			}
+		}
+	}
+	return h
+}
+
+// CheckInitiators checks the Iscsiinitiators in order. An initiator is
+// rejected if its host is already defined, by the spec, a host group or an
+// initiator accepted before it, if it maps a LUN the gateway does not have or if its
+// credentials are not usable. A rejected initiator does not affect the
+// rest of the gateway.
+func (pl *Planner) CheckInitiators() []InitiatorCheck {
+	owners := map[string]string{}
+	for _, h := range pl.Iscsigateway.Spec.Hosts {
+		owners[h.HostName] = "the spec of the gateway"
+	}
+	for _, g := range pl.Iscsigateway.Spec.HostGroups {
+		for _, m := range g.Members {
+			owners[m] = "host group " + g.Name
+		}
+	}
+	disks := pl.diskKeys()
+
+	checks := []InitiatorCheck{}
+	for i := range pl.Initiators {
+		ini := &pl.Initiators[i]
+		err := pl.checkInitiator(ini, owners, disks)
+		if err == nil {
+			owners[ini.Spec.InitiatorName] = fmt.Sprintf(
+				"Iscsiinitiator %s/%s", ini.Namespace, ini.Name)
+		}
+		checks = append(checks, InitiatorCheck{Iscsiinitiator: ini, Err: err})
+	}
+	return checks
+}
+
+func (pl *Planner) checkInitiator(
+	ini *api.Iscsiinitiator,
+	owners map[string]string,
+	disks map[string]bool) error {
+
+	h := initiatorHostSpec(ini)
+	if owner, found := owners[h.HostName]; found {
+		return fmt.Errorf("host %s is already defined by %s", h.HostName, owner)
+	}
+	for _, lun := range iscsicc.GetLuns(h.Luns) {
+		if !disks[lun] {
+			return fmt.Errorf("LUN %s is not a disk of the gateway", lun)
+		}
+	}
+	if _, err := assignLunIDs(h, pl.currentLunIDs(h.HostName)); err != nil {
+		return err
+	}
+	if h.Chap != nil {
+		refs := []string{h.Chap.SecretRef.Name}
+		if h.Chap.MutualSecretRef != nil {
+			refs = append(refs, h.Chap.MutualSecretRef.Name)
+		}
+		for _, ref := range refs {
+			if _, found := pl.ChapSecrets[ref]; !found {
+				return fmt.Errorf("CHAP secret %s is missing or invalid", ref)
+			}
+		}
+	}
+	return pl.validateHostAuth(h)
+}
+
+// hostSpecs returns the hosts of the spec, the members of its host groups
+// and the hosts of the accepted Iscsiinitiators.
+func (pl *Planner) hostSpecs() []api.IscsiHostSpec {
+	hosts := append([]api.IscsiHostSpec{}, pl.Iscsigateway.Spec.Hosts...)
+	hosts = append(hosts, pl.groupMemberSpecs()...)
+	if len(pl.Initiators) == 0 {
+		return hosts
+	}
+	for _, c := range pl.CheckInitiators() {
+		if c.Err == nil {
+			hosts = append(hosts, initiatorHostSpec(c.Iscsiinitiator))
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,136 @@
+package planner
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	api "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// initiator returns an Iscsiinitiator of the apps namespace mapping the
+// LUNs.
+func initiator(
+	name, initiatorName string, luns ...api.IscsiLunSpec) api.Iscsiinitiator {
+
+	return api.Iscsiinitiator{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "apps"},
+		Spec: api.IscsiinitiatorSpec{
+			InitiatorName: initiatorName,
+			Luns:          luns,
+		},
+	}
+}
+
+var _ = Describe("Initiators", func() {
+	const (
+		specHost   = "iqn.2023-01.com.example:h1"
+		clientHost = "iqn.2023-01.com.example:client"
+	)
+
+	var pl *Planner
+
+	BeforeEach(func() {
+		pl = newPlanner(newGateway(api.IscsigatewaySpec{
+			Storage: []api.IscsiStorageSpec{
+				pool("rbd", disk("d1", "1Gi"), disk("d2", "1Gi")),
+			},
+			Hosts: []api.IscsiHostSpec{host(specHost, lun("rbd", "d1"))},
+		}), iscsicc.New())
+	})
+
+	errors := func() []error {
+		errs := []error{}
+		for _, c := range pl.CheckInitiators() {
+			errs = append(errs, c.Err)
+		}
+		return errs
+	}
+
+	It("adds an accepted initiator as a host", func() {
+		pl.Initiators = []api.Iscsiinitiator{
+			initiator("client", clientHost, lun("rbd", "d2")),
+		}
+		Expect(errors()).To(Equal([]error{nil}))
+
+		_, err := pl.Update()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pl.ConfigState.Hosts).To(HaveKey(clientHost))
+		Expect(pl.ConfigState.Hosts[clientHost].Lun).To(ConsistOf("rbd/d2"))
+	})
+
+	It("rejects a host defined by the spec", func() {
+		pl.Initiators = []api.Iscsiinitiator{
+			initiator("client", specHost, lun("rbd", "d2")),
+		}
+		Expect(errors()).To(ConsistOf(
+			MatchError("host " + specHost + " is already defined by the spec of the gateway")))
+	})
+
+	It("rejects a member of a host group", func() {
+		pl.Iscsigateway.Spec.HostGroups = []api.IscsiHostGroupSpec{{
+			Name:    "cluster",
+			Members: []string{clientHost},
+			Luns:    []api.IscsiLunSpec{lun("rbd", "d1")},
+		}}
+		pl.Initiators = []api.Iscsiinitiator{
+			initiator("client", clientHost, lun("rbd", "d2")),
+		}
+		Expect(errors()).To(ConsistOf(
+			MatchError("host " + clientHost + " is already defined by host group cluster")))
+	})
+
+	It("accepts the first initiator defining a host", func() {
+		pl.Initiators = []api.Iscsiinitiator{
+			initiator("a", clientHost, lun("rbd", "d1")),
+			initiator("b", clientHost, lun("rbd", "d2")),
+		}
+		errs := errors()
+		Expect(errs[0]).NotTo(HaveOccurred())
+		Expect(errs[1]).To(MatchError(ContainSubstring("Iscsiinitiator apps/a")))
+
+		_, err := pl.Update()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pl.ConfigState.Hosts[clientHost].Lun).To(ConsistOf("rbd/d1"))
+	})
+
+	It("rejects a LUN the gateway does not have", func() {
+		pl.Initiators = []api.Iscsiinitiator{
+			initiator("client", clientHost, lun("rbd", "d3")),
+		}
+		Expect(errors()).To(ConsistOf(
+			MatchError("LUN rbd/d3 is not a disk of the gateway")))
+
+		_, err := pl.Update()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pl.ConfigState.Hosts).NotTo(HaveKey(clientHost))
+	})
+
+	Describe("with CHAP", func() {
+		BeforeEach(func() {
+			ini := initiator("client", clientHost, lun("rbd", "d2"))
+			ini.Spec.Chap = chapSpec("client-chap", "")
+			pl.Initiators = []api.Iscsiinitiator{ini}
+		})
+
+		It("takes the secret from the namespace of the initiator", func() {
+			key := InitiatorSecretKey("apps", "client-chap")
+			Expect(key).To(Equal("apps/client-chap"))
+			pl.ChapSecrets = map[string]*corev1.Secret{
+				key: chapSecret("client-chap", "initiator-client", "client-pass0"),
+			}
+			Expect(errors()).To(Equal([]error{nil}))
+			Expect(pl.Credentials().Hosts[clientHost].User).To(Equal("initiator-client"))
+		})
+
+		It("rejects the initiator without its secret", func() {
+			pl.ChapSecrets = map[string]*corev1.Secret{
+				"client-chap": chapSecret("client-chap", "initiator-client", "client-pass0"),
+			}
+			Expect(errors()).To(ConsistOf(
+				MatchError("CHAP secret apps/client-chap is missing or invalid")))
+		})
+	})
+})
@@ -4,16 +4,54 @@ import (
 	api "github.com/Erichorng/iscsi-operator/api/v1alpha1"
 	"github.com/Erichorng/iscsi-operator/internal/conf"
 	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+	corev1 "k8s.io/api/core/v1"
 )
 
 type InstanceConfiguration struct {
 	Iscsigateway *api.Iscsigateway
 	GlobalConfig *conf.OperatorConfig
+	// ChapSecrets maps the names of the CHAP secrets referenced by the
+	// gateway, and the InitiatorSecretKey of those of its Iscsiinitiators,
+	// to their contents.
+	ChapSecrets map[string]*corev1.Secret
+	// CephConfig is the ConfigMap holding the ceph configuration of the
+	// gateway, nil if it could not be found.
+	CephConfig *corev1.ConfigMap
+	// CephSecret is the Secret holding the ceph.conf and keyring generated
+	// by the operator, nil if it does not exist yet or the gateway takes
+	// them from a ConfigMap.
+	CephSecret *corev1.Secret
+	// Portals maps the host name of a gateway to the address, as
+	// host:port, initiators reach it at. Gateways without an address yet
+	// are left out.
+	Portals map[string]string
+	// GatewayIPs maps the host name of a gateway pod to its pod IP.
+	// Gateways without an IP yet are left out.
+	GatewayIPs map[string]string
+	// ApiSecret holds the credentials of the rbd-target-api, nil until
+	// they are known.
+	ApiSecret *corev1.Secret
+	// Disks are the Iscsidisks exported by the gateway.
+	Disks []api.Iscsidisk
+	// Initiators are the Iscsiinitiators requesting access to the gateway
+	// from the namespaces it allows, sorted by namespace and name.
+	Initiators []api.Iscsiinitiator
 }
 
 type Planner struct {
 	InstanceConfiguration
 	ConfigState *iscsicc.IscsiContainerConfig
+	// target is the name of the additional target planned, empty for the
+	// default target of the gateway.
+	target string
+	// instanceName is the name of the resources of the gateway of an
+	// additional target.
+	instanceName string
+	// changes are the changes made by the last call to Update.
+	changes []Change
+	// sessions tells the hosts removed from the spec that are logged in,
+	// see SetSessions.
+	sessions map[Session]bool
 }
 
 func New(
@@ -0,0 +1,43 @@
+package planner
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Inventory sums up what the container config exports.
+type Inventory struct {
+	Targets          int
+	Disks            int
+	ProvisionedBytes int64
+	// MappedHosts counts the hosts of every target with a LUN mapped.
+	MappedHosts int
+}
+
+// Inventory returns what the container config exports. Sizes that can not
+// be parsed are left out of the provisioned bytes.
+func (pl *Planner) Inventory() Inventory {
+	inv := Inventory{}
+	if pl.ConfigState == nil {
+		return inv
+	}
+	for _, t := range pl.targetConfigs() {
+		if t.cfg.TargetName == "" {
+			continue
+		}
+		inv.Targets++
+		for _, pool := range t.cfg.Storage {
+			for _, disk := range pool {
+				inv.Disks++
+				if size, err := resource.ParseQuantity(disk.Size); err == nil {
+					inv.ProvisionedBytes += size.Value()
+				}
+			}
+		}
+		for _, host := range t.cfg.Hosts {
+			if len(host.Lun) > 0 {
+				inv.MappedHosts++
+			}
+		}
+	}
+	return inv
+}
@@ -0,0 +1,57 @@
+package planner
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	api "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+)
+
+var _ = Describe("Inventory", func() {
+	It("sums up every target", func() {
+		pl := newPlanner(newGateway(api.IscsigatewaySpec{
+			Storage: []api.IscsiStorageSpec{
+				pool("rbd", disk("a", "1Gi"), disk("b", "2Gi")),
+			},
+			Hosts: []api.IscsiHostSpec{
+				host(host1, lun("rbd", "a")),
+				host(host2),
+			},
+			Targets: []api.IscsiTargetSpec{{
+				Name:    "second",
+				Storage: []api.IscsiStorageSpec{pool("rbd", disk("c", "1Gi"))},
+				Hosts:   []api.IscsiHostSpec{host(host2, lun("rbd", "c"))},
+			}},
+		}), iscsicc.New())
+		_, err := pl.Update()
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(pl.Inventory()).To(Equal(Inventory{
+			Targets:          2,
+			Disks:            3,
+			ProvisionedBytes: 4 << 30,
+			MappedHosts:      2,
+		}))
+	})
+
+	It("leaves out sizes it can not parse", func() {
+		cc := iscsicc.New()
+		cc.TargetName = "iqn.2003-01.com.redhat.iscsi-gw:storage.gw"
+		cc.Storage["rbd"] = iscsicc.DiskConfig{
+			"a": iscsicc.NewDiskInfo("1Gi", ""),
+			"b": iscsicc.NewDiskInfo("big", ""),
+		}
+		pl := newPlanner(newGateway(api.IscsigatewaySpec{}), cc)
+		Expect(pl.Inventory()).To(Equal(Inventory{
+			Targets:          1,
+			Disks:            2,
+			ProvisionedBytes: 1 << 30,
+		}))
+	})
+
+	It("is empty without a container config", func() {
+		pl := newPlanner(newGateway(api.IscsigatewaySpec{}), nil)
+		Expect(pl.Inventory()).To(Equal(Inventory{}))
+	})
+})
@@ -27,3 +27,11 @@ func (pl *Planner) LibMountPath() string {
 func (pl *Planner) ContainerConfig() string {
 	return path.Join(pl.ConfigMountPath(), "config.json")
 }
+
+func (pl *Planner) AuthMountPath() string {
+	return "/etc/container-auth"
+}
+
+func (pl *Planner) ContainerAuth() string {
+	return path.Join(pl.AuthMountPath(), "auth.json")
+}
@@ -0,0 +1,345 @@
+package planner
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+)
+
+// Kinds of change in a plan.
+const (
+	ChangeSetOption           = "SetOption"
+	ChangeAddTarget           = "AddTarget"
+	ChangeRemoveTarget        = "RemoveTarget"
+	ChangeSetTargetName       = "SetTargetName"
+	ChangeSetMutualChap       = "SetMutualChapRequired"
+	ChangeAddPool             = "AddPool"
+	ChangeRemovePool          = "RemovePool"
+	ChangeAddDisk             = "AddDisk"
+	ChangeResizeDisk          = "ResizeDisk"
+	ChangeSetDeletionPolicy   = "SetDeletionPolicy"
+	ChangeRemoveDisk          = "RemoveDisk"
+	ChangeAddHostGroup        = "AddHostGroup"
+	ChangeRemoveHostGroup     = "RemoveHostGroup"
+	ChangeAddHost             = "AddHost"
+	ChangeSetHostAuth         = "SetHostAuth"
+	ChangeSetHostGroup        = "SetHostGroup"
+	ChangeMapLun              = "MapLun"
+	ChangeRemapLun            = "RemapLun"
+	ChangeUnmapLun            = "UnmapLun"
+	ChangeScheduleHostRemoval = "ScheduleHostRemoval"
+	ChangeCancelHostRemoval   = "CancelHostRemoval"
+	ChangeRemoveHost          = "RemoveHost"
+	ChangeAddGateway          = "AddGateway"
+	ChangeSetPortal           = "SetPortal"
+	ChangeRemoveGateway       = "RemoveGateway"
+	ChangePurgeImage          = "PurgeImage"
+)
+
+// Change is a change made to the container config by Update.
+type Change struct {
+	Kind string
+	// Target is the name of the additional target in the spec, empty for
+	// the default target and for changes shared by the targets.
+	Target string
+	// Object is what the change applies to: an option, pool, disk as
+	// pool/disk, host group, host or gateway.
+	Object string
+	// Host is the host a LUN is mapped to, for LUN changes.
+	Host string
+	From string
+	To   string
+}
+
+func (c Change) String() string {
+	var s string
+	switch c.Kind {
+	case ChangeSetOption:
+		s = fmt.Sprintf("set option %s from %q to %q", c.Object, c.From, c.To)
+	case ChangeAddTarget:
+		s = fmt.Sprintf("add target %s", c.Object)
+	case ChangeRemoveTarget:
+		s = fmt.Sprintf("remove target %s", c.Object)
+	case ChangeSetTargetName:
+		s = fmt.Sprintf("set target name to %s", c.To)
+	case ChangeSetMutualChap:
+		s = fmt.Sprintf("set mutual CHAP required to %s", c.To)
+	case ChangeAddPool:
+		s = fmt.Sprintf("add pool %s", c.Object)
+	case ChangeRemovePool:
+		s = fmt.Sprintf("remove pool %s", c.Object)
+	case ChangeAddDisk:
+		s = fmt.Sprintf("add disk %s of %s", c.Object, c.To)
+	case ChangeResizeDisk:
+		s = fmt.Sprintf("resize disk %s from %s to %s", c.Object, c.From, c.To)
+	case ChangeSetDeletionPolicy:
+		s = fmt.Sprintf("set deletion policy of disk %s from %q to %q",
+			c.Object, c.From, c.To)
+	case ChangeRemoveDisk:
+		s = fmt.Sprintf("remove disk %s", c.Object)
+	case ChangeAddHostGroup:
+		s = fmt.Sprintf("add host group %s", c.Object)
+	case ChangeRemoveHostGroup:
+		s = fmt.Sprintf("remove host group %s", c.Object)
+	case ChangeAddHost:
+		s = fmt.Sprintf("add host %s with %s authentication", c.Object, c.To)
+	case ChangeSetHostAuth:
+		s = fmt.Sprintf("set authentication of host %s from %s to %s",
+			c.Object, c.From, c.To)
+	case ChangeSetHostGroup:
+		s = fmt.Sprintf("move host %s from group %q to %q", c.Object, c.From, c.To)
+	case ChangeMapLun:
+		s = fmt.Sprintf("map LUN %s to host %s", c.Object, c.Host)
+		if c.To != "" {
+			s += " at " + c.To
+		}
+	case ChangeRemapLun:
+		s = fmt.Sprintf("move LUN %s of host %s from %s to %s",
+			c.Object, c.Host, c.From, c.To)
+	case ChangeUnmapLun:
+		s = fmt.Sprintf("unmap LUN %s from host %s", c.Object, c.Host)
+	case ChangeScheduleHostRemoval:
+		s = fmt.Sprintf("schedule removal of host %s", c.Object)
+	case ChangeCancelHostRemoval:
+		s = fmt.Sprintf("cancel removal of host %s", c.Object)
+	case ChangeRemoveHost:
+		s = fmt.Sprintf("remove host %s", c.Object)
+	case ChangeAddGateway:
+		s = fmt.Sprintf("add gateway %s", c.Object)
+	case ChangeSetPortal:
+		s = fmt.Sprintf("set portal of gateway %s from %q to %q",
+			c.Object, c.From, c.To)
+	case ChangeRemoveGateway:
+		s = fmt.Sprintf("remove gateway %s", c.Object)
+	case ChangePurgeImage:
+		s = fmt.Sprintf("delete image %s", c.Object)
+	default:
+		s = fmt.Sprintf("%s %s", c.Kind, c.Object)
+	}
+	if c.Target != "" {
+		s = "target " + c.Target + ": " + s
+	}
+	return s
+}
+
+// Changes returns the changes the last call to Update made to the
+// container config, in the order they are applied.
+func (pl *Planner) Changes() []Change {
+	return pl.changes
+}
+
+// Paused returns true if the container config and the resources of the
+// gateway are left unchanged, the changes being only planned.
+func (pl *Planner) Paused() bool {
+	return pl.Iscsigateway.Spec.Paused
+}
+
+// copyConfig returns a deep copy of the container config.
+func copyConfig(
+	cc *iscsicc.IscsiContainerConfig) *iscsicc.IscsiContainerConfig {
+
+	out := iscsicc.New()
+	if cc == nil {
+		return out
+	}
+	data, err := json.Marshal(cc)
+	if err != nil {
+		panic(err)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// diffConfig returns the changes turning the before container config into
+// the after one: options first, then every target, additions before
+// removals, then the gateways and the images to delete.
+func diffConfig(before, after *iscsicc.IscsiContainerConfig) []Change {
+	changes := []Change{}
+	opts := func(cc *iscsicc.IscsiContainerConfig) iscsicc.IscsiOptions {
+		return cc.Globals[iscsicc.Globals].Options
+	}
+	bo, ao := opts(before), opts(after)
+	for _, k := range sortedKeys(ao) {
+		if bo[k] != ao[k] {
+			changes = append(changes, Change{
+				Kind: ChangeSetOption, Object: k, From: bo[k], To: ao[k]})
+		}
+	}
+	for _, k := range sortedKeys(bo) {
+		if _, found := ao[k]; !found {
+			changes = append(changes, Change{
+				Kind: ChangeSetOption, Object: k, From: bo[k]})
+		}
+	}
+
+	defaultTarget := func(cc *iscsicc.IscsiContainerConfig) iscsicc.TargetConfig {
+		return iscsicc.TargetConfig{
+			TargetName: cc.TargetName,
+			Storage:    cc.Storage,
+			Hosts:      cc.Hosts,
+			HostGroups: cc.HostGroups,
+		}
+	}
+	changes = append(changes,
+		diffTarget("", defaultTarget(before), defaultTarget(after))...)
+	for _, name := range sortedKeys(after.Targets) {
+		b, found := before.Targets[name]
+		if !found {
+			changes = append(changes, Change{
+				Kind: ChangeAddTarget, Target: name, Object: after.Targets[name].TargetName})
+		}
+		changes = append(changes, diffTarget(name, b, after.Targets[name])...)
+	}
+	for _, name := range sortedKeys(before.Targets) {
+		if _, found := after.Targets[name]; !found {
+			b := before.Targets[name]
+			changes = append(changes,
+				diffTarget(name, b, iscsicc.TargetConfig{TargetName: b.TargetName})...)
+			changes = append(changes, Change{
+				Kind: ChangeRemoveTarget, Target: name, Object: b.TargetName})
+		}
+	}
+
+	for _, gw := range after.Gateways {
+		if !exist(gw, before.Gateways) {
+			changes = append(changes, Change{Kind: ChangeAddGateway, Object: gw})
+		}
+	}
+	for _, gw := range sortedKeys(after.Portals) {
+		if before.Portals[gw] != after.Portals[gw] {
+			changes = append(changes, Change{
+				Kind: ChangeSetPortal, Object: gw,
+				From: before.Portals[gw], To: after.Portals[gw]})
+		}
+	}
+	for _, gw := range before.Gateways {
+		if !exist(gw, after.Gateways) {
+			changes = append(changes, Change{Kind: ChangeRemoveGateway, Object: gw})
+		}
+	}
+	for _, key := range after.Purge {
+		if !exist(key, before.Purge) {
+			changes = append(changes, Change{Kind: ChangePurgeImage, Object: key})
+		}
+	}
+	return changes
+}
+
+// diffTarget returns the changes turning the before target into the
+// after one.
+func diffTarget(name string, before, after iscsicc.TargetConfig) []Change {
+	changes := []Change{}
+	add := func(c Change) {
+		c.Target = name
+		changes = append(changes, c)
+	}
+	if before.TargetName != after.TargetName && after.TargetName != "" {
+		add(Change{Kind: ChangeSetTargetName,
+			From: before.TargetName, To: after.TargetName})
+	}
+	if before.MutualChapRequired != after.MutualChapRequired {
+		add(Change{Kind: ChangeSetMutualChap,
+			From: fmt.Sprint(before.MutualChapRequired),
+			To:   fmt.Sprint(after.MutualChapRequired)})
+	}
+
+	// storage additions and updates
+	for _, pool := range sortedKeys(after.Storage) {
+		bdisks, found := before.Storage[pool]
+		if !found {
+			add(Change{Kind: ChangeAddPool, Object: pool})
+		}
+		for _, disk := range sortedKeys(after.Storage[pool]) {
+			key := iscsicc.DiskKey(pool, disk)
+			a := after.Storage[pool][disk]
+			b, found := bdisks[disk]
+			switch {
+			case !found:
+				add(Change{Kind: ChangeAddDisk, Object: key, To: a.Size})
+			case b.Size != a.Size:
+				add(Change{Kind: ChangeResizeDisk, Object: key,
+					From: b.Size, To: a.Size})
+			}
+			if found && b.DeletionPolicy != a.DeletionPolicy {
+				add(Change{Kind: ChangeSetDeletionPolicy, Object: key,
+					From: b.DeletionPolicy, To: a.DeletionPolicy})
+			}
+		}
+	}
+	for _, group := range sortedKeys(after.HostGroups) {
+		if _, found := before.HostGroups[group]; !found {
+			add(Change{Kind: ChangeAddHostGroup, Object: group})
+		}
+	}
+
+	// host additions and updates
+	for _, host := range sortedKeys(after.Hosts) {
+		a := after.Hosts[host]
+		b, found := before.Hosts[host]
+		if !found {
+			add(Change{Kind: ChangeAddHost, Object: host, To: a.Auth})
+		} else if b.Auth != a.Auth {
+			add(Change{Kind: ChangeSetHostAuth, Object: host,
+				From: b.Auth, To: a.Auth})
+		}
+		if b.Group != a.Group {
+			add(Change{Kind: ChangeSetHostGroup, Object: host,
+				From: b.Group, To: a.Group})
+		}
+		for _, key := range a.Lun {
+			id, hasID := a.LunIDs[key]
+			to := ""
+			if hasID {
+				to = fmt.Sprint(id)
+			}
+			if !exist(key, b.Lun) {
+				add(Change{Kind: ChangeMapLun, Object: key, Host: host, To: to})
+				continue
+			}
+			if bid, found := b.LunIDs[key]; found && hasID && bid != id {
+				add(Change{Kind: ChangeRemapLun, Object: key, Host: host,
+					From: fmt.Sprint(bid), To: to})
+			}
+		}
+		for _, key := range b.Lun {
+			if !exist(key, a.Lun) {
+				add(Change{Kind: ChangeUnmapLun, Object: key, Host: host})
+			}
+		}
+		switch {
+		case b.RemovalRequested == "" && a.RemovalRequested != "":
+			add(Change{Kind: ChangeScheduleHostRemoval, Object: host,
+				To: a.RemovalRequested})
+		case found && b.RemovalRequested != "" && a.RemovalRequested == "":
+			add(Change{Kind: ChangeCancelHostRemoval, Object: host})
+		}
+	}
+
+	// removals
+	for _, host := range sortedKeys(before.Hosts) {
+		if _, found := after.Hosts[host]; !found {
+			add(Change{Kind: ChangeRemoveHost, Object: host})
+		}
+	}
+	for _, group := range sortedKeys(before.HostGroups) {
+		if _, found := after.HostGroups[group]; !found {
+			add(Change{Kind: ChangeRemoveHostGroup, Object: group})
+		}
+	}
+	for _, pool := range sortedKeys(before.Storage) {
+		adisks, found := after.Storage[pool]
+		for _, disk := range sortedKeys(before.Storage[pool]) {
+			if _, kept := adisks[disk]; !kept {
+				add(Change{Kind: ChangeRemoveDisk,
+					Object: iscsicc.DiskKey(pool, disk)})
+			}
+		}
+		if !found {
+			add(Change{Kind: ChangeRemovePool, Object: pool})
+		}
+	}
+	return changes
+}
@@ -0,0 +1,67 @@
+package planner
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	api "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+)
+
+var _ = Describe("Change plan", func() {
+	var cc *iscsicc.IscsiContainerConfig
+
+	// changes plans the spec against cc and returns the changes made.
+	changes := func(spec api.IscsigatewaySpec) []string {
+		pl := newPlanner(newGateway(spec), cc)
+		_, err := pl.Update()
+		Expect(err).NotTo(HaveOccurred())
+		s := []string{}
+		for _, c := range pl.Changes() {
+			s = append(s, c.String())
+		}
+		return s
+	}
+
+	BeforeEach(func() {
+		var err error
+		cc, err = plan(newGateway(api.IscsigatewaySpec{
+			Storage: []api.IscsiStorageSpec{pool("rbd", disk("a", "1Gi"))},
+			Hosts:   []api.IscsiHostSpec{host(host1, lun("rbd", "a"))},
+		}), iscsicc.New())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("has nothing to apply to an up to date config", func() {
+		Expect(changes(api.IscsigatewaySpec{
+			Storage: []api.IscsiStorageSpec{pool("rbd", disk("a", "1Gi"))},
+			Hosts:   []api.IscsiHostSpec{host(host1, lun("rbd", "a"))},
+		})).To(BeEmpty())
+	})
+
+	It("lists the changes of an edit, additions first", func() {
+		Expect(changes(api.IscsigatewaySpec{
+			Storage: []api.IscsiStorageSpec{
+				pool("rbd", disk("a", "2Gi"), disk("b", "1Gi")),
+			},
+			Hosts: []api.IscsiHostSpec{host(host1, lun("rbd", "b"))},
+		})).To(Equal([]string{
+			"resize disk rbd/a from 1Gi to 2Gi",
+			"add disk rbd/b of 1Gi",
+			"map LUN rbd/b to host " + host1 + " at 0",
+			"unmap LUN rbd/a from host " + host1,
+		}))
+	})
+
+	It("names the additional target a change applies to", func() {
+		c := Change{Kind: ChangeRemoveHost, Target: "second", Object: host1}
+		Expect(c.String()).To(Equal("target second: remove host " + host1))
+	})
+
+	It("is only applied when the gateway is not paused", func() {
+		pl := newPlanner(newGateway(api.IscsigatewaySpec{}), cc)
+		Expect(pl.Paused()).To(BeFalse())
+		pl.Iscsigateway.Spec.Paused = true
+		Expect(pl.Paused()).To(BeTrue())
+	})
+})
@@ -11,8 +11,33 @@ func (pl *Planner) IsClustered() bool {
 	return pl.Scale() != 1
 }
 
+// InstanceName returns the name used for the kubernetes resources of the
+// gateway. It is not the target name, which is an IQN, except for the
+// gateways created when the target name was the name of their resources.
 func (pl *Planner) InstanceName() string {
-	return pl.Iscsigateway.Spec.TargetName
+	if pl.instanceName != "" {
+		return pl.instanceName
+	}
+	if name, found := pl.legacyTargetName(); found {
+		return name
+	}
+	return pl.Iscsigateway.Name
+}
+
+// legacyTargetName returns the target name of the spec if it is not an
+// iSCSI name. Older versions of the operator named the resources of the
+// gateway after it; it keeps naming them so that they are adopted, while
+// the target is served under a generated IQN.
+func (pl *Planner) legacyTargetName() (string, bool) {
+	name := pl.Iscsigateway.Spec.TargetName
+	legacy := pl.target == "" && name != "" && !checkValidTargetName(name)
+	return name, legacy
+}
+
+// ContainerConfigName returns the name of the ConfigMap holding the
+// container config, always named after the gateway.
+func (pl *Planner) ContainerConfigName() string {
+	return pl.Iscsigateway.Name
 }
 
 func (pl *Planner) CephConfigName() string {
@@ -0,0 +1,306 @@
+package planner
+
+import (
+	"fmt"
+	"sort"
+
+	api "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// updateStorage brings the storage section of the container config in line
+// with the spec. Disks are never shrunk, and a disk is only dropped once
+// it is no longer mapped to a host and has a deletion policy.
+func (pl *Planner) updateStorage() (changed bool, err error) {
+	for _, pool := range pl.storageSpec() {
+		_, found := pl.ConfigState.Storage[pool.PoolName]
+		if !found {
+			pl.ConfigState.Storage[pool.PoolName] = iscsicc.NewEmptyDisk()
+			changed = true
+		}
+		for _, disk := range pool.Disks {
+			diskChanged, err := pl.updateDisk(pool.PoolName, disk)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || diskChanged
+		}
+	}
+
+	// disks and pools removed from the spec
+	for poolName, disks := range pl.ConfigState.Storage {
+		for diskName, disk := range disks {
+			if pl.diskInSpec(poolName, diskName) {
+				continue
+			}
+			// hosts pending removal keep their LUNs until they are gone
+			if hosts := pl.pendingHostsMapping(
+				iscsicc.DiskKey(poolName, diskName)); len(hosts) > 0 {
+				continue
+			}
+			if err := pl.removeDisk(poolName, diskName, disk); err != nil {
+				return false, err
+			}
+			changed = true
+		}
+		if len(pl.ConfigState.Storage[poolName]) == 0 && !pl.poolInSpec(poolName) {
+			delete(pl.ConfigState.Storage, poolName)
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+func (pl *Planner) updateDisk(
+	poolName string, disk api.IscsiDiskSpec) (bool, error) {
+
+	key := iscsicc.DiskKey(poolName, disk.DiskName)
+	goalSize, err := resource.ParseQuantity(disk.DiskSize)
+	if err != nil {
+		return false, fmt.Errorf("disk %s: invalid size %q: %w",
+			key, disk.DiskSize, err)
+	}
+	goalPolicy := string(disk.DeletionPolicy)
+
+	changed := false
+	// a disk coming back must not be purged
+	if exist(key, pl.ConfigState.Purge) {
+		pl.ConfigState.Purge = remove(key, pl.ConfigState.Purge)
+		changed = true
+	}
+
+	current, found := pl.ConfigState.Storage[poolName][disk.DiskName]
+	if !found {
+		pl.ConfigState.Storage[poolName][disk.DiskName] = iscsicc.NewDiskInfo(
+			disk.DiskSize, goalPolicy)
+		return true, nil
+	}
+
+	currentSize, err := resource.ParseQuantity(current.Size)
+	if err != nil {
+		return false, fmt.Errorf("disk %s: invalid size %q in container config: %w",
+			key, current.Size, err)
+	}
+	switch goalSize.Cmp(currentSize) {
+	case -1:
+		return false, fmt.Errorf("disk %s: refusing to shrink from %s to %s",
+			key, current.Size, disk.DiskSize)
+	case 1:
+		current.Size = disk.DiskSize
+		changed = true
+	}
+	if current.DeletionPolicy != goalPolicy {
+		current.DeletionPolicy = goalPolicy
+		changed = true
+	}
+	pl.ConfigState.Storage[poolName][disk.DiskName] = current
+	return changed, nil
+}
+
+func (pl *Planner) removeDisk(
+	poolName, diskName string, disk iscsicc.DiskInfo) error {
+
+	key := iscsicc.DiskKey(poolName, diskName)
+	if hosts := pl.hostsMapping(key); len(hosts) > 0 {
+		return fmt.Errorf("disk %s: refusing to remove a disk mapped to %v",
+			key, hosts)
+	}
+	switch api.DiskDeletionPolicy(disk.DeletionPolicy) {
+	case api.DiskDeletionRetain:
+	case api.DiskDeletionDelete:
+		pl.ConfigState.Purge = append(pl.ConfigState.Purge, key)
+		sort.Strings(pl.ConfigState.Purge)
+	default:
+		return fmt.Errorf(
+			"disk %s: refusing to remove a disk without a deletion policy,"+
+				" set it to Retain or Delete first", key)
+	}
+	delete(pl.ConfigState.Storage[poolName], diskName)
+	return nil
+}
+
+// PendingPurge returns the disks, as pool/disk, removed with the Delete
+// policy whose RBD image is still to be deleted.
+func (pl *Planner) PendingPurge() []string {
+	if pl.ConfigState == nil {
+		return nil
+	}
+	return pl.ConfigState.Purge
+}
+
+// PurgeDone drops the disks whose RBD image was deleted from the purge
+// list of the container config.
+func (pl *Planner) PurgeDone(keys []string) {
+	for _, key := range keys {
+		pl.ConfigState.Purge = remove(key, pl.ConfigState.Purge)
+	}
+	if len(pl.ConfigState.Purge) == 0 {
+		pl.ConfigState.Purge = nil
+	}
+}
+
+// hostsMapping returns the hosts, of the spec or of the accepted
+// Iscsiinitiators, with a LUN on the disk.
+func (pl *Planner) hostsMapping(key string) []string {
+	hosts := []string{}
+	for _, h := range pl.hostSpecs() {
+		if exist(key, iscsicc.GetLuns(h.Luns)) {
+			hosts = append(hosts, h.HostName)
+		}
+	}
+	return hosts
+}
+
+// pendingHostsMapping returns the hosts pending removal with a LUN on the
+// disk.
+func (pl *Planner) pendingHostsMapping(key string) []string {
+	hosts := []string{}
+	for name, host := range pl.ConfigState.Hosts {
+		if host.RemovalRequested != "" && exist(key, host.Lun) {
+			hosts = append(hosts, name)
+		}
+	}
+	return hosts
+}
+
+func (pl *Planner) poolInSpec(poolName string) bool {
+	for _, pool := range pl.storageSpec() {
+		if pool.PoolName == poolName {
+			return true
+		}
+	}
+	return false
+}
+
+func (pl *Planner) diskInSpec(poolName, diskName string) bool {
+	for _, pool := range pl.storageSpec() {
+		if pool.PoolName != poolName {
+			continue
+		}
+		for _, disk := range pool.Disks {
+			if disk.DiskName == diskName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// storageSpec returns the storage of the spec along with the disks of the
+// Iscsidisks exported by the gateway. The images of Iscsidisks are managed
+// by their own controller, the gateway always retains them.
+func (pl *Planner) storageSpec() []api.IscsiStorageSpec {
+	storage := make([]api.IscsiStorageSpec, 0, len(pl.Iscsigateway.Spec.Storage))
+	pools := map[string]int{}
+	for _, pool := range pl.Iscsigateway.Spec.Storage {
+		pools[pool.PoolName] = len(storage)
+		storage = append(storage, api.IscsiStorageSpec{
+			PoolName: pool.PoolName,
+			Disks:    append([]api.IscsiDiskSpec{}, pool.Disks...),
+		})
+	}
+	for _, d := range pl.iscsidisks() {
+		i, found := pools[d.Spec.Pool]
+		if !found {
+			i = len(storage)
+			pools[d.Spec.Pool] = i
+			storage = append(storage, api.IscsiStorageSpec{PoolName: d.Spec.Pool})
+		}
+		storage[i].Disks = append(storage[i].Disks, api.IscsiDiskSpec{
+			DiskName:       d.ImageName(),
+			DiskSize:       d.Spec.Size.String(),
+			DeletionPolicy: api.DiskDeletionRetain,
+		})
+	}
+	return storage
+}
+
+// iscsidisks returns the Iscsidisks exported by the gateway. An
+// Iscsidisk being deleted is still exported while hosts map it, its
+// controller waits for the gateway to stop exporting it and reports the
+// hosts left on the Iscsidisk.
+func (pl *Planner) iscsidisks() []api.Iscsidisk {
+	mapped := pl.mappedDisks()
+	disks := make([]api.Iscsidisk, 0, len(pl.Disks))
+	for _, d := range pl.Disks {
+		key := iscsicc.DiskKey(d.Spec.Pool, d.ImageName())
+		if d.GetDeletionTimestamp() != nil && !mapped[key] {
+			continue
+		}
+		disks = append(disks, d)
+	}
+	return disks
+}
+
+// mappedDisks returns the disks, as pool/disk, the hosts and host groups
+// of the spec or the Iscsiinitiators map a LUN to. The LUNs of the
+// Iscsiinitiators are taken as requested, whether or not the initiators
+// are accepted.
+func (pl *Planner) mappedDisks() map[string]bool {
+	mapped := map[string]bool{}
+	for _, h := range pl.Iscsigateway.Spec.Hosts {
+		for _, lun := range iscsicc.GetLuns(h.Luns) {
+			mapped[lun] = true
+		}
+	}
+	for _, g := range pl.Iscsigateway.Spec.HostGroups {
+		for _, lun := range iscsicc.GetLuns(g.Luns) {
+			mapped[lun] = true
+		}
+	}
+	for _, ini := range pl.Initiators {
+		for _, lun := range iscsicc.GetLuns(ini.Spec.Luns) {
+			mapped[lun] = true
+		}
+	}
+	return mapped
+}
+
+// validateStorage makes sure the Iscsidisks do not clash with the disks
+// of the spec and that every LUN of the hosts refers to a known disk.
+func (pl *Planner) validateStorage() error {
+	disks := map[string]bool{}
+	for _, pool := range pl.Iscsigateway.Spec.Storage {
+		for _, disk := range pool.Disks {
+			disks[iscsicc.DiskKey(pool.PoolName, disk.DiskName)] = true
+		}
+	}
+	for _, d := range pl.iscsidisks() {
+		key := iscsicc.DiskKey(d.Spec.Pool, d.ImageName())
+		if disks[key] {
+			return fmt.Errorf(
+				"disk %s: declared in the spec and by Iscsidisk %s", key, d.Name)
+		}
+		disks[key] = true
+	}
+	// the LUNs of Iscsiinitiators are checked by CheckInitiators
+	for _, h := range pl.Iscsigateway.Spec.Hosts {
+		for _, lun := range iscsicc.GetLuns(h.Luns) {
+			if !disks[lun] {
+				return fmt.Errorf("host %s: LUN %s is not a disk of the gateway",
+					h.HostName, lun)
+			}
+		}
+	}
+	for _, g := range pl.Iscsigateway.Spec.HostGroups {
+		for _, lun := range iscsicc.GetLuns(g.Luns) {
+			if !disks[lun] {
+				return fmt.Errorf("host group %s: LUN %s is not a disk of the gateway",
+					g.Name, lun)
+			}
+		}
+	}
+	return nil
+}
+
+// diskKeys returns the disks of the gateway, as pool/disk.
+func (pl *Planner) diskKeys() map[string]bool {
+	disks := map[string]bool{}
+	for _, pool := range pl.storageSpec() {
+		for _, disk := range pool.Disks {
+			disks[iscsicc.DiskKey(pool.PoolName, disk.DiskName)] = true
+		}
+	}
+	return disks
+}
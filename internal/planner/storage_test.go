@@ -0,0 +1,92 @@
+package planner
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	api "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+)
+
+var _ = Describe("Storage", func() {
+	var cc *iscsicc.IscsiContainerConfig
+
+	BeforeEach(func() {
+		var err error
+		cc, err = plan(newGateway(api.IscsigatewaySpec{
+			Storage: []api.IscsiStorageSpec{pool("rbd", disk("d1", "10Gi"))},
+		}), iscsicc.New())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cc.Storage["rbd"]["d1"].Size).To(Equal("10Gi"))
+	})
+
+	DescribeTable("resizing a disk",
+		func(size string, fails bool, want string) {
+			_, err := plan(newGateway(api.IscsigatewaySpec{
+				Storage: []api.IscsiStorageSpec{pool("rbd", disk("d1", size))},
+			}), cc)
+			if fails {
+				Expect(err).To(MatchError(ContainSubstring("refusing to shrink")))
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cc.Storage["rbd"]["d1"].Size).To(Equal(want))
+		},
+		Entry("grows the disk", "20Gi", false, "20Gi"),
+		Entry("keeps the size when equal in other units", "10240Mi", false, "10Gi"),
+		Entry("refuses to shrink the disk", "5Gi", true, ""),
+		Entry("refuses to shrink the disk by a byte", "10737418239", true, ""),
+	)
+
+	It("rejects an invalid size", func() {
+		_, err := plan(newGateway(api.IscsigatewaySpec{
+			Storage: []api.IscsiStorageSpec{pool("rbd", disk("d1", "lots"))},
+		}), cc)
+		Expect(err).To(MatchError(ContainSubstring("invalid size")))
+	})
+
+	DescribeTable("removing a disk",
+		func(policy api.DiskDeletionPolicy, fails bool, purged bool) {
+			cc.Storage["rbd"]["d1"] = iscsicc.NewDiskInfo("10Gi", string(policy))
+			_, err := plan(newGateway(api.IscsigatewaySpec{}), cc)
+			if fails {
+				Expect(err).To(MatchError(ContainSubstring("without a deletion policy")))
+				Expect(cc.Storage["rbd"]).To(HaveKey("d1"))
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cc.Storage).NotTo(HaveKey("rbd"))
+			if purged {
+				Expect(cc.Purge).To(ConsistOf("rbd/d1"))
+			} else {
+				Expect(cc.Purge).To(BeEmpty())
+			}
+		},
+		Entry("is refused without a deletion policy", api.DiskDeletionPolicy(""), true, false),
+		Entry("retains the image", api.DiskDeletionRetain, false, false),
+		Entry("purges the image", api.DiskDeletionDelete, false, true),
+	)
+
+	It("refuses to remove a disk still mapped to a host", func() {
+		cc.Storage["rbd"]["d1"] = iscsicc.NewDiskInfo("10Gi", string(api.DiskDeletionDelete))
+		ig := newGateway(api.IscsigatewaySpec{
+			Hosts: []api.IscsiHostSpec{host("iqn.2023-01.com.example:h1", lun("rbd", "d1"))},
+		})
+		_, err := plan(ig, cc)
+		Expect(err).To(MatchError(ContainSubstring("is not a disk of the gateway")))
+		Expect(cc.Storage["rbd"]).To(HaveKey("d1"))
+	})
+
+	It("stops purging a disk added back", func() {
+		cc.Storage["rbd"]["d1"] = iscsicc.NewDiskInfo("10Gi", string(api.DiskDeletionDelete))
+		_, err := plan(newGateway(api.IscsigatewaySpec{}), cc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cc.Purge).To(ConsistOf("rbd/d1"))
+
+		_, err = plan(newGateway(api.IscsigatewaySpec{
+			Storage: []api.IscsiStorageSpec{pool("rbd", disk("d1", "10Gi"))},
+		}), cc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cc.Purge).To(BeEmpty())
+	})
+})
@@ -0,0 +1,76 @@
+package planner
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	api "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/conf"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPlanner(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Planner Suite")
+}
+
+// newGateway returns a gateway with the given spec.
+func newGateway(spec api.IscsigatewaySpec) *api.Iscsigateway {
+	if spec.Scale == 0 {
+		spec.Scale = 2
+	}
+	return &api.Iscsigateway{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "gw",
+			Namespace: "storage",
+		},
+		Spec: spec,
+	}
+}
+
+// newPlanner returns a planner of the gateway, with the default operator
+// config, working on cc.
+func newPlanner(
+	ig *api.Iscsigateway, cc *iscsicc.IscsiContainerConfig) *Planner {
+
+	cfg := conf.DefaultOperatorConfig
+	return New(InstanceConfiguration{
+		Iscsigateway: ig,
+		GlobalConfig: &cfg,
+	}, cc)
+}
+
+// plan runs the planner of the gateway against cc and returns the updated
+// container config.
+func plan(
+	ig *api.Iscsigateway,
+	cc *iscsicc.IscsiContainerConfig) (*iscsicc.IscsiContainerConfig, error) {
+
+	pl := newPlanner(ig, cc)
+	_, err := pl.Update()
+	return pl.ConfigState, err
+}
+
+func disk(name, size string) api.IscsiDiskSpec {
+	return api.IscsiDiskSpec{DiskName: name, DiskSize: size}
+}
+
+func pool(name string, disks ...api.IscsiDiskSpec) api.IscsiStorageSpec {
+	return api.IscsiStorageSpec{PoolName: name, Disks: disks}
+}
+
+func lun(pool, disk string) api.IscsiLunSpec {
+	return api.IscsiLunSpec{PoolName: pool, DiskName: disk}
+}
+
+func lunAt(pool, disk string, id int32) api.IscsiLunSpec {
+	return api.IscsiLunSpec{PoolName: pool, DiskName: disk, LunID: &id}
+}
+
+func host(name string, luns ...api.IscsiLunSpec) api.IscsiHostSpec {
+	return api.IscsiHostSpec{HostName: name, Luns: luns}
+}
@@ -0,0 +1,230 @@
+package planner
+
+import (
+	"fmt"
+	"sort"
+
+	api "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+)
+
+// targetSpec returns the spec of the additional target, nil if it is not
+// in the spec.
+func (pl *Planner) targetSpec(name string) *api.IscsiTargetSpec {
+	for i := range pl.Iscsigateway.Spec.Targets {
+		if pl.Iscsigateway.Spec.Targets[i].Name == name {
+			return &pl.Iscsigateway.Spec.Targets[i]
+		}
+	}
+	return nil
+}
+
+// targetPlanner returns a planner for the additional target. It works on
+// a container config of its own, built from the one of the target, that
+// shares the purge list of the gateway.
+func (pl *Planner) targetPlanner(t api.IscsiTargetSpec) *Planner {
+	ig := pl.Iscsigateway.DeepCopy()
+	ig.Spec = api.IscsigatewaySpec{
+		TargetName: t.TargetName,
+		Storage:    t.Storage,
+		Hosts:      t.Hosts,
+		HostGroups: t.HostGroups,
+		Scale:      ig.Spec.Scale,
+		CephConfig: ig.Spec.CephConfig,
+		Ceph:       ig.Spec.Ceph,
+	}
+	// discovery sessions are configured on the gateway only
+	if t.Auth != nil {
+		ig.Spec.Auth = &api.IscsiTargetAuthSpec{
+			RequireMutualChap: t.Auth.RequireMutualChap,
+		}
+	}
+	ig.Status = api.IscsigatewayStatus{}
+	for _, s := range pl.Iscsigateway.Status.Targets {
+		if s.Name == t.Name {
+			ig.Status.TargetName = s.TargetName
+		}
+	}
+
+	state := iscsicc.New()
+	if pl.ConfigState != nil {
+		state.Purge = pl.ConfigState.Purge
+		if cfg, found := pl.ConfigState.Targets[t.Name]; found {
+			state.TargetName = cfg.TargetName
+			if cfg.Storage != nil {
+				state.Storage = cfg.Storage
+			}
+			if cfg.Hosts != nil {
+				state.Hosts = cfg.Hosts
+			}
+			state.HostGroups = cfg.HostGroups
+		}
+	}
+	tp := New(InstanceConfiguration{
+		Iscsigateway: ig,
+		GlobalConfig: pl.GlobalConfig,
+		ChapSecrets:  pl.ChapSecrets,
+	}, state)
+	tp.target = t.Name
+	tp.instanceName = pl.InstanceName()
+	tp.sessions = pl.sessions
+	return tp
+}
+
+// validateTargets makes sure the additional targets have distinct names
+// and that no disk is exported by more than one target.
+func (pl *Planner) validateTargets() error {
+	owners := map[string]string{}
+	for key := range pl.diskKeys() {
+		owners[key] = "the default target"
+	}
+	names := map[string]bool{}
+	for _, t := range pl.Iscsigateway.Spec.Targets {
+		if t.Name == "" {
+			return fmt.Errorf("target without a name")
+		}
+		if names[t.Name] {
+			return fmt.Errorf("target %s is defined twice", t.Name)
+		}
+		names[t.Name] = true
+		for _, pool := range t.Storage {
+			for _, disk := range pool.Disks {
+				key := iscsicc.DiskKey(pool.PoolName, disk.DiskName)
+				if owner, found := owners[key]; found {
+					return fmt.Errorf(
+						"target %s: disk %s is already exported by %s",
+						t.Name, key, owner)
+				}
+				owners[key] = "target " + t.Name
+			}
+		}
+	}
+	return nil
+}
+
+// updateTargets brings the additional targets of the container config in
+// line with the spec. A target removed from the spec is kept until its
+// hosts are past the removal grace period and its disks are removed.
+func (pl *Planner) updateTargets() (bool, error) {
+	if err := pl.validateTargets(); err != nil {
+		return false, err
+	}
+	if pl.ConfigState.Targets == nil {
+		pl.ConfigState.Targets = map[string]iscsicc.TargetConfig{}
+	}
+
+	specs := append([]api.IscsiTargetSpec{}, pl.Iscsigateway.Spec.Targets...)
+	removed := []string{}
+	for name := range pl.ConfigState.Targets {
+		if pl.targetSpec(name) == nil {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+	for _, name := range removed {
+		specs = append(specs, api.IscsiTargetSpec{Name: name})
+	}
+
+	changed := false
+	targetNames := []string{pl.ConfigState.TargetName}
+	for _, t := range specs {
+		tp := pl.targetPlanner(t)
+		targetChanged, err := tp.updateTarget()
+		if err != nil {
+			return false, fmt.Errorf("target %s: %w", t.Name, err)
+		}
+		if exist(tp.ConfigState.TargetName, targetNames) {
+			return false, fmt.Errorf("target %s: target name %s is already in use",
+				t.Name, tp.ConfigState.TargetName)
+		}
+		targetNames = append(targetNames, tp.ConfigState.TargetName)
+		pl.ConfigState.Purge = tp.ConfigState.Purge
+
+		current, found := pl.ConfigState.Targets[t.Name]
+		if pl.targetSpec(t.Name) == nil &&
+			len(tp.ConfigState.Hosts) == 0 && len(tp.ConfigState.Storage) == 0 {
+			delete(pl.ConfigState.Targets, t.Name)
+			changed = true
+			continue
+		}
+		goal := iscsicc.TargetConfig{
+			TargetName:         tp.ConfigState.TargetName,
+			Storage:            tp.ConfigState.Storage,
+			Hosts:              tp.ConfigState.Hosts,
+			HostGroups:         tp.ConfigState.HostGroups,
+			MutualChapRequired: tp.mutualChapRequired(),
+		}
+		if !found || current.MutualChapRequired != goal.MutualChapRequired {
+			targetChanged = true
+		}
+		pl.ConfigState.Targets[t.Name] = goal
+		changed = changed || targetChanged
+	}
+
+	// a disk moved to a target planned ahead of the one it left must not
+	// be purged
+	purge := []string{}
+	exported := pl.exportedDisks()
+	for _, key := range pl.ConfigState.Purge {
+		if exported[key] {
+			changed = true
+			continue
+		}
+		purge = append(purge, key)
+	}
+	pl.ConfigState.Purge = purge
+	return changed, nil
+}
+
+// exportedDisks returns the disks, as pool/disk, of every target in the
+// container config.
+func (pl *Planner) exportedDisks() map[string]bool {
+	disks := map[string]bool{}
+	add := func(storage iscsicc.PoolConfig) {
+		for poolName, pool := range storage {
+			for diskName := range pool {
+				disks[iscsicc.DiskKey(poolName, diskName)] = true
+			}
+		}
+	}
+	add(pl.ConfigState.Storage)
+	for _, t := range pl.ConfigState.Targets {
+		add(t.Storage)
+	}
+	return disks
+}
+
+// TargetNames returns the IQNs of every target in the container config,
+// the default target first.
+func (pl *Planner) TargetNames() []string {
+	names := []string{}
+	if pl.ConfigState == nil {
+		return names
+	}
+	if pl.ConfigState.TargetName != "" {
+		names = append(names, pl.ConfigState.TargetName)
+	}
+	for _, t := range pl.TargetStates() {
+		names = append(names, t.TargetName)
+	}
+	return names
+}
+
+// TargetStates returns the additional targets of the container config,
+// sorted by name.
+func (pl *Planner) TargetStates() []api.IscsiTargetState {
+	states := []api.IscsiTargetState{}
+	if pl.ConfigState == nil {
+		return states
+	}
+	for name, t := range pl.ConfigState.Targets {
+		states = append(states, api.IscsiTargetState{
+			Name:       name,
+			TargetName: t.TargetName,
+		})
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+	return states
+}
@@ -0,0 +1,142 @@
+package planner
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	api "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+)
+
+var _ = Describe("Targets", func() {
+	It("generates a stable IQN for the default target", func() {
+		ig := newGateway(api.IscsigatewaySpec{})
+		cc, err := plan(ig, iscsicc.New())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cc.TargetName).To(Equal(
+			"iqn.2003-01.com.redhat.iscsi-gw:storage.gw"))
+
+		// a name written before is kept
+		cc.TargetName = "iqn.2023-01.com.example:kept"
+		_, err = plan(ig, cc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cc.TargetName).To(Equal("iqn.2023-01.com.example:kept"))
+
+		// as is the name reported in the status
+		ig.Status.TargetName = "iqn.2023-01.com.example:reported"
+		_, err = plan(ig, cc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cc.TargetName).To(Equal("iqn.2023-01.com.example:reported"))
+	})
+
+	It("generates the IQN under the naming authority of the operator", func() {
+		pl := newPlanner(newGateway(api.IscsigatewaySpec{}), iscsicc.New())
+		pl.GlobalConfig.NamingAuthority = "iqn.2023-01.com.example"
+		_, err := pl.Update()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pl.ConfigState.TargetName).To(Equal(
+			"iqn.2023-01.com.example:storage.gw"))
+	})
+
+	DescribeTable("naming the default target",
+		func(targetName, want, instanceName string) {
+			ig := newGateway(api.IscsigatewaySpec{TargetName: targetName})
+			pl := newPlanner(ig, iscsicc.New())
+			_, err := pl.Update()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pl.ConfigState.TargetName).To(Equal(want))
+			Expect(pl.InstanceName()).To(Equal(instanceName))
+			Expect(pl.ContainerConfigName()).To(Equal("gw"))
+		},
+		Entry("uses the IQN of the spec",
+			"iqn.2023-01.com.example:disks",
+			"iqn.2023-01.com.example:disks", "gw"),
+		Entry("uses the EUI of the spec",
+			"eui.02004567A425678D", "eui.02004567A425678D", "gw"),
+		Entry("generates an IQN for a legacy name, which names the resources",
+			"legacy", "iqn.2003-01.com.redhat.iscsi-gw:storage.gw", "legacy"),
+	)
+
+	Describe("additional targets", func() {
+		storage := []api.IscsiStorageSpec{pool("rbd", disk("a", "1Gi"))}
+		second := api.IscsiTargetSpec{
+			Name:    "second",
+			Storage: []api.IscsiStorageSpec{pool("rbd", disk("b", "2Gi"))},
+			Hosts:   []api.IscsiHostSpec{host(host2, lun("rbd", "b"))},
+		}
+
+		It("keeps the disks and hosts of each target apart", func() {
+			cc, err := plan(newGateway(api.IscsigatewaySpec{
+				Storage: storage,
+				Hosts:   []api.IscsiHostSpec{host(host1, lun("rbd", "a"))},
+				Targets: []api.IscsiTargetSpec{second},
+			}), iscsicc.New())
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(cc.Storage["rbd"]).To(HaveKey("a"))
+			Expect(cc.Storage["rbd"]).NotTo(HaveKey("b"))
+			Expect(cc.Hosts).To(HaveKey(host1))
+			Expect(cc.Hosts).NotTo(HaveKey(host2))
+
+			t := cc.Targets["second"]
+			Expect(t.TargetName).To(Equal(
+				"iqn.2003-01.com.redhat.iscsi-gw:storage.gw.second"))
+			Expect(t.Storage["rbd"]).To(HaveKey("b"))
+			Expect(t.Hosts[host2].LunIDs).To(Equal(map[string]int32{"rbd/b": 0}))
+		})
+
+		DescribeTable("validating targets",
+			func(targets []api.IscsiTargetSpec, msg string) {
+				_, err := plan(newGateway(api.IscsigatewaySpec{
+					Storage: storage,
+					Targets: targets,
+				}), iscsicc.New())
+				Expect(err).To(MatchError(ContainSubstring(msg)))
+			},
+			Entry("rejects a target without a name",
+				[]api.IscsiTargetSpec{{}}, "target without a name"),
+			Entry("rejects a target defined twice",
+				[]api.IscsiTargetSpec{second, second}, "defined twice"),
+			Entry("rejects a disk exported by two targets",
+				[]api.IscsiTargetSpec{{
+					Name:    "other",
+					Storage: storage,
+				}}, "already exported by the default target"),
+			Entry("rejects a target name already in use",
+				[]api.IscsiTargetSpec{{
+					Name:       "other",
+					TargetName: "iqn.2003-01.com.redhat.iscsi-gw:storage.gw",
+				}}, "already in use"),
+		)
+
+		It("keeps a removed target until its hosts are gone", func() {
+			now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+			timeNow = func() time.Time { return now }
+			DeferCleanup(func() { timeNow = time.Now })
+
+			cc, err := plan(newGateway(api.IscsigatewaySpec{
+				Storage: storage,
+				Targets: []api.IscsiTargetSpec{{
+					Name:  "second",
+					Hosts: []api.IscsiHostSpec{host(host2)},
+				}},
+			}), iscsicc.New())
+			Expect(err).NotTo(HaveOccurred())
+
+			ig := newGateway(api.IscsigatewaySpec{Storage: storage})
+			pl := newPlanner(ig, cc)
+			_, err = pl.Update()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cc.Targets).To(HaveKey("second"))
+			Expect(pl.PendingHostRemovals()).To(HaveLen(1))
+			Expect(pl.PendingHostRemovals()[0].Target).To(Equal("second"))
+
+			now = now.Add(10 * time.Minute)
+			_, err = plan(ig, cc)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cc.Targets).NotTo(HaveKey("second"))
+		})
+	})
+})
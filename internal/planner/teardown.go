@@ -0,0 +1,102 @@
+package planner
+
+import (
+	"sort"
+	"time"
+
+	api "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+)
+
+// Drain removes every host, of every target, from the container config so
+// that the gateways log the initiators out. Returns true if the config changed.
+func (pl *Planner) Drain() bool {
+	changed := false
+	if len(pl.ConfigState.Hosts) > 0 {
+		pl.ConfigState.Hosts = iscsicc.HostConfig{}
+		changed = true
+	}
+	for name, t := range pl.ConfigState.Targets {
+		if len(t.Hosts) > 0 {
+			t.Hosts = iscsicc.HostConfig{}
+			pl.ConfigState.Targets[name] = t
+			changed = true
+		}
+	}
+	return changed
+}
+
+// DrainTimeout returns how long initiators are given to log out before
+// the gateways are stopped.
+func (pl *Planner) DrainTimeout() time.Duration {
+	d, err := time.ParseDuration(pl.GlobalConfig.DrainTimeout)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// TeardownTimeout returns how long the teardown waits for a ready gateway
+// to remove the targets through.
+func (pl *Planner) TeardownTimeout() time.Duration {
+	d, err := time.ParseDuration(pl.GlobalConfig.TeardownTimeout)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// SkipTargetRemoval returns true if the teardown may go on without
+// removing the targets, as asked through the SkipTargetRemovalAnnotation.
+func (pl *Planner) SkipTargetRemoval() bool {
+	return pl.Iscsigateway.GetAnnotations()[api.SkipTargetRemovalAnnotation] == "true"
+}
+
+// TeardownPurge returns the disks, as pool/disk, whose RBD image must be
+// deleted along with the gateway. Disks without a deletion policy are
+// retained.
+func (pl *Planner) TeardownPurge() []string {
+	purge := []string{}
+	purge = append(purge, pl.ConfigState.Purge...)
+	add := func(storage iscsicc.PoolConfig) {
+		for poolName, disks := range storage {
+			for diskName, disk := range disks {
+				key := iscsicc.DiskKey(poolName, diskName)
+				if api.DiskDeletionPolicy(disk.DeletionPolicy) == api.DiskDeletionDelete &&
+					!exist(key, purge) {
+					purge = append(purge, key)
+				}
+			}
+		}
+	}
+	add(pl.ConfigState.Storage)
+	for _, t := range pl.ConfigState.Targets {
+		add(t.Storage)
+	}
+	sort.Strings(purge)
+	return purge
+}
+
+// TeardownRetain returns the disks, as pool/disk, dropped from the
+// gateway configuration along with the gateway while their RBD image is
+// kept.
+func (pl *Planner) TeardownRetain() []string {
+	purge := pl.TeardownPurge()
+	retain := []string{}
+	add := func(storage iscsicc.PoolConfig) {
+		for poolName, disks := range storage {
+			for diskName := range disks {
+				key := iscsicc.DiskKey(poolName, diskName)
+				if !exist(key, purge) && !exist(key, retain) {
+					retain = append(retain, key)
+				}
+			}
+		}
+	}
+	add(pl.ConfigState.Storage)
+	for _, t := range pl.ConfigState.Targets {
+		add(t.Storage)
+	}
+	sort.Strings(retain)
+	return retain
+}
@@ -0,0 +1,339 @@
+// Package rbdapi is a client of rbd-target-api, the REST API ceph-iscsi
+// gateways are configured through.
+package rbdapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds the requests of a client created without an
+// http.Client.
+const DefaultTimeout = 30 * time.Second
+
+// Client calls the API of a single gateway.
+type Client struct {
+	baseURL    string
+	username   string
+	password   string
+	httpClient *http.Client
+}
+
+// New returns a client of the API at baseURL, such as
+// http://gw-0.gw.ns.svc:5001, authenticating with the given credentials.
+// A nil httpClient uses one with DefaultTimeout.
+func New(
+	baseURL, username, password string, httpClient *http.Client) *Client {
+
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: DefaultTimeout}
+	}
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		username:   username,
+		password:   password,
+		httpClient: httpClient,
+	}
+}
+
+// Error is an error returned by the API.
+type Error struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %d %s",
+		e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// IsNotFound returns true if the API reported the object of the request
+// as not found.
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// IsUnauthorized returns true if the API rejected the credentials of the
+// client.
+func IsUnauthorized(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) &&
+		apiErr.StatusCode == http.StatusUnauthorized
+}
+
+// Ping checks the API is up and accepts the credentials of the client.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/api/_ping", nil, nil)
+}
+
+// Config returns the configuration of the gateways.
+func (c *Client) Config(ctx context.Context) (*Config, error) {
+	cfg := NewConfig()
+	if err := c.do(ctx, http.MethodGet, "/api/config", nil, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// CreateTarget defines the target.
+func (c *Client) CreateTarget(ctx context.Context, target string) error {
+	return c.do(ctx, http.MethodPut, path("target", target), nil, nil)
+}
+
+// DeleteTarget removes the target.
+func (c *Client) DeleteTarget(ctx context.Context, target string) error {
+	return c.do(ctx, http.MethodDelete, path("target", target), nil, nil)
+}
+
+// CreateGateway adds the gateway to the target portal group of the
+// target, serving it at the given addresses.
+func (c *Client) CreateGateway(
+	ctx context.Context, target, name string, ips []string) error {
+
+	form := url.Values{"ip_address": {strings.Join(ips, ",")}}
+	return c.do(ctx, http.MethodPut, path("gateway", target, name), form, nil)
+}
+
+// DeleteGateway removes the gateway from the target portal group of the
+// target.
+func (c *Client) DeleteGateway(
+	ctx context.Context, target, name string) error {
+
+	return c.do(ctx, http.MethodDelete, path("gateway", target, name), nil, nil)
+}
+
+// CreateDisk defines the RBD image pool/image as a disk of the gateways,
+// creating the image with the given size, such as 10G, if create is true.
+func (c *Client) CreateDisk(
+	ctx context.Context, pool, image, size string, create bool) error {
+
+	form := url.Values{
+		"mode":         {"create"},
+		"size":         {size},
+		"create_image": {strconv.FormatBool(create)},
+	}
+	return c.do(ctx, http.MethodPut, path("disk", pool, image), form, nil)
+}
+
+// ResizeDisk grows the disk to the given size.
+func (c *Client) ResizeDisk(
+	ctx context.Context, pool, image, size string) error {
+
+	form := url.Values{"mode": {"resize"}, "size": {size}}
+	return c.do(ctx, http.MethodPut, path("disk", pool, image), form, nil)
+}
+
+// DeleteDisk removes the disk from the gateways, leaving the image.
+func (c *Client) DeleteDisk(ctx context.Context, pool, image string) error {
+	form := url.Values{"preserve_image": {"true"}}
+	return c.do(ctx, http.MethodDelete, path("disk", pool, image), form, nil)
+}
+
+// PurgeDisk removes the disk from the gateways and deletes its image. The
+// disk must not be exported by any target.
+func (c *Client) PurgeDisk(ctx context.Context, pool, image string) error {
+	form := url.Values{"preserve_image": {"false"}}
+	return c.do(ctx, http.MethodDelete, path("disk", pool, image), form, nil)
+}
+
+// AddTargetLun exports the disk, as pool/image, through the target at the
+// given LUN.
+func (c *Client) AddTargetLun(
+	ctx context.Context, target, disk string, lunID int32) error {
+
+	form := url.Values{
+		"disk":   {disk},
+		"lun_id": {strconv.Itoa(int(lunID))},
+	}
+	return c.do(ctx, http.MethodPut, path("targetlun", target), form, nil)
+}
+
+// RemoveTargetLun stops exporting the disk through the target.
+func (c *Client) RemoveTargetLun(
+	ctx context.Context, target, disk string) error {
+
+	form := url.Values{"disk": {disk}}
+	return c.do(ctx, http.MethodDelete, path("targetlun", target), form, nil)
+}
+
+// CreateClient allows the initiator to log in to the target.
+func (c *Client) CreateClient(
+	ctx context.Context, target, client string) error {
+
+	return c.do(ctx, http.MethodPut, path("client", target, client), nil, nil)
+}
+
+// DeleteClient removes the initiator from the target.
+func (c *Client) DeleteClient(
+	ctx context.Context, target, client string) error {
+
+	return c.do(ctx, http.MethodDelete, path("client", target, client), nil, nil)
+}
+
+// ClientInfo returns the state of the sessions of the initiator to the
+// target on the gateway the client calls.
+func (c *Client) ClientInfo(
+	ctx context.Context, target, client string) (*ClientInfo, error) {
+
+	info := &ClientInfo{}
+	err := c.do(ctx, http.MethodGet, path("clientinfo", target, client), nil, info)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// SetClientAuth sets the CHAP credentials of the initiator.
+func (c *Client) SetClientAuth(
+	ctx context.Context, target, client string, auth Auth) error {
+
+	return c.do(ctx, http.MethodPut,
+		path("clientauth", target, client), authForm(auth), nil)
+}
+
+// AddClientLun maps the disk, a LUN of the target, to the initiator.
+func (c *Client) AddClientLun(
+	ctx context.Context, target, client, disk string) error {
+
+	form := url.Values{"disk": {disk}}
+	return c.do(ctx, http.MethodPut, path("clientlun", target, client), form, nil)
+}
+
+// RemoveClientLun unmaps the disk from the initiator.
+func (c *Client) RemoveClientLun(
+	ctx context.Context, target, client, disk string) error {
+
+	form := url.Values{"disk": {disk}}
+	return c.do(ctx, http.MethodDelete,
+		path("clientlun", target, client), form, nil)
+}
+
+// SetTargetAuth sets the CHAP credentials of the target.
+func (c *Client) SetTargetAuth(
+	ctx context.Context, target string, auth Auth) error {
+
+	return c.do(ctx, http.MethodPut, path("targetauth", target), authForm(auth), nil)
+}
+
+// SetDiscoveryAuth sets the CHAP credentials of discovery sessions.
+func (c *Client) SetDiscoveryAuth(ctx context.Context, auth Auth) error {
+	return c.do(ctx, http.MethodPut, "/api/discoveryauth", authForm(auth), nil)
+}
+
+// AddToHostGroup adds the initiators and disks to the host group of the
+// target, creating the group if needed.
+func (c *Client) AddToHostGroup(
+	ctx context.Context, target, group string, members, disks []string) error {
+
+	return c.do(ctx, http.MethodPut, path("hostgroup", target, group),
+		hostGroupForm("add", members, disks), nil)
+}
+
+// RemoveFromHostGroup removes the initiators and disks from the host group
+// of the target.
+func (c *Client) RemoveFromHostGroup(
+	ctx context.Context, target, group string, members, disks []string) error {
+
+	return c.do(ctx, http.MethodPut, path("hostgroup", target, group),
+		hostGroupForm("remove", members, disks), nil)
+}
+
+// DeleteHostGroup removes the host group from the target. Its members
+// keep their LUNs.
+func (c *Client) DeleteHostGroup(
+	ctx context.Context, target, group string) error {
+
+	return c.do(ctx, http.MethodDelete, path("hostgroup", target, group), nil, nil)
+}
+
+func path(resource string, names ...string) string {
+	p := "/api/" + resource
+	for _, n := range names {
+		p += "/" + url.PathEscape(n)
+	}
+	return p
+}
+
+func authForm(auth Auth) url.Values {
+	return url.Values{
+		"username":        {auth.Username},
+		"password":        {auth.Password},
+		"mutual_username": {auth.MutualUsername},
+		"mutual_password": {auth.MutualPassword},
+	}
+}
+
+func hostGroupForm(action string, members, disks []string) url.Values {
+	form := url.Values{"action": {action}}
+	if len(members) > 0 {
+		form.Set("members", strings.Join(members, ","))
+	}
+	if len(disks) > 0 {
+		form.Set("disks", strings.Join(disks, ","))
+	}
+	return form
+}
+
+// do sends the request, with the form as its body, and decodes the JSON
+// response into out unless it is nil.
+func (c *Client) do(
+	ctx context.Context,
+	method, p string,
+	form url.Values,
+	out interface{}) error {
+
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+p, body)
+	if err != nil {
+		return err
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.SetBasicAuth(c.username, c.password)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg := struct {
+			Message string `json:"message"`
+		}{}
+		if json.Unmarshal(data, &msg) != nil || msg.Message == "" {
+			msg.Message = strings.TrimSpace(string(data))
+		}
+		return &Error{
+			Method:     method,
+			Path:       p,
+			StatusCode: resp.StatusCode,
+			Message:    msg.Message,
+		}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%s %s: invalid response: %w", method, p, err)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package rbdapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	target  = "iqn.2003-01.com.redhat.iscsi-gw:gw"
+	client1 = "iqn.2023-01.com.example:h1"
+	client2 = "iqn.2023-01.com.example:h2"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		ctx    context.Context
+		fake   *FakeServer
+		client *Client
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		fake = NewFakeServer("admin", "secret")
+		client = fake.Client()
+	})
+
+	// exportDisk defines the target with the disk exported at LUN 0.
+	exportDisk := func(disk string) {
+		Expect(client.CreateTarget(ctx, target)).To(Succeed())
+		Expect(client.CreateDisk(ctx, "rbd", disk, "1G", true)).To(Succeed())
+		Expect(client.AddTargetLun(ctx, target, "rbd/"+disk, 0)).To(Succeed())
+	}
+
+	It("configures a target", func() {
+		exportDisk("d1")
+		Expect(client.CreateGateway(ctx, target, "gw-0", []string{"10.0.0.1"})).To(Succeed())
+		Expect(client.CreateClient(ctx, target, client1)).To(Succeed())
+		Expect(client.SetClientAuth(ctx, target, client1, Auth{
+			Username: "user", Password: "password",
+		})).To(Succeed())
+		Expect(client.AddClientLun(ctx, target, client1, "rbd/d1")).To(Succeed())
+
+		cfg, err := client.Config(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.Disks).To(HaveKeyWithValue("rbd/d1", Disk{
+			Pool: "rbd", Image: "d1", Backstore: "user:rbd",
+		}))
+		t := cfg.Targets[target]
+		Expect(t.Disks).To(Equal(map[string]Lun{"rbd/d1": {LunID: 0}}))
+		Expect(t.Portals).To(HaveKeyWithValue("gw-0",
+			Portal{PortalIPAddresses: []string{"10.0.0.1"}}))
+		Expect(t.Clients[client1].Auth.Username).To(Equal("user"))
+		Expect(t.Clients[client1].Luns).To(HaveKey("rbd/d1"))
+		Expect(cfg.Epoch).To(Equal(7))
+	})
+
+	It("maps the disks of a host group to its members", func() {
+		exportDisk("d1")
+		for _, c := range []string{client1, client2} {
+			Expect(client.CreateClient(ctx, target, c)).To(Succeed())
+		}
+		Expect(client.AddToHostGroup(ctx, target, "cluster",
+			[]string{client2, client1}, []string{"rbd/d1"})).To(Succeed())
+
+		t := fake.Config().Targets[target]
+		Expect(t.Groups["cluster"].Members).To(Equal([]string{client1, client2}))
+		Expect(t.Clients[client2].GroupName).To(Equal("cluster"))
+		Expect(t.Clients[client2].Luns).To(HaveKey("rbd/d1"))
+
+		err := client.DeleteClient(ctx, target, client1)
+		Expect(err).To(MatchError(ContainSubstring("is a member of cluster")))
+
+		Expect(client.RemoveFromHostGroup(ctx, target, "cluster",
+			[]string{client1}, nil)).To(Succeed())
+		Expect(client.DeleteClient(ctx, target, client1)).To(Succeed())
+		Expect(client.DeleteHostGroup(ctx, target, "cluster")).To(Succeed())
+		Expect(fake.Config().Targets[target].Clients[client2].GroupName).To(BeEmpty())
+	})
+
+	DescribeTable("reporting errors",
+		func(call func(*Client) error, status int, notFound bool) {
+			exportDisk("d1")
+			err := call(client)
+			var apiErr *Error
+			Expect(err).To(BeAssignableToTypeOf(apiErr))
+			Expect(err.(*Error).StatusCode).To(Equal(status))
+			Expect(IsNotFound(err)).To(Equal(notFound))
+		},
+		Entry("of an unknown target", func(c *Client) error {
+			return c.DeleteTarget(context.Background(), "iqn.2003-01.com.example:none")
+		}, http.StatusNotFound, true),
+		Entry("of an unknown disk", func(c *Client) error {
+			return c.ResizeDisk(context.Background(), "rbd", "none", "2G")
+		}, http.StatusNotFound, true),
+		Entry("of a LUN id already used", func(c *Client) error {
+			Expect(c.CreateDisk(context.Background(), "rbd", "d2", "1G", true)).To(Succeed())
+			return c.AddTargetLun(context.Background(), target, "rbd/d2", 0)
+		}, http.StatusBadRequest, false),
+		Entry("of a disk still exported", func(c *Client) error {
+			return c.DeleteDisk(context.Background(), "rbd", "d1")
+		}, http.StatusBadRequest, false),
+		Entry("of a disk purged while still exported", func(c *Client) error {
+			return c.PurgeDisk(context.Background(), "rbd", "d1")
+		}, http.StatusBadRequest, false),
+	)
+
+	It("deletes a disk no longer exported", func() {
+		exportDisk("d1")
+		Expect(client.RemoveTargetLun(ctx, target, "rbd/d1")).To(Succeed())
+		Expect(client.DeleteDisk(ctx, "rbd", "d1")).To(Succeed())
+		Expect(fake.Config().Disks).To(BeEmpty())
+		Expect(fake.Requests()).To(ContainElement("DELETE /api/disk/rbd/d1"))
+	})
+
+	It("purges a disk no longer exported", func() {
+		exportDisk("d1")
+		Expect(client.RemoveTargetLun(ctx, target, "rbd/d1")).To(Succeed())
+		Expect(client.PurgeDisk(ctx, "rbd", "d1")).To(Succeed())
+		Expect(fake.Config().Disks).To(BeEmpty())
+
+		err := client.PurgeDisk(ctx, "rbd", "d1")
+		Expect(IsNotFound(err)).To(BeTrue())
+	})
+
+	It("reports the sessions of a client", func() {
+		exportDisk("d1")
+		Expect(client.CreateClient(ctx, target, client1)).To(Succeed())
+
+		info, err := client.ClientInfo(ctx, target, client1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.LoggedIn()).To(BeFalse())
+
+		fake.SetLoggedIn(target, client1, true)
+		info, err = client.ClientInfo(ctx, target, client1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.LoggedIn()).To(BeTrue())
+
+		_, err = client.ClientInfo(ctx, target, client2)
+		Expect(IsNotFound(err)).To(BeTrue())
+	})
+
+	It("rejects invalid credentials", func() {
+		client = New("http://rbd-target-api", "admin", "wrong",
+			&http.Client{Transport: handlerTransport{fake}})
+		err := client.Ping(ctx)
+		Expect(IsUnauthorized(err)).To(BeTrue())
+		Expect(fake.Requests()).To(BeEmpty())
+	})
+
+	It("fails the requests it is told to", func() {
+		fake.FailNext("/api/config", 1)
+		_, err := client.Config(ctx)
+		Expect(err).To(MatchError(ContainSubstring("500 injected failure")))
+		_, err = client.Config(ctx)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("serves the API over HTTP", func() {
+		server := httptest.NewServer(fake)
+		DeferCleanup(server.Close)
+		client = New(server.URL+"/", "admin", "secret", nil)
+
+		Expect(client.Ping(ctx)).To(Succeed())
+		Expect(client.SetDiscoveryAuth(ctx, Auth{
+			Username: "discovery", Password: "password",
+		})).To(Succeed())
+		cfg, err := client.Config(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.DiscoveryAuth.Username).To(Equal("discovery"))
+	})
+})
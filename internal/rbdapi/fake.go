@@ -0,0 +1,566 @@
+package rbdapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FakeServer is an in-memory implementation of the subset of
+// rbd-target-api used by Client, for exercising the operator without a
+// ceph cluster. It serves the API as an http.Handler, over a listener such
+// as httptest.NewServer or in process through Client. Unlike the real API
+// it creates objects idempotently.
+type FakeServer struct {
+	username string
+	password string
+
+	mu       sync.Mutex
+	config   *Config
+	requests []string
+	failures map[string]int
+	// sessions holds the initiators logged in, keyed by target/client.
+	sessions map[string]bool
+}
+
+// NewFakeServer returns a fake API with an empty configuration, accepting
+// the given credentials.
+func NewFakeServer(username, password string) *FakeServer {
+	return &FakeServer{
+		username: username,
+		password: password,
+		config:   NewConfig(),
+		failures: map[string]int{},
+		sessions: map[string]bool{},
+	}
+}
+
+// Client returns a client calling the fake in process.
+func (f *FakeServer) Client() *Client {
+	return New("http://rbd-target-api", f.username, f.password,
+		&http.Client{Transport: handlerTransport{f}})
+}
+
+// Config returns a copy of the configuration of the fake.
+func (f *FakeServer) Config() *Config {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return copyConfig(f.config)
+}
+
+// SetConfig replaces the configuration of the fake, such as to simulate
+// changes made behind the back of the operator.
+func (f *FakeServer) SetConfig(cfg *Config) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.config = copyConfig(cfg)
+}
+
+// Requests returns the requests served so far, as "METHOD path".
+func (f *FakeServer) Requests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.requests...)
+}
+
+// SetLoggedIn simulates the initiator logging in to, or out of, the
+// target.
+func (f *FakeServer) SetLoggedIn(target, client string, loggedIn bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sessions[target+"/"+client] = loggedIn
+}
+
+// FailNext makes the next n requests to the path, such as /api/config,
+// fail with an internal server error.
+func (f *FakeServer) FailNext(path string, n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[path] = n
+}
+
+func (f *FakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, password, ok := r.BasicAuth()
+	if !ok || user != f.username || password != f.password {
+		reply(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	form, err := readForm(r)
+	if err != nil {
+		reply(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	if n := f.failures[r.URL.Path]; n > 0 {
+		f.failures[r.URL.Path] = n - 1
+		reply(w, http.StatusInternalServerError, "injected failure")
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
+	for i := range parts {
+		if parts[i], err = url.PathUnescape(parts[i]); err != nil {
+			reply(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	status, msg := f.serve(r.Method, parts, form)
+	if status == http.StatusOK && r.Method == http.MethodGet {
+		var out interface{}
+		switch parts[0] {
+		case "config":
+			out = f.config
+		case "clientinfo":
+			info := ClientInfo{IPAddress: []string{}}
+			if f.sessions[parts[1]+"/"+parts[2]] {
+				info.State = ClientStateLoggedIn
+			}
+			out = info
+		}
+		if out != nil {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(out)
+			return
+		}
+	}
+	if status == http.StatusOK && r.Method != http.MethodGet {
+		f.config.Epoch++
+	}
+	reply(w, status, msg)
+}
+
+// serve applies the request to the configuration and returns the status
+// and message of the response.
+func (f *FakeServer) serve(
+	method string, parts []string, form url.Values) (int, string) {
+
+	resource, args := parts[0], parts[1:]
+	route := method + " " + resource
+	switch {
+	case route == "GET _ping" && len(args) == 0:
+		return http.StatusOK, "pong"
+	case route == "GET config" && len(args) == 0:
+		return http.StatusOK, ""
+	case route == "PUT discoveryauth" && len(args) == 0:
+		f.config.DiscoveryAuth = formAuth(form)
+		return http.StatusOK, "discovery auth updated"
+	case resource == "target" && len(args) == 1:
+		return f.serveTarget(method, args[0])
+	case resource == "disk" && len(args) == 2:
+		return f.serveDisk(method, args[0]+"/"+args[1], form)
+	}
+
+	if len(args) == 0 {
+		return http.StatusNotFound, "unknown resource " + resource
+	}
+	target, found := f.config.Targets[args[0]]
+	if !found {
+		return http.StatusNotFound, "target " + args[0] + " does not exist"
+	}
+	target = fillTarget(target)
+	f.config.Targets[args[0]] = target
+	switch {
+	case route == "PUT targetauth" && len(args) == 1:
+		target.Auth = formAuth(form)
+		f.config.Targets[args[0]] = target
+		return http.StatusOK, "target auth updated"
+	case resource == "targetlun" && len(args) == 1:
+		return f.serveTargetLun(method, target, form)
+	case resource == "gateway" && len(args) == 2:
+		return f.serveGateway(method, target, args[1], form)
+	case resource == "client" && len(args) == 2:
+		return f.serveClient(method, target, args[1])
+	case route == "GET clientinfo" && len(args) == 2:
+		if _, found := target.Clients[args[1]]; !found {
+			return http.StatusNotFound, "client " + args[1] + " does not exist"
+		}
+		return http.StatusOK, ""
+	case route == "PUT clientauth" && len(args) == 2:
+		client, found := target.Clients[args[1]]
+		if !found {
+			return http.StatusNotFound, "client " + args[1] + " does not exist"
+		}
+		client.Auth = formAuth(form)
+		target.Clients[args[1]] = client
+		return http.StatusOK, "client auth updated"
+	case resource == "clientlun" && len(args) == 2:
+		return f.serveClientLun(method, target, args[1], form)
+	case resource == "hostgroup" && len(args) == 2:
+		return f.serveHostGroup(method, target, args[1], form)
+	}
+	return http.StatusNotFound, "unknown resource " + resource
+}
+
+func (f *FakeServer) serveTarget(method, name string) (int, string) {
+	_, found := f.config.Targets[name]
+	switch method {
+	case http.MethodPut:
+		if !found {
+			f.config.Targets[name] = newTarget()
+		}
+		return http.StatusOK, "target defined"
+	case http.MethodDelete:
+		if !found {
+			return http.StatusNotFound, "target " + name + " does not exist"
+		}
+		delete(f.config.Targets, name)
+		return http.StatusOK, "target removed"
+	}
+	return http.StatusMethodNotAllowed, "method not allowed"
+}
+
+func (f *FakeServer) serveDisk(
+	method, disk string, form url.Values) (int, string) {
+
+	_, found := f.config.Disks[disk]
+	switch {
+	case method == http.MethodPut && form.Get("mode") == "create":
+		if form.Get("size") == "" {
+			return http.StatusBadRequest, "size is required"
+		}
+		if !found {
+			pool, image, _ := strings.Cut(disk, "/")
+			f.config.Disks[disk] = Disk{
+				Pool:      pool,
+				Image:     image,
+				Backstore: "user:rbd",
+			}
+		}
+		return http.StatusOK, "disk defined"
+	case method == http.MethodPut && form.Get("mode") == "resize":
+		if !found {
+			return http.StatusNotFound, "disk " + disk + " does not exist"
+		}
+		if form.Get("size") == "" {
+			return http.StatusBadRequest, "size is required"
+		}
+		return http.StatusOK, "disk resized"
+	case method == http.MethodDelete:
+		if !found {
+			return http.StatusNotFound, "disk " + disk + " does not exist"
+		}
+		for name, t := range f.config.Targets {
+			if _, used := t.Disks[disk]; used {
+				return http.StatusBadRequest,
+					"disk " + disk + " is mapped by target " + name
+			}
+		}
+		delete(f.config.Disks, disk)
+		return http.StatusOK, "disk removed"
+	}
+	return http.StatusBadRequest, "invalid mode " + form.Get("mode")
+}
+
+func (f *FakeServer) serveTargetLun(
+	method string, target Target, form url.Values) (int, string) {
+
+	disk := form.Get("disk")
+	_, mapped := target.Disks[disk]
+	switch method {
+	case http.MethodPut:
+		if _, found := f.config.Disks[disk]; !found {
+			return http.StatusNotFound, "disk " + disk + " does not exist"
+		}
+		id, err := strconv.Atoi(form.Get("lun_id"))
+		if err != nil {
+			return http.StatusBadRequest, "invalid lun_id"
+		}
+		for d, lun := range target.Disks {
+			if d != disk && lun.LunID == int32(id) {
+				return http.StatusBadRequest,
+					fmt.Sprintf("LUN %d is used by %s", id, d)
+			}
+		}
+		target.Disks[disk] = Lun{LunID: int32(id)}
+		return http.StatusOK, "LUN added"
+	case http.MethodDelete:
+		if !mapped {
+			return http.StatusNotFound, "disk " + disk + " is not a LUN"
+		}
+		for name, c := range target.Clients {
+			if _, used := c.Luns[disk]; used {
+				return http.StatusBadRequest,
+					"disk " + disk + " is mapped to client " + name
+			}
+		}
+		delete(target.Disks, disk)
+		return http.StatusOK, "LUN removed"
+	}
+	return http.StatusMethodNotAllowed, "method not allowed"
+}
+
+func (f *FakeServer) serveGateway(
+	method string, target Target, name string, form url.Values) (int, string) {
+
+	switch method {
+	case http.MethodPut:
+		ips := splitList(form.Get("ip_address"))
+		if len(ips) == 0 {
+			return http.StatusBadRequest, "ip_address is required"
+		}
+		target.Portals[name] = Portal{PortalIPAddresses: ips}
+		gw := f.config.Gateways[name]
+		f.config.Gateways[name] = gw
+		return http.StatusOK, "gateway added"
+	case http.MethodDelete:
+		if _, found := target.Portals[name]; !found {
+			return http.StatusNotFound, "gateway " + name + " does not exist"
+		}
+		delete(target.Portals, name)
+		used := false
+		for _, t := range f.config.Targets {
+			if _, found := t.Portals[name]; found {
+				used = true
+			}
+		}
+		if !used {
+			delete(f.config.Gateways, name)
+		}
+		return http.StatusOK, "gateway removed"
+	}
+	return http.StatusMethodNotAllowed, "method not allowed"
+}
+
+func (f *FakeServer) serveClient(
+	method string, target Target, name string) (int, string) {
+
+	client, found := target.Clients[name]
+	switch method {
+	case http.MethodPut:
+		if !found {
+			target.Clients[name] = ClientConfig{Luns: map[string]Lun{}}
+		}
+		return http.StatusOK, "client defined"
+	case http.MethodDelete:
+		if !found {
+			return http.StatusNotFound, "client " + name + " does not exist"
+		}
+		if client.GroupName != "" {
+			return http.StatusBadRequest,
+				"client " + name + " is a member of " + client.GroupName
+		}
+		delete(target.Clients, name)
+		return http.StatusOK, "client removed"
+	}
+	return http.StatusMethodNotAllowed, "method not allowed"
+}
+
+func (f *FakeServer) serveClientLun(
+	method string, target Target, name string, form url.Values) (int, string) {
+
+	client, found := target.Clients[name]
+	if !found {
+		return http.StatusNotFound, "client " + name + " does not exist"
+	}
+	disk := form.Get("disk")
+	lun, mapped := target.Disks[disk]
+	if !mapped {
+		return http.StatusNotFound, "disk " + disk + " is not a LUN"
+	}
+	switch method {
+	case http.MethodPut:
+		if client.Luns == nil {
+			client.Luns = map[string]Lun{}
+		}
+		client.Luns[disk] = lun
+		target.Clients[name] = client
+		return http.StatusOK, "LUN mapped"
+	case http.MethodDelete:
+		if _, found := client.Luns[disk]; !found {
+			return http.StatusNotFound, "disk " + disk + " is not mapped"
+		}
+		delete(client.Luns, disk)
+		return http.StatusOK, "LUN unmapped"
+	}
+	return http.StatusMethodNotAllowed, "method not allowed"
+}
+
+func (f *FakeServer) serveHostGroup(
+	method string, target Target, name string, form url.Values) (int, string) {
+
+	group, found := target.Groups[name]
+	if group.Disks == nil {
+		group.Disks = map[string]Lun{}
+	}
+	members := splitList(form.Get("members"))
+	disks := splitList(form.Get("disks"))
+	switch {
+	case method == http.MethodPut && form.Get("action") == "add":
+		for _, m := range members {
+			client, found := target.Clients[m]
+			if !found {
+				return http.StatusNotFound, "client " + m + " does not exist"
+			}
+			if client.GroupName != "" && client.GroupName != name {
+				return http.StatusBadRequest,
+					"client " + m + " is a member of " + client.GroupName
+			}
+		}
+		for _, d := range disks {
+			if _, found := target.Disks[d]; !found {
+				return http.StatusNotFound, "disk " + d + " is not a LUN"
+			}
+		}
+		for _, d := range disks {
+			group.Disks[d] = target.Disks[d]
+		}
+		for _, m := range members {
+			if !contains(group.Members, m) {
+				group.Members = append(group.Members, m)
+			}
+		}
+		sort.Strings(group.Members)
+		target.Groups[name] = group
+		f.syncGroup(target, name)
+		return http.StatusOK, "group updated"
+	case method == http.MethodPut && form.Get("action") == "remove":
+		if !found {
+			return http.StatusNotFound, "group " + name + " does not exist"
+		}
+		kept := []string{}
+		for _, m := range group.Members {
+			if contains(members, m) {
+				client := target.Clients[m]
+				client.GroupName = ""
+				target.Clients[m] = client
+				continue
+			}
+			kept = append(kept, m)
+		}
+		group.Members = kept
+		for _, d := range disks {
+			delete(group.Disks, d)
+		}
+		target.Groups[name] = group
+		return http.StatusOK, "group updated"
+	case method == http.MethodDelete:
+		if !found {
+			return http.StatusNotFound, "group " + name + " does not exist"
+		}
+		for _, m := range group.Members {
+			client := target.Clients[m]
+			client.GroupName = ""
+			target.Clients[m] = client
+		}
+		delete(target.Groups, name)
+		return http.StatusOK, "group removed"
+	}
+	return http.StatusBadRequest, "invalid action " + form.Get("action")
+}
+
+// syncGroup maps the disks of the group to its members.
+func (f *FakeServer) syncGroup(target Target, name string) {
+	group := target.Groups[name]
+	for _, m := range group.Members {
+		client := target.Clients[m]
+		client.GroupName = name
+		if client.Luns == nil {
+			client.Luns = map[string]Lun{}
+		}
+		for d, lun := range group.Disks {
+			client.Luns[d] = lun
+		}
+		target.Clients[m] = client
+	}
+}
+
+// fillTarget returns the target with the maps a configuration set
+// through SetConfig may lack.
+func fillTarget(t Target) Target {
+	if t.Disks == nil {
+		t.Disks = map[string]Lun{}
+	}
+	if t.Clients == nil {
+		t.Clients = map[string]ClientConfig{}
+	}
+	if t.Portals == nil {
+		t.Portals = map[string]Portal{}
+	}
+	if t.Groups == nil {
+		t.Groups = map[string]HostGroup{}
+	}
+	return t
+}
+
+// handlerTransport serves the requests of a client with a handler, in
+// process.
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	t.handler.ServeHTTP(rec, req)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// readForm returns the form sent in the body of the request. Unlike
+// http.Request.ParseForm, it also reads the body of DELETE requests.
+func readForm(r *http.Request) (url.Values, error) {
+	if r.Body == nil {
+		return url.Values{}, nil
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	return url.ParseQuery(string(data))
+}
+
+func reply(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": msg})
+}
+
+func formAuth(form url.Values) Auth {
+	return Auth{
+		Username:       form.Get("username"),
+		Password:       form.Get("password"),
+		MutualUsername: form.Get("mutual_username"),
+		MutualPassword: form.Get("mutual_password"),
+	}
+}
+
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func contains(l []string, s string) bool {
+	for _, item := range l {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func copyConfig(cfg *Config) *Config {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		panic(err)
+	}
+	out := NewConfig()
+	if err := json.Unmarshal(data, out); err != nil {
+		panic(err)
+	}
+	return out
+}
@@ -0,0 +1,14 @@
+package rbdapi
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestRbdApi(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "RBD Target API Suite")
+}
@@ -0,0 +1,111 @@
+package rbdapi
+
+// Config is the configuration of the gateways as returned by
+// /api/config, the gateway.conf object ceph-iscsi keeps in the pool.
+type Config struct {
+	Disks         map[string]Disk    `json:"disks"`
+	Gateways      map[string]Gateway `json:"gateways"`
+	Targets       map[string]Target  `json:"targets"`
+	DiscoveryAuth Auth               `json:"discovery_auth"`
+	Epoch         int                `json:"epoch"`
+	Version       int                `json:"version"`
+}
+
+// Disk is an RBD image known to the gateways, keyed by pool/image in
+// Config.
+type Disk struct {
+	Pool      string `json:"pool"`
+	Image     string `json:"image"`
+	Backstore string `json:"backstore,omitempty"`
+	Owner     string `json:"owner,omitempty"`
+	WWN       string `json:"wwn,omitempty"`
+}
+
+// Gateway is a gateway node, keyed by host name in Config.
+type Gateway struct {
+	ActiveLuns int `json:"active_luns"`
+}
+
+// Target is an iSCSI target, keyed by IQN in Config.
+type Target struct {
+	// Disks maps the disks, as pool/image, of the target to their LUN.
+	Disks map[string]Lun `json:"disks"`
+	// Clients maps the IQN of an initiator to its configuration.
+	Clients map[string]ClientConfig `json:"clients"`
+	// Portals maps the host name of a gateway to its portal.
+	Portals map[string]Portal `json:"portals"`
+	// Groups maps the name of a host group to its members and disks.
+	Groups     map[string]HostGroup `json:"groups"`
+	Auth       Auth                 `json:"auth"`
+	AclEnabled bool                 `json:"acl_enabled"`
+}
+
+// Lun is the LUN a disk is exported at.
+type Lun struct {
+	LunID int32 `json:"lun_id"`
+}
+
+// ClientConfig is an initiator allowed to log in to a target.
+type ClientConfig struct {
+	Auth Auth `json:"auth"`
+	// Luns maps the disks, as pool/image, mapped to the initiator to
+	// their LUN.
+	Luns      map[string]Lun `json:"luns"`
+	GroupName string         `json:"group_name"`
+}
+
+// ClientStateLoggedIn is the state of an initiator with a session to the
+// gateway.
+const ClientStateLoggedIn = "LOGGED_IN"
+
+// ClientInfo is the state of the sessions of an initiator to a target on a
+// gateway, as returned by /api/clientinfo.
+type ClientInfo struct {
+	Alias     string   `json:"alias"`
+	IPAddress []string `json:"ip_address"`
+	State     string   `json:"state"`
+}
+
+// LoggedIn returns true if the initiator has a session to the gateway.
+func (i *ClientInfo) LoggedIn() bool {
+	return i.State == ClientStateLoggedIn
+}
+
+// Portal is the portal a gateway serves a target at.
+type Portal struct {
+	PortalIPAddresses []string `json:"portal_ip_addresses"`
+}
+
+// HostGroup maps the same disks to every member.
+type HostGroup struct {
+	Members []string       `json:"members"`
+	Disks   map[string]Lun `json:"disks"`
+}
+
+// Auth are CHAP credentials, optionally followed by the ones the target
+// answers with for mutual CHAP. Empty credentials disable CHAP.
+type Auth struct {
+	Username       string `json:"username"`
+	Password       string `json:"password"`
+	MutualUsername string `json:"mutual_username"`
+	MutualPassword string `json:"mutual_password"`
+}
+
+// NewConfig returns an empty configuration.
+func NewConfig() *Config {
+	return &Config{
+		Disks:    map[string]Disk{},
+		Gateways: map[string]Gateway{},
+		Targets:  map[string]Target{},
+	}
+}
+
+func newTarget() Target {
+	return Target{
+		Disks:      map[string]Lun{},
+		Clients:    map[string]ClientConfig{},
+		Portals:    map[string]Portal{},
+		Groups:     map[string]HostGroup{},
+		AclEnabled: true,
+	}
+}
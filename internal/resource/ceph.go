@@ -0,0 +1,366 @@
+package resource
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	iscsigateway "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/conf"
+	pln "github.com/Erichorng/iscsi-operator/internal/planner"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// cephHashAnnotation records the digest of the generated ceph.conf and
+// keyring the gateway pods were started with.
+const cephHashAnnotation = "iscsi.ruohwai/ceph-hash"
+
+// Objects Rook keeps the connection details of a cluster in, in the
+// namespace of the cluster.
+const (
+	rookMonEndpoints    = "rook-ceph-mon-endpoints"
+	rookMonSecret       = "rook-ceph-mon"
+	rookClientSecretPfx = "rook-ceph-client-"
+)
+
+// The Rook resources are looked up as unstructured objects, the operator
+// does not depend on Rook being installed.
+var (
+	rookClusterGVK = schema.GroupVersionKind{
+		Group: "ceph.rook.io", Version: "v1", Kind: "CephCluster",
+	}
+	rookClientGVK = schema.GroupVersionKind{
+		Group: "ceph.rook.io", Version: "v1", Kind: "CephClient",
+	}
+)
+
+// cephCredentials are the details the ceph.conf and keyring of the
+// gateways are generated from.
+type cephCredentials struct {
+	fsid     string
+	monitors []string
+	key      string
+}
+
+// updateCephConfig generates the ceph.conf and keyring of the gateways
+// into the ceph secret when the spec takes them from a keyring Secret or
+// a Rook cluster.
+func (m *IscsiGatewayManager) updateCephConfig(
+	ctx context.Context,
+	planner *pln.Planner) Result {
+
+	if !planner.CephGenerated() {
+		return Done
+	}
+	ig := planner.Iscsigateway
+	cred, err := m.getCephCredentials(ctx, planner)
+	if err != nil {
+		m.logger.Error(err, "Unable to resolve the ceph configuration")
+		m.recorder.Eventf(ig,
+			EventWarning,
+			ReasonInvalidCephConfig,
+			"Unable to resolve the ceph configuration: %s", err)
+		return Result{err: err}
+	}
+	data := map[string][]byte{
+		pln.CephConfFile:    []byte(planner.CephConf(cred.fsid, cred.monitors)),
+		pln.CephKeyringFile: []byte(planner.CephKeyringData(cred.key)),
+	}
+
+	secret := &corev1.Secret{}
+	err = m.client.Get(ctx, types.NamespacedName{
+		Namespace: ig.Namespace,
+		Name:      planner.CephSecretName(),
+	}, secret)
+	if errors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      planner.CephSecretName(),
+				Namespace: ig.Namespace,
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: data,
+		}
+		err = controllerutil.SetControllerReference(ig, secret, m.scheme)
+		if err != nil {
+			return Result{err: err}
+		}
+		if err = m.client.Create(ctx, secret); err != nil {
+			m.logger.Error(
+				err,
+				"Failed to create new ceph Secret",
+				"IscsiGateway.Namespace", ig.Namespace,
+				"IscsiGateway.Name", ig.Name,
+				"Secret.Name", secret.Name,
+			)
+			return Result{err: err}
+		}
+		m.logger.Info("Created ceph Secret")
+		return Requeue
+	}
+	if err != nil {
+		m.logger.Error(
+			err,
+			"Failed to get ceph Secret",
+			"IscsiGateway.Namespace", ig.Namespace,
+			"IscsiGateway.Name", ig.Name,
+			"Secret.Name", planner.CephSecretName(),
+		)
+		return Result{err: err}
+	}
+	if string(secret.Data[pln.CephConfFile]) == string(data[pln.CephConfFile]) &&
+		string(secret.Data[pln.CephKeyringFile]) == string(data[pln.CephKeyringFile]) {
+		return Done
+	}
+	secret.Data = data
+	if err = m.client.Update(ctx, secret); err != nil {
+		m.logger.Error(
+			err,
+			"Failed to update ceph Secret",
+			"Secret.Namespace", secret.Namespace,
+			"Secret.Name", secret.Name,
+		)
+		return Result{err: err}
+	}
+	m.logger.Info("Updated ceph Secret")
+	return Requeue
+}
+
+// getCephCredentials resolves the monitors and the key of the client of
+// the gateways.
+func (m *IscsiGatewayManager) getCephCredentials(
+	ctx context.Context,
+	planner *pln.Planner) (*cephCredentials, error) {
+
+	ceph := planner.Iscsigateway.Spec.Ceph
+	if ceph.Rook != nil {
+		return m.getRookCredentials(ctx, planner)
+	}
+	if ceph.KeyringSecretRef == nil {
+		return nil, fmt.Errorf("neither a keyring secret nor a Rook cluster is set")
+	}
+	secret := &corev1.Secret{}
+	err := m.client.Get(ctx, types.NamespacedName{
+		Namespace: planner.Iscsigateway.Namespace,
+		Name:      ceph.KeyringSecretRef.Name,
+	}, secret)
+	if err != nil {
+		return nil, fmt.Errorf("keyring secret %s: %w",
+			ceph.KeyringSecretRef.Name, err)
+	}
+	key := strings.TrimSpace(string(secret.Data["key"]))
+	if key == "" {
+		key = keyringKey(string(secret.Data[pln.CephKeyringFile]))
+	}
+	if key == "" {
+		return nil, fmt.Errorf(
+			"keyring secret %s holds neither a \"key\" nor a \"keyring\"",
+			secret.Name)
+	}
+	return &cephCredentials{
+		fsid:     ceph.FSID,
+		monitors: ceph.Monitors,
+		key:      key,
+	}, nil
+}
+
+// getRookCredentials resolves the monitors and the key of the client from
+// the objects Rook maintains for the cluster.
+func (m *IscsiGatewayManager) getRookCredentials(
+	ctx context.Context,
+	planner *pln.Planner) (*cephCredentials, error) {
+
+	ig := planner.Iscsigateway
+	rook := ig.Spec.Ceph.Rook
+	ns := planner.RookNamespace()
+	if ns != ig.Namespace && !m.cfg.AllowsRookNamespace(ns) {
+		return nil, fmt.Errorf(
+			"the Rook clusters of namespace %s are not allowed by the operator config",
+			ns)
+	}
+
+	cluster, err := m.getRookObject(ctx, rookClusterGVK, ns, rook.ClusterName)
+	if err != nil {
+		return nil, err
+	}
+	cred := &cephCredentials{}
+	cred.fsid, _, _ = unstructured.NestedString(
+		cluster.Object, "status", "ceph", "fsid")
+
+	endpoints := &corev1.ConfigMap{}
+	err = m.client.Get(ctx, types.NamespacedName{
+		Namespace: ns,
+		Name:      rookMonEndpoints,
+	}, endpoints)
+	if err != nil {
+		return nil, fmt.Errorf("monitor endpoints of CephCluster %s/%s: %w",
+			ns, rook.ClusterName, err)
+	}
+	cred.monitors = parseRookMonEndpoints(endpoints.Data["data"])
+	if len(cred.monitors) == 0 {
+		return nil, fmt.Errorf("CephCluster %s/%s has no monitors yet",
+			ns, rook.ClusterName)
+	}
+
+	secretName, keyName := rookMonSecret, "ceph-secret"
+	if client := planner.CephClientName(); client != "admin" {
+		obj, err := m.getRookObject(ctx, rookClientGVK, ns, client)
+		if err != nil {
+			return nil, err
+		}
+		secretName, _, _ = unstructured.NestedString(
+			obj.Object, "status", "info", "secretName")
+		if secretName == "" {
+			secretName = rookClientSecretPfx + client
+		}
+		keyName = client
+	}
+	secret := &corev1.Secret{}
+	err = m.client.Get(ctx, types.NamespacedName{
+		Namespace: ns,
+		Name:      secretName,
+	}, secret)
+	if err != nil {
+		return nil, fmt.Errorf("key of client %s: %w",
+			planner.CephClientName(), err)
+	}
+	cred.key = strings.TrimSpace(string(secret.Data[keyName]))
+	if cred.key == "" {
+		return nil, fmt.Errorf("secret %s/%s is missing the %q key",
+			ns, secretName, keyName)
+	}
+	if cred.fsid == "" {
+		cred.fsid = string(secret.Data["fsid"])
+	}
+	return cred, nil
+}
+
+// getRookObject returns the Rook resource, reporting a missing resource or
+// a cluster without Rook as an error.
+func (m *IscsiGatewayManager) getRookObject(
+	ctx context.Context,
+	gvk schema.GroupVersionKind,
+	ns, name string) (*unstructured.Unstructured, error) {
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	err := m.client.Get(ctx, types.NamespacedName{
+		Namespace: ns,
+		Name:      name,
+	}, obj)
+	switch {
+	case meta.IsNoMatchError(err):
+		return nil, fmt.Errorf("the Rook resources are not installed: %w", err)
+	case errors.IsNotFound(err):
+		return nil, fmt.Errorf("%s %s/%s not found", gvk.Kind, ns, name)
+	case err != nil:
+		m.logger.Error(
+			err,
+			"Failed to get Rook resource",
+			"Kind", gvk.Kind,
+			"Namespace", ns,
+			"Name", name,
+		)
+		return nil, err
+	}
+	return obj, nil
+}
+
+// getCephSecret returns the secret holding the generated ceph.conf and
+// keyring, nil if it does not exist yet.
+func (m *IscsiGatewayManager) getCephSecret(
+	ctx context.Context,
+	planner *pln.Planner) (*corev1.Secret, error) {
+
+	secret := &corev1.Secret{}
+	err := m.client.Get(ctx, types.NamespacedName{
+		Namespace: planner.Iscsigateway.Namespace,
+		Name:      planner.CephSecretName(),
+	}, secret)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		m.logger.Error(err, "Failed to get ceph Secret",
+			"Secret.Name", planner.CephSecretName())
+		return nil, err
+	}
+	return secret, nil
+}
+
+// parseRookMonEndpoints returns the addresses of the monitors from the
+// "a=10.0.0.1:6789,b=10.0.0.2:6789" form Rook stores them in.
+func parseRookMonEndpoints(data string) []string {
+	monitors := []string{}
+	for _, mon := range strings.Split(data, ",") {
+		_, addr, found := strings.Cut(strings.TrimSpace(mon), "=")
+		if found && addr != "" {
+			monitors = append(monitors, addr)
+		}
+	}
+	sort.Strings(monitors)
+	return monitors
+}
+
+// keyringKey returns the first key found in the keyring.
+func keyringKey(keyring string) string {
+	for _, line := range strings.Split(keyring, "\n") {
+		name, value, found := strings.Cut(line, "=")
+		if found && strings.TrimSpace(name) == "key" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// cephSecretHash returns a digest of the generated ceph.conf and keyring
+// so that the gateway pods are restarted when the monitors or the key
+// change.
+func cephSecretHash(secret *corev1.Secret) string {
+	if secret == nil {
+		return ""
+	}
+	h := sha256.New()
+	for _, k := range []string{pln.CephConfFile, pln.CephKeyringFile} {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write(secret.Data[k])
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// UsesCephSecret returns true if the iscsigateway takes the key of its
+// ceph client from the named secret in its namespace.
+func UsesCephSecret(ig *iscsigateway.Iscsigateway, name string) bool {
+	ceph := ig.Spec.Ceph
+	return ceph != nil && ceph.KeyringSecretRef != nil &&
+		ceph.KeyringSecretRef.Name == name
+}
+
+// UsesRookObject returns true if the iscsigateway takes its ceph
+// configuration from the Rook cluster the named ConfigMap or Secret
+// belongs to.
+func UsesRookObject(ig *iscsigateway.Iscsigateway, namespace, name string) bool {
+	planner := pln.New(
+		pln.InstanceConfiguration{
+			Iscsigateway: ig,
+			GlobalConfig: conf.Get(),
+		},
+		nil)
+	if planner.RookNamespace() != namespace {
+		return false
+	}
+	return name == rookMonEndpoints || name == rookMonSecret ||
+		strings.HasPrefix(name, rookClientSecretPfx)
+}
@@ -1,7 +1,12 @@
 package resource
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
 
 	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
 	corev1 "k8s.io/api/core/v1"
@@ -32,19 +37,58 @@ func newDefaultConfigMap(name, ns string) (*corev1.ConfigMap, error) {
 	return configMap, nil
 }
 
+// invalidConfigError reports a container config that can not be read from
+// its ConfigMap. Retrying does not help until the ConfigMap is fixed.
+type invalidConfigError struct {
+	name string
+	err  error
+}
+
+func (e *invalidConfigError) Error() string {
+	return fmt.Sprintf("invalid container config in ConfigMap %s: %s",
+		e.name, e.err)
+}
+
+func (e *invalidConfigError) Unwrap() error {
+	return e.err
+}
+
+func isInvalidConfig(err error) bool {
+	var ice *invalidConfigError
+	return errors.As(err, &ice)
+}
+
+// getContainerConfig decodes the container config of the ConfigMap,
+// migrating it from older layouts.
 func getContainerConfig(
 	configMap *corev1.ConfigMap) (*iscsicc.IscsiContainerConfig, error) {
-	cc := iscsicc.New()
 	jstr, found := configMap.Data[ConfigJSONKey]
 	if !found {
-		return cc, nil
+		return nil, &invalidConfigError{
+			name: configMap.Name,
+			err:  fmt.Errorf("missing the %q key", ConfigJSONKey),
+		}
 	}
-	if err := json.Unmarshal([]byte(jstr), cc); err != nil {
-		return nil, err
+	cc, err := iscsicc.Decode([]byte(jstr))
+	if err != nil {
+		return nil, &invalidConfigError{name: configMap.Name, err: err}
 	}
 	return cc, nil
 }
 
+// containerConfigStale returns true if the serialized config in the
+// ConfigMap differs from cc, for example because it still carries fields
+// dropped from the container config such as plaintext credentials.
+func containerConfigStale(
+	cm *corev1.ConfigMap, cc *iscsicc.IscsiContainerConfig) (bool, error) {
+
+	jb, err := json.MarshalIndent(cc, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	return cm.Data[ConfigJSONKey] != string(jb), nil
+}
+
 func setContainerConfig(
 	cm *corev1.ConfigMap, cc *iscsicc.IscsiContainerConfig) error {
 
@@ -55,3 +99,53 @@ func setContainerConfig(
 	cm.Data[ConfigJSONKey] = string(jb)
 	return nil
 }
+
+// configHash returns a digest of what the gateways only read from the
+// container config when they start, along with the ceph configuration, so
+// that the gateway pods are restarted when either changes. Targets, disks,
+// hosts and their LUNs are left out: the update-config --watch sidecar of
+// every gateway applies them from the mounted ConfigMap while the gateway
+// runs. So are the gateways of the portal group and the bookkeeping of
+// the operator.
+func configHash(
+	cc *iscsicc.IscsiContainerConfig, cephConfig *corev1.ConfigMap) string {
+
+	h := sha256.New()
+	var hashed interface{}
+	if cc != nil {
+		hashed = struct {
+			Version int                                  `json:"version"`
+			Globals map[iscsicc.Key]iscsicc.GlobalConfig `json:"globals,omitempty"`
+		}{cc.Version, cc.Globals}
+	}
+	jb, err := json.Marshal(hashed)
+	if err != nil {
+		return ""
+	}
+	h.Write(jb)
+	if cephConfig != nil {
+		keys := []string{}
+		for k := range cephConfig.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			h.Write([]byte{0})
+			h.Write([]byte(k))
+			h.Write([]byte{0})
+			h.Write([]byte(cephConfig.Data[k]))
+		}
+		keys = keys[:0]
+		for k := range cephConfig.BinaryData {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			h.Write([]byte{0})
+			h.Write([]byte(k))
+			h.Write([]byte{0})
+			h.Write(cephConfig.BinaryData[k])
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -2,19 +2,35 @@ package resource
 
 import (
 	"context"
+	"sort"
 
+	iscsigateway "github.com/Erichorng/iscsi-operator/api/v1alpha1"
 	pln "github.com/Erichorng/iscsi-operator/internal/planner"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
+// tcmuRunnerSourceAnnotation records on the tcmu-runner daemon set the
+// gateway it takes its ceph configuration from.
+const tcmuRunnerSourceAnnotation = "iscsi.ruohwai/ceph-config-source"
+
+// buildDaemonset returns the tcmu-runner daemon set shared by the
+// gateways of a namespace. It is scheduled on the nodes any of the
+// gateways may run on and mounts the ceph configuration of the source
+// gateway, see tcmuRunnerSource.
 func buildDaemonset(
 	ctx context.Context,
 	name string,
-	pl *pln.Planner) *appsv1.DaemonSet {
+	pl *pln.Planner,
+	gateways []iscsigateway.Iscsigateway) *appsv1.DaemonSet {
 
 	podSpec := buildTcmuRunnerPodSpec(pl)
+	podSpec.Affinity = tcmuRunnerAffinity(gateways)
+	podSpec.Tolerations = tcmuRunnerTolerations(gateways)
 	labels := map[string]string{
 		"app":                          "tcmu-runner",
 		"app.kubernetes.io/name":       "tcmu-runner",
@@ -27,6 +43,9 @@ func buildDaemonset(
 			Name:      name,
 			Namespace: pl.Iscsigateway.Namespace,
 			Labels:    labels,
+			Annotations: map[string]string{
+				tcmuRunnerSourceAnnotation: pl.Iscsigateway.Name,
+			},
 		},
 		Spec: appsv1.DaemonSetSpec{
 			Selector: &metav1.LabelSelector{
@@ -42,3 +61,200 @@ func buildDaemonset(
 	}
 	return ds
 }
+
+// tcmuRunnerAffinity returns a node affinity matching the node selector of
+// any of the gateways. Nil is returned if one of the gateways may run on
+// any node.
+func tcmuRunnerAffinity(
+	gateways []iscsigateway.Iscsigateway) *corev1.Affinity {
+
+	terms := []corev1.NodeSelectorTerm{}
+	for _, ig := range gateways {
+		if len(ig.Spec.NodeSelector) == 0 {
+			return nil
+		}
+		keys := make([]string, 0, len(ig.Spec.NodeSelector))
+		for k := range ig.Spec.NodeSelector {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		term := corev1.NodeSelectorTerm{}
+		for _, k := range keys {
+			term.MatchExpressions = append(term.MatchExpressions,
+				corev1.NodeSelectorRequirement{
+					Key:      k,
+					Operator: corev1.NodeSelectorOpIn,
+					Values:   []string{ig.Spec.NodeSelector[k]},
+				})
+		}
+		if !containsTerm(terms, term) {
+			terms = append(terms, term)
+		}
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+	return &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: terms,
+			},
+		},
+	}
+}
+
+// tcmuRunnerTolerations returns the union of the tolerations of the
+// gateways.
+func tcmuRunnerTolerations(
+	gateways []iscsigateway.Iscsigateway) []corev1.Toleration {
+
+	var tolerations []corev1.Toleration
+	for _, ig := range gateways {
+		for i := range ig.Spec.Tolerations {
+			t := ig.Spec.Tolerations[i]
+			found := false
+			for j := range tolerations {
+				if tolerations[j].MatchToleration(&t) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				tolerations = append(tolerations, t)
+			}
+		}
+	}
+	return tolerations
+}
+
+func containsTerm(
+	terms []corev1.NodeSelectorTerm, term corev1.NodeSelectorTerm) bool {
+	for _, t := range terms {
+		if equality.Semantic.DeepEqual(t, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncDaemonSet updates the fields of the daemon set the operator manages
+// to match the desired daemon set and returns true if any changed.
+func syncDaemonSet(ds, desired *appsv1.DaemonSet) bool {
+	changed := false
+	current := &ds.Spec.Template.Spec
+	want := &desired.Spec.Template.Spec
+	for i := range current.Containers {
+		for _, c := range want.Containers {
+			if current.Containers[i].Name != c.Name {
+				continue
+			}
+			if current.Containers[i].Image != c.Image {
+				current.Containers[i].Image = c.Image
+				changed = true
+			}
+			if current.Containers[i].ImagePullPolicy != c.ImagePullPolicy {
+				current.Containers[i].ImagePullPolicy = c.ImagePullPolicy
+				changed = true
+			}
+		}
+	}
+	if !equality.Semantic.DeepEqual(current.Affinity, want.Affinity) {
+		current.Affinity = want.Affinity
+		changed = true
+	}
+	if !equality.Semantic.DeepEqual(current.Tolerations, want.Tolerations) {
+		current.Tolerations = want.Tolerations
+		changed = true
+	}
+	// the projected ceph volume follows the source gateway, the other
+	// volumes are host paths that do not change
+	for i := range current.Volumes {
+		for _, v := range want.Volumes {
+			cv := &current.Volumes[i]
+			if cv.Name != v.Name || cv.Projected == nil || v.Projected == nil {
+				continue
+			}
+			if !equality.Semantic.DeepEqual(cv.Projected.Sources, v.Projected.Sources) {
+				cv.Projected.Sources = v.Projected.Sources
+				changed = true
+			}
+		}
+	}
+	source := desired.Annotations[tcmuRunnerSourceAnnotation]
+	if ds.Annotations[tcmuRunnerSourceAnnotation] != source {
+		if ds.Annotations == nil {
+			ds.Annotations = map[string]string{}
+		}
+		ds.Annotations[tcmuRunnerSourceAnnotation] = source
+		changed = true
+	}
+	return changed
+}
+
+// tcmuRunnerSource returns a planner for the gateway the tcmu-runner
+// daemon set takes its ceph configuration from. The source is kept as
+// long as it exists, so that the tcmu-runner pods are not restarted, and
+// moves to the first of the remaining gateways once it is deleted, before
+// its ceph configuration is garbage collected.
+func (m *IscsiGatewayManager) tcmuRunnerSource(
+	ctx context.Context,
+	pl *pln.Planner,
+	ds *appsv1.DaemonSet,
+	gateways []iscsigateway.Iscsigateway) (*pln.Planner, error) {
+
+	if len(gateways) == 0 {
+		return pl, nil
+	}
+	source := &gateways[0]
+	if ds != nil {
+		current := ds.Annotations[tcmuRunnerSourceAnnotation]
+		for i := range gateways {
+			if gateways[i].Name == current {
+				source = &gateways[i]
+			}
+		}
+	}
+	if source.Name == pl.Iscsigateway.Name && pl.Iscsigateway.GetDeletionTimestamp() == nil {
+		return pl, nil
+	}
+	instance := pln.InstanceConfiguration{
+		Iscsigateway: source,
+		GlobalConfig: m.cfg,
+	}
+	if source.Spec.CephConfig != "" {
+		cephConfig := &corev1.ConfigMap{}
+		err := m.client.Get(ctx, types.NamespacedName{
+			Namespace: source.Namespace,
+			Name:      source.Spec.CephConfig,
+		}, cephConfig)
+		switch {
+		case err == nil:
+			instance.CephConfig = cephConfig
+		case !errors.IsNotFound(err):
+			m.logger.Error(err, "Failed to get ceph ConfigMap",
+				"IscsiGateway.Name", source.Name,
+				"ConfigMap.Name", source.Spec.CephConfig)
+			return nil, err
+		}
+	}
+	return pln.New(instance, nil), nil
+}
+
+// sharedOwnerReferences drops the controller flag from the owner references
+// of the daemon set, written by older versions of the operator, so that
+// every gateway holds an equal reference on it.
+func sharedOwnerReferences(ds *appsv1.DaemonSet) bool {
+	changed := false
+	refs := ds.GetOwnerReferences()
+	for i := range refs {
+		if refs[i].Controller != nil && *refs[i].Controller {
+			refs[i].Controller = nil
+			refs[i].BlockOwnerDeletion = nil
+			changed = true
+		}
+	}
+	if changed {
+		ds.SetOwnerReferences(refs)
+	}
+	return changed
+}
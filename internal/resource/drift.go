@@ -0,0 +1,301 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	iscsigateway "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+	pln "github.com/Erichorng/iscsi-operator/internal/planner"
+	"github.com/Erichorng/iscsi-operator/internal/rbdapi"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// configUpdatedAnnotation records on the gateway ConfigMap when the
+// container config was last changed.
+const configUpdatedAnnotation = "iscsi.ruohwai/config-updated"
+
+// driftSettleTime is how long the gateways are given to apply a changed
+// container config before the differences are reported as drift.
+const driftSettleTime = 2 * time.Minute
+
+// maxDriftsReported bounds the differences listed in the Drifted
+// condition and in events.
+const maxDriftsReported = 10
+
+// reapplyOrder is the order drift is repaired in, so that the objects a
+// change depends on exist first and are removed last.
+var reapplyOrder = []string{
+	pln.DriftMissingTarget,
+	pln.DriftMissingPortal,
+	pln.DriftMissingLun,
+	pln.DriftMissingHost,
+	pln.DriftHostAuth,
+	pln.DriftUnexpectedHostLun,
+	pln.DriftMissingHostLun,
+	pln.DriftUnexpectedHost,
+	pln.DriftUnexpectedLun,
+}
+
+// gatewayDrift is the drift found on a gateway along with the
+// configuration it reported.
+type gatewayDrift struct {
+	gateway string
+	api     *rbdapi.Client
+	config  *rbdapi.Config
+	drifts  []pln.Drift
+}
+
+// checkDrift asks the API of every ready gateway for its targets, LUNs and
+// ACLs and compares them with the container config. The differences are
+// reported in the Drifted condition and in events and, if the spec asks
+// for it, repaired through the API.
+func (m *IscsiGatewayManager) checkDrift(
+	ctx context.Context,
+	planner *pln.Planner) Result {
+
+	gateways, err := m.readyGatewayPods(ctx, planner)
+	if err != nil {
+		return Result{err: err}
+	}
+	if len(gateways) == 0 {
+		return Done
+	}
+	settled, err := m.containerConfigSettled(ctx, planner)
+	if err != nil {
+		return Result{err: err}
+	}
+
+	found := []gatewayDrift{}
+	for _, gw := range gateways {
+		api, err := m.gatewayApi(ctx, planner, gw)
+		if err != nil {
+			m.logger.Error(err, "Unable to reach gateway API", "Gateway", gw)
+			setDriftUnknown(planner.Iscsigateway, gw, err)
+			return RequeueAfter(gatewayApiPollInterval)
+		}
+		cfg, err := api.Config(ctx)
+		if err != nil {
+			m.logger.Error(err, "Failed to get gateway configuration",
+				"Gateway", gw)
+			setDriftUnknown(planner.Iscsigateway, gw, err)
+			return RequeueAfter(gatewayApiPollInterval)
+		}
+		if drifts := planner.Drift(gw, cfg); len(drifts) > 0 {
+			found = append(found, gatewayDrift{gw, api, cfg, drifts})
+		}
+	}
+
+	if len(found) == 0 {
+		setDriftCondition(planner.Iscsigateway, metav1.ConditionFalse,
+			reasonNoDrift, "The gateways match the container config")
+		return Done
+	}
+	if !settled {
+		m.logger.Info("Gateways have not applied the container config yet",
+			"Gateways", len(found))
+		return RequeueAfter(gatewayApiPollInterval)
+	}
+
+	msg := driftMessage(found)
+	ig := planner.Iscsigateway
+	cond := meta.FindStatusCondition(ig.Status.Conditions,
+		iscsigateway.ConditionDrifted)
+	if cond == nil || cond.Status != metav1.ConditionTrue || cond.Message != msg {
+		m.recorder.Eventf(ig,
+			EventWarning,
+			ReasonDriftDetected,
+			"Gateways differ from the container config: %s", msg)
+	}
+	setDriftCondition(ig, metav1.ConditionTrue, reasonDrifted, msg)
+	if !planner.DriftReapply() {
+		return Done
+	}
+
+	// the gateways share their configuration, repairing it through one
+	// of them is enough
+	gd := found[0]
+	applied, err := m.reapplyDrift(ctx, planner, gd)
+	if err != nil {
+		m.logger.Error(err, "Failed to reapply the container config",
+			"Gateway", gd.gateway)
+		m.recorder.Eventf(ig,
+			EventWarning,
+			ReasonDriftReapplyFailed,
+			"Unable to reapply the container config through gateway %s: %s",
+			gd.gateway, err)
+		return RequeueAfter(gatewayApiPollInterval)
+	}
+	m.recorder.Eventf(ig,
+		EventNormal,
+		ReasonDriftReapplied,
+		"Reapplied %d differences through gateway %s", applied, gd.gateway)
+	return RequeueAfter(gatewayApiPollInterval)
+}
+
+// reapplyDrift repairs the drift of the gateway through its API and
+// returns the number of differences repaired. Differences that depend on
+// others, such as the LUNs of a missing host, are repaired once they are
+// found again by the next check.
+func (m *IscsiGatewayManager) reapplyDrift(
+	ctx context.Context,
+	planner *pln.Planner,
+	gd gatewayDrift) (int, error) {
+
+	applied := 0
+	for _, kind := range reapplyOrder {
+		for _, d := range gd.drifts {
+			if d.Kind != kind {
+				continue
+			}
+			done, err := m.reapply(ctx, planner, gd, d)
+			if err != nil {
+				return applied, fmt.Errorf("%s: %w", d, err)
+			}
+			if done {
+				applied++
+			}
+		}
+	}
+	return applied, nil
+}
+
+// reapply repairs a single difference and returns false if it can not be
+// repaired through the API.
+func (m *IscsiGatewayManager) reapply(
+	ctx context.Context,
+	planner *pln.Planner,
+	gd gatewayDrift,
+	d pln.Drift) (bool, error) {
+
+	api := gd.api
+	observed := gd.config.Targets[d.TargetName]
+	switch d.Kind {
+	case pln.DriftMissingTarget:
+		return true, api.CreateTarget(ctx, d.TargetName)
+	case pln.DriftMissingPortal:
+		ip := planner.GatewayIPs[d.Gateway]
+		if ip == "" {
+			return false, nil
+		}
+		return true, api.CreateGateway(ctx, d.TargetName, d.Gateway, []string{ip})
+	case pln.DriftMissingLun:
+		if _, found := gd.config.Disks[d.Disk]; !found {
+			pool, image, _ := strings.Cut(d.Disk, "/")
+			err := api.CreateDisk(ctx, pool, image, planner.DriftDiskSize(d), false)
+			if err != nil {
+				return false, err
+			}
+		}
+		return true, api.AddTargetLun(
+			ctx, d.TargetName, d.Disk, planner.TargetLunID(d, observed))
+	case pln.DriftUnexpectedLun:
+		return true, api.RemoveTargetLun(ctx, d.TargetName, d.Disk)
+	case pln.DriftMissingHost:
+		if err := api.CreateClient(ctx, d.TargetName, d.Host); err != nil {
+			return false, err
+		}
+		host, _ := planner.DriftHost(d)
+		if host.Auth == "" || host.Auth == iscsicc.AuthNone {
+			return true, nil
+		}
+		return true, api.SetClientAuth(
+			ctx, d.TargetName, d.Host, apiAuth(planner, d))
+	case pln.DriftHostAuth:
+		return true, api.SetClientAuth(
+			ctx, d.TargetName, d.Host, apiAuth(planner, d))
+	case pln.DriftMissingHostLun:
+		return true, api.AddClientLun(ctx, d.TargetName, d.Host, d.Disk)
+	case pln.DriftUnexpectedHostLun:
+		return true, api.RemoveClientLun(ctx, d.TargetName, d.Host, d.Disk)
+	case pln.DriftUnexpectedHost:
+		return true, api.DeleteClient(ctx, d.TargetName, d.Host)
+	}
+	// a LUN id follows the LUN of the target, it is only reported
+	return false, nil
+}
+
+// apiAuth returns the credentials of the host of the drift as expected by
+// the API, none if the host does not use CHAP.
+func apiAuth(planner *pln.Planner, d pln.Drift) rbdapi.Auth {
+	host, _ := planner.DriftHost(d)
+	if host.Auth == "" || host.Auth == iscsicc.AuthNone {
+		return rbdapi.Auth{}
+	}
+	cred := planner.HostCredentials(d.Target, d.Host)
+	auth := rbdapi.Auth{
+		Username: cred.User,
+		Password: cred.Password,
+	}
+	if host.Auth == iscsicc.AuthMutualChap {
+		auth.MutualUsername = cred.MutualUser
+		auth.MutualPassword = cred.MutualPassword
+	}
+	return auth
+}
+
+// containerConfigSettled returns true if the container config has not
+// changed for long enough for the gateways to have applied it.
+func (m *IscsiGatewayManager) containerConfigSettled(
+	ctx context.Context,
+	planner *pln.Planner) (bool, error) {
+
+	cm := &corev1.ConfigMap{}
+	err := m.client.Get(ctx, types.NamespacedName{
+		Namespace: planner.Iscsigateway.Namespace,
+		Name:      planner.ContainerConfigName(),
+	}, cm)
+	if err != nil {
+		m.logger.Error(err, "Failed to get ConfigMap")
+		return false, err
+	}
+	updated, err := time.Parse(time.RFC3339, cm.Annotations[configUpdatedAnnotation])
+	if err != nil {
+		// written before changes were recorded
+		return true, nil
+	}
+	return time.Since(updated) >= driftSettleTime, nil
+}
+
+// driftMessage lists the differences found on the gateways.
+func driftMessage(found []gatewayDrift) string {
+	items := []string{}
+	total := 0
+	for _, gd := range found {
+		for _, d := range gd.drifts {
+			total++
+			if len(items) < maxDriftsReported {
+				items = append(items, gd.gateway+": "+d.String())
+			}
+		}
+	}
+	msg := strings.Join(items, "; ")
+	if total > len(items) {
+		msg += fmt.Sprintf("; and %d more", total-len(items))
+	}
+	return msg
+}
+
+func setDriftCondition(
+	ig *iscsigateway.Iscsigateway,
+	status metav1.ConditionStatus,
+	reason, msg string) {
+
+	meta.SetStatusCondition(&ig.Status.Conditions, metav1.Condition{
+		Type:               iscsigateway.ConditionDrifted,
+		Status:             status,
+		ObservedGeneration: ig.Generation,
+		Reason:             reason,
+		Message:            msg,
+	})
+}
+
+func setDriftUnknown(ig *iscsigateway.Iscsigateway, gateway string, err error) {
+	setDriftCondition(ig, metav1.ConditionUnknown, reasonGatewayApiUnreachable,
+		fmt.Sprintf("Unable to query gateway %s: %s", gateway, err))
+}
@@ -0,0 +1,219 @@
+package resource
+
+import (
+	"context"
+	"time"
+
+	"github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	iscsigateway "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/conf"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+	pln "github.com/Erichorng/iscsi-operator/internal/planner"
+	"github.com/Erichorng/iscsi-operator/internal/rbdapi"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = ginkgo.Describe("Gateway API", func() {
+	var (
+		ctx       context.Context
+		ig        *iscsigateway.Iscsigateway
+		planner   *pln.Planner
+		configMap *corev1.ConfigMap
+		api       *rbdapi.FakeServer
+		recorder  *record.FakeRecorder
+		m         *IscsiGatewayManager
+	)
+
+	ginkgo.BeforeEach(func() {
+		ctx = context.Background()
+		ig = &iscsigateway.Iscsigateway{
+			ObjectMeta: metav1.ObjectMeta{Name: "gw", Namespace: "storage"},
+			Spec: iscsigateway.IscsigatewaySpec{
+				Scale:      1,
+				CephConfig: "ceph-config",
+				Storage: []iscsigateway.IscsiStorageSpec{{
+					PoolName: "rbd",
+					Disks: []iscsigateway.IscsiDiskSpec{
+						{DiskName: "d1", DiskSize: "1Gi"},
+					},
+				}},
+				Hosts: []iscsigateway.IscsiHostSpec{{
+					HostName: testHost1,
+					Luns: []iscsigateway.IscsiLunSpec{
+						{PoolName: "rbd", DiskName: "d1"},
+					},
+				}},
+			},
+		}
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "gw", Namespace: "storage"},
+		}
+		api = rbdapi.NewFakeServer("admin", "secret")
+		recorder = record.NewFakeRecorder(100)
+	})
+
+	// setup plans the container config of the gateway and starts the
+	// manager with a ready gateway pod reached through the fake API.
+	setup := func() {
+		cfg := conf.DefaultOperatorConfig
+		planner = pln.New(pln.InstanceConfiguration{
+			Iscsigateway: ig,
+			GlobalConfig: &cfg,
+			GatewayIPs:   map[string]string{"gw-0": "10.0.0.1"},
+		}, iscsicc.New())
+		_, err := planner.Update()
+		Expect(err).NotTo(HaveOccurred())
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "gw-0",
+				Namespace: "storage",
+				Labels:    labelsForIscsiServer(planner.InstanceName()),
+			},
+			Status: corev1.PodStatus{
+				Conditions: []corev1.PodCondition{{
+					Type:   corev1.PodReady,
+					Status: corev1.ConditionTrue,
+				}},
+			},
+		}
+		client := fake.NewClientBuilder().
+			WithScheme(testScheme).
+			WithObjects(pod, configMap).
+			Build()
+		m = NewIscsiGatewayManager(client, testScheme, logr.Discard(), recorder)
+		m.cfg = &cfg
+		m.SetGatewayApiFunc(func(
+			context.Context, *pln.Planner, string) (*rbdapi.Client, error) {
+			return api.Client(), nil
+		})
+	}
+
+	driftCondition := func() *metav1.Condition {
+		return meta.FindStatusCondition(ig.Status.Conditions,
+			iscsigateway.ConditionDrifted)
+	}
+
+	ginkgo.Describe("checking drift", func() {
+		ginkgo.It("reports the differences without repairing them", func() {
+			setup()
+			Expect(m.checkDrift(ctx, planner)).To(Equal(Done))
+
+			cond := driftCondition()
+			Expect(cond).NotTo(BeNil())
+			Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+			Expect(cond.Reason).To(Equal(reasonDrifted))
+			Expect(cond.Message).To(ContainSubstring(pln.DriftMissingTarget))
+			Expect(recorder.Events).To(Receive(ContainSubstring(ReasonDriftDetected)))
+			Expect(api.Requests()).To(Equal([]string{"GET /api/config"}))
+
+			// the same drift is only reported once
+			Expect(m.checkDrift(ctx, planner)).To(Equal(Done))
+			Expect(recorder.Events).NotTo(Receive())
+		})
+
+		ginkgo.It("repairs the differences when asked to", func() {
+			ig.Spec.Drift = &iscsigateway.IscsiDriftSpec{Reapply: true}
+			setup()
+			for i := 0; i < 5; i++ {
+				result := m.checkDrift(ctx, planner)
+				if cond := driftCondition(); cond.Status == metav1.ConditionFalse {
+					Expect(result).To(Equal(Done))
+					break
+				}
+				Expect(result.RequeueAfter()).To(Equal(gatewayApiPollInterval))
+			}
+			Expect(driftCondition().Reason).To(Equal(reasonNoDrift))
+			Expect(recorder.Events).To(Receive(ContainSubstring(ReasonDriftDetected)))
+			Expect(recorder.Events).To(Receive(ContainSubstring(ReasonDriftReapplied)))
+
+			target := api.Config().Targets[planner.ConfigState.TargetName]
+			Expect(target.Portals).To(HaveKey("gw-0"))
+			Expect(target.Disks).To(HaveKey("rbd/d1"))
+			Expect(target.Clients[testHost1].Luns).To(HaveKey("rbd/d1"))
+		})
+
+		ginkgo.It("removes what the container config does not have", func() {
+			ig.Spec.Drift = &iscsigateway.IscsiDriftSpec{Reapply: true}
+			setup()
+			client := api.Client()
+			target := planner.ConfigState.TargetName
+			Expect(client.CreateTarget(ctx, target)).To(Succeed())
+			Expect(client.CreateClient(ctx, target, testHost2)).To(Succeed())
+
+			for i := 0; i < 5 && !meta.IsStatusConditionFalse(ig.Status.Conditions, iscsigateway.ConditionDrifted); i++ {
+				m.checkDrift(ctx, planner)
+			}
+			Expect(driftCondition().Status).To(Equal(metav1.ConditionFalse))
+			Expect(api.Config().Targets[target].Clients).NotTo(HaveKey(testHost2))
+		})
+
+		ginkgo.It("waits for the gateways to apply a changed container config", func() {
+			configMap.Annotations = map[string]string{
+				configUpdatedAnnotation: time.Now().UTC().Format(time.RFC3339),
+			}
+			setup()
+			result := m.checkDrift(ctx, planner)
+			Expect(result.RequeueAfter()).To(Equal(gatewayApiPollInterval))
+			Expect(driftCondition()).To(BeNil())
+			Expect(recorder.Events).NotTo(Receive())
+		})
+
+		ginkgo.It("reports a gateway it can not query", func() {
+			setup()
+			api.FailNext("/api/config", 1)
+			result := m.checkDrift(ctx, planner)
+			Expect(result.RequeueAfter()).To(Equal(gatewayApiPollInterval))
+			Expect(driftCondition().Status).To(Equal(metav1.ConditionUnknown))
+			Expect(driftCondition().Reason).To(Equal(reasonGatewayApiUnreachable))
+		})
+	})
+
+	ginkgo.Describe("checking host sessions", func() {
+		ginkgo.BeforeEach(func() {
+			setup()
+			// host2 was removed from the spec
+			planner.ConfigState.Hosts[testHost2] = iscsicc.NewHostInfo(
+				iscsicc.AuthNone, nil, nil)
+			client := api.Client()
+			target := planner.ConfigState.TargetName
+			Expect(client.CreateTarget(ctx, target)).To(Succeed())
+			Expect(client.CreateClient(ctx, target, testHost2)).To(Succeed())
+		})
+
+		session := func() pln.Session {
+			return pln.Session{
+				TargetName: planner.ConfigState.TargetName,
+				HostName:   testHost2,
+			}
+		}
+
+		ginkgo.DescribeTable("asking the gateways",
+			func(loggedIn bool) {
+				api.SetLoggedIn(session().TargetName, testHost2, loggedIn)
+				Expect(m.hostSessions(ctx, planner)).To(Equal(
+					map[pln.Session]bool{session(): loggedIn}))
+			},
+			ginkgo.Entry("finds a logged in host", true),
+			ginkgo.Entry("finds a logged out host", false),
+		)
+
+		ginkgo.It("leaves the sessions unknown when a gateway fails", func() {
+			api.FailNext("/api/clientinfo/"+session().TargetName+"/"+testHost2, 1)
+			Expect(m.hostSessions(ctx, planner)).To(BeNil())
+		})
+
+		ginkgo.It("does not ask when no host was removed", func() {
+			delete(planner.ConfigState.Hosts, testHost2)
+			Expect(m.hostSessions(ctx, planner)).To(BeNil())
+			Expect(api.Requests()).To(HaveLen(2))
+		})
+	})
+})
@@ -8,6 +8,33 @@ const (
 const (
 	//ReasonCreatedPersistentVolumeClaim = "CreatedPersistentVolumeClaim"
 	//ReasonCreatedDeployment            = "CreatedDeployment"
-	ReasonCreatedStatefulSet = "CreatedStatefulSet"
-	//ReasonInvalidConfiguration         = "InvalidConfiguration"
+	ReasonCreatedStatefulSet   = "CreatedStatefulSet"
+	ReasonReplacingStatefulSet = "ReplacingStatefulSet"
+	ReasonInvalidChapSecret    = "InvalidChapSecret"
+	ReasonInvalidConfiguration = "InvalidConfiguration"
+	ReasonRollingGateways      = "RollingGateways"
+	ReasonScalingDown          = "ScalingDown"
+	ReasonScaledDown           = "ScaledDown"
+	ReasonHostRemovalPending   = "HostRemovalPending"
+	ReasonDrainingSessions     = "DrainingSessions"
+	ReasonStoppedGateways      = "StoppedGateways"
+	ReasonTeardownStarted      = "TeardownStarted"
+	ReasonTeardownFailed       = "TeardownFailed"
+	ReasonRemovedTargets       = "RemovedTargets"
+	ReasonRemovedTcmuRunner    = "RemovedTcmuRunner"
+	ReasonReleasedTcmuRunner   = "ReleasedTcmuRunner"
+	ReasonSyncedImage          = "SyncedImage"
+	ReasonImageSyncFailed      = "ImageSyncFailed"
+	ReasonDiskInUse            = "DiskInUse"
+	ReasonDeletedImage         = "DeletedImage"
+	ReasonImageRetained        = "ImageRetained"
+	ReasonInitiatorRejected    = "InitiatorRejected"
+	ReasonInvalidApiSecret     = "InvalidApiSecret"
+	ReasonInvalidCephConfig    = "InvalidCephConfig"
+	ReasonDriftDetected        = "DriftDetected"
+	ReasonDriftReapplied       = "DriftReapplied"
+	ReasonDriftReapplyFailed   = "DriftReapplyFailed"
+	ReasonApplyingPlan         = "ApplyingPlan"
+	ReasonPlanPending          = "PlanPending"
+	ReasonInvalidConfigMap     = "InvalidConfigMap"
 )
@@ -0,0 +1,159 @@
+package resource
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"time"
+
+	pln "github.com/Erichorng/iscsi-operator/internal/planner"
+	"github.com/Erichorng/iscsi-operator/internal/rbdapi"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	rtclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// gatewayApiPollInterval is how often a gateway is checked while its API
+// is unreachable or has not applied the container config yet.
+const gatewayApiPollInterval = 30 * time.Second
+
+// GatewayApiFunc returns a client of the rbd-target-api of the named
+// gateway pod.
+type GatewayApiFunc func(
+	ctx context.Context,
+	planner *pln.Planner,
+	gateway string) (*rbdapi.Client, error)
+
+// SetGatewayApiFunc replaces how the manager reaches the API of the
+// gateways, such as with rbdapi.FakeServer.Client.
+func (m *IscsiGatewayManager) SetGatewayApiFunc(f GatewayApiFunc) {
+	m.gatewayApi = f
+}
+
+// newGatewayApiClient returns a client of the API of the gateway pod,
+// reached through the headless service. In secure mode the certificate of
+// the API, or the CA in its ca.crt key, must be valid for the DNS name of
+// the pod.
+func (m *IscsiGatewayManager) newGatewayApiClient(
+	ctx context.Context,
+	planner *pln.Planner,
+	gateway string) (*rbdapi.Client, error) {
+
+	secret, err := m.getApiSecret(ctx, planner)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, fmt.Errorf(
+			"API secret %s not found", planner.ApiSecretName())
+	}
+	host := fmt.Sprintf("%s.%s.%s.svc",
+		gateway, headlessServiceName(planner), planner.Iscsigateway.Namespace)
+	scheme := "http"
+	httpClient := &http.Client{Timeout: rbdapi.DefaultTimeout}
+	if planner.ApiSecure() {
+		tlsConfig, err := m.getApiTLSConfig(ctx, planner)
+		if err != nil {
+			return nil, err
+		}
+		scheme = "https"
+		httpClient.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	return rbdapi.New(
+		fmt.Sprintf("%s://%s:%d", scheme, host, planner.GetApiPort()),
+		string(secret.Data[corev1.BasicAuthUsernameKey]),
+		string(secret.Data[corev1.BasicAuthPasswordKey]),
+		httpClient), nil
+}
+
+// getApiTLSConfig returns the TLS configuration trusting the certificate
+// the API is served with.
+func (m *IscsiGatewayManager) getApiTLSConfig(
+	ctx context.Context,
+	planner *pln.Planner) (*tls.Config, error) {
+
+	secret := &corev1.Secret{}
+	err := m.client.Get(ctx, types.NamespacedName{
+		Namespace: planner.Iscsigateway.Namespace,
+		Name:      planner.ApiTLSSecretName(),
+	}, secret)
+	if err != nil {
+		return nil, err
+	}
+	ca := secret.Data["ca.crt"]
+	if len(ca) == 0 {
+		ca = secret.Data[corev1.TLSCertKey]
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf(
+			"TLS secret %s holds no certificate", secret.Name)
+	}
+	return &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+// readyGatewayPods returns the names of the gateway pods that are ready.
+func (m *IscsiGatewayManager) readyGatewayPods(
+	ctx context.Context,
+	planner *pln.Planner) ([]string, error) {
+
+	pods := &corev1.PodList{}
+	err := m.client.List(ctx, pods,
+		rtclient.InNamespace(planner.Iscsigateway.Namespace),
+		rtclient.MatchingLabels(labelsForIscsiServer(planner.InstanceName())))
+	if err != nil {
+		m.logger.Error(err, "Failed to list gateway pods")
+		return nil, err
+	}
+	names := []string{}
+	for i := range pods.Items {
+		if podReady(&pods.Items[i]) {
+			names = append(names, pods.Items[i].Name)
+		}
+	}
+	return names, nil
+}
+
+// hostSessions asks every ready gateway which of the hosts removed from
+// the spec are logged in. The sessions are left unknown, deferring the
+// removal of the hosts, when a gateway can not tell.
+func (m *IscsiGatewayManager) hostSessions(
+	ctx context.Context,
+	planner *pln.Planner) map[pln.Session]bool {
+
+	removed := planner.RemovedHosts()
+	if len(removed) == 0 {
+		return nil
+	}
+	gateways, err := m.readyGatewayPods(ctx, planner)
+	if err != nil || len(gateways) == 0 {
+		return nil
+	}
+	sessions := map[pln.Session]bool{}
+	for _, gw := range gateways {
+		api, err := m.gatewayApi(ctx, planner, gw)
+		if err != nil {
+			m.logger.Error(err, "Unable to reach gateway API", "Gateway", gw)
+			return nil
+		}
+		for _, s := range removed {
+			info, err := api.ClientInfo(ctx, s.TargetName, s.HostName)
+			switch {
+			case rbdapi.IsNotFound(err):
+				sessions[s] = sessions[s] || false
+			case err != nil:
+				m.logger.Error(err, "Unable to get host sessions",
+					"Gateway", gw, "Host", s.HostName)
+				return nil
+			default:
+				sessions[s] = sessions[s] || info.LoggedIn()
+			}
+		}
+	}
+	return sessions
+}
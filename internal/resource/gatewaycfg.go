@@ -0,0 +1,256 @@
+package resource
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+
+	iscsigateway "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	pln "github.com/Erichorng/iscsi-operator/internal/planner"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	rtclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// gatewayCfgHashAnnotation records the digest of the iscsi-gateway.cfg the
+// gateway pods were started with.
+const gatewayCfgHashAnnotation = "iscsi.ruohwai/gateway-cfg-hash"
+
+// apiCredentialChars are the characters generated API credentials are
+// made of.
+const apiCredentialChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// updateGatewayConfig renders the iscsi-gateway.cfg of the gateways into
+// the gateway config secret, generating the API credentials on first use
+// unless the spec names a secret holding them.
+func (m *IscsiGatewayManager) updateGatewayConfig(
+	ctx context.Context,
+	planner *pln.Planner) Result {
+
+	secret, created, err := m.getOrCreateGatewayConfigSecret(ctx, planner)
+	if err != nil {
+		return Result{err: err}
+	}
+	if created {
+		m.logger.Info("Created gateway config Secret")
+		return Requeue
+	}
+
+	changed := false
+	if planner.ApiSecretGenerated() {
+		if len(secret.Data[corev1.BasicAuthUsernameKey]) == 0 ||
+			len(secret.Data[corev1.BasicAuthPasswordKey]) == 0 {
+			if err := setApiCredentials(secret); err != nil {
+				return Result{err: err}
+			}
+			changed = true
+		}
+		planner.ApiSecret = secret
+	}
+	cfg, err := planner.GatewayConfig(planner.TrustedIPs())
+	if err != nil {
+		m.logger.Error(err, "Unable to render gateway config")
+		m.recorder.Eventf(planner.Iscsigateway,
+			EventWarning,
+			ReasonInvalidApiSecret,
+			"Unable to use API secret %s: %s", planner.ApiSecretName(), err)
+		return Result{err: err}
+	}
+	if string(secret.Data[pln.GatewayConfigFile]) != cfg {
+		secret.Data[pln.GatewayConfigFile] = []byte(cfg)
+		changed = true
+	}
+	if !changed {
+		return Done
+	}
+	err = m.client.Update(ctx, secret)
+	if err != nil {
+		m.logger.Error(
+			err,
+			"Failed to update gateway config Secret",
+			"Secret.Namespace", secret.Namespace,
+			"Secret.Name", secret.Name,
+		)
+		return Result{err: err}
+	}
+	m.logger.Info("Updated gateway config Secret")
+	return Requeue
+}
+
+func (m *IscsiGatewayManager) getOrCreateGatewayConfigSecret(
+	ctx context.Context,
+	planner *pln.Planner) (*corev1.Secret, bool, error) {
+
+	ig := planner.Iscsigateway
+	found := &corev1.Secret{}
+	secretNsname := types.NamespacedName{
+		Namespace: ig.Namespace,
+		Name:      planner.GatewayConfigSecretName(),
+	}
+	err := m.client.Get(ctx, secretNsname, found)
+	if err == nil {
+		if found.Data == nil {
+			found.Data = map[string][]byte{}
+		}
+		return found, false, nil
+	}
+	if !errors.IsNotFound(err) {
+		m.logger.Error(
+			err,
+			"Failed to get gateway config Secret",
+			"IscsiGateway.Namespace", ig.Namespace,
+			"IscsiGateway.Name", ig.Name,
+			"Secret.Name", secretNsname.Name,
+		)
+		return nil, false, err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretNsname.Name,
+			Namespace: secretNsname.Namespace,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{},
+	}
+	if planner.ApiSecretGenerated() {
+		if err := setApiCredentials(secret); err != nil {
+			return nil, false, err
+		}
+	}
+	err = controllerutil.SetControllerReference(ig, secret, m.scheme)
+	if err != nil {
+		m.logger.Error(
+			err,
+			"Failed to set controller reference",
+			"IscsiGateway.Namespace", ig.Namespace,
+			"IscsiGateway.Name", ig.Name,
+			"Secret.Name", secret.Name,
+		)
+		return nil, false, err
+	}
+	err = m.client.Create(ctx, secret)
+	if err != nil {
+		m.logger.Error(
+			err,
+			"Failed to create new gateway config Secret",
+			"IscsiGateway.Namespace", ig.Namespace,
+			"IscsiGateway.Name", ig.Name,
+			"Secret.Name", secret.Name,
+		)
+		return nil, false, err
+	}
+	return secret, true, nil
+}
+
+// getApiSecret returns the secret holding the credentials of the API, nil
+// if it does not exist yet or is unusable.
+func (m *IscsiGatewayManager) getApiSecret(
+	ctx context.Context,
+	planner *pln.Planner) (*corev1.Secret, error) {
+
+	ig := planner.Iscsigateway
+	secret := &corev1.Secret{}
+	err := m.client.Get(ctx, types.NamespacedName{
+		Namespace: ig.Namespace,
+		Name:      planner.ApiSecretName(),
+	}, secret)
+	if errors.IsNotFound(err) {
+		if !planner.ApiSecretGenerated() {
+			m.recorder.Eventf(ig,
+				EventWarning,
+				ReasonInvalidApiSecret,
+				"API secret %s not found", planner.ApiSecretName())
+		}
+		return nil, nil
+	}
+	if err != nil {
+		m.logger.Error(
+			err,
+			"Failed to get API secret",
+			"IscsiGateway.Namespace", ig.Namespace,
+			"IscsiGateway.Name", ig.Name,
+			"Secret.Name", planner.ApiSecretName(),
+		)
+		return nil, err
+	}
+	return secret, nil
+}
+
+// getGatewayIPs returns the pod IP of every gateway pod that has one.
+func (m *IscsiGatewayManager) getGatewayIPs(
+	ctx context.Context,
+	planner *pln.Planner) (map[string]string, error) {
+
+	pods := &corev1.PodList{}
+	err := m.client.List(ctx, pods,
+		rtclient.InNamespace(planner.Iscsigateway.Namespace),
+		rtclient.MatchingLabels(labelsForIscsiServer(planner.InstanceName())))
+	if err != nil {
+		m.logger.Error(err, "Failed to list gateway pods")
+		return nil, err
+	}
+	ips := map[string]string{}
+	for i := range pods.Items {
+		if ip := pods.Items[i].Status.PodIP; ip != "" {
+			ips[pods.Items[i].Name] = ip
+		}
+	}
+	return ips, nil
+}
+
+// setApiCredentials generates API credentials into the secret.
+func setApiCredentials(secret *corev1.Secret) error {
+	user, err := randomString(12)
+	if err != nil {
+		return err
+	}
+	password, err := randomString(24)
+	if err != nil {
+		return err
+	}
+	secret.Data[corev1.BasicAuthUsernameKey] = []byte("admin-" + user)
+	secret.Data[corev1.BasicAuthPasswordKey] = []byte(password)
+	return nil
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	max := big.NewInt(int64(len(apiCredentialChars)))
+	for i := range b {
+		c, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = apiCredentialChars[c.Int64()]
+	}
+	return string(b), nil
+}
+
+// gatewayCfgHash returns a digest of the iscsi-gateway.cfg so that the
+// gateway pods are restarted when it changes. The trusted addresses are
+// left out, they change whenever a gateway pod is restarted.
+func gatewayCfgHash(pl *pln.Planner) string {
+	cfg, err := pl.GatewayConfig(nil)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(cfg))
+	return hex.EncodeToString(sum[:])
+}
+
+// usesApiSecret returns true if the gateway takes the credentials or the
+// certificate of its API from the named secret.
+func usesApiSecret(ig *iscsigateway.Iscsigateway, name string) bool {
+	api := ig.Spec.Api
+	if api == nil {
+		return false
+	}
+	return (api.SecretRef != nil && api.SecretRef.Name == name) ||
+		(api.TLSSecretRef != nil && api.TLSSecretRef.Name == name)
+}
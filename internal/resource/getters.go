@@ -2,6 +2,8 @@ package resource
 
 import (
 	"context"
+	"sort"
+	"strings"
 
 	iscsigateway "github.com/Erichorng/iscsi-operator/api/v1alpha1"
 	pln "github.com/Erichorng/iscsi-operator/internal/planner"
@@ -12,6 +14,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	kresource "k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/types"
+	rtclient "sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
@@ -207,10 +210,274 @@ func (m *IscsiGatewayManager) getGatewayInstance(
 	gatewayInstance := pln.InstanceConfiguration{
 		Iscsigateway: ig,
 		GlobalConfig: m.cfg,
+		ChapSecrets:  map[string]*corev1.Secret{},
+	}
+	// a generated ceph configuration is resolved by updateCephConfig
+	if ig.Spec.CephConfig != "" {
+		cephConfig := &corev1.ConfigMap{}
+		err := m.client.Get(ctx, types.NamespacedName{
+			Namespace: ig.Namespace,
+			Name:      ig.Spec.CephConfig,
+		}, cephConfig)
+		switch {
+		case err == nil:
+			gatewayInstance.CephConfig = cephConfig
+		case !errors.IsNotFound(err):
+			m.logger.Error(
+				err,
+				"Failed to get ceph ConfigMap",
+				"IscsiGateway.Namespace", ig.Namespace,
+				"IscsiGateway.Name", ig.Name,
+				"ConfigMap.Name", ig.Spec.CephConfig,
+			)
+			return gatewayInstance, err
+		}
+	}
+	initiators, err := m.getInitiators(ctx, ig)
+	if err != nil {
+		return gatewayInstance, err
+	}
+	gatewayInstance.Initiators = initiators
+	planner := pln.New(gatewayInstance, nil)
+	for _, name := range planner.ChapSecretNames() {
+		if strings.Contains(name, "/") {
+			secret, err := m.getInitiatorSecret(ctx, name, ig)
+			if err != nil {
+				return gatewayInstance, err
+			}
+			if secret != nil {
+				gatewayInstance.ChapSecrets[name] = secret
+			}
+			continue
+		}
+		secret, err := m.getChapSecret(ctx, name, ig)
+		if err != nil {
+			return gatewayInstance, err
+		}
+		gatewayInstance.ChapSecrets[name] = secret
+	}
+	portals, err := m.getPortals(ctx, planner)
+	if err != nil {
+		return gatewayInstance, err
 	}
+	gatewayInstance.Portals = portals
+	ips, err := m.getGatewayIPs(ctx, planner)
+	if err != nil {
+		return gatewayInstance, err
+	}
+	gatewayInstance.GatewayIPs = ips
+	apiSecret, err := m.getApiSecret(ctx, planner)
+	if err != nil {
+		return gatewayInstance, err
+	}
+	gatewayInstance.ApiSecret = apiSecret
+	if planner.CephGenerated() {
+		cephSecret, err := m.getCephSecret(ctx, planner)
+		if err != nil {
+			return gatewayInstance, err
+		}
+		gatewayInstance.CephSecret = cephSecret
+	}
+	disks, err := m.listDisks(ctx, ig)
+	if err != nil {
+		return gatewayInstance, err
+	}
+	gatewayInstance.Disks = disks
 	return gatewayInstance, nil
 }
 
+// getPortals returns the portal address of every gateway pod that has one.
+func (m *IscsiGatewayManager) getPortals(
+	ctx context.Context,
+	planner *pln.Planner) (map[string]string, error) {
+
+	ig := planner.Iscsigateway
+	pods := &corev1.PodList{}
+	err := m.client.List(ctx, pods,
+		rtclient.InNamespace(ig.Namespace),
+		rtclient.MatchingLabels(labelsForIscsiServer(planner.InstanceName())))
+	if err != nil {
+		m.logger.Error(err, "Failed to list gateway pods")
+		return nil, err
+	}
+	services := map[string]*corev1.Service{}
+	if ig.Spec.Portals != nil {
+		list := &corev1.ServiceList{}
+		err = m.client.List(ctx, list,
+			rtclient.InNamespace(ig.Namespace),
+			rtclient.MatchingLabels(labelsForPortalService(planner.InstanceName())))
+		if err != nil {
+			m.logger.Error(err, "Failed to list portal Services")
+			return nil, err
+		}
+		for i := range list.Items {
+			services[list.Items[i].Name] = &list.Items[i]
+		}
+	}
+
+	portals := map[string]string{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		var svc *corev1.Service
+		if ig.Spec.Portals != nil {
+			if svc = services[pod.Name]; svc == nil {
+				// wait for the Service, the pod address is unreachable
+				continue
+			}
+		}
+		if portal := portalAddress(planner, pod, svc); portal != "" {
+			portals[pod.Name] = portal
+		}
+	}
+	return portals, nil
+}
+
+// getOrCreateService returns the named Service, creating it from desired
+// if it does not exist yet.
+func (m *IscsiGatewayManager) getOrCreateService(
+	ctx context.Context,
+	ig *iscsigateway.Iscsigateway,
+	desired *corev1.Service) (*corev1.Service, bool, error) {
+
+	found := &corev1.Service{}
+	svcKey := types.NamespacedName{
+		Namespace: desired.Namespace,
+		Name:      desired.Name,
+	}
+	err := m.client.Get(ctx, svcKey, found)
+	if err == nil {
+		return found, false, nil
+	}
+	if !errors.IsNotFound(err) {
+		m.logger.Error(
+			err,
+			"Failed to get Service",
+			"Service.Namespace", svcKey.Namespace,
+			"Service.Name", svcKey.Name,
+		)
+		return nil, false, err
+	}
+
+	err = controllerutil.SetControllerReference(ig, desired, m.scheme)
+	if err != nil {
+		m.logger.Error(
+			err,
+			"Failed to set controller reference",
+			"Iscsigateway.Namespace", ig.Namespace,
+			"Iscsigateway.Name", ig.Name,
+			"Service.Namespace", desired.Namespace,
+			"Service.Name", desired.Name,
+		)
+		return nil, false, err
+	}
+	m.logger.Info(
+		"Creating a new Service",
+		"Iscsigateway.Namespace", ig.Namespace,
+		"Iscsigateway.Name", ig.Name,
+		"Service.Namespace", desired.Namespace,
+		"Service.Name", desired.Name,
+	)
+	err = m.client.Create(ctx, desired)
+	if err != nil {
+		m.logger.Error(
+			err,
+			"Failed to create new Service",
+			"Iscsigateway.Namespace", ig.Namespace,
+			"Iscsigateway.Name", ig.Name,
+			"Service.Namespace", desired.Namespace,
+			"Service.Name", desired.Name,
+		)
+		return nil, false, err
+	}
+	return desired, true, nil
+}
+
+func (m *IscsiGatewayManager) getChapSecret(
+	ctx context.Context,
+	name string,
+	ig *iscsigateway.Iscsigateway) (*corev1.Secret, error) {
+
+	secret := &corev1.Secret{}
+	secretNsname := types.NamespacedName{
+		Namespace: ig.Namespace,
+		Name:      name,
+	}
+	err := m.client.Get(ctx, secretNsname, secret)
+	if err == nil {
+		err = checkChapSecret(secret)
+	}
+	if err != nil {
+		m.logger.Error(
+			err,
+			"Failed to get CHAP secret",
+			"IscsiGateway.Namespace", ig.Namespace,
+			"IscsiGateway.Name", ig.Name,
+			"Secret.Name", name,
+		)
+		m.recorder.Eventf(ig,
+			EventWarning,
+			ReasonInvalidChapSecret,
+			"Unable to use CHAP secret %s: %s", name, err)
+		return nil, err
+	}
+	return secret, nil
+}
+
+func (m *IscsiGatewayManager) getOrCreateAuthSecret(
+	ctx context.Context,
+	planner *pln.Planner) (*corev1.Secret, bool, error) {
+
+	ig := planner.Iscsigateway
+	found := &corev1.Secret{}
+	secretNsname := types.NamespacedName{
+		Namespace: ig.Namespace,
+		Name:      authSecretName(planner),
+	}
+	err := m.client.Get(ctx, secretNsname, found)
+	if err == nil {
+		return found, false, nil
+	}
+	if !errors.IsNotFound(err) {
+		m.logger.Error(
+			err,
+			"Failed to get auth Secret",
+			"IscsiGateway.Namespace", ig.Namespace,
+			"IscsiGateway.Name", ig.Name,
+			"Secret.Name", secretNsname.Name,
+		)
+		return nil, false, err
+	}
+
+	secret, err := newAuthSecret(
+		secretNsname.Name, secretNsname.Namespace, planner.Credentials())
+	if err != nil {
+		return nil, false, err
+	}
+	err = controllerutil.SetControllerReference(ig, secret, m.scheme)
+	if err != nil {
+		m.logger.Error(
+			err,
+			"Failed to set controller reference",
+			"IscsiGateway.Namespace", ig.Namespace,
+			"IscsiGateway.Name", ig.Name,
+			"Secret.Name", secret.Name,
+		)
+		return secret, false, err
+	}
+	err = m.client.Create(ctx, secret)
+	if err != nil {
+		m.logger.Error(
+			err,
+			"Failed to create new auth Secret",
+			"IscsiGateway.Namespace", ig.Namespace,
+			"IscsiGateway.Name", ig.Name,
+			"Secret.Name", secret.Name,
+		)
+		return secret, false, err
+	}
+	return secret, true, nil
+}
+
 func (m *IscsiGatewayManager) getOrCreateGenericPVC(
 	ctx context.Context,
 	ig *iscsigateway.Iscsigateway,
@@ -295,36 +562,39 @@ func (m *IscsiGatewayManager) getExistingPVC(
 func (m *IscsiGatewayManager) getOrCreateTcmuRunner(
 	ctx context.Context,
 	name string,
-	pl *pln.Planner) error {
+	pl *pln.Planner,
+	gateways []iscsigateway.Iscsigateway) (*appsv1.DaemonSet, bool, error) {
 
 	ds, err := m.getExistingDaemonset(ctx, name, pl.Iscsigateway)
-
 	if err != nil {
-		if !errors.IsNotFound(err) {
-			return err
-		}
+		return nil, false, err
 	}
-
 	if ds != nil {
-		return nil
+		return ds, false, nil
 	}
 
 	// create
 	m.logger.Info("Start creating tcmu-runner daemonset")
-	ds = buildDaemonset(ctx, name, pl)
+	source, err := m.tcmuRunnerSource(ctx, pl, nil, gateways)
+	if err != nil {
+		return nil, false, err
+	}
+	ds = buildDaemonset(ctx, name, source, gateways)
 
-	err = controllerutil.SetControllerReference(
+	// the daemon set is shared by the gateways of the namespace, each of
+	// them holds a reference so that it outlives any single gateway.
+	err = controllerutil.SetOwnerReference(
 		pl.Iscsigateway, ds, m.scheme)
 	if err != nil {
 		m.logger.Error(
 			err,
-			"Failed to set controller reference",
+			"Failed to set owner reference",
 			"Iscsigateway.Namespace", pl.Iscsigateway.Namespace,
 			"Iscsigateway.Name", pl.Iscsigateway.Name,
 			"Daemonset.Namespace", ds.Namespace,
 			"Daemonset.Name", ds.Name,
 		)
-		return err
+		return nil, false, err
 	}
 	m.logger.Info(
 		"Creating a new DaemonSet",
@@ -343,9 +613,59 @@ func (m *IscsiGatewayManager) getOrCreateTcmuRunner(
 			"DaemonSet.Namespace", ds.Namespace,
 			"DaemonSet.Name", ds.Name,
 		)
-		return err
+		return nil, false, err
+	}
+	return ds, true, nil
+}
+
+// listGateways returns the gateways of the namespace that are not being
+// deleted, sorted by name.
+func (m *IscsiGatewayManager) listGateways(
+	ctx context.Context,
+	ns string) ([]iscsigateway.Iscsigateway, error) {
+
+	list := &iscsigateway.IscsigatewayList{}
+	err := m.client.List(ctx, list, rtclient.InNamespace(ns))
+	if err != nil {
+		m.logger.Error(err, "Failed to list IscsiGateways", "Namespace", ns)
+		return nil, err
+	}
+	gateways := []iscsigateway.Iscsigateway{}
+	for _, ig := range list.Items {
+		if ig.GetDeletionTimestamp() == nil {
+			gateways = append(gateways, ig)
+		}
+	}
+	sort.Slice(gateways, func(i, j int) bool {
+		return gateways[i].Name < gateways[j].Name
+	})
+	return gateways, nil
+}
+
+// listDisks returns the Iscsidisks referring to the gateway, sorted by
+// name. Iscsidisks being deleted are kept, the planner exports them while
+// hosts still map them.
+func (m *IscsiGatewayManager) listDisks(
+	ctx context.Context,
+	ig *iscsigateway.Iscsigateway) ([]iscsigateway.Iscsidisk, error) {
+
+	list := &iscsigateway.IscsidiskList{}
+	err := m.client.List(ctx, list, rtclient.InNamespace(ig.Namespace))
+	if err != nil {
+		m.logger.Error(err, "Failed to list Iscsidisks", "Namespace", ig.Namespace)
+		return nil, err
+	}
+	disks := []iscsigateway.Iscsidisk{}
+	for _, d := range list.Items {
+		if d.Spec.GatewayRef == nil || d.Spec.GatewayRef.Name != ig.Name {
+			continue
+		}
+		disks = append(disks, d)
 	}
-	return nil
+	sort.Slice(disks, func(i, j int) bool {
+		return disks[i].Name < disks[j].Name
+	})
+	return disks, nil
 }
 
 func (m *IscsiGatewayManager) getExistingDaemonset(
@@ -0,0 +1,245 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	iscsigateway "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	pln "github.com/Erichorng/iscsi-operator/internal/planner"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// getInitiators returns the Iscsiinitiators requesting access to the
+// gateway from the namespaces it allows, sorted by namespace and name.
+func (m *IscsiGatewayManager) getInitiators(
+	ctx context.Context,
+	ig *iscsigateway.Iscsigateway) ([]iscsigateway.Iscsiinitiator, error) {
+
+	list := &iscsigateway.IscsiinitiatorList{}
+	if err := m.client.List(ctx, list); err != nil {
+		m.logger.Error(err, "Failed to list Iscsiinitiators")
+		return nil, err
+	}
+	initiators := []iscsigateway.Iscsiinitiator{}
+	for _, ini := range list.Items {
+		if ini.GetDeletionTimestamp() != nil || !ini.SelectsGateway(ig) {
+			continue
+		}
+		allowed, err := m.initiatorNamespaceAllowed(ctx, ig, ini.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		if allowed {
+			initiators = append(initiators, ini)
+		}
+	}
+	sort.Slice(initiators, func(i, j int) bool {
+		if initiators[i].Namespace != initiators[j].Namespace {
+			return initiators[i].Namespace < initiators[j].Namespace
+		}
+		return initiators[i].Name < initiators[j].Name
+	})
+	return initiators, nil
+}
+
+// initiatorNamespaceAllowed returns true if Iscsiinitiators of the
+// namespace may be granted access to the gateway.
+func (m *IscsiGatewayManager) initiatorNamespaceAllowed(
+	ctx context.Context,
+	ig *iscsigateway.Iscsigateway,
+	namespace string) (bool, error) {
+
+	if namespace == ig.Namespace {
+		return true, nil
+	}
+	if ig.Spec.InitiatorNamespaceSelector == nil {
+		return false, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(
+		ig.Spec.InitiatorNamespaceSelector)
+	if err != nil {
+		return false, err
+	}
+	ns := &corev1.Namespace{}
+	err = m.client.Get(ctx, types.NamespacedName{Name: namespace}, ns)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		m.logger.Error(err, "Failed to get Namespace", "Namespace", namespace)
+		return false, err
+	}
+	return selector.Matches(labels.Set(ns.Labels)), nil
+}
+
+// getInitiatorSecret returns the CHAP secret of an Iscsiinitiator, named by
+// its InitiatorSecretKey. A missing or invalid secret only rejects the
+// initiator, nil is returned for it.
+func (m *IscsiGatewayManager) getInitiatorSecret(
+	ctx context.Context,
+	key string,
+	ig *iscsigateway.Iscsigateway) (*corev1.Secret, error) {
+
+	namespace, name, _ := strings.Cut(key, "/")
+	secret := &corev1.Secret{}
+	err := m.client.Get(ctx, types.NamespacedName{
+		Namespace: namespace,
+		Name:      name,
+	}, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		m.logger.Error(
+			err,
+			"Failed to get CHAP secret",
+			"IscsiGateway.Namespace", ig.Namespace,
+			"IscsiGateway.Name", ig.Name,
+			"Secret.Namespace", namespace,
+			"Secret.Name", name,
+		)
+		return nil, err
+	}
+	if err != nil || checkChapSecret(secret) != nil {
+		return nil, nil
+	}
+	return secret, nil
+}
+
+// updateInitiatorStatus reports in the status of every Iscsiinitiator
+// selecting the gateway whether the gateway accepted it, and drops the
+// gateway from the status of the initiators no longer selecting it.
+func (m *IscsiGatewayManager) updateInitiatorStatus(
+	ctx context.Context,
+	planner *pln.Planner,
+	targetName string) error {
+
+	ig := planner.Iscsigateway
+	checks := map[types.NamespacedName]error{}
+	for _, c := range planner.CheckInitiators() {
+		checks[types.NamespacedName{
+			Namespace: c.Iscsiinitiator.Namespace,
+			Name:      c.Iscsiinitiator.Name,
+		}] = c.Err
+	}
+
+	list := &iscsigateway.IscsiinitiatorList{}
+	if err := m.client.List(ctx, list); err != nil {
+		m.logger.Error(err, "Failed to list Iscsiinitiators")
+		return err
+	}
+	for i := range list.Items {
+		ini := &list.Items[i]
+		if ini.GetDeletionTimestamp() != nil || !ini.SelectsGateway(ig) {
+			if err := m.setInitiatorState(ctx, ini, ig, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		state := &iscsigateway.IscsiInitiatorGatewayState{
+			Namespace:          ig.Namespace,
+			Name:               ig.Name,
+			TargetName:         targetName,
+			Accepted:           true,
+			ObservedGeneration: ini.Generation,
+		}
+		err, found := checks[types.NamespacedName{
+			Namespace: ini.Namespace,
+			Name:      ini.Name,
+		}]
+		switch {
+		case !found:
+			state.Accepted = false
+			state.Message = fmt.Sprintf(
+				"namespace %s is not allowed by the gateway", ini.Namespace)
+		case err != nil:
+			state.Accepted = false
+			state.Message = err.Error()
+		}
+		if err := m.setInitiatorState(ctx, ini, ig, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseInitiators drops the gateway from the status of every
+// Iscsiinitiator.
+func (m *IscsiGatewayManager) releaseInitiators(
+	ctx context.Context,
+	ig *iscsigateway.Iscsigateway) Result {
+
+	list := &iscsigateway.IscsiinitiatorList{}
+	if err := m.client.List(ctx, list); err != nil {
+		m.logger.Error(err, "Failed to list Iscsiinitiators")
+		return Result{err: err}
+	}
+	for i := range list.Items {
+		err := m.setInitiatorState(ctx, &list.Items[i], ig, nil)
+		if errors.IsConflict(err) {
+			return Requeue
+		}
+		if err != nil {
+			return Result{err: err}
+		}
+	}
+	return Done
+}
+
+// setInitiatorState sets the state of the gateway in the status of the
+// initiator, removing it if state is nil.
+func (m *IscsiGatewayManager) setInitiatorState(
+	ctx context.Context,
+	ini *iscsigateway.Iscsiinitiator,
+	ig *iscsigateway.Iscsigateway,
+	state *iscsigateway.IscsiInitiatorGatewayState) error {
+
+	gateways := []iscsigateway.IscsiInitiatorGatewayState{}
+	var previous *iscsigateway.IscsiInitiatorGatewayState
+	for i, s := range ini.Status.Gateways {
+		if s.Namespace == ig.Namespace && s.Name == ig.Name {
+			previous = &ini.Status.Gateways[i]
+			continue
+		}
+		gateways = append(gateways, s)
+	}
+	if state == nil && previous == nil {
+		return nil
+	}
+	if state != nil && previous != nil &&
+		equality.Semantic.DeepEqual(state, previous) {
+		return nil
+	}
+	if state != nil {
+		gateways = append(gateways, *state)
+		if !state.Accepted &&
+			(previous == nil || previous.Message != state.Message) {
+			m.recorder.Eventf(ini,
+				EventWarning,
+				ReasonInitiatorRejected,
+				"Gateway %s/%s rejected the initiator: %s",
+				ig.Namespace, ig.Name, state.Message)
+		}
+	}
+	sort.Slice(gateways, func(i, j int) bool {
+		if gateways[i].Namespace != gateways[j].Namespace {
+			return gateways[i].Namespace < gateways[j].Namespace
+		}
+		return gateways[i].Name < gateways[j].Name
+	})
+	ini.Status.Gateways = gateways
+	err := m.client.Status().Update(ctx, ini)
+	if err != nil && !errors.IsConflict(err) {
+		m.logger.Error(
+			err,
+			"Failed to update Iscsiinitiator status",
+			"Iscsiinitiator.Namespace", ini.Namespace,
+			"Iscsiinitiator.Name", ini.Name,
+		)
+	}
+	return err
+}
@@ -0,0 +1,204 @@
+package resource
+
+import (
+	"context"
+
+	"github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	iscsigateway "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	rtclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = ginkgo.Describe("Initiators", func() {
+	const clientHost = "iqn.2023-01.com.example:client"
+
+	var (
+		ctx      context.Context
+		recorder *record.FakeRecorder
+		ig       *iscsigateway.Iscsigateway
+	)
+
+	// initiator returns an Iscsiinitiator of the namespace selecting the
+	// gateway and mapping a LUN to the disk.
+	initiator := func(namespace, name, disk string) *iscsigateway.Iscsiinitiator {
+		return &iscsigateway.Iscsiinitiator{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       name,
+				Namespace:  namespace,
+				Generation: 1,
+			},
+			Spec: iscsigateway.IscsiinitiatorSpec{
+				InitiatorName: clientHost,
+				Luns: []iscsigateway.IscsiLunSpec{
+					{PoolName: "rbd", DiskName: disk},
+				},
+				GatewaySelector: iscsigateway.IscsiGatewaySelector{
+					Namespace: "storage",
+				},
+			},
+		}
+	}
+
+	namespace := func(name string, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		}
+	}
+
+	names := func(initiators []iscsigateway.Iscsiinitiator) []string {
+		n := []string{}
+		for _, ini := range initiators {
+			n = append(n, ini.Namespace+"/"+ini.Name)
+		}
+		return n
+	}
+
+	gatewayStates := func(
+		m *IscsiGatewayManager,
+		namespace, name string) []iscsigateway.IscsiInitiatorGatewayState {
+
+		ini := &iscsigateway.Iscsiinitiator{}
+		Expect(m.client.Get(ctx, types.NamespacedName{
+			Namespace: namespace,
+			Name:      name,
+		}, ini)).To(Succeed())
+		return ini.Status.Gateways
+	}
+
+	ginkgo.BeforeEach(func() {
+		ctx = context.Background()
+		recorder = record.NewFakeRecorder(10)
+		ig = testGateway()
+	})
+
+	ginkgo.It("only takes initiators from the namespace of the gateway by default", func() {
+		m := testManager(recorder,
+			namespace("apps", nil),
+			initiator("storage", "b", "d1"),
+			initiator("storage", "a", "d1"),
+			initiator("apps", "c", "d1"))
+		initiators, err := m.getInitiators(ctx, ig)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names(initiators)).To(Equal([]string{"storage/a", "storage/b"}))
+	})
+
+	ginkgo.It("takes initiators from the namespaces selected by the gateway", func() {
+		ig.Spec.InitiatorNamespaceSelector = &metav1.LabelSelector{
+			MatchLabels: map[string]string{"iscsi": "allowed"},
+		}
+		m := testManager(recorder,
+			namespace("apps", map[string]string{"iscsi": "allowed"}),
+			namespace("other", nil),
+			initiator("apps", "a", "d1"),
+			initiator("other", "b", "d1"))
+		initiators, err := m.getInitiators(ctx, ig)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names(initiators)).To(Equal([]string{"apps/a"}))
+	})
+
+	ginkgo.It("skips initiators selecting another gateway", func() {
+		ini := initiator("storage", "a", "d1")
+		ini.Spec.GatewaySelector.MatchLabels = map[string]string{"tier": "gold"}
+		m := testManager(recorder, ini)
+		initiators, err := m.getInitiators(ctx, ig)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(initiators).To(BeEmpty())
+	})
+
+	ginkgo.It("ignores missing and invalid CHAP secrets", func() {
+		invalid := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "bad", Namespace: "apps"},
+			Type:       corev1.SecretTypeOpaque,
+		}
+		m := testManager(recorder, invalid)
+		for _, key := range []string{"apps/missing", "apps/bad"} {
+			secret, err := m.getInitiatorSecret(ctx, key, ig)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(secret).To(BeNil())
+		}
+	})
+
+	ginkgo.Describe("reporting in the status", func() {
+		var (
+			m        *IscsiGatewayManager
+			accepted *iscsigateway.Iscsiinitiator
+			rejected *iscsigateway.Iscsiinitiator
+			foreign  *iscsigateway.Iscsiinitiator
+		)
+
+		ginkgo.BeforeEach(func() {
+			accepted = initiator("storage", "a", "d1")
+			rejected = initiator("storage", "b", "d2")
+			rejected.Spec.InitiatorName = "iqn.2023-01.com.example:other"
+			foreign = initiator("apps", "c", "d1")
+			m = testManager(recorder, namespace("apps", nil),
+				accepted, rejected, foreign)
+		})
+
+		update := func() {
+			initiators, err := m.getInitiators(ctx, ig)
+			Expect(err).NotTo(HaveOccurred())
+			planner := testPlanner(ig)
+			planner.Initiators = initiators
+			Expect(m.updateInitiatorStatus(ctx, planner, "iqn.target")).To(Succeed())
+		}
+
+		ginkgo.It("tells whether the gateway accepted the initiator", func() {
+			update()
+			Expect(gatewayStates(m, "storage", "a")).To(Equal(
+				[]iscsigateway.IscsiInitiatorGatewayState{{
+					Namespace:          "storage",
+					Name:               "gw",
+					TargetName:         "iqn.target",
+					Accepted:           true,
+					ObservedGeneration: 1,
+				}}))
+
+			states := gatewayStates(m, "storage", "b")
+			Expect(states).To(HaveLen(1))
+			Expect(states[0].Accepted).To(BeFalse())
+			Expect(states[0].Message).To(Equal(
+				"LUN rbd/d2 is not a disk of the gateway"))
+
+			states = gatewayStates(m, "apps", "c")
+			Expect(states).To(HaveLen(1))
+			Expect(states[0].Message).To(Equal(
+				"namespace apps is not allowed by the gateway"))
+		})
+
+		ginkgo.It("warns once about a rejected initiator", func() {
+			update()
+			Expect(recorder.Events).To(Receive(ContainSubstring(ReasonInitiatorRejected)))
+			Expect(recorder.Events).To(Receive(ContainSubstring(ReasonInitiatorRejected)))
+			Expect(recorder.Events).NotTo(Receive())
+
+			update()
+			Expect(recorder.Events).NotTo(Receive())
+		})
+
+		ginkgo.It("drops the gateway from initiators no longer selecting it", func() {
+			update()
+			ini := &iscsigateway.Iscsiinitiator{}
+			Expect(m.client.Get(ctx, rtclient.ObjectKeyFromObject(accepted), ini)).To(Succeed())
+			ini.Spec.GatewaySelector.Namespace = "other"
+			Expect(m.client.Update(ctx, ini)).To(Succeed())
+
+			update()
+			Expect(gatewayStates(m, "storage", "a")).To(BeEmpty())
+			Expect(gatewayStates(m, "storage", "b")).To(HaveLen(1))
+		})
+
+		ginkgo.It("drops the gateway from every initiator once released", func() {
+			update()
+			Expect(m.releaseInitiators(ctx, ig)).To(Equal(Done))
+			Expect(gatewayStates(m, "storage", "a")).To(BeEmpty())
+			Expect(gatewayStates(m, "storage", "b")).To(BeEmpty())
+			Expect(gatewayStates(m, "apps", "c")).To(BeEmpty())
+		})
+	})
+})
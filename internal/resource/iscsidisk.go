@@ -0,0 +1,602 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	iscsigateway "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/conf"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+	pln "github.com/Erichorng/iscsi-operator/internal/planner"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	kresource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	rtclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const diskFinalizer = "diskFinalizer"
+
+const (
+	// diskPollInterval is how often a running rbd job is checked.
+	diskPollInterval = 5 * time.Second
+	// diskRetryInterval is how long to wait before retrying a failed rbd
+	// job or a disk missing its gateway.
+	diskRetryInterval = time.Minute
+	// diskResyncInterval is how often the state of the RBD image is
+	// refreshed in the status.
+	diskResyncInterval = 10 * time.Minute
+)
+
+// reasons used for the conditions of the iscsidisk status
+const (
+	reasonImageSynced      = "ImageSynced"
+	reasonImageSyncing     = "ImageSyncing"
+	reasonImageSyncFailed  = "ImageSyncFailed"
+	reasonGatewayMissing   = "GatewayNotFound"
+	reasonCephConfigNotSet = "CephConfigNotSet"
+	reasonDiskInUse        = "DiskInUse"
+)
+
+type IscsiDiskManager struct {
+	client   rtclient.Client
+	scheme   *runtime.Scheme
+	recorder record.EventRecorder
+	logger   Logger
+	cfg      *conf.OperatorConfig
+}
+
+func NewIscsiDiskManager(
+	client rtclient.Client,
+	scheme *runtime.Scheme,
+	logger logr.Logger,
+	recorder record.EventRecorder,
+) *IscsiDiskManager {
+	return &IscsiDiskManager{
+		client:   client,
+		scheme:   scheme,
+		recorder: recorder,
+		logger:   logger,
+		cfg:      conf.Get(),
+	}
+}
+
+func (m *IscsiDiskManager) Process(
+	ctx context.Context,
+	nsname types.NamespacedName) Result {
+	instance := &iscsigateway.Iscsidisk{}
+	err := m.client.Get(ctx, nsname, instance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return Done
+		}
+		m.logger.Error(
+			err,
+			"Failed to get Iscsidisk",
+			"Iscsidisk.Namespace", nsname.Namespace,
+			"Iscsidisk.Name", nsname.Name,
+		)
+		return Result{err: err}
+	}
+
+	if instance.GetDeletionTimestamp() != nil {
+		if controllerutil.ContainsFinalizer(instance, diskFinalizer) {
+			return m.Finalize(ctx, instance)
+		}
+		return Done
+	}
+	return m.Update(ctx, instance)
+}
+
+// Update creates or grows the RBD image of the disk and reports its state.
+func (m *IscsiDiskManager) Update(
+	ctx context.Context,
+	instance *iscsigateway.Iscsidisk) Result {
+	m.logger.Info(
+		"Updating state for Iscsidisk",
+		"Iscsidisk.Namespace", instance.Namespace,
+		"Iscsidisk.Name", instance.Name,
+	)
+
+	if !controllerutil.ContainsFinalizer(instance, diskFinalizer) {
+		controllerutil.AddFinalizer(instance, diskFinalizer)
+		if err := m.client.Update(ctx, instance); err != nil {
+			return Result{err: err}
+		}
+		return Requeue
+	}
+
+	planner, result := m.getDiskPlanner(ctx, instance)
+	if result.Yield() {
+		return result
+	}
+	if !planner.HasCephConfig() {
+		return m.setNotReady(ctx, instance, reasonCephConfigNotSet,
+			"No ceph configuration is set and the disk has no gateway")
+	}
+	return m.syncImage(ctx, planner)
+}
+
+// getDiskPlanner returns a planner for the disk, resolving the gateway it
+// references. A missing gateway is reported in the status.
+func (m *IscsiDiskManager) getDiskPlanner(
+	ctx context.Context,
+	disk *iscsigateway.Iscsidisk) (*pln.DiskPlanner, Result) {
+
+	ref := disk.Spec.GatewayRef
+	if ref == nil {
+		return pln.NewDiskPlanner(disk, nil, m.cfg), Done
+	}
+	ig := &iscsigateway.Iscsigateway{}
+	err := m.client.Get(ctx, types.NamespacedName{
+		Namespace: disk.Namespace,
+		Name:      ref.Name,
+	}, ig)
+	switch {
+	case err == nil:
+		return pln.NewDiskPlanner(disk, ig, m.cfg), Done
+	case !errors.IsNotFound(err):
+		m.logger.Error(
+			err,
+			"Failed to get IscsiGateway",
+			"IscsiGateway.Namespace", disk.Namespace,
+			"IscsiGateway.Name", ref.Name,
+		)
+		return nil, Result{err: err}
+	case disk.Spec.CephConfig != "":
+		// the image can be managed without the gateway
+		return pln.NewDiskPlanner(disk, nil, m.cfg), Done
+	}
+	result := m.setNotReady(ctx, disk, reasonGatewayMissing,
+		fmt.Sprintf("IscsiGateway %s not found", ref.Name))
+	if result.Err() != nil {
+		return nil, result
+	}
+	return nil, RequeueAfter(diskRetryInterval)
+}
+
+// syncImage runs a job creating or growing the RBD image and reports the
+// state of the image once it completes. The job is rerun when the spec
+// changes, when the image is too small and every diskResyncInterval.
+func (m *IscsiDiskManager) syncImage(
+	ctx context.Context,
+	planner *pln.DiskPlanner) Result {
+
+	disk := planner.Iscsidisk
+	job, err := m.getRbdJob(ctx, planner, rbdSyncTask)
+	if err != nil {
+		return Result{err: err}
+	}
+	if job == nil {
+		if wait := m.nextSync(planner); wait > 0 {
+			return RequeueAfter(wait)
+		}
+		job = buildRbdJob(planner, rbdSyncTask,
+			planner.SyncCommand(), planner.Env(planner.NeedsResize()))
+		return m.createRbdJob(ctx, disk, job)
+	}
+
+	finished, succeeded := jobFinished(job)
+	if !finished {
+		return RequeueAfter(diskPollInterval)
+	}
+	msg, err := m.getJobMessage(ctx, job)
+	if err != nil {
+		return Result{err: err}
+	}
+	if err := m.deleteJob(ctx, job); err != nil {
+		return Result{err: err}
+	}
+	generation, _ := strconv.ParseInt(job.Annotations[rbdGenerationAnnotation], 10, 64)
+	if !succeeded {
+		m.recorder.Eventf(disk,
+			EventWarning,
+			ReasonImageSyncFailed,
+			"Failed to sync image %s: %s", planner.ImageSpec(), msg)
+		return m.setSyncFailed(ctx, disk, generation, msg)
+	}
+
+	info, err := parseRbdImageInfo(msg)
+	if err != nil {
+		m.logger.Error(err, "Failed to read the state of the image",
+			"Iscsidisk.Namespace", disk.Namespace,
+			"Iscsidisk.Name", disk.Name,
+		)
+		return m.setSyncFailed(ctx, disk, generation, err.Error())
+	}
+	previous := disk.Status.Size
+	result := m.setSynced(ctx, disk, info, generation)
+	if result.Yield() {
+		return result
+	}
+	if previous == nil || previous.Value() != info.Size {
+		m.recorder.Eventf(disk,
+			EventNormal,
+			ReasonSyncedImage,
+			"Image %s has a size of %s", planner.ImageSpec(),
+			disk.Status.Size.String())
+	}
+	if disk.Status.ObservedGeneration != disk.Generation || planner.NeedsResize() {
+		return Requeue
+	}
+	return RequeueAfter(diskResyncInterval)
+}
+
+// nextSync returns how long to wait before the image is synced again, zero
+// if it must be synced right away.
+func (m *IscsiDiskManager) nextSync(planner *pln.DiskPlanner) time.Duration {
+	disk := planner.Iscsidisk
+	last := disk.Status.LastSyncTime
+	cond := meta.FindStatusCondition(
+		disk.Status.Conditions, iscsigateway.ConditionReady)
+	interval := diskResyncInterval
+	switch {
+	case last == nil || cond == nil:
+		return 0
+	case disk.Status.ObservedGeneration != disk.Generation:
+		return 0
+	case cond.Reason == reasonImageSyncFailed:
+		// back off, the job may be failing for good
+		interval = diskRetryInterval
+	case planner.NeedsResize() || cond.Status != metav1.ConditionTrue:
+		return 0
+	}
+	wait := time.Until(last.Add(interval))
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// Finalize waits for the gateway to stop exporting the disk and deletes
+// the RBD image when the reclaim policy is Delete, before the finalizer is
+// removed.
+func (m *IscsiDiskManager) Finalize(
+	ctx context.Context,
+	instance *iscsigateway.Iscsidisk) Result {
+
+	m.logger.Info(
+		"Finalizing Iscsidisk",
+		"Iscsidisk.Namespace", instance.Namespace,
+		"Iscsidisk.Name", instance.Name,
+	)
+
+	planner, result := m.getDiskPlanner(ctx, instance)
+	switch {
+	case result.Err() != nil:
+		return result
+	case planner == nil:
+		// the gateway is gone along with the ceph configuration
+		planner = pln.NewDiskPlanner(instance, nil, m.cfg)
+	}
+	if result := m.waitUnexported(ctx, planner); result.Yield() {
+		return result
+	}
+	if result := m.removeImage(ctx, planner); result.Yield() {
+		return result
+	}
+	// a sync job may still be around
+	job, err := m.getRbdJob(ctx, planner, rbdSyncTask)
+	if err != nil {
+		return Result{err: err}
+	}
+	if job != nil {
+		if err := m.deleteJob(ctx, job); err != nil {
+			return Result{err: err}
+		}
+	}
+
+	m.logger.Info("Remove finalizer")
+	controllerutil.RemoveFinalizer(instance, diskFinalizer)
+	if err := m.client.Update(ctx, instance); err != nil {
+		return Result{err: err}
+	}
+	return Done
+}
+
+// waitUnexported waits until the container config of the gateway no longer
+// lists the disk.
+func (m *IscsiDiskManager) waitUnexported(
+	ctx context.Context,
+	planner *pln.DiskPlanner) Result {
+
+	if planner.Gateway == nil {
+		return Done
+	}
+	disk := planner.Iscsidisk
+	configMap := &corev1.ConfigMap{}
+	err := m.client.Get(ctx, types.NamespacedName{
+		Namespace: planner.Gateway.Namespace,
+		Name:      planner.Gateway.Name,
+	}, configMap)
+	if errors.IsNotFound(err) {
+		return Done
+	}
+	if err != nil {
+		return Result{err: err}
+	}
+	cc, err := getContainerConfig(configMap)
+	if isInvalidConfig(err) {
+		// the gateway may still export the disk, wait for the ConfigMap
+		// to be fixed or deleted rather than failing the reconcile
+		m.recorder.Eventf(disk,
+			EventWarning,
+			ReasonInvalidConfigMap,
+			"Unable to tell if gateway %s exports %s: %s, fix or delete the ConfigMap",
+			planner.Gateway.Name, planner.ImageSpec(), err)
+		return RequeueAfter(diskRetryInterval)
+	}
+	if err != nil {
+		return Result{err: err}
+	}
+	if _, found := cc.Storage[disk.Spec.Pool][disk.ImageName()]; !found {
+		return Done
+	}
+	msg := fmt.Sprintf(
+		"Waiting for gateway %s to stop exporting %s, remove its LUNs from hosts %v",
+		planner.Gateway.Name, planner.ImageSpec(), mappingHosts(cc, planner.ImageSpec()))
+	m.recorder.Event(disk, EventWarning, ReasonDiskInUse, msg)
+	if result := m.setNotReady(ctx, disk, reasonDiskInUse, msg); result.Yield() {
+		return result
+	}
+	return RequeueAfter(diskRetryInterval)
+}
+
+// mappingHosts returns the hosts of the container config with a LUN on
+// the disk, sorted by name.
+func mappingHosts(cc *iscsicc.IscsiContainerConfig, key string) []string {
+	hosts := []string{}
+	for name, h := range cc.Hosts {
+		for _, lun := range h.Lun {
+			if lun == key {
+				hosts = append(hosts, name)
+				break
+			}
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// removeImage runs a job deleting the RBD image if the reclaim policy of
+// the disk is Delete.
+func (m *IscsiDiskManager) removeImage(
+	ctx context.Context,
+	planner *pln.DiskPlanner) Result {
+
+	disk := planner.Iscsidisk
+	if !planner.ReclaimImage() {
+		return Done
+	}
+	if !planner.HasCephConfig() {
+		m.recorder.Eventf(disk,
+			EventWarning,
+			ReasonImageRetained,
+			"Can not delete image %s without a ceph configuration, leaving it behind",
+			planner.ImageSpec())
+		return Done
+	}
+
+	job, err := m.getRbdJob(ctx, planner, rbdRemoveTask)
+	if err != nil {
+		return Result{err: err}
+	}
+	if job == nil {
+		job = buildRbdJob(planner, rbdRemoveTask,
+			planner.RemoveCommand(), planner.Env(false))
+		return m.createRbdJob(ctx, disk, job)
+	}
+	finished, succeeded := jobFinished(job)
+	if !finished {
+		return RequeueAfter(diskPollInterval)
+	}
+	msg, err := m.getJobMessage(ctx, job)
+	if err != nil {
+		return Result{err: err}
+	}
+	if err := m.deleteJob(ctx, job); err != nil {
+		return Result{err: err}
+	}
+	if !succeeded {
+		m.recorder.Eventf(disk,
+			EventWarning,
+			ReasonImageSyncFailed,
+			"Failed to delete image %s: %s", planner.ImageSpec(), msg)
+		return RequeueAfter(diskRetryInterval)
+	}
+	m.recorder.Eventf(disk,
+		EventNormal,
+		ReasonDeletedImage,
+		"Deleted image %s", planner.ImageSpec())
+	return Done
+}
+
+func (m *IscsiDiskManager) getRbdJob(
+	ctx context.Context,
+	planner *pln.DiskPlanner,
+	task string) (*batchv1.Job, error) {
+
+	job := &batchv1.Job{}
+	name := rbdJobName(planner, task)
+	err := m.client.Get(ctx, types.NamespacedName{
+		Namespace: planner.Iscsidisk.Namespace,
+		Name:      name,
+	}, job)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		m.logger.Error(err, "Failed to get rbd Job", "Job.Name", name)
+		return nil, err
+	}
+	return job, nil
+}
+
+func (m *IscsiDiskManager) createRbdJob(
+	ctx context.Context,
+	disk *iscsigateway.Iscsidisk,
+	job *batchv1.Job) Result {
+
+	err := controllerutil.SetControllerReference(disk, job, m.scheme)
+	if err != nil {
+		return Result{err: err}
+	}
+	err = m.client.Create(ctx, job)
+	if err != nil {
+		m.logger.Error(
+			err,
+			"Failed to create rbd Job",
+			"Job.Namespace", job.Namespace,
+			"Job.Name", job.Name,
+		)
+		return Result{err: err}
+	}
+	return RequeueAfter(diskPollInterval)
+}
+
+// getJobMessage returns the termination message of the pod of the job.
+func (m *IscsiDiskManager) getJobMessage(
+	ctx context.Context,
+	job *batchv1.Job) (string, error) {
+
+	pods := &corev1.PodList{}
+	err := m.client.List(ctx, pods,
+		rtclient.InNamespace(job.Namespace),
+		rtclient.MatchingLabels{"job-name": job.Name})
+	if err != nil {
+		m.logger.Error(err, "Failed to list the pods of Job", "Job.Name", job.Name)
+		return "", err
+	}
+	return terminationMessage(pods.Items), nil
+}
+
+func (m *IscsiDiskManager) deleteJob(
+	ctx context.Context, job *batchv1.Job) error {
+
+	err := m.client.Delete(ctx, job,
+		rtclient.PropagationPolicy(metav1.DeletePropagationBackground))
+	if err != nil && !errors.IsNotFound(err) {
+		m.logger.Error(
+			err,
+			"Failed to delete Job",
+			"Job.Namespace", job.Namespace,
+			"Job.Name", job.Name,
+		)
+		return err
+	}
+	return nil
+}
+
+// setSynced records the state of the image reported by a sync job created
+// for the given generation of the disk.
+func (m *IscsiDiskManager) setSynced(
+	ctx context.Context,
+	disk *iscsigateway.Iscsidisk,
+	info *rbdImageInfo,
+	generation int64) Result {
+
+	status := disk.Status.DeepCopy()
+	status.ObservedGeneration = generation
+	status.Size = kresource.NewQuantity(info.Size, kresource.BinarySI)
+	status.Watchers = nil
+	for _, w := range info.Watchers {
+		status.Watchers = append(status.Watchers, w.Address)
+	}
+	now := metav1.Now()
+	status.LastSyncTime = &now
+
+	cond := metav1.Condition{
+		Type:               iscsigateway.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             reasonImageSynced,
+		Message:            "RBD image matches the spec",
+	}
+	if status.Size.Cmp(disk.Spec.Size) < 0 {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = reasonImageSyncing
+		cond.Message = fmt.Sprintf("RBD image is %s, growing it to %s",
+			status.Size.String(), disk.Spec.Size.String())
+	}
+	meta.SetStatusCondition(&status.Conditions, cond)
+	return m.updateStatus(ctx, disk, status)
+}
+
+// setSyncFailed records that the sync job created for the given
+// generation of the disk failed, and asks for the sync to be retried.
+func (m *IscsiDiskManager) setSyncFailed(
+	ctx context.Context,
+	disk *iscsigateway.Iscsidisk,
+	generation int64,
+	message string) Result {
+
+	status := disk.Status.DeepCopy()
+	status.ObservedGeneration = generation
+	now := metav1.Now()
+	status.LastSyncTime = &now
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               iscsigateway.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             reasonImageSyncFailed,
+		Message:            message,
+	})
+	if result := m.updateStatus(ctx, disk, status); result.Yield() {
+		return result
+	}
+	return RequeueAfter(diskRetryInterval)
+}
+
+func (m *IscsiDiskManager) setNotReady(
+	ctx context.Context,
+	disk *iscsigateway.Iscsidisk,
+	reason, message string) Result {
+
+	status := disk.Status.DeepCopy()
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               iscsigateway.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: disk.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+	return m.updateStatus(ctx, disk, status)
+}
+
+func (m *IscsiDiskManager) updateStatus(
+	ctx context.Context,
+	disk *iscsigateway.Iscsidisk,
+	status *iscsigateway.IscsidiskStatus) Result {
+
+	if equality.Semantic.DeepEqual(&disk.Status, status) {
+		return Done
+	}
+	disk.Status = *status
+	err := m.client.Status().Update(ctx, disk)
+	switch {
+	case err == nil:
+		return Done
+	case errors.IsConflict(err):
+		// the disk changed while we were reconciling it
+		return Requeue
+	default:
+		m.logger.Error(
+			err,
+			"Failed to update Iscsidisk status",
+			"Iscsidisk.Namespace", disk.Namespace,
+			"Iscsidisk.Name", disk.Name,
+		)
+		return Result{err: err}
+	}
+}
@@ -0,0 +1,256 @@
+package resource
+
+import (
+	"context"
+
+	"github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	iscsigateway "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/conf"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	kresource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	rtclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const rbdInfoMessage = `{"name":"d1","size":2147483648}
+{"watchers":[{"address":"10.0.0.1:0/1"}]}`
+
+var _ = ginkgo.Describe("Iscsidisk", func() {
+	var (
+		ctx      context.Context
+		recorder *record.FakeRecorder
+		m        *IscsiDiskManager
+		disk     *iscsigateway.Iscsidisk
+	)
+
+	// manage sets up a manager working on a fake client holding the disk
+	// and the objects.
+	manage := func(objs ...rtclient.Object) {
+		client := fake.NewClientBuilder().
+			WithScheme(testScheme).
+			WithObjects(append(objs, disk)...).
+			Build()
+		cfg := conf.DefaultOperatorConfig
+		m = NewIscsiDiskManager(client, testScheme, logr.Discard(), recorder)
+		m.cfg = &cfg
+		Expect(client.Get(ctx, rtclient.ObjectKeyFromObject(disk), disk)).To(Succeed())
+	}
+
+	getJob := func(task string) *batchv1.Job {
+		job := &batchv1.Job{}
+		err := m.client.Get(ctx, types.NamespacedName{
+			Namespace: disk.Namespace,
+			Name:      labelValue(disk.Name, task),
+		}, job)
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return job
+	}
+
+	// finishJob marks the job of the task as finished, with a pod
+	// terminated with the message.
+	finishJob := func(task string, condition batchv1.JobConditionType, msg string) {
+		job := getJob(task)
+		Expect(job).NotTo(BeNil())
+		job.Status.Conditions = []batchv1.JobCondition{{
+			Type:   condition,
+			Status: corev1.ConditionTrue,
+		}}
+		Expect(m.client.Status().Update(ctx, job)).To(Succeed())
+		Expect(m.client.Create(ctx, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      job.Name + "-abcde",
+				Namespace: job.Namespace,
+				Labels:    map[string]string{"job-name": job.Name},
+			},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{{
+					State: corev1.ContainerState{
+						Terminated: &corev1.ContainerStateTerminated{
+							Message: msg,
+						},
+					},
+				}},
+			},
+		})).To(Succeed())
+	}
+
+	readyCondition := func() *metav1.Condition {
+		return meta.FindStatusCondition(
+			disk.Status.Conditions, iscsigateway.ConditionReady)
+	}
+
+	ginkgo.BeforeEach(func() {
+		ctx = context.Background()
+		recorder = record.NewFakeRecorder(10)
+		disk = &iscsigateway.Iscsidisk{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       "d1",
+				Namespace:  "storage",
+				Generation: 1,
+				Finalizers: []string{diskFinalizer},
+			},
+			Spec: iscsigateway.IscsidiskSpec{
+				Pool:       "rbd",
+				Size:       kresource.MustParse("2Gi"),
+				GatewayRef: &corev1.LocalObjectReference{Name: "gw"},
+			},
+		}
+	})
+
+	ginkgo.It("adds its finalizer first", func() {
+		disk.Finalizers = nil
+		manage(testGateway())
+		Expect(m.Update(ctx, disk)).To(Equal(Requeue))
+		Expect(disk.Finalizers).To(ConsistOf(diskFinalizer))
+		Expect(getJob(rbdSyncTask)).To(BeNil())
+	})
+
+	ginkgo.It("waits for a missing gateway", func() {
+		manage()
+		Expect(m.Update(ctx, disk)).To(Equal(RequeueAfter(diskRetryInterval)))
+		Expect(readyCondition().Reason).To(Equal(reasonGatewayMissing))
+		Expect(getJob(rbdSyncTask)).To(BeNil())
+	})
+
+	ginkgo.It("needs a ceph config without a gateway", func() {
+		disk.Spec.GatewayRef = nil
+		manage()
+		Expect(m.Update(ctx, disk)).To(Equal(Done))
+		Expect(readyCondition().Reason).To(Equal(reasonCephConfigNotSet))
+	})
+
+	ginkgo.It("creates the image with a job", func() {
+		manage(testGateway())
+		Expect(m.Update(ctx, disk)).To(Equal(RequeueAfter(diskPollInterval)))
+		job := getJob(rbdSyncTask)
+		Expect(job).NotTo(BeNil())
+		Expect(job.Annotations).To(HaveKeyWithValue(rbdGenerationAnnotation, "1"))
+		Expect(job.OwnerReferences).To(HaveLen(1))
+		Expect(job.Spec.Template.Spec.Volumes[0].ConfigMap.Name).To(Equal("ceph-config"))
+		Expect(job.Spec.Template.Spec.Containers[0].Env).To(ContainElement(
+			corev1.EnvVar{Name: "RBD_IMAGE", Value: "rbd/d1"}))
+
+		// the job is polled until it finishes
+		Expect(m.Update(ctx, disk)).To(Equal(RequeueAfter(diskPollInterval)))
+	})
+
+	ginkgo.It("reports the state of the image once synced", func() {
+		manage(testGateway())
+		Expect(m.Update(ctx, disk)).To(Equal(RequeueAfter(diskPollInterval)))
+		finishJob(rbdSyncTask, batchv1.JobComplete, rbdInfoMessage)
+
+		Expect(m.Update(ctx, disk)).To(Equal(RequeueAfter(diskResyncInterval)))
+		Expect(getJob(rbdSyncTask)).To(BeNil())
+		Expect(disk.Status.ObservedGeneration).To(Equal(int64(1)))
+		Expect(disk.Status.Size.String()).To(Equal("2Gi"))
+		Expect(disk.Status.Watchers).To(ConsistOf("10.0.0.1:0/1"))
+		Expect(readyCondition().Status).To(Equal(metav1.ConditionTrue))
+		Expect(recorder.Events).To(Receive(ContainSubstring(ReasonSyncedImage)))
+
+		// no job is run again before the resync interval
+		result := m.Update(ctx, disk)
+		Expect(result.Requeue()).To(BeTrue())
+		Expect(getJob(rbdSyncTask)).To(BeNil())
+	})
+
+	ginkgo.It("reports a failed sync and retries it later", func() {
+		manage(testGateway())
+		Expect(m.Update(ctx, disk)).To(Equal(RequeueAfter(diskPollInterval)))
+		finishJob(rbdSyncTask, batchv1.JobFailed, "rbd: error opening pool")
+
+		Expect(m.Update(ctx, disk)).To(Equal(RequeueAfter(diskRetryInterval)))
+		Expect(getJob(rbdSyncTask)).To(BeNil())
+		cond := readyCondition()
+		Expect(cond.Reason).To(Equal(reasonImageSyncFailed))
+		Expect(cond.Message).To(Equal("rbd: error opening pool"))
+		Expect(recorder.Events).To(Receive(ContainSubstring(ReasonImageSyncFailed)))
+
+		result := m.Update(ctx, disk)
+		Expect(result.Requeue()).To(BeTrue())
+		Expect(getJob(rbdSyncTask)).To(BeNil())
+	})
+
+	ginkgo.Describe("on deletion", func() {
+		// gatewayConfig returns the ConfigMap of the gateway, exporting
+		// the disk if asked to.
+		gatewayConfig := func(exported bool) *corev1.ConfigMap {
+			cc := iscsicc.New()
+			if exported {
+				cc.Storage["rbd"] = iscsicc.NewEmptyDisk()
+				cc.Storage["rbd"]["d1"] = iscsicc.NewDiskInfo("2Gi",
+					string(iscsigateway.DiskDeletionRetain))
+				cc.Hosts[testHost1] = iscsicc.HostInfo{Lun: []string{"rbd/d1"}}
+			}
+			cm := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "gw", Namespace: "storage"},
+				Data:       map[string]string{},
+			}
+			Expect(setContainerConfig(cm, cc)).To(Succeed())
+			return cm
+		}
+
+		ginkgo.It("waits for the gateway to stop exporting the disk", func() {
+			manage(testGateway(), gatewayConfig(true))
+			Expect(m.Finalize(ctx, disk)).To(Equal(RequeueAfter(diskRetryInterval)))
+			Expect(disk.Finalizers).To(ConsistOf(diskFinalizer))
+			Expect(recorder.Events).To(Receive(And(
+				ContainSubstring(ReasonDiskInUse), ContainSubstring(testHost1))))
+			cond := readyCondition()
+			Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+			Expect(cond.Reason).To(Equal(reasonDiskInUse))
+			Expect(cond.Message).To(ContainSubstring(testHost1))
+		})
+
+		ginkgo.It("retains the image by default", func() {
+			manage(testGateway(), gatewayConfig(false))
+			Expect(m.Finalize(ctx, disk)).To(Equal(Done))
+			Expect(disk.Finalizers).To(BeEmpty())
+			Expect(getJob(rbdRemoveTask)).To(BeNil())
+		})
+
+		ginkgo.It("deletes the image with the Delete policy", func() {
+			disk.Spec.ReclaimPolicy = iscsigateway.DiskDeletionDelete
+			manage(testGateway(), gatewayConfig(false))
+			Expect(m.Finalize(ctx, disk)).To(Equal(RequeueAfter(diskPollInterval)))
+			Expect(getJob(rbdRemoveTask)).NotTo(BeNil())
+			Expect(disk.Finalizers).To(ConsistOf(diskFinalizer))
+
+			finishJob(rbdRemoveTask, batchv1.JobFailed, "rbd: image is busy")
+			Expect(m.Finalize(ctx, disk)).To(Equal(RequeueAfter(diskRetryInterval)))
+			Expect(recorder.Events).To(Receive(ContainSubstring(ReasonImageSyncFailed)))
+			Expect(disk.Finalizers).To(ConsistOf(diskFinalizer))
+
+			Expect(m.Finalize(ctx, disk)).To(Equal(RequeueAfter(diskPollInterval)))
+			Expect(m.client.Delete(ctx, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:      labelValue(disk.Name, rbdRemoveTask) + "-abcde",
+				Namespace: disk.Namespace,
+			}})).To(Succeed())
+			finishJob(rbdRemoveTask, batchv1.JobComplete, "")
+			Expect(m.Finalize(ctx, disk)).To(Equal(Done))
+			Expect(recorder.Events).To(Receive(ContainSubstring(ReasonDeletedImage)))
+			Expect(getJob(rbdRemoveTask)).To(BeNil())
+			Expect(disk.Finalizers).To(BeEmpty())
+		})
+
+		ginkgo.It("leaves the image behind without a ceph config", func() {
+			disk.Spec.ReclaimPolicy = iscsigateway.DiskDeletionDelete
+			manage()
+			Expect(m.Finalize(ctx, disk)).To(Equal(Done))
+			Expect(recorder.Events).To(Receive(ContainSubstring(ReasonImageRetained)))
+			Expect(disk.Finalizers).To(BeEmpty())
+		})
+	})
+})
@@ -3,14 +3,18 @@ package resource
 import (
 	"context"
 	"fmt"
+	"time"
 
 	iscsigateway "github.com/Erichorng/iscsi-operator/api/v1alpha1"
 	"github.com/Erichorng/iscsi-operator/internal/conf"
+	"github.com/Erichorng/iscsi-operator/internal/metrics"
 	pln "github.com/Erichorng/iscsi-operator/internal/planner"
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -24,12 +28,21 @@ import (
 const gatewayfinalizer = "gatewayFinalizer"
 const tcmuDaemonSet = "tcmu-runner"
 
+// scaleDownStartedAnnotation records on the stateful set when the departing
+// gateways were removed from the target portal group.
+const scaleDownStartedAnnotation = "iscsi.ruohwai/scale-down-started"
+
+// gatewayRolloutPollInterval is how often the stateful set is checked while
+// waiting for an update to be observed.
+const gatewayRolloutPollInterval = 10 * time.Second
+
 type IscsiGatewayManager struct {
-	client   rtclient.Client
-	scheme   *runtime.Scheme
-	recorder record.EventRecorder
-	logger   Logger
-	cfg      *conf.OperatorConfig
+	client     rtclient.Client
+	scheme     *runtime.Scheme
+	recorder   record.EventRecorder
+	logger     Logger
+	cfg        *conf.OperatorConfig
+	gatewayApi GatewayApiFunc
 }
 
 func NewIscsiGatewayManager(
@@ -38,13 +51,15 @@ func NewIscsiGatewayManager(
 	logger logr.Logger,
 	recorder record.EventRecorder,
 ) *IscsiGatewayManager {
-	return &IscsiGatewayManager{
+	m := &IscsiGatewayManager{
 		client:   client,
 		scheme:   scheme,
 		recorder: recorder,
 		logger:   logger,
 		cfg:      conf.Get(),
 	}
+	m.gatewayApi = m.newGatewayApiClient
+	return m
 }
 
 func (m *IscsiGatewayManager) Process(
@@ -54,6 +69,7 @@ func (m *IscsiGatewayManager) Process(
 	err := m.client.Get(ctx, nsname, instance)
 	if err != nil {
 		if errors.IsNotFound(err) {
+			metrics.ForgetGateway(nsname.Namespace, nsname.Name)
 			return Done
 		}
 		m.logger.Error(
@@ -66,14 +82,45 @@ func (m *IscsiGatewayManager) Process(
 	}
 
 	if instance.GetDeletionTimestamp() != nil {
-		if controllerutil.ContainsFinalizer(instance, gatewayfinalizer) {
-			return m.Finalize(ctx, instance)
+		if !controllerutil.ContainsFinalizer(instance, gatewayfinalizer) {
+			return Done
 		}
+		result := runPhase(instance, phaseFinalize, func() Result {
+			return m.Finalize(ctx, instance)
+		})
+		metrics.CountReconcile(instance.Namespace, instance.Name,
+			resultLabel(result))
+		return result
 	}
-	return m.Update(ctx, instance)
+	result := m.Update(ctx, instance)
+	metrics.CountReconcile(instance.Namespace, instance.Name,
+		resultLabel(result))
+	return result
 }
 
+// Update reconciles the resources of the iscsigateway and reports the
+// observed state in the status of the instance.
 func (m *IscsiGatewayManager) Update(
+	ctx context.Context,
+	instance *iscsigateway.Iscsigateway) Result {
+	result := m.update(ctx, instance)
+	var err error
+	runPhase(instance, phaseStatus, func() Result {
+		err = m.updateStatus(ctx, instance, result)
+		return Result{err: err}
+	})
+	switch {
+	case err == nil || result.Err() != nil:
+		return result
+	case errors.IsConflict(err):
+		// the instance changed while we were reconciling it
+		return Requeue
+	default:
+		return Result{err: err}
+	}
+}
+
+func (m *IscsiGatewayManager) update(
 	ctx context.Context,
 	instance *iscsigateway.Iscsigateway) Result {
 	m.logger.Info(
@@ -88,7 +135,7 @@ func (m *IscsiGatewayManager) Update(
 	if err != nil {
 		if errors.IsNotFound(err) {
 			m.logger.Error(err,
-				"Can't find cephConfigMap. Please create configMap contains ceph.conf, keyring and iscsigateway.cfg")
+				"Can't find cephConfigMap. Please create configMap contains ceph.conf and keyring")
 			return Result{err: err}
 		}
 		m.logger.Error(err, "Failed to get cephConfigMap")
@@ -109,72 +156,107 @@ func (m *IscsiGatewayManager) Update(
 	}
 
 	var planner *pln.Planner
-	if p, result := m.updateConfigMap(ctx, instance); !result.Yield() {
+	if result := runPhase(instance, phaseConfigMap, func() Result {
+		p, result := m.updateConfigMap(ctx, instance)
 		planner = p
-	} else {
+		return result
+	}); result.Yield() {
 		return result
 	}
-
-	// make sure tcmu-runner daemon set is running
-	success, err := m.updateTcmuRunner(ctx, planner)
-	if success {
-		m.logger.Info("tcmu-runner daemonSet exist.",
-			"Namespace:", instance.Namespace,
-			"Name:", tcmuDaemonSet,
-		)
-	}
-	if err != nil {
-		return Result{err: err}
+	if planner == nil {
+		return Done
 	}
 
-	if planner.Scale() == 1 {
-		// TODO
-		m.logger.Info("Please set the scale to a number greater then 1. Do not allow single node service")
+	if planner.Paused() {
+		m.logger.Info("IscsiGateway is paused, leaving its resources unchanged")
 		return Done
+	}
 
-	} else {
-		if result := m.updateClusterState(ctx, planner); result.Yield() {
+	phases := []struct {
+		name string
+		run  func(context.Context, *pln.Planner) Result
+	}{
+		{phaseAuthSecret, m.updateAuthSecret},
+		{phaseCephConfig, m.updateCephConfig},
+		{phaseGatewayConfig, m.updateGatewayConfig},
+		// make sure tcmu-runner daemon set is running
+		{phaseTcmuRunner, m.updateTcmuRunner},
+		{phaseClusterState, m.updateClusterState},
+		{phasePurge, m.purgeImages},
+		{phaseDrift, m.checkDrift},
+	}
+	for _, phase := range phases {
+		if result := runPhase(instance, phase.name, func() Result {
+			return phase.run(ctx, planner)
+		}); result.Yield() {
 			return result
 		}
 	}
 
-	// Update iscsi service
-
 	m.logger.Info("Done updating iscsi gateway resources")
-	return Done
-
-}
-
-func (m *IscsiGatewayManager) Finalize(
-	ctx context.Context,
-	instance *iscsigateway.Iscsigateway) Result {
-
-	// some handle
-	// TODO
-
-	m.logger.Info("Remove finalizer")
-	controllerutil.RemoveFinalizer(instance, gatewayfinalizer)
-	err := m.client.Update(ctx, instance)
-	if err != nil {
-		return Result{err: err}
+	// come back to check the gateways for drift, or to remove the hosts
+	// once their grace period is over
+	resync := planner.DriftResyncInterval()
+	if d, found := planner.NextHostRemoval(); found {
+		// check again for the sessions of the hosts to end, a removal
+		// already due is retried right away
+		if d > gatewayApiPollInterval {
+			d = gatewayApiPollInterval
+		}
+		if resync <= 0 || d < resync {
+			return RequeueAfter(d)
+		}
+	}
+	if resync > 0 {
+		return RequeueAfter(resync)
 	}
 	return Done
+
 }
 
 func (m *IscsiGatewayManager) checkCephConfig(
 	ctx context.Context,
 	ig *iscsigateway.Iscsigateway) error {
 
+	if ig.Spec.CephConfig == "" {
+		// generated by updateCephConfig
+		return nil
+	}
 	found := &corev1.ConfigMap{}
 	cmNsname := types.NamespacedName{
 		Namespace: ig.Namespace,
 		Name:      ig.Spec.CephConfig,
 	}
 	err := m.client.Get(ctx, cmNsname, found)
-	if err == nil {
-		return nil
+	if err != nil {
+		return err
 	}
-	return err
+	if err := checkCephConfigMap(ig, found); err != nil {
+		m.recorder.Eventf(ig,
+			EventWarning,
+			ReasonInvalidConfiguration,
+			"Unable to use ceph ConfigMap %s: %s", found.Name, err)
+		return err
+	}
+	return nil
+}
+
+// checkCephConfigMap makes sure the ConfigMap holds the ceph.conf and the
+// keyring of the gateways.
+func checkCephConfigMap(
+	ig *iscsigateway.Iscsigateway, cm *corev1.ConfigMap) error {
+
+	if _, found := cm.Data[pln.CephConfFile]; !found {
+		return fmt.Errorf("missing the %q key", pln.CephConfFile)
+	}
+	planner := pln.New(pln.InstanceConfiguration{
+		Iscsigateway: ig,
+		CephConfig:   cm,
+	}, nil)
+	if planner.CephKeyring() == "" {
+		return fmt.Errorf("missing a keyring")
+	}
+	return nil
 }
 
 func (m *IscsiGatewayManager) addFinalizer(
@@ -182,24 +264,63 @@ func (m *IscsiGatewayManager) addFinalizer(
 	ig *iscsigateway.Iscsigateway) (bool, error) {
 
 	if controllerutil.ContainsFinalizer(ig, gatewayfinalizer) {
-		return true, m.client.Update(ctx, ig)
+		return false, nil
 	}
-	return false, nil
+	controllerutil.AddFinalizer(ig, gatewayfinalizer)
+	return true, m.client.Update(ctx, ig)
 }
 
+// updateTcmuRunner makes sure the tcmu-runner daemon set shared by the
+// gateways of the namespace exists, is referenced by this gateway and runs
+// the configured image on the nodes the gateways are scheduled on.
 func (m *IscsiGatewayManager) updateTcmuRunner(
-	ctx context.Context, pl *pln.Planner) (bool, error) {
-
-	// check if tcmu-runner exist
-	err := m.getOrCreateTcmuRunner(ctx, tcmuDaemonSet, pl)
+	ctx context.Context, pl *pln.Planner) Result {
 
+	gateways, err := m.listGateways(ctx, pl.Iscsigateway.Namespace)
 	if err != nil {
-		m.logger.Info("Successfully find tcmu-runner daemonset")
-		return false, err
+		return Result{err: err}
+	}
+	ds, created, err := m.getOrCreateTcmuRunner(ctx, tcmuDaemonSet, pl, gateways)
+	if err != nil {
+		return Result{err: err}
+	}
+	if created {
+		m.logger.Info("Created tcmu-runner DaemonSet")
+		return Requeue
 	}
-	return true, nil
 
-	// create tcmu-runner if not exist
+	changed := sharedOwnerReferences(ds)
+	refs := append([]metav1.OwnerReference{}, ds.GetOwnerReferences()...)
+	err = controllerutil.SetOwnerReference(pl.Iscsigateway, ds, m.scheme)
+	if err != nil {
+		return Result{err: err}
+	}
+	if !equality.Semantic.DeepEqual(refs, ds.GetOwnerReferences()) {
+		changed = true
+	}
+	source, err := m.tcmuRunnerSource(ctx, pl, ds, gateways)
+	if err != nil {
+		return Result{err: err}
+	}
+	desired := buildDaemonset(ctx, tcmuDaemonSet, source, gateways)
+	if syncDaemonSet(ds, desired) {
+		changed = true
+	}
+	if !changed {
+		return Done
+	}
+	err = m.client.Update(ctx, ds)
+	if err != nil {
+		m.logger.Error(
+			err,
+			"Failed to update DaemonSet",
+			"DaemonSet.Namespace", ds.Namespace,
+			"DaemonSet.Name", ds.Name,
+		)
+		return Result{err: err}
+	}
+	m.logger.Info("Updated tcmu-runner DaemonSet")
+	return Requeue
 }
 
 func (m *IscsiGatewayManager) updateConfigMap(
@@ -223,9 +344,19 @@ func (m *IscsiGatewayManager) updateConfigMap(
 	}
 
 	planner, changed, err := m.updateConfiguration(ctx, configMap, ig)
+	if isInvalidConfig(err) {
+		// reported in the ConfigReady condition, the ConfigMap is watched
+		// for it to be fixed
+		return nil, Done
+	}
 	if err != nil {
 		return nil, Result{err: err}
 	}
+	if !planner.Paused() {
+		if err := m.clearForceRemoveHosts(ctx, ig); err != nil {
+			return nil, Result{err: err}
+		}
+	}
 	if changed {
 		m.logger.Info("Updated configMap")
 		return nil, Requeue
@@ -233,6 +364,69 @@ func (m *IscsiGatewayManager) updateConfigMap(
 	return planner, Done
 }
 
+// clearForceRemoveHosts drops the ForceRemoveHostsAnnotation once the
+// hosts it lists have been removed, so that it does not apply to the
+// hosts removed later on.
+func (m *IscsiGatewayManager) clearForceRemoveHosts(
+	ctx context.Context,
+	ig *iscsigateway.Iscsigateway) error {
+
+	if _, found := ig.Annotations[iscsigateway.ForceRemoveHostsAnnotation]; !found {
+		return nil
+	}
+	// the status of the instance is written at the end of the reconcile,
+	// keep it from being replaced by the patched object
+	status := ig.Status.DeepCopy()
+	orig := ig.DeepCopy()
+	delete(ig.Annotations, iscsigateway.ForceRemoveHostsAnnotation)
+	err := m.client.Patch(ctx, ig, rtclient.MergeFrom(orig))
+	ig.Status = *status
+	if err != nil {
+		m.logger.Error(
+			err,
+			"Failed to clear the force-remove-hosts annotation",
+			"IscsiGateway.Namespace", ig.Namespace,
+			"IscsiGateway.Name", ig.Name,
+		)
+		return err
+	}
+	m.logger.Info("Cleared the force-remove-hosts annotation")
+	return nil
+}
+
+func (m *IscsiGatewayManager) updateAuthSecret(
+	ctx context.Context,
+	planner *pln.Planner) Result {
+
+	secret, created, err := m.getOrCreateAuthSecret(ctx, planner)
+	if err != nil {
+		return Result{err: err}
+	}
+	if created {
+		m.logger.Info("Created auth Secret")
+		return Requeue
+	}
+	changed, err := setCredentials(secret, planner.Credentials())
+	if err != nil {
+		return Result{err: err}
+	}
+	if !changed {
+		return Done
+	}
+	err = m.client.Update(ctx, secret)
+	if err != nil {
+		m.logger.Error(
+			err,
+			"failed to update auth Secret",
+			"Secret.Namespace", secret.Namespace,
+			"Secret.Name", secret.Name,
+		)
+		return Result{err: err}
+	}
+	m.logger.Info("Updated auth Secret")
+	return Requeue
+}
+
 // obj is an object that can be any api resource
 func (m *IscsiGatewayManager) claimOwnership(
 	ctx context.Context,
@@ -273,6 +467,16 @@ func (m *IscsiGatewayManager) updateConfiguration(
 	cc, err := getContainerConfig(configMap)
 	if err != nil {
 		m.logger.Error(err, "Unable to reade iscsi container config")
+		// reported once, the ConfigReady condition keeps the message
+		msg := fmt.Sprintf("%s, fix or delete the ConfigMap", err)
+		cond := meta.FindStatusCondition(ig.Status.Conditions,
+			iscsigateway.ConditionConfigReady)
+		if cond == nil || cond.Reason != reasonConfigInvalid || cond.Message != msg {
+			m.recorder.Eventf(ig,
+				EventWarning,
+				ReasonInvalidConfigMap,
+				"%s", msg)
+		}
 		return nil, false, err
 	}
 	isDeleting := ig.GetDeletionTimestamp() != nil
@@ -290,15 +494,43 @@ func (m *IscsiGatewayManager) updateConfiguration(
 	// extract config from map
 	var changed bool
 	planner := pln.New(gatewayInstance, cc)
+	planner.SetSessions(m.hostSessions(ctx, planner))
+	pending := planner.PendingHostRemovals()
 	changed, err = planner.Update()
 	if err != nil {
 		m.logger.Error(err, "unable to update iscsi container config")
+		m.recorder.Eventf(ig,
+			EventWarning,
+			ReasonInvalidConfiguration,
+			"Invalid configuration: %s", err)
 		return nil, false, err
 	}
+	if planner.Paused() {
+		m.publishPlan(ig, planner.Changes(), false)
+		return planner, false, nil
+	}
+	m.reportHostRemovals(ig, pending, planner.PendingHostRemovals())
+	if plan := ig.Status.Plan; plan != nil && !plan.Applied {
+		// the gateway was resumed, nothing is pending anymore
+		ig.Status.Plan = nil
+	}
+	if !changed {
+		changed, err = containerConfigStale(configMap, planner.ConfigState)
+		if err != nil {
+			return nil, false, err
+		}
+	}
 	if !changed {
 		return planner, false, nil
 	}
 	err = setContainerConfig(configMap, planner.ConfigState) // we have already update the configstate in the last step
+	if err == nil {
+		if configMap.Annotations == nil {
+			configMap.Annotations = map[string]string{}
+		}
+		configMap.Annotations[configUpdatedAnnotation] =
+			time.Now().UTC().Format(time.RFC3339)
+	}
 	if err != nil {
 		m.logger.Error(
 			err,
@@ -318,9 +550,41 @@ func (m *IscsiGatewayManager) updateConfiguration(
 		)
 		return nil, false, err
 	}
+	if changes := planner.Changes(); len(changes) > 0 {
+		m.publishPlan(ig, changes, true)
+		for _, c := range changes {
+			metrics.CountChange(ig.Namespace, ig.Name, c.Kind)
+		}
+	}
 	return planner, true, nil
 }
 
+// reportHostRemovals emits an event for every host whose removal was just
+// deferred.
+func (m *IscsiGatewayManager) reportHostRemovals(
+	ig *iscsigateway.Iscsigateway,
+	before, after []pln.HostRemoval) {
+
+	for _, r := range after {
+		known := false
+		for _, b := range before {
+			if b.HostName == r.HostName {
+				known = true
+				break
+			}
+		}
+		if known {
+			continue
+		}
+		m.recorder.Eventf(ig,
+			EventWarning,
+			ReasonHostRemovalPending,
+			"Host %s may still be logged in, it is removed at %s or once logged out, unless listed in the %s annotation",
+			r.HostName, r.RemoveAfter.Format(time.RFC3339),
+			iscsigateway.ForceRemoveHostsAnnotation)
+	}
+}
+
 func (m *IscsiGatewayManager) updateClusterState(
 	ctx context.Context,
 	planner *pln.Planner) Result {
@@ -335,6 +599,10 @@ func (m *IscsiGatewayManager) updateClusterState(
 		return Requeue
 	}
 
+	if result := m.updateHeadlessService(ctx, planner); result.Yield() {
+		return result
+	}
+
 	statefulset, created, err := m.getOrCreateStatefulSet(
 		ctx, planner, planner.Iscsigateway.Namespace)
 	if err != nil {
@@ -356,38 +624,285 @@ func (m *IscsiGatewayManager) updateClusterState(
 		m.logger.Info("Updated statefulSet ownership")
 		return Requeue
 	}
-	resized, err := m.updateStatefulSetSize(
-		ctx, statefulset,
-		int32(planner.Scale()))
-	if err != nil {
-		return Result{err: err}
-	} else if resized {
-		m.logger.Info("Resized statefulSet")
-		return Requeue
+	if result := m.updateStatefulSet(ctx, statefulset, planner); result.Yield() {
+		return result
+	}
+	if result := m.updateStatefulSetSize(ctx, statefulset, planner); result.Yield() {
+		return result
+	}
+	if result := m.updatePortalServices(ctx, statefulset, planner); result.Yield() {
+		return result
 	}
 	return Done
 }
 
+// updateStatefulSetSize scales the stateful set to the size in the spec.
+// Scaling up is immediate. When scaling down, the departing gateways have
+// already been removed from the target portal group by the container
+// config; they are kept running for the drain timeout so the initiators
+// can move their sessions to the remaining gateways before they stop.
 func (m *IscsiGatewayManager) updateStatefulSetSize(
 	ctx context.Context,
 	ss *appsv1.StatefulSet,
-	size int32) (bool, error) {
+	planner *pln.Planner) Result {
 
-	if *ss.Spec.Replicas < size {
+	size := planner.Scale()
+	started, draining := ss.Annotations[scaleDownStartedAnnotation]
+	switch {
+	case *ss.Spec.Replicas < size:
 		ss.Spec.Replicas = &size
-		err := m.client.Update(ctx, ss)
-		if err != nil {
+		delete(ss.Annotations, scaleDownStartedAnnotation)
+	case *ss.Spec.Replicas > size && !draining:
+		if ss.Annotations == nil {
+			ss.Annotations = map[string]string{}
+		}
+		ss.Annotations[scaleDownStartedAnnotation] =
+			time.Now().UTC().Format(time.RFC3339)
+		if err := m.updateStatefulSetObj(ctx, ss); err != nil {
+			return Result{err: err}
+		}
+		m.recorder.Eventf(planner.Iscsigateway,
+			EventNormal,
+			ReasonScalingDown,
+			"Removed gateways %v from the target, waiting %s for sessions to move",
+			departingGateways(ss, planner), planner.DrainTimeout())
+		return RequeueAfter(planner.DrainTimeout())
+	case *ss.Spec.Replicas > size:
+		startTime, err := time.Parse(time.RFC3339, started)
+		if err == nil {
+			remaining := time.Until(startTime.Add(planner.DrainTimeout()))
+			if remaining > 0 {
+				return RequeueAfter(remaining)
+			}
+		}
+		m.recorder.Eventf(planner.Iscsigateway,
+			EventNormal,
+			ReasonScaledDown,
+			"Stopping gateways %v", departingGateways(ss, planner))
+		ss.Spec.Replicas = &size
+		delete(ss.Annotations, scaleDownStartedAnnotation)
+	case draining:
+		// the scale down was reverted before it completed
+		delete(ss.Annotations, scaleDownStartedAnnotation)
+	default:
+		return Done
+	}
+	if err := m.updateStatefulSetObj(ctx, ss); err != nil {
+		return Result{err: err}
+	}
+	m.logger.Info("Resized statefulSet", "Replicas", size)
+	return Requeue
+}
+
+func (m *IscsiGatewayManager) updateStatefulSetObj(
+	ctx context.Context,
+	ss *appsv1.StatefulSet) error {
+
+	err := m.client.Update(ctx, ss)
+	if err != nil {
+		m.logger.Error(
+			err,
+			"Failed to update StatefulSet",
+			"StatefulSet.Namespace", ss.Namespace,
+			"StatefulSet.Name", ss.Name)
+	}
+	return err
+}
+
+// departingGateways returns the gateways the stateful set runs beyond the
+// size in the spec.
+func departingGateways(ss *appsv1.StatefulSet, planner *pln.Planner) []string {
+	names := []string{}
+	for i := planner.Scale(); i < *ss.Spec.Replicas; i++ {
+		names = append(names, planner.GatewayName(i))
+	}
+	return names
+}
+
+// updateStatefulSet reconciles the stateful set against the one built from
+// the spec. Changes to the pod template are rolled out by the stateful set
+// controller one gateway at a time, each waiting for the previous one to
+// be ready.
+func (m *IscsiGatewayManager) updateStatefulSet(
+	ctx context.Context,
+	ss *appsv1.StatefulSet,
+	planner *pln.Planner) Result {
+
+	desired := buildStatefulSet(planner, ss.Namespace, sharedStatePVCName(planner))
+	if ss.Spec.ServiceName != desired.Spec.ServiceName {
+		return m.recreateStatefulSet(ctx, ss, planner)
+	}
+	if !statefulSetChanged(ss, desired) {
+		return Done
+	}
+	if statefulSetPending(ss) {
+		m.logger.Info("Waiting for the StatefulSet to observe the last update",
+			"StatefulSet.Name", ss.Name)
+		return RequeueAfter(gatewayRolloutPollInterval)
+	}
+
+	annotations := ss.Spec.Template.Annotations
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	for k, v := range desired.Spec.Template.Annotations {
+		annotations[k] = v
+	}
+	ss.Spec.Template.Labels = desired.Spec.Template.Labels
+	ss.Spec.Template.Annotations = annotations
+	ss.Spec.Template.Spec = desired.Spec.Template.Spec
+	ss.Spec.UpdateStrategy = desired.Spec.UpdateStrategy
+	ss.Spec.MinReadySeconds = desired.Spec.MinReadySeconds
+	err := m.client.Update(ctx, ss)
+	if err != nil {
+		m.logger.Error(
+			err,
+			"Failed to update StatefulSet",
+			"StatefulSet.Namespace", ss.Namespace,
+			"StatefulSet.Name", ss.Name)
+		return Result{err: err}
+	}
+	m.recorder.Eventf(planner.Iscsigateway,
+		EventNormal,
+		ReasonRollingGateways,
+		"Rolling out updated gateway pods of stateful set %s", ss.Name)
+	return Requeue
+}
+
+// recreateStatefulSet deletes the stateful set, leaving its pods running,
+// for it to be created again with fields that cannot be updated, such as
+// the service name stateful sets of older versions of the operator were
+// created without. The new stateful set adopts the pods and rolls them
+// out to its pod template one gateway at a time.
+func (m *IscsiGatewayManager) recreateStatefulSet(
+	ctx context.Context,
+	ss *appsv1.StatefulSet,
+	planner *pln.Planner) Result {
+
+	err := m.client.Delete(ctx, ss,
+		rtclient.Preconditions{UID: &ss.UID},
+		rtclient.PropagationPolicy(metav1.DeletePropagationOrphan))
+	if err != nil && !errors.IsNotFound(err) {
+		m.logger.Error(
+			err,
+			"Failed to delete StatefulSet",
+			"StatefulSet.Namespace", ss.Namespace,
+			"StatefulSet.Name", ss.Name)
+		return Result{err: err}
+	}
+	m.recorder.Eventf(planner.Iscsigateway,
+		EventNormal,
+		ReasonReplacingStatefulSet,
+		"Recreating stateful set %s with service name %s, its pods are adopted",
+		ss.Name, headlessServiceName(planner))
+	return Requeue
+}
+
+// statefulSetPending returns true until the stateful set controller has
+// observed the last change to the stateful set. A newer change may be
+// applied while a rollout is in progress, the stateful set controller then
+// moves on to the newest revision, still one gateway at a time, which also
+// lets a fix replace a rollout stuck on a broken pod template.
+func statefulSetPending(ss *appsv1.StatefulSet) bool {
+	return ss.Status.ObservedGeneration < ss.Generation
+}
+
+func (m *IscsiGatewayManager) updateHeadlessService(
+	ctx context.Context,
+	planner *pln.Planner) Result {
+
+	desired := buildHeadlessService(planner, planner.Iscsigateway.Namespace)
+	svc, created, err := m.getOrCreateService(ctx, planner.Iscsigateway, desired)
+	if err != nil {
+		return Result{err: err}
+	}
+	if created {
+		m.logger.Info("Created headless Service")
+		return Requeue
+	}
+	return m.updateService(ctx, svc, desired)
+}
+
+// updatePortalServices makes sure every gateway has a Service of its own
+// when the portals are exposed, and removes the Services of gateways that
+// are gone. The Service of a gateway being scaled down is kept until its
+// pod stops.
+func (m *IscsiGatewayManager) updatePortalServices(
+	ctx context.Context,
+	ss *appsv1.StatefulSet,
+	planner *pln.Planner) Result {
+
+	ig := planner.Iscsigateway
+	wanted := map[string]bool{}
+	if ig.Spec.Portals != nil {
+		count := planner.Scale()
+		if *ss.Spec.Replicas > count {
+			count = *ss.Spec.Replicas
+		}
+		for i := int32(0); i < count; i++ {
+			desired := buildPortalService(planner, ig.Namespace, i)
+			wanted[desired.Name] = true
+			svc, created, err := m.getOrCreateService(ctx, ig, desired)
+			if err != nil {
+				return Result{err: err}
+			}
+			if created {
+				m.logger.Info("Created portal Service", "Service.Name", svc.Name)
+				continue
+			}
+			if result := m.updateService(ctx, svc, desired); result.Yield() {
+				return result
+			}
+		}
+	}
+
+	list := &corev1.ServiceList{}
+	err := m.client.List(ctx, list,
+		rtclient.InNamespace(ig.Namespace),
+		rtclient.MatchingLabels(labelsForPortalService(planner.InstanceName())))
+	if err != nil {
+		m.logger.Error(err, "Failed to list portal Services")
+		return Result{err: err}
+	}
+	for i := range list.Items {
+		svc := &list.Items[i]
+		if wanted[svc.Name] || !metav1.IsControlledBy(svc, ig) {
+			continue
+		}
+		err = m.client.Delete(ctx, svc)
+		if err != nil && !errors.IsNotFound(err) {
 			m.logger.Error(
 				err,
-				"Failed to update StatefulSet",
-				"StatefulSet.Namespace", ss.Namespace,
-				"StatefulSet.Name", ss.Name)
-			return false, err
+				"Failed to delete Service",
+				"Service.Namespace", svc.Namespace,
+				"Service.Name", svc.Name,
+			)
+			return Result{err: err}
 		}
-		return true, nil
+		m.logger.Info("Deleted portal Service", "Service.Name", svc.Name)
 	}
-	return false, nil
+	return Done
+}
+
+func (m *IscsiGatewayManager) updateService(
+	ctx context.Context,
+	svc, desired *corev1.Service) Result {
 
+	if !syncService(svc, desired) {
+		return Done
+	}
+	err := m.client.Update(ctx, svc)
+	if err != nil {
+		m.logger.Error(
+			err,
+			"Failed to update Service",
+			"Service.Namespace", svc.Namespace,
+			"Service.Name", svc.Name,
+		)
+		return Result{err: err}
+	}
+	m.logger.Info("Updated Service", "Service.Name", svc.Name)
+	return Requeue
 }
 
 func sharedStatePVCName(planner *pln.Planner) string {
@@ -0,0 +1,32 @@
+package resource
+
+import (
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func labelsForIscsiJob(name, task string) map[string]string {
+	return map[string]string{
+		"app":                          "iscsi-" + task,
+		"app.kubernetes.io/name":       "iscsi-" + task,
+		"app.kubernetes.io/instance":   labelValue("iscsi", task, name),
+		"app.kubernetes.io/managed-by": "iscsi-operator",
+	}
+}
+
+// jobFinished returns whether the job finished and, if so, whether it
+// succeeded.
+func jobFinished(job *batchv1.Job) (finished, succeeded bool) {
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return true, true
+		case batchv1.JobFailed:
+			return true, false
+		}
+	}
+	return false, false
+}
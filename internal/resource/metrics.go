@@ -0,0 +1,67 @@
+package resource
+
+import (
+	"time"
+
+	iscsigateway "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/metrics"
+	pln "github.com/Erichorng/iscsi-operator/internal/planner"
+)
+
+// phases of the reconcile of an iscsigateway, as reported in metrics
+const (
+	phaseConfigMap     = "ConfigMap"
+	phaseAuthSecret    = "AuthSecret"
+	phaseCephConfig    = "CephConfig"
+	phaseGatewayConfig = "GatewayConfig"
+	phaseTcmuRunner    = "TcmuRunner"
+	phaseClusterState  = "ClusterState"
+	phasePurge         = "Purge"
+	phaseDrift         = "Drift"
+	phaseStatus        = "Status"
+	phaseFinalize      = "Finalize"
+)
+
+// runPhase runs a phase of the reconcile of the gateway and records its
+// duration and result.
+func runPhase(
+	ig *iscsigateway.Iscsigateway,
+	phase string,
+	run func() Result) Result {
+
+	start := time.Now()
+	result := run()
+	label := resultLabel(result)
+	metrics.ObservePhase(phase, label, time.Since(start))
+	if label == metrics.ResultRequeue {
+		metrics.CountRequeue(ig.Namespace, ig.Name, phase)
+	}
+	return result
+}
+
+func resultLabel(result Result) string {
+	switch {
+	case result.Err() != nil:
+		return metrics.ResultError
+	case result.Requeue():
+		return metrics.ResultRequeue
+	default:
+		return metrics.ResultSuccess
+	}
+}
+
+// recordInventory records what the container config of the gateway
+// exports and, while the gateway is paused, the changes held back.
+func recordInventory(
+	ig *iscsigateway.Iscsigateway,
+	inv pln.Inventory,
+	pending int) {
+
+	metrics.SetInventory(ig.Namespace, ig.Name, metrics.Inventory{
+		Targets:          inv.Targets,
+		Disks:            inv.Disks,
+		ProvisionedBytes: inv.ProvisionedBytes,
+		MappedHosts:      inv.MappedHosts,
+	})
+	metrics.SetPendingChanges(ig.Namespace, ig.Name, pending)
+}
@@ -0,0 +1,35 @@
+package resource
+
+import (
+	"errors"
+
+	"github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/Erichorng/iscsi-operator/internal/metrics"
+)
+
+var _ = ginkgo.Describe("Metrics", func() {
+	ginkgo.DescribeTable("labelling the result of a phase",
+		func(result Result, label string) {
+			Expect(resultLabel(result)).To(Equal(label))
+		},
+		ginkgo.Entry("done", Done, metrics.ResultSuccess),
+		ginkgo.Entry("requeued", Requeue, metrics.ResultRequeue),
+		ginkgo.Entry("requeued after a delay",
+			RequeueAfter(gatewayApiPollInterval), metrics.ResultRequeue),
+		ginkgo.Entry("failed", Result{err: errors.New("boom")}, metrics.ResultError),
+	)
+
+	ginkgo.It("returns the result of the phase", func() {
+		ig := testGateway()
+		ginkgo.DeferCleanup(metrics.ForgetGateway, ig.Namespace, ig.Name)
+		calls := 0
+		result := runPhase(ig, phaseDrift, func() Result {
+			calls++
+			return Requeue
+		})
+		Expect(result).To(Equal(Requeue))
+		Expect(calls).To(Equal(1))
+	})
+})
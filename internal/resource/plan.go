@@ -0,0 +1,83 @@
+package resource
+
+import (
+	"fmt"
+	"strings"
+
+	iscsigateway "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	pln "github.com/Erichorng/iscsi-operator/internal/planner"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// maxPlannedChanges bounds the changes listed in the plan of the status.
+const maxPlannedChanges = 100
+
+// maxChangesReported bounds the changes listed in plan events.
+const maxChangesReported = 10
+
+// publishPlan records the changes made to the container config, or planned
+// while the gateway is paused, in the status of the gateway. An event
+// lists them whenever the plan changes.
+func (m *IscsiGatewayManager) publishPlan(
+	ig *iscsigateway.Iscsigateway,
+	changes []pln.Change,
+	applied bool) {
+
+	plan := &iscsigateway.IscsiPlanState{
+		ObservedGeneration: ig.Generation,
+		Applied:            applied,
+	}
+	for i, c := range changes {
+		if i == maxPlannedChanges {
+			plan.Omitted = int32(len(changes) - i)
+			break
+		}
+		plan.Changes = append(plan.Changes, iscsigateway.IscsiPlannedChange{
+			Kind:        c.Kind,
+			Target:      c.Target,
+			Object:      c.Object,
+			Host:        c.Host,
+			From:        c.From,
+			To:          c.To,
+			Description: c.String(),
+		})
+	}
+	if equality.Semantic.DeepEqual(ig.Status.Plan, plan) {
+		return
+	}
+	ig.Status.Plan = plan
+
+	if applied {
+		m.logger.Info("Applying changes to the container config",
+			"Changes", len(changes))
+		m.recorder.Eventf(ig,
+			EventNormal,
+			ReasonApplyingPlan,
+			"Applying %d changes: %s", len(changes), planMessage(changes))
+		return
+	}
+	if len(changes) == 0 {
+		return
+	}
+	m.logger.Info("Paused with changes pending", "Changes", len(changes))
+	m.recorder.Eventf(ig,
+		EventNormal,
+		ReasonPlanPending,
+		"Paused with %d pending changes: %s", len(changes), planMessage(changes))
+}
+
+// planMessage lists the first changes of the plan.
+func planMessage(changes []pln.Change) string {
+	items := []string{}
+	for i, c := range changes {
+		if i == maxChangesReported {
+			break
+		}
+		items = append(items, c.String())
+	}
+	msg := strings.Join(items, "; ")
+	if len(changes) > len(items) {
+		msg += fmt.Sprintf("; and %d more", len(changes)-len(items))
+	}
+	return msg
+}
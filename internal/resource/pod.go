@@ -44,6 +44,9 @@ func buildClusteredPodSpec(
 	configVol := configVolumeAndMount(pl)
 	volumes.add(configVol)
 
+	authVol := authVolumeAndMount(pl)
+	volumes.add(authVol)
+
 	stateVol := iscsiStateVolumeAndMount(pl)
 	volumes.add(stateVol)
 
@@ -65,6 +68,50 @@ func buildClusteredPodSpec(
 	podSpec.Volumes = getVolumes(volumes.all())
 	podSpec.InitContainers = initContainers
 	podSpec.Containers = containers
+	podSpec.NodeSelector = pl.Iscsigateway.Spec.NodeSelector
+	podSpec.Tolerations = pl.Iscsigateway.Spec.Tolerations
+	return podSpec
+}
+
+// buildStandalonePodSpec returns the pod spec of a gateway running without
+// peers. The target is initialized on every start and the gateway is not
+// registered as a node of a cluster.
+func buildStandalonePodSpec(pl *pln.Planner) corev1.PodSpec {
+	var (
+		volumes        = newVolKeeper()
+		initContainers []corev1.Container
+		containers     []corev1.Container
+	)
+	cephVol := cephVolumeAndMount(pl)
+	volumes.add(cephVol)
+
+	configVol := configVolumeAndMount(pl)
+	volumes.add(configVol)
+
+	authVol := authVolumeAndMount(pl)
+	volumes.add(authVol)
+
+	stateVol := iscsiStateVolumeAndMount(pl)
+	volumes.add(stateVol)
+
+	devVol := devVolumeAndMount(pl)
+	volumes.add(devVol)
+
+	libVol := libVolumeAndMount(pl)
+	volumes.add(libVol)
+
+	podEnv := defaultPodEnv(pl)
+
+	initContainers = append(initContainers, buildInitCtr(pl, podEnv, volumes))
+
+	containers = append(containers, buildIscsiCtrs(pl, podEnv, volumes)...)
+
+	podSpec := corev1.PodSpec{}
+	podSpec.Volumes = getVolumes(volumes.all())
+	podSpec.InitContainers = initContainers
+	podSpec.Containers = containers
+	podSpec.NodeSelector = pl.Iscsigateway.Spec.NodeSelector
+	podSpec.Tolerations = pl.Iscsigateway.Spec.Tolerations
 	return podSpec
 }
 
@@ -78,6 +125,10 @@ func defaultPodEnv(planner *pln.Planner) []corev1.EnvVar {
 			Name:  "ISCSI_CONFIG",
 			Value: planner.ContainerConfig(),
 		},
+		{
+			Name:  "ISCSI_AUTH",
+			Value: planner.ContainerAuth(),
+		},
 	}
 
 	return env
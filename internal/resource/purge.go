@@ -0,0 +1,88 @@
+package resource
+
+import (
+	"context"
+	"strings"
+
+	pln "github.com/Erichorng/iscsi-operator/internal/planner"
+	"github.com/Erichorng/iscsi-operator/internal/rbdapi"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// purgeImages deletes, through the API of a ready gateway, the RBD images
+// of the disks removed from the spec with the Delete policy, and drops
+// them from the purge list of the container config. An image still
+// exported is retried once the gateways have applied the container config.
+func (m *IscsiGatewayManager) purgeImages(
+	ctx context.Context,
+	planner *pln.Planner) Result {
+
+	purge := planner.PendingPurge()
+	if len(purge) == 0 {
+		return Done
+	}
+	gateways, err := m.readyGatewayPods(ctx, planner)
+	if err != nil {
+		return Result{err: err}
+	}
+	if len(gateways) == 0 {
+		return RequeueAfter(gatewayApiPollInterval)
+	}
+	api, err := m.gatewayApi(ctx, planner, gateways[0])
+	if err != nil {
+		m.logger.Error(err, "Unable to reach gateway API", "Gateway", gateways[0])
+		return RequeueAfter(gatewayApiPollInterval)
+	}
+
+	ig := planner.Iscsigateway
+	purged := []string{}
+	for _, key := range purge {
+		pool, image, _ := strings.Cut(key, "/")
+		err := api.PurgeDisk(ctx, pool, image)
+		switch {
+		case err == nil:
+			m.recorder.Eventf(ig,
+				EventNormal,
+				ReasonDeletedImage,
+				"Deleted image %s", key)
+		case rbdapi.IsNotFound(err):
+			m.recorder.Eventf(ig,
+				EventWarning,
+				ReasonImageRetained,
+				"Image %s is not known to the gateways, leaving it behind", key)
+		default:
+			m.logger.Error(err, "Failed to delete image", "Image", key)
+			continue
+		}
+		purged = append(purged, key)
+	}
+	if len(purged) == 0 {
+		return RequeueAfter(gatewayApiPollInterval)
+	}
+
+	configMap := &corev1.ConfigMap{}
+	err = m.client.Get(ctx, types.NamespacedName{
+		Namespace: ig.Namespace,
+		Name:      planner.ContainerConfigName(),
+	}, configMap)
+	if err != nil {
+		m.logger.Error(err, "Failed to get ConfigMap")
+		return Result{err: err}
+	}
+	planner.PurgeDone(purged)
+	if err := setContainerConfig(configMap, planner.ConfigState); err != nil {
+		return Result{err: err}
+	}
+	if err := m.client.Update(ctx, configMap); err != nil {
+		m.logger.Error(
+			err,
+			"failed to update ConfigMap",
+			"ConfigMap.Namespace", configMap.Namespace,
+			"ConfigMap.Name", configMap.Name,
+		)
+		return Result{err: err}
+	}
+	m.logger.Info("Purged images", "Images", purged)
+	return Requeue
+}
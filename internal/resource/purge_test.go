@@ -0,0 +1,102 @@
+package resource
+
+import (
+	"context"
+
+	"github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	pln "github.com/Erichorng/iscsi-operator/internal/planner"
+	"github.com/Erichorng/iscsi-operator/internal/rbdapi"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	rtclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = ginkgo.Describe("Purging images", func() {
+	var (
+		ctx      context.Context
+		recorder *record.FakeRecorder
+		api      *rbdapi.FakeServer
+		planner  *pln.Planner
+		m        *IscsiGatewayManager
+	)
+
+	ginkgo.BeforeEach(func() {
+		ctx = context.Background()
+		recorder = record.NewFakeRecorder(10)
+		api = rbdapi.NewFakeServer("admin", "secret")
+		planner = testPlanner(testGateway())
+		planner.ConfigState.Purge = []string{"rbd/d0"}
+		Expect(api.Client().CreateDisk(ctx, "rbd", "d0", "1G", true)).To(Succeed())
+	})
+
+	// setup starts the manager with the container config of the planner
+	// and the objects.
+	setup := func(objs ...rtclient.Object) {
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "gw", Namespace: "storage"},
+			Data:       map[string]string{},
+		}
+		Expect(setContainerConfig(configMap, planner.ConfigState)).To(Succeed())
+		m = testManager(recorder, append(objs, configMap)...)
+		useGatewayApi(m, api)
+	}
+
+	storedPurge := func() []string {
+		configMap := &corev1.ConfigMap{}
+		Expect(m.client.Get(ctx, types.NamespacedName{
+			Namespace: "storage",
+			Name:      "gw",
+		}, configMap)).To(Succeed())
+		cc, err := getContainerConfig(configMap)
+		Expect(err).NotTo(HaveOccurred())
+		return cc.Purge
+	}
+
+	ginkgo.It("has nothing to do without images to delete", func() {
+		planner.ConfigState.Purge = nil
+		setup(readyPod(planner, "gw-0"))
+		Expect(m.purgeImages(ctx, planner)).To(Equal(Done))
+		// only the request creating the image was made
+		Expect(api.Requests()).To(Equal([]string{"PUT /api/disk/rbd/d0"}))
+	})
+
+	ginkgo.It("waits for a ready gateway", func() {
+		setup()
+		Expect(m.purgeImages(ctx, planner)).To(Equal(
+			RequeueAfter(gatewayApiPollInterval)))
+		Expect(storedPurge()).To(ConsistOf("rbd/d0"))
+	})
+
+	ginkgo.It("deletes the image and drops it from the container config", func() {
+		setup(readyPod(planner, "gw-0"))
+		Expect(m.purgeImages(ctx, planner)).To(Equal(Requeue))
+		Expect(api.Config().Disks).NotTo(HaveKey("rbd/d0"))
+		Expect(storedPurge()).To(BeEmpty())
+		Expect(planner.PendingPurge()).To(BeEmpty())
+		Expect(recorder.Events).To(Receive(ContainSubstring(ReasonDeletedImage)))
+	})
+
+	ginkgo.It("drops an image the gateways do not know", func() {
+		planner.ConfigState.Purge = []string{"rbd/gone"}
+		setup(readyPod(planner, "gw-0"))
+		Expect(m.purgeImages(ctx, planner)).To(Equal(Requeue))
+		Expect(storedPurge()).To(BeEmpty())
+		Expect(recorder.Events).To(Receive(ContainSubstring(ReasonImageRetained)))
+	})
+
+	ginkgo.It("retries an image still exported", func() {
+		client := api.Client()
+		Expect(client.CreateTarget(ctx, "iqn.target")).To(Succeed())
+		Expect(client.AddTargetLun(ctx, "iqn.target", "rbd/d0", 0)).To(Succeed())
+		setup(readyPod(planner, "gw-0"))
+		Expect(m.purgeImages(ctx, planner)).To(Equal(
+			RequeueAfter(gatewayApiPollInterval)))
+		Expect(api.Config().Disks).To(HaveKey("rbd/d0"))
+		Expect(storedPurge()).To(ConsistOf("rbd/d0"))
+		Expect(recorder.Events).NotTo(Receive())
+	})
+})
@@ -0,0 +1,130 @@
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	pln "github.com/Erichorng/iscsi-operator/internal/planner"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	rbdSyncTask   = "rbd-sync"
+	rbdRemoveTask = "rbd-remove"
+
+	// rbdGenerationAnnotation records the generation of the disk a job was
+	// created for.
+	rbdGenerationAnnotation = "iscsi.ruohwai/disk-generation"
+)
+
+// rbdImageInfo is the part of the output of rbd info and rbd status the
+// operator reports.
+type rbdImageInfo struct {
+	Size     int64 `json:"size"`
+	Watchers []struct {
+		Address string `json:"address"`
+	} `json:"watchers"`
+}
+
+func rbdJobName(dp *pln.DiskPlanner, task string) string {
+	return labelValue(dp.Iscsidisk.Name, task)
+}
+
+func buildRbdJob(
+	dp *pln.DiskPlanner,
+	task string,
+	command []string,
+	env []corev1.EnvVar) *batchv1.Job {
+
+	disk := dp.Iscsidisk
+	labels := labelsForIscsiJob(disk.Name, task)
+	// failures are retried by the operator, which reports them first
+	backoffLimit := int32(0)
+
+	cephVolSrc := corev1.VolumeSource{}
+	if name := dp.CephSecretName(); name != "" {
+		cephVolSrc.Secret = &corev1.SecretVolumeSource{SecretName: name}
+	} else {
+		cephVolSrc.ConfigMap = &corev1.ConfigMapVolumeSource{}
+		cephVolSrc.ConfigMap.Name = dp.CephConfigName()
+	}
+	podSpec := corev1.PodSpec{
+		RestartPolicy: corev1.RestartPolicyNever,
+		Volumes: []corev1.Volume{{
+			Name:         cephVolName,
+			VolumeSource: cephVolSrc,
+		}},
+		Containers: []corev1.Container{{
+			Image:           dp.GlobalConfig.IscsiContainerImage,
+			ImagePullPolicy: corev1.PullPolicy(dp.GlobalConfig.ImagePullPolicy),
+			Name:            task,
+			Command:         command,
+			Env:             env,
+			VolumeMounts: []corev1.VolumeMount{{
+				Name:      cephVolName,
+				MountPath: dp.CephMountPath(),
+			}},
+			// rbd errors end up in the termination message
+			TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+		}},
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      rbdJobName(dp, task),
+			Namespace: disk.Namespace,
+			Labels:    labels,
+			Annotations: map[string]string{
+				rbdGenerationAnnotation: fmt.Sprint(disk.Generation),
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: podSpec,
+			},
+		},
+	}
+}
+
+// terminationMessage returns the termination message of the last pod of
+// the job to terminate.
+func terminationMessage(pods []corev1.Pod) string {
+	var (
+		msg    string
+		latest metav1.Time
+	)
+	for _, pod := range pods {
+		for _, cs := range pod.Status.ContainerStatuses {
+			t := cs.State.Terminated
+			if t == nil {
+				continue
+			}
+			if msg == "" || latest.Before(&t.FinishedAt) {
+				msg = t.Message
+				latest = t.FinishedAt
+			}
+		}
+	}
+	return msg
+}
+
+// parseRbdImageInfo parses the output of rbd info followed by rbd status,
+// both in json format.
+func parseRbdImageInfo(msg string) (*rbdImageInfo, error) {
+	info := &rbdImageInfo{}
+	dec := json.NewDecoder(bytes.NewBufferString(msg))
+	// rbd info and rbd status fill different fields
+	for i := 0; i < 2; i++ {
+		if err := dec.Decode(info); err != nil {
+			return nil, fmt.Errorf("unable to parse rbd output: %w", err)
+		}
+	}
+	return info, nil
+}
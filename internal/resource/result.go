@@ -1,8 +1,11 @@
 package resource
 
+import "time"
+
 type Result struct {
-	err     error
-	requeue bool
+	err          error
+	requeue      bool
+	requeueAfter time.Duration
 }
 
 func (r Result) Err() error {
@@ -10,14 +13,29 @@ func (r Result) Err() error {
 }
 
 func (r Result) Requeue() bool {
-	return r.requeue
+	return r.requeue || r.requeueAfter > 0
+}
+
+// RequeueAfter returns how long to wait before the next reconcile, zero
+// meaning right away.
+func (r Result) RequeueAfter() time.Duration {
+	return r.requeueAfter
 }
 
 func (r Result) Yield() bool {
-	return r.requeue || r.err != nil
+	return r.Requeue() || r.err != nil
 }
 
 var (
 	Done    = Result{}
 	Requeue = Result{requeue: true}
 )
+
+// RequeueAfter returns a result asking to reconcile again after d, right
+// away if d is not positive.
+func RequeueAfter(d time.Duration) Result {
+	if d <= 0 {
+		return Requeue
+	}
+	return Result{requeueAfter: d}
+}
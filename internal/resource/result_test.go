@@ -0,0 +1,27 @@
+package resource
+
+import (
+	"time"
+
+	"github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Result", func() {
+	ginkgo.DescribeTable("requeueing after a delay",
+		func(d time.Duration, requeueAfter time.Duration) {
+			result := RequeueAfter(d)
+			Expect(result.Requeue()).To(BeTrue())
+			Expect(result.Yield()).To(BeTrue())
+			Expect(result.RequeueAfter()).To(Equal(requeueAfter))
+		},
+		ginkgo.Entry("waits for a positive delay", time.Minute, time.Minute),
+		ginkgo.Entry("requeues right away without a delay", time.Duration(0), time.Duration(0)),
+		ginkgo.Entry("requeues right away for a delay already over", -time.Second, time.Duration(0)),
+	)
+
+	ginkgo.It("is done without an error or a requeue", func() {
+		Expect(Done.Requeue()).To(BeFalse())
+		Expect(Done.Yield()).To(BeFalse())
+	})
+})
@@ -0,0 +1,117 @@
+package resource
+
+import (
+	"context"
+	"time"
+
+	"github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	pln "github.com/Erichorng/iscsi-operator/internal/planner"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = ginkgo.Describe("Gateway scaling", func() {
+	var (
+		ctx      context.Context
+		recorder *record.FakeRecorder
+		m        *IscsiGatewayManager
+	)
+
+	// statefulSet returns the stateful set of the planner, as stored by
+	// the manager, running the replicas.
+	statefulSet := func(planner *pln.Planner, replicas int32) *appsv1.StatefulSet {
+		ss := buildStatefulSet(planner, "storage", sharedStatePVCName(planner))
+		ss.Spec.Replicas = &replicas
+		m = testManager(recorder, ss)
+		stored := &appsv1.StatefulSet{}
+		Expect(m.client.Get(ctx, types.NamespacedName{
+			Namespace: ss.Namespace,
+			Name:      ss.Name,
+		}, stored)).To(Succeed())
+		return stored
+	}
+
+	scaledTo := func(scale int) *pln.Planner {
+		ig := testGateway()
+		ig.Spec.Scale = scale
+		return testPlanner(ig)
+	}
+
+	ginkgo.BeforeEach(func() {
+		ctx = context.Background()
+		recorder = record.NewFakeRecorder(10)
+	})
+
+	ginkgo.It("leaves a stateful set of the right size alone", func() {
+		planner := scaledTo(2)
+		ss := statefulSet(planner, 2)
+		Expect(m.updateStatefulSetSize(ctx, ss, planner)).To(Equal(Done))
+	})
+
+	ginkgo.It("scales up right away", func() {
+		planner := scaledTo(3)
+		ss := statefulSet(planner, 2)
+		Expect(m.updateStatefulSetSize(ctx, ss, planner)).To(Equal(Requeue))
+		Expect(*ss.Spec.Replicas).To(Equal(int32(3)))
+		Expect(recorder.Events).NotTo(Receive())
+	})
+
+	ginkgo.It("drains the departing gateways before scaling down", func() {
+		planner := scaledTo(2)
+		ss := statefulSet(planner, 3)
+		Expect(m.updateStatefulSetSize(ctx, ss, planner)).To(
+			Equal(RequeueAfter(planner.DrainTimeout())))
+		Expect(*ss.Spec.Replicas).To(Equal(int32(3)))
+		Expect(ss.Annotations).To(HaveKey(scaleDownStartedAnnotation))
+		Expect(recorder.Events).To(Receive(And(
+			ContainSubstring(ReasonScalingDown),
+			ContainSubstring("[gw-2]"))))
+
+		// the departing gateway is out of the target portal group
+		Expect(planner.ConfigState.Gateways).To(Equal([]string{"gw-0", "gw-1"}))
+
+		// and keeps running until the drain timeout is over
+		result := m.updateStatefulSetSize(ctx, ss, planner)
+		Expect(result.RequeueAfter()).To(And(
+			BeNumerically(">", 0),
+			BeNumerically("<=", planner.DrainTimeout())))
+		Expect(*ss.Spec.Replicas).To(Equal(int32(3)))
+	})
+
+	ginkgo.It("stops the departing gateways once drained", func() {
+		planner := scaledTo(2)
+		ss := statefulSet(planner, 3)
+		ss.Annotations = map[string]string{
+			scaleDownStartedAnnotation: time.Now().UTC().
+				Add(-planner.DrainTimeout()).Format(time.RFC3339),
+		}
+		Expect(m.updateStatefulSetSize(ctx, ss, planner)).To(Equal(Requeue))
+		Expect(*ss.Spec.Replicas).To(Equal(int32(2)))
+		Expect(ss.Annotations).NotTo(HaveKey(scaleDownStartedAnnotation))
+		Expect(recorder.Events).To(Receive(ContainSubstring(ReasonScaledDown)))
+	})
+
+	ginkgo.It("forgets a scale down reverted before it completed", func() {
+		planner := scaledTo(3)
+		ss := statefulSet(planner, 3)
+		ss.Annotations = map[string]string{
+			scaleDownStartedAnnotation: time.Now().UTC().Format(time.RFC3339),
+		}
+		Expect(m.updateStatefulSetSize(ctx, ss, planner)).To(Equal(Requeue))
+		Expect(*ss.Spec.Replicas).To(Equal(int32(3)))
+		Expect(ss.Annotations).NotTo(HaveKey(scaleDownStartedAnnotation))
+	})
+
+	ginkgo.It("does not restart the remaining gateways", func() {
+		planner := scaledTo(3)
+		hash := configHash(planner.ConfigState, nil)
+		planner.Iscsigateway.Spec.Scale = 2
+		_, err := planner.Update()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(planner.ConfigState.Gateways).To(HaveLen(2))
+		Expect(configHash(planner.ConfigState, nil)).To(Equal(hash))
+	})
+})
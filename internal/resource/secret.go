@@ -0,0 +1,130 @@
+package resource
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	iscsigateway "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/conf"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+	pln "github.com/Erichorng/iscsi-operator/internal/planner"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// authSecretVolName is the name of the auth secret volume.
+	authSecretVolName = "iscsi-container-auth"
+
+	// AuthJSONKey is the name of the key the CHAP credentials are under.
+	AuthJSONKey = "auth.json"
+)
+
+func authSecretName(planner *pln.Planner) string {
+	return planner.Iscsigateway.Name + "-auth"
+}
+
+func newAuthSecret(
+	name, ns string,
+	cred *iscsicc.CredentialConfig) (*corev1.Secret, error) {
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{},
+	}
+	if _, err := setCredentials(secret, cred); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// setCredentials stores the credentials in the secret and returns true if
+// the secret data changed.
+func setCredentials(
+	secret *corev1.Secret, cred *iscsicc.CredentialConfig) (bool, error) {
+
+	jb, err := json.MarshalIndent(cred, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	if string(secret.Data[AuthJSONKey]) == string(jb) {
+		return false, nil
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[AuthJSONKey] = jb
+	return true, nil
+}
+
+// authHash returns a digest of the credentials of the gateway so that
+// gateway pods can be restarted when they change, without exposing the
+// credentials. The credentials of the hosts are left out, the
+// update-config --watch sidecar of every gateway applies them from the
+// mounted Secret while the gateway runs.
+func authHash(cred *iscsicc.CredentialConfig) string {
+	var hashed *iscsicc.CredentialConfig
+	if cred != nil {
+		hashed = &iscsicc.CredentialConfig{
+			Globals:   cred.Globals,
+			Discovery: cred.Discovery,
+		}
+	}
+	jb, err := json.Marshal(hashed)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(jb)
+	return hex.EncodeToString(sum[:])
+}
+
+// checkChapSecret makes sure the secret holds usable CHAP credentials.
+func checkChapSecret(secret *corev1.Secret) error {
+	for _, k := range []string{
+		corev1.BasicAuthUsernameKey,
+		corev1.BasicAuthPasswordKey,
+	} {
+		if len(secret.Data[k]) == 0 {
+			return fmt.Errorf(
+				"CHAP secret %s is missing the %q key", secret.Name, k)
+		}
+	}
+	return nil
+}
+
+// UsesSecret returns true if the iscsigateway takes credentials, or the
+// certificate of its API, from the named secret in its namespace.
+func UsesSecret(ig *iscsigateway.Iscsigateway, name string) bool {
+	planner := pln.New(
+		pln.InstanceConfiguration{
+			Iscsigateway: ig,
+			GlobalConfig: conf.Get(),
+		},
+		nil)
+	if usesApiSecret(ig, name) || UsesCephSecret(ig, name) {
+		return true
+	}
+	for _, n := range planner.ChapSecretNames() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// InitiatorUsesSecret returns true if the iscsiinitiator takes credentials
+// from the named secret in its namespace.
+func InitiatorUsesSecret(ini *iscsigateway.Iscsiinitiator, name string) bool {
+	chap := ini.Spec.Chap
+	if chap == nil {
+		return false
+	}
+	return chap.SecretRef.Name == name ||
+		(chap.MutualSecretRef != nil && chap.MutualSecretRef.Name == name)
+}
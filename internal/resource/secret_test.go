@@ -0,0 +1,83 @@
+package resource
+
+import (
+	"encoding/json"
+
+	"github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = ginkgo.Describe("Auth secret", func() {
+	var cred *iscsicc.CredentialConfig
+
+	ginkgo.BeforeEach(func() {
+		cred = iscsicc.NewCredentialConfig()
+		cred.Globals = iscsicc.Credentials{User: "admin", Password: "secret0"}
+		cred.Hosts["iqn.2023-01.com.example:h1"] = iscsicc.Credentials{
+			User: "h1", Password: "secret1"}
+	})
+
+	ginkgo.It("stores the credentials under the auth key", func() {
+		secret, err := newAuthSecret("gw-auth", "storage", cred)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secret.Name).To(Equal("gw-auth"))
+		Expect(secret.Namespace).To(Equal("storage"))
+
+		stored := iscsicc.NewCredentialConfig()
+		Expect(json.Unmarshal(secret.Data[AuthJSONKey], stored)).To(Succeed())
+		Expect(stored).To(Equal(cred))
+	})
+
+	ginkgo.It("only reports a change of the credentials", func() {
+		secret, err := newAuthSecret("gw-auth", "storage", cred)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(setCredentials(secret, cred)).To(BeFalse())
+
+		cred.Hosts["iqn.2023-01.com.example:h1"] = iscsicc.Credentials{
+			User: "h1", Password: "rotated"}
+		Expect(setCredentials(secret, cred)).To(BeTrue())
+		Expect(string(secret.Data[AuthJSONKey])).To(ContainSubstring("rotated"))
+	})
+
+	ginkgo.It("changes the hash along with the credentials", func() {
+		hash := authHash(cred)
+		Expect(hash).NotTo(BeEmpty())
+		Expect(hash).NotTo(ContainSubstring("secret"))
+		Expect(authHash(cred)).To(Equal(hash))
+
+		cred.Hosts["iqn.2023-01.com.example:h1"] = iscsicc.Credentials{
+			User: "h1", Password: "rotated"}
+		Expect(authHash(cred)).To(Equal(hash))
+
+		cred.Globals.Password = "rotated"
+		Expect(authHash(cred)).NotTo(Equal(hash))
+	})
+
+	ginkgo.DescribeTable("checking CHAP secrets",
+		func(data map[string]string, valid bool) {
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "chap"},
+				Data:       map[string][]byte{},
+			}
+			for k, v := range data {
+				secret.Data[k] = []byte(v)
+			}
+			err := checkChapSecret(secret)
+			if valid {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(ContainSubstring("CHAP secret chap")))
+			}
+		},
+		ginkgo.Entry("accepts a user name and a password",
+			map[string]string{"username": "h1", "password": "secret1"}, true),
+		ginkgo.Entry("rejects a secret without a password",
+			map[string]string{"username": "h1"}, false),
+		ginkgo.Entry("rejects an empty user name",
+			map[string]string{"username": "", "password": "secret1"}, false),
+	)
+})
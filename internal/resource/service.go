@@ -0,0 +1,172 @@
+package resource
+
+import (
+	"net"
+	"strconv"
+
+	pln "github.com/Erichorng/iscsi-operator/internal/planner"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	iscsiPortName = "iscsi"
+	apiPortName   = "api"
+
+	// portalLabel marks the Services exposing a single gateway.
+	portalLabel = "iscsi.ruohwai/portal"
+)
+
+// headlessServiceName returns the name of the Service giving the gateway
+// pods stable DNS names. It is the service name of the stateful set.
+func headlessServiceName(pl *pln.Planner) string {
+	return pl.InstanceName()
+}
+
+func buildHeadlessService(pl *pln.Planner, ns string) *corev1.Service {
+	labels := labelsForIscsiServer(pl.InstanceName())
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      headlessServiceName(pl),
+			Namespace: ns,
+			Labels:    labels,
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Selector:  labels,
+			// the gateways look each other up before they are ready
+			PublishNotReadyAddresses: true,
+			Ports: []corev1.ServicePort{
+				servicePort(iscsiPortName, pl.GlobalConfig.IscsiPort),
+				servicePort(apiPortName, pl.GetApiPort()),
+			},
+		},
+	}
+}
+
+func labelsForPortalService(name string) map[string]string {
+	labels := labelsForIscsiServer(name)
+	labels[portalLabel] = "true"
+	return labels
+}
+
+func buildPortalService(
+	pl *pln.Planner, ns string, i int32) *corev1.Service {
+
+	portals := pl.Iscsigateway.Spec.Portals
+	name := pl.GatewayName(i)
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   ns,
+			Labels:      labelsForPortalService(pl.InstanceName()),
+			Annotations: portals.Annotations,
+		},
+		Spec: corev1.ServiceSpec{
+			Type: portals.Type,
+			Selector: map[string]string{
+				appsv1.StatefulSetPodNameLabel: name,
+			},
+			Ports: []corev1.ServicePort{
+				servicePort(iscsiPortName, pl.GlobalConfig.IscsiPort),
+			},
+		},
+	}
+}
+
+func servicePort(name string, port int) corev1.ServicePort {
+	return corev1.ServicePort{
+		Name:       name,
+		Protocol:   corev1.ProtocolTCP,
+		Port:       int32(port),
+		TargetPort: intstr.FromInt(port),
+	}
+}
+
+// syncService updates the fields of the Service the operator manages to
+// match the desired Service and returns true if any changed. Node ports
+// allocated by the API server are kept.
+func syncService(svc, desired *corev1.Service) bool {
+	changed := false
+	for k, v := range desired.Annotations {
+		if svc.Annotations[k] != v {
+			if svc.Annotations == nil {
+				svc.Annotations = map[string]string{}
+			}
+			svc.Annotations[k] = v
+			changed = true
+		}
+	}
+	if desired.Spec.Type != "" && svc.Spec.Type != desired.Spec.Type {
+		svc.Spec.Type = desired.Spec.Type
+		changed = true
+	}
+	if !samePorts(svc.Spec.Ports, desired.Spec.Ports) {
+		svc.Spec.Ports = desired.Spec.Ports
+		changed = true
+	}
+	for k, v := range desired.Spec.Selector {
+		if svc.Spec.Selector[k] != v {
+			svc.Spec.Selector = desired.Spec.Selector
+			changed = true
+			break
+		}
+	}
+	return changed
+}
+
+func samePorts(x, y []corev1.ServicePort) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i].Name != y[i].Name ||
+			x[i].Port != y[i].Port ||
+			x[i].TargetPort != y[i].TargetPort {
+			return false
+		}
+	}
+	return true
+}
+
+// portalAddress returns the address initiators reach the gateway pod at,
+// through its Service if there is one. An empty string is returned until
+// the address is known.
+func portalAddress(
+	pl *pln.Planner, pod *corev1.Pod, svc *corev1.Service) string {
+
+	if svc == nil {
+		if pod.Status.PodIP == "" {
+			return ""
+		}
+		return net.JoinHostPort(
+			pod.Status.PodIP, strconv.Itoa(pl.GlobalConfig.IscsiPort))
+	}
+	var port corev1.ServicePort
+	for _, p := range svc.Spec.Ports {
+		if p.Name == iscsiPortName {
+			port = p
+		}
+	}
+	switch svc.Spec.Type {
+	case corev1.ServiceTypeNodePort:
+		if pod.Status.HostIP == "" || port.NodePort == 0 {
+			return ""
+		}
+		return net.JoinHostPort(
+			pod.Status.HostIP, strconv.Itoa(int(port.NodePort)))
+	case corev1.ServiceTypeLoadBalancer:
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			host := ingress.IP
+			if host == "" {
+				host = ingress.Hostname
+			}
+			if host != "" {
+				return net.JoinHostPort(host, strconv.Itoa(int(port.Port)))
+			}
+		}
+	}
+	return ""
+}
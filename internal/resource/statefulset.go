@@ -3,20 +3,39 @@ package resource
 import (
 	"strings"
 
-	"github.com/Erichorng/iscsi-operator/internal/conf"
 	pln "github.com/Erichorng/iscsi-operator/internal/planner"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// authHashAnnotation records the digest of the CHAP credentials the gateway
+// pods were started with.
+const authHashAnnotation = "iscsi.ruohwai/auth-hash"
+
+// configHashAnnotation records the digest of the part of the container
+// config the gateways read at start and of the ceph configuration the
+// gateway pods were started with.
+const configHashAnnotation = "iscsi.ruohwai/config-hash"
+
+// gatewayMinReadySeconds is how long a restarted gateway must stay ready
+// before the next one is restarted, giving the initiators time to log back
+// in to it.
+const gatewayMinReadySeconds = int32(30)
+
 func buildStatefulSet(
 	pl *pln.Planner,
 	ns,
 	statePVCName string) *appsv1.StatefulSet {
 	labels := labelsForIscsiServer(pl.InstanceName())
 	size := pl.Scale()
-	podSpec := buildClusteredPodSpec(pl, statePVCName)
+	var podSpec corev1.PodSpec
+	if pl.IsClustered() {
+		podSpec = buildClusteredPodSpec(pl, statePVCName)
+	} else {
+		podSpec = buildStandalonePodSpec(pl)
+	}
 
 	statefulSet := &appsv1.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
@@ -25,14 +44,22 @@ func buildStatefulSet(
 			Labels:    labels,
 		},
 		Spec: appsv1.StatefulSetSpec{
-			Replicas: &size,
+			Replicas:    &size,
+			ServiceName: headlessServiceName(pl),
+			// restart one gateway at a time, waiting for it to be ready
+			// again, so that the initiators keep a path to the target.
+			PodManagementPolicy: appsv1.OrderedReadyPodManagement,
+			UpdateStrategy: appsv1.StatefulSetUpdateStrategy{
+				Type: appsv1.RollingUpdateStatefulSetStrategyType,
+			},
+			MinReadySeconds: gatewayMinReadySeconds,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: labels,
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
 					Labels:      labels,
-					Annotations: annotationsForIscsiPod(pl.GlobalConfig),
+					Annotations: annotationsForIscsiPod(pl),
 				},
 				Spec: podSpec,
 			},
@@ -59,11 +86,47 @@ func labelValue(s ...string) string {
 	return out
 }
 
-func annotationsForIscsiPod(cfg *conf.OperatorConfig) map[string]string {
-	name := cfg.IscsiContainerName
+func annotationsForIscsiPod(pl *pln.Planner) map[string]string {
+	name := pl.GlobalConfig.IscsiContainerName
 	annotations := map[string]string{
 		"kubectl.kubernetes.io/default-logs-container": name,
 		"kubectl.kubernetes.io/default-container":      name,
+		authHashAnnotation:                             authHash(pl.Credentials()),
+		configHashAnnotation:                           configHash(pl.ConfigState, pl.CephConfig),
+		gatewayCfgHashAnnotation:                       gatewayCfgHash(pl),
+		cephHashAnnotation:                             cephSecretHash(pl.CephSecret),
 	}
 	return annotations
 }
+
+// statefulSetChanged returns true if the stateful set differs from the
+// desired one in any field managed by the operator. Fields left unset in
+// desired are defaulted by the API server and are not compared, with the
+// exception of the pod placement which may be cleared.
+func statefulSetChanged(ss, desired *appsv1.StatefulSet) bool {
+	current := &ss.Spec.Template
+	want := &desired.Spec.Template
+	for k, v := range want.Annotations {
+		if current.Annotations[k] != v {
+			return true
+		}
+	}
+	for k, v := range want.Labels {
+		if current.Labels[k] != v {
+			return true
+		}
+	}
+	if ss.Spec.MinReadySeconds != desired.Spec.MinReadySeconds ||
+		ss.Spec.UpdateStrategy.Type != desired.Spec.UpdateStrategy.Type {
+		return true
+	}
+	if !equality.Semantic.DeepEqual(
+		current.Spec.NodeSelector, want.Spec.NodeSelector) ||
+		!equality.Semantic.DeepEqual(
+			current.Spec.Tolerations, want.Spec.Tolerations) ||
+		!equality.Semantic.DeepEqual(
+			current.Spec.Affinity, want.Spec.Affinity) {
+		return true
+	}
+	return !equality.Semantic.DeepDerivative(want.Spec, current.Spec)
+}
@@ -0,0 +1,220 @@
+package resource
+
+import (
+	"context"
+
+	"github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	iscsigateway "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+	pln "github.com/Erichorng/iscsi-operator/internal/planner"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = ginkgo.Describe("Gateway rollout", func() {
+	var (
+		ctx      context.Context
+		planner  *pln.Planner
+		recorder *record.FakeRecorder
+		m        *IscsiGatewayManager
+		ss       *appsv1.StatefulSet
+	)
+
+	ginkgo.BeforeEach(func() {
+		ctx = context.Background()
+		planner = testPlanner(testGateway())
+		recorder = record.NewFakeRecorder(10)
+		m = testManager(recorder,
+			buildStatefulSet(planner, "storage", sharedStatePVCName(planner)))
+		ss = &appsv1.StatefulSet{}
+		Expect(m.client.Get(ctx, types.NamespacedName{
+			Namespace: "storage",
+			Name:      planner.InstanceName(),
+		}, ss)).To(Succeed())
+	})
+
+	ginkgo.It("restarts one gateway at a time", func() {
+		Expect(ss.Spec.PodManagementPolicy).To(
+			Equal(appsv1.OrderedReadyPodManagement))
+		Expect(ss.Spec.UpdateStrategy.Type).To(
+			Equal(appsv1.RollingUpdateStatefulSetStrategyType))
+		Expect(ss.Spec.MinReadySeconds).To(Equal(gatewayMinReadySeconds))
+	})
+
+	ginkgo.It("hashes the container config and the ceph config", func() {
+		cephConfig := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "ceph-config"},
+			Data:       map[string]string{"ceph.conf": "[global]"},
+		}
+		hash := configHash(planner.ConfigState, cephConfig)
+		Expect(configHash(planner.ConfigState, cephConfig)).To(Equal(hash))
+
+		cephConfig.Data["ceph.conf"] = "[global]\nmon_host = 10.0.0.1"
+		Expect(configHash(planner.ConfigState, cephConfig)).NotTo(Equal(hash))
+		hash = configHash(planner.ConfigState, cephConfig)
+
+		cephConfig.BinaryData = map[string][]byte{"keyring": []byte("key")}
+		Expect(configHash(planner.ConfigState, cephConfig)).NotTo(Equal(hash))
+		hash = configHash(planner.ConfigState, cephConfig)
+
+		planner.ConfigState.Globals[iscsicc.Globals].Options[iscsicc.Glob_Host] = "gw"
+		Expect(configHash(planner.ConfigState, cephConfig)).NotTo(Equal(hash))
+	})
+
+	ginkgo.It("leaves what the running gateways apply out of the hash", func() {
+		hash := configHash(planner.ConfigState, nil)
+		planner.ConfigState.Storage["rbd"]["d2"] = iscsicc.NewDiskInfo("1Gi", "")
+		planner.ConfigState.Hosts[testHost2] = iscsicc.NewHostInfo(
+			iscsicc.AuthNone, []string{"rbd/d2"}, nil)
+		Expect(configHash(planner.ConfigState, nil)).To(Equal(hash))
+	})
+
+	ginkgo.It("leaves the time a host removal was requested out of the hash", func() {
+		hash := configHash(planner.ConfigState, nil)
+		h := planner.ConfigState.Hosts[testHost1]
+		h.RemovalRequested = "2023-01-01T00:00:00Z"
+		planner.ConfigState.Hosts[testHost1] = h
+		Expect(configHash(planner.ConfigState, nil)).To(Equal(hash))
+	})
+
+	ginkgo.DescribeTable("applying what is left out of the hashes",
+		func(scale int) {
+			ig := testGateway()
+			ig.Spec.Scale = scale
+			planner := testPlanner(ig)
+			spec := buildStatefulSet(
+				planner, "storage", sharedStatePVCName(planner)).Spec.Template.Spec
+
+			var sidecar *corev1.Container
+			for i := range spec.Containers {
+				if spec.Containers[i].Name == "watch-update-config" {
+					sidecar = &spec.Containers[i]
+				}
+			}
+			Expect(sidecar).NotTo(BeNil())
+			Expect(sidecar.Args).To(Equal([]string{"update-config", "--watch"}))
+			// mounted whole, rather than through a subPath, the ConfigMap
+			// and the Secret are updated in the running pods
+			Expect(sidecar.VolumeMounts).To(ContainElements(
+				corev1.VolumeMount{
+					Name:      configMapName,
+					MountPath: planner.ConfigMountPath(),
+				},
+				corev1.VolumeMount{
+					Name:      authSecretVolName,
+					MountPath: planner.AuthMountPath(),
+					ReadOnly:  true,
+				},
+			))
+		},
+		ginkgo.Entry("by clustered gateways", 2),
+		ginkgo.Entry("by a standalone gateway", 1),
+	)
+
+	ginkgo.It("leaves an up to date stateful set alone", func() {
+		Expect(m.updateStatefulSet(ctx, ss, planner)).To(Equal(Done))
+		Expect(recorder.Events).NotTo(Receive())
+	})
+
+	ginkgo.It("ignores the fields defaulted by the API server", func() {
+		ss.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyAlways
+		ss.Spec.Template.Spec.DNSPolicy = corev1.DNSClusterFirst
+		ss.Spec.Template.Spec.SchedulerName = corev1.DefaultSchedulerName
+		desired := buildStatefulSet(planner, "storage", sharedStatePVCName(planner))
+		Expect(statefulSetChanged(ss, desired)).To(BeFalse())
+	})
+
+	ginkgo.It("rolls the gateways when the container config changes", func() {
+		planner.ConfigState.Globals[iscsicc.Globals].Options[iscsicc.Glob_Host] = "gw"
+		Expect(m.updateStatefulSet(ctx, ss, planner)).To(Equal(Requeue))
+		Expect(recorder.Events).To(Receive(ContainSubstring(ReasonRollingGateways)))
+
+		updated := &appsv1.StatefulSet{}
+		Expect(m.client.Get(ctx, types.NamespacedName{
+			Namespace: ss.Namespace,
+			Name:      ss.Name,
+		}, updated)).To(Succeed())
+		Expect(updated.Spec.Template.Annotations).To(HaveKeyWithValue(
+			configHashAnnotation,
+			configHash(planner.ConfigState, planner.CephConfig)))
+	})
+
+	ginkgo.It("recreates a stateful set without the service name", func() {
+		ss.Spec.ServiceName = ""
+		Expect(m.client.Update(ctx, ss)).To(Succeed())
+		Expect(m.updateStatefulSet(ctx, ss, planner)).To(Equal(Requeue))
+		Expect(recorder.Events).To(Receive(ContainSubstring(ReasonReplacingStatefulSet)))
+		err := m.client.Get(ctx, types.NamespacedName{
+			Namespace: ss.Namespace,
+			Name:      ss.Name,
+		}, &appsv1.StatefulSet{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+
+		created, isNew, err := m.getOrCreateStatefulSet(ctx, planner, "storage")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(isNew).To(BeTrue())
+		Expect(created.Spec.ServiceName).To(Equal(headlessServiceName(planner)))
+	})
+
+	ginkgo.It("waits for the last update to be observed", func() {
+		ss.Generation = 2
+		ss.Status.ObservedGeneration = 1
+		planner.ConfigState.Globals[iscsicc.Globals].Options[iscsicc.Glob_Host] = "gw"
+		Expect(m.updateStatefulSet(ctx, ss, planner)).To(
+			Equal(RequeueAfter(gatewayRolloutPollInterval)))
+		Expect(recorder.Events).NotTo(Receive())
+	})
+})
+
+var _ = ginkgo.Describe("Standalone gateway", func() {
+	containerNames := func(containers []corev1.Container) []string {
+		names := []string{}
+		for _, c := range containers {
+			names = append(names, c.Name)
+		}
+		return names
+	}
+
+	ginkgo.DescribeTable("building the stateful set",
+		func(scale int, replicas int32, initContainers []string) {
+			ig := testGateway()
+			ig.Spec.Scale = scale
+			planner := testPlanner(ig)
+			ss := buildStatefulSet(planner, "storage", sharedStatePVCName(planner))
+			Expect(*ss.Spec.Replicas).To(Equal(replicas))
+			Expect(containerNames(ss.Spec.Template.Spec.InitContainers)).To(
+				Equal(initContainers))
+		},
+		ginkgo.Entry("registers clustered gateways as nodes",
+			2, int32(2), []string{"init", "Iscsi-set-node"}),
+		ginkgo.Entry("runs a single gateway without peers",
+			1, int32(1), []string{"init"}),
+		ginkgo.Entry("runs a single gateway by default",
+			0, int32(1), []string{"init"}),
+	)
+
+	ginkgo.DescribeTable("reporting path redundancy",
+		func(scale int, status metav1.ConditionStatus, reason string) {
+			ig := testGateway()
+			ig.Spec.Scale = scale
+			st := &iscsigateway.IscsigatewayStatus{}
+			observePathRedundancy(testPlanner(ig), st)
+			cond := meta.FindStatusCondition(st.Conditions,
+				iscsigateway.ConditionPathRedundant)
+			Expect(cond).NotTo(BeNil())
+			Expect(cond.Status).To(Equal(status))
+			Expect(cond.Reason).To(Equal(reason))
+		},
+		ginkgo.Entry("with several gateways", 2,
+			metav1.ConditionTrue, reasonMultipleGateways),
+		ginkgo.Entry("with a single gateway", 1,
+			metav1.ConditionFalse, reasonSingleGateway),
+	)
+})
@@ -0,0 +1,431 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+
+	iscsigateway "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/metrics"
+	pln "github.com/Erichorng/iscsi-operator/internal/planner"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	rtclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// reasons used for the conditions of the iscsigateway status
+const (
+	reasonConfigSynced          = "ConfigSynced"
+	reasonConfigPending         = "ConfigPending"
+	reasonConfigPaused          = "ConfigPaused"
+	reasonConfigMissing         = "ConfigMapNotFound"
+	reasonConfigInvalid         = "ConfigInvalid"
+	reasonDaemonSetReady        = "DaemonSetReady"
+	reasonDaemonSetNotReady     = "DaemonSetNotReady"
+	reasonDaemonSetMissing      = "DaemonSetNotFound"
+	reasonReplicasReady         = "ReplicasReady"
+	reasonReplicasNotReady      = "ReplicasNotReady"
+	reasonRollingUpdate         = "RollingUpdate"
+	reasonScalingUp             = "ScalingUp"
+	reasonScalingDown           = "ScalingDown"
+	reasonSingleGateway         = "SingleGateway"
+	reasonMultipleGateways      = "MultipleGateways"
+	reasonStatefulSetMissing    = "StatefulSetNotFound"
+	reasonReconcileFailed       = "ReconcileFailed"
+	reasonInsufficientReplica   = "InsufficientReplicas"
+	reasonAsExpected            = "AsExpected"
+	reasonAllReady              = "AllComponentsReady"
+	reasonNotReady              = "ComponentsNotReady"
+	reasonNoDrift               = "NoDrift"
+	reasonDrifted               = "Drifted"
+	reasonGatewayApiUnreachable = "GatewayApiUnreachable"
+)
+
+// updateStatus gathers the observed state of the resources backing the
+// iscsigateway and writes it to the status subresource. The result of the
+// reconcile pass that preceded it is used to report failures.
+func (m *IscsiGatewayManager) updateStatus(
+	ctx context.Context,
+	instance *iscsigateway.Iscsigateway,
+	result Result) error {
+
+	status := instance.Status.DeepCopy()
+	status.ObservedGeneration = instance.Generation
+
+	gatewayInstance, err := m.getGatewayInstance(ctx, instance)
+	if err != nil && result.Err() == nil {
+		result = Result{err: err}
+	}
+	planner := pln.New(gatewayInstance, nil)
+
+	if err := m.observeConfig(ctx, planner, status); err != nil {
+		return err
+	}
+	if err := m.observeTcmuRunner(ctx, instance, status); err != nil {
+		return err
+	}
+	if err := m.observeGateways(ctx, planner, status); err != nil {
+		return err
+	}
+	metrics.SetReadyReplicas(
+		instance.Namespace, instance.Name, status.ReadyReplicas)
+	if err := m.updateInitiatorStatus(ctx, planner, status.TargetName); err != nil {
+		return err
+	}
+	observePathRedundancy(planner, status)
+	observeDegraded(instance, status, result)
+	observeReady(instance, status)
+
+	if equality.Semantic.DeepEqual(&instance.Status, status) {
+		return nil
+	}
+	instance.Status = *status
+	err = m.client.Status().Update(ctx, instance)
+	if err != nil {
+		m.logger.Error(
+			err,
+			"Failed to update IscsiGateway status",
+			"IscsiGateway.Namespace", instance.Namespace,
+			"IscsiGateway.Name", instance.Name,
+		)
+	}
+	return err
+}
+
+func (m *IscsiGatewayManager) observeConfig(
+	ctx context.Context,
+	planner *pln.Planner,
+	status *iscsigateway.IscsigatewayStatus) error {
+
+	ig := planner.Iscsigateway
+	cond := metav1.Condition{
+		Type:               iscsigateway.ConditionConfigReady,
+		ObservedGeneration: ig.Generation,
+	}
+	configMap := &corev1.ConfigMap{}
+	cmNsname := types.NamespacedName{
+		Namespace: ig.Namespace,
+		Name:      ig.Name,
+	}
+	err := m.client.Get(ctx, cmNsname, configMap)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err != nil {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = reasonConfigMissing
+		cond.Message = fmt.Sprintf("ConfigMap %s not found", cmNsname.Name)
+		meta.SetStatusCondition(&status.Conditions, cond)
+		return nil
+	}
+
+	cc, err := getContainerConfig(configMap)
+	if err != nil {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = reasonConfigInvalid
+		cond.Message = fmt.Sprintf("%s, fix or delete the ConfigMap", err)
+		meta.SetStatusCondition(&status.Conditions, cond)
+		return nil
+	}
+	// run the planner against the stored config to find out if the
+	// ConfigMap is in sync with the spec without touching the ConfigMap.
+	planner.ConfigState = cc
+	inv := planner.Inventory()
+	status.PendingHostRemovals = nil
+	for _, r := range planner.PendingHostRemovals() {
+		status.PendingHostRemovals = append(status.PendingHostRemovals,
+			iscsigateway.IscsiHostRemovalState{
+				HostName:    r.HostName,
+				Target:      r.Target,
+				RemoveAfter: metav1.NewTime(r.RemoveAfter),
+			})
+	}
+	changed, err := planner.Update()
+	pending := 0
+	if changed && planner.Paused() {
+		pending = len(planner.Changes())
+	}
+	recordInventory(planner.Iscsigateway, inv, pending)
+	switch {
+	case err != nil:
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = reasonConfigInvalid
+		cond.Message = err.Error()
+	case changed && planner.Paused():
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = reasonConfigPaused
+		cond.Message = fmt.Sprintf(
+			"Paused with %d changes pending", pending)
+	case changed:
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = reasonConfigPending
+		cond.Message = "Container config has not been updated to match the spec"
+	default:
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = reasonConfigSynced
+		cond.Message = "Container config matches the spec"
+	}
+	status.TargetName = cc.TargetName
+	status.Targets = nil
+	if targets := planner.TargetStates(); len(targets) > 0 {
+		status.Targets = targets
+	}
+	meta.SetStatusCondition(&status.Conditions, cond)
+	return nil
+}
+
+func (m *IscsiGatewayManager) observeTcmuRunner(
+	ctx context.Context,
+	ig *iscsigateway.Iscsigateway,
+	status *iscsigateway.IscsigatewayStatus) error {
+
+	cond := metav1.Condition{
+		Type:               iscsigateway.ConditionTcmuRunnerReady,
+		ObservedGeneration: ig.Generation,
+	}
+	ds, err := m.getExistingDaemonset(ctx, tcmuDaemonSet, ig)
+	if err != nil {
+		return err
+	}
+	switch {
+	case ds == nil:
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = reasonDaemonSetMissing
+		cond.Message = fmt.Sprintf("DaemonSet %s not found", tcmuDaemonSet)
+	case daemonSetReady(ds):
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = reasonDaemonSetReady
+		cond.Message = fmt.Sprintf("%d/%d tcmu-runner pods ready",
+			ds.Status.NumberReady, ds.Status.DesiredNumberScheduled)
+	default:
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = reasonDaemonSetNotReady
+		cond.Message = fmt.Sprintf("%d/%d tcmu-runner pods ready",
+			ds.Status.NumberReady, ds.Status.DesiredNumberScheduled)
+	}
+	meta.SetStatusCondition(&status.Conditions, cond)
+	return nil
+}
+
+func (m *IscsiGatewayManager) observeGateways(
+	ctx context.Context,
+	planner *pln.Planner,
+	status *iscsigateway.IscsigatewayStatus) error {
+
+	ig := planner.Iscsigateway
+	cond := metav1.Condition{
+		Type:               iscsigateway.ConditionGatewaysReady,
+		ObservedGeneration: ig.Generation,
+	}
+	status.Replicas = planner.Scale()
+
+	ss, err := m.getExistingStatefulSet(ctx, planner, ig.Namespace)
+	if err != nil {
+		return err
+	}
+	if ss == nil {
+		status.ReadyReplicas = 0
+		status.Gateways = nil
+		meta.RemoveStatusCondition(&status.Conditions, iscsigateway.ConditionScaling)
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = reasonStatefulSetMissing
+		cond.Message = fmt.Sprintf(
+			"StatefulSet %s not found", planner.InstanceName())
+		meta.SetStatusCondition(&status.Conditions, cond)
+		return nil
+	}
+	status.ReadyReplicas = ss.Status.ReadyReplicas
+
+	gateways, err := m.listGatewayStates(ctx, planner, ss)
+	if err != nil {
+		return err
+	}
+	status.Gateways = gateways
+
+	cond.Message = fmt.Sprintf("%d/%d gateways ready",
+		status.ReadyReplicas, status.Replicas)
+	if ss.Status.UpdateRevision != ss.Status.CurrentRevision {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = reasonRollingUpdate
+		cond.Message = fmt.Sprintf("%d/%d gateways updated, %d ready",
+			ss.Status.UpdatedReplicas, status.Replicas, status.ReadyReplicas)
+	} else if status.ReadyReplicas >= status.Replicas {
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = reasonReplicasReady
+	} else {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = reasonReplicasNotReady
+	}
+	meta.SetStatusCondition(&status.Conditions, cond)
+	observeScaling(planner, ss, status)
+	return nil
+}
+
+func observeScaling(
+	planner *pln.Planner,
+	ss *appsv1.StatefulSet,
+	status *iscsigateway.IscsigatewayStatus) {
+
+	cond := metav1.Condition{
+		Type:               iscsigateway.ConditionScaling,
+		ObservedGeneration: planner.Iscsigateway.Generation,
+	}
+	switch {
+	case *ss.Spec.Replicas > status.Replicas:
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = reasonScalingDown
+		cond.Message = fmt.Sprintf(
+			"Draining gateways %v before stopping them",
+			departingGateways(ss, planner))
+	case ss.Status.Replicas > status.Replicas:
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = reasonScalingDown
+		cond.Message = fmt.Sprintf("Stopping %d gateways",
+			ss.Status.Replicas-status.Replicas)
+	case ss.Status.Replicas < status.Replicas:
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = reasonScalingUp
+		cond.Message = fmt.Sprintf("Starting %d gateways",
+			status.Replicas-ss.Status.Replicas)
+	default:
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = reasonAsExpected
+		cond.Message = fmt.Sprintf("%d gateways", status.Replicas)
+	}
+	meta.SetStatusCondition(&status.Conditions, cond)
+}
+
+func (m *IscsiGatewayManager) listGatewayStates(
+	ctx context.Context,
+	planner *pln.Planner,
+	ss *appsv1.StatefulSet) ([]iscsigateway.IscsiGatewayState, error) {
+
+	pods := &corev1.PodList{}
+	err := m.client.List(ctx, pods,
+		rtclient.InNamespace(ss.Namespace),
+		rtclient.MatchingLabels(labelsForIscsiServer(planner.InstanceName())))
+	if err != nil {
+		m.logger.Error(
+			err,
+			"Failed to list gateway pods",
+			"StatefulSet.Namespace", ss.Namespace,
+			"StatefulSet.Name", ss.Name,
+		)
+		return nil, err
+	}
+	var gateways []iscsigateway.IscsiGatewayState
+	for _, pod := range pods.Items {
+		gateways = append(gateways, iscsigateway.IscsiGatewayState{
+			Name:     pod.Name,
+			NodeName: pod.Spec.NodeName,
+			PodIP:    pod.Status.PodIP,
+			Ready:    podReady(&pod),
+			Portal:   planner.Portals[pod.Name],
+		})
+	}
+	return gateways, nil
+}
+
+func observePathRedundancy(
+	planner *pln.Planner,
+	status *iscsigateway.IscsigatewayStatus) {
+
+	cond := metav1.Condition{
+		Type:               iscsigateway.ConditionPathRedundant,
+		ObservedGeneration: planner.Iscsigateway.Generation,
+	}
+	if planner.IsClustered() {
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = reasonMultipleGateways
+		cond.Message = fmt.Sprintf(
+			"%d gateways serve the target", planner.Scale())
+	} else {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = reasonSingleGateway
+		cond.Message = "A single gateway serves the target, " +
+			"initiators have no alternate path if it stops"
+	}
+	meta.SetStatusCondition(&status.Conditions, cond)
+}
+
+func observeDegraded(
+	ig *iscsigateway.Iscsigateway,
+	status *iscsigateway.IscsigatewayStatus,
+	result Result) {
+
+	cond := metav1.Condition{
+		Type:               iscsigateway.ConditionDegraded,
+		ObservedGeneration: ig.Generation,
+	}
+	switch {
+	case result.Err() != nil:
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = reasonReconcileFailed
+		cond.Message = result.Err().Error()
+	case configInvalid(status):
+		// the reconcile stops at the container config until it is fixed
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = reasonConfigInvalid
+		cond.Message = meta.FindStatusCondition(status.Conditions,
+			iscsigateway.ConditionConfigReady).Message
+	case status.Replicas > 0 && status.ReadyReplicas < status.Replicas:
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = reasonInsufficientReplica
+		cond.Message = fmt.Sprintf("Only %d/%d gateways ready",
+			status.ReadyReplicas, status.Replicas)
+	default:
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = reasonAsExpected
+		cond.Message = ""
+	}
+	meta.SetStatusCondition(&status.Conditions, cond)
+}
+
+func configInvalid(status *iscsigateway.IscsigatewayStatus) bool {
+	cond := meta.FindStatusCondition(status.Conditions,
+		iscsigateway.ConditionConfigReady)
+	return cond != nil && cond.Reason == reasonConfigInvalid
+}
+
+func observeReady(
+	ig *iscsigateway.Iscsigateway,
+	status *iscsigateway.IscsigatewayStatus) {
+
+	cond := metav1.Condition{
+		Type:               iscsigateway.ConditionReady,
+		ObservedGeneration: ig.Generation,
+		Status:             metav1.ConditionTrue,
+		Reason:             reasonAllReady,
+	}
+	for _, t := range []string{
+		iscsigateway.ConditionConfigReady,
+		iscsigateway.ConditionTcmuRunnerReady,
+		iscsigateway.ConditionGatewaysReady,
+	} {
+		if !meta.IsStatusConditionTrue(status.Conditions, t) {
+			cond.Status = metav1.ConditionFalse
+			cond.Reason = reasonNotReady
+			cond.Message = fmt.Sprintf("%s is not true", t)
+			break
+		}
+	}
+	meta.SetStatusCondition(&status.Conditions, cond)
+}
+
+func daemonSetReady(ds *appsv1.DaemonSet) bool {
+	return ds.Status.DesiredNumberScheduled > 0 &&
+		ds.Status.NumberReady == ds.Status.DesiredNumberScheduled
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
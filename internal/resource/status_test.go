@@ -0,0 +1,47 @@
+package resource
+
+import (
+	"fmt"
+
+	"github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	iscsigateway "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = ginkgo.Describe("Gateway status", func() {
+	ginkgo.DescribeTable("reporting degraded gateways",
+		func(replicas, ready int32, degraded metav1.ConditionStatus, reason string) {
+			status := &iscsigateway.IscsigatewayStatus{
+				Replicas:      replicas,
+				ReadyReplicas: ready,
+			}
+			observeDegraded(testGateway(), status, Done)
+			cond := meta.FindStatusCondition(
+				status.Conditions, iscsigateway.ConditionDegraded)
+			Expect(cond).NotTo(BeNil())
+			Expect(cond.Status).To(Equal(degraded))
+			Expect(cond.Reason).To(Equal(reason))
+		},
+		ginkgo.Entry("with every gateway ready",
+			int32(2), int32(2), metav1.ConditionFalse, reasonAsExpected),
+		ginkgo.Entry("with some gateways not ready",
+			int32(2), int32(1), metav1.ConditionTrue, reasonInsufficientReplica),
+		ginkgo.Entry("with no gateway ready",
+			int32(2), int32(0), metav1.ConditionTrue, reasonInsufficientReplica),
+		ginkgo.Entry("without gateways",
+			int32(0), int32(0), metav1.ConditionFalse, reasonAsExpected),
+	)
+
+	ginkgo.It("reports a failed reconcile first", func() {
+		status := &iscsigateway.IscsigatewayStatus{Replicas: 2}
+		observeDegraded(testGateway(), status,
+			Result{err: fmt.Errorf("boom")})
+		cond := meta.FindStatusCondition(
+			status.Conditions, iscsigateway.ConditionDegraded)
+		Expect(cond.Reason).To(Equal(reasonReconcileFailed))
+		Expect(cond.Message).To(Equal("boom"))
+	})
+})
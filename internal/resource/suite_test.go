@@ -0,0 +1,120 @@
+package resource
+
+import (
+	"context"
+	"testing"
+
+	// resource has its own Done, so ginkgo is not dot-imported
+	"github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	iscsigateway "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	"github.com/Erichorng/iscsi-operator/internal/conf"
+	"github.com/Erichorng/iscsi-operator/internal/iscsicc"
+	pln "github.com/Erichorng/iscsi-operator/internal/planner"
+	"github.com/Erichorng/iscsi-operator/internal/rbdapi"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	rtclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestResource(t *testing.T) {
+	RegisterFailHandler(ginkgo.Fail)
+
+	ginkgo.RunSpecs(t, "Resource Suite")
+}
+
+var testScheme = runtime.NewScheme()
+
+var _ = ginkgo.BeforeSuite(func() {
+	Expect(clientgoscheme.AddToScheme(testScheme)).To(Succeed())
+	Expect(iscsigateway.AddToScheme(testScheme)).To(Succeed())
+})
+
+const (
+	testHost1 = "iqn.2023-01.com.example:h1"
+	testHost2 = "iqn.2023-01.com.example:h2"
+)
+
+// testGateway returns a gateway exporting one disk to one host.
+func testGateway() *iscsigateway.Iscsigateway {
+	return &iscsigateway.Iscsigateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "gw", Namespace: "storage"},
+		Spec: iscsigateway.IscsigatewaySpec{
+			Scale:      2,
+			CephConfig: "ceph-config",
+			Storage: []iscsigateway.IscsiStorageSpec{{
+				PoolName: "rbd",
+				Disks: []iscsigateway.IscsiDiskSpec{
+					{DiskName: "d1", DiskSize: "1Gi"},
+				},
+			}},
+			Hosts: []iscsigateway.IscsiHostSpec{{
+				HostName: testHost1,
+				Luns: []iscsigateway.IscsiLunSpec{
+					{PoolName: "rbd", DiskName: "d1"},
+				},
+			}},
+		},
+	}
+}
+
+// testPlanner returns the planner of the gateway, with the default
+// operator config, and its container config planned.
+func testPlanner(ig *iscsigateway.Iscsigateway) *pln.Planner {
+	cfg := conf.DefaultOperatorConfig
+	planner := pln.New(pln.InstanceConfiguration{
+		Iscsigateway: ig,
+		GlobalConfig: &cfg,
+	}, iscsicc.New())
+	_, err := planner.Update()
+	Expect(err).NotTo(HaveOccurred())
+	return planner
+}
+
+// testManager returns a manager with the default operator config, working
+// on a fake client holding the objects.
+func testManager(
+	recorder record.EventRecorder,
+	objs ...rtclient.Object) *IscsiGatewayManager {
+
+	client := fake.NewClientBuilder().
+		WithScheme(testScheme).
+		WithObjects(objs...).
+		Build()
+	cfg := conf.DefaultOperatorConfig
+	m := NewIscsiGatewayManager(client, testScheme, logr.Discard(), recorder)
+	m.cfg = &cfg
+	return m
+}
+
+// readyPod returns a ready gateway pod of the planner.
+func readyPod(planner *pln.Planner, name string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: planner.Iscsigateway.Namespace,
+			Labels:    labelsForIscsiServer(planner.InstanceName()),
+		},
+		Status: corev1.PodStatus{
+			Conditions: []corev1.PodCondition{{
+				Type:   corev1.PodReady,
+				Status: corev1.ConditionTrue,
+			}},
+		},
+	}
+}
+
+// useGatewayApi makes the manager reach the API of every gateway through
+// the fake server.
+func useGatewayApi(m *IscsiGatewayManager, api *rbdapi.FakeServer) {
+	m.SetGatewayApiFunc(func(
+		context.Context, *pln.Planner, string) (*rbdapi.Client, error) {
+		return api.Client(), nil
+	})
+}
@@ -0,0 +1,415 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	iscsigateway "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	pln "github.com/Erichorng/iscsi-operator/internal/planner"
+	"github.com/Erichorng/iscsi-operator/internal/rbdapi"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	rtclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// drainStartedAnnotation records on the ConfigMap when the hosts were
+// removed from the container config during teardown.
+const drainStartedAnnotation = "iscsi.ruohwai/drain-started"
+
+// targetsRemovedAnnotation records on the ConfigMap when the targets were
+// removed from the gateway configuration stored in ceph, or left there,
+// during teardown.
+const targetsRemovedAnnotation = "iscsi.ruohwai/targets-removed"
+
+// teardownPollInterval is how often the progress of the teardown is
+// checked while waiting for a ready gateway and for the gateway pods to
+// terminate.
+const teardownPollInterval = 5 * time.Second
+
+// Finalize tears the gateway down before the finalizer is removed: the
+// initiators are drained, the targets and the disks with the Delete policy
+// removed from ceph, the gateways stopped, the reference on the
+// tcmu-runner daemon set released and the gateway dropped from the status
+// of the Iscsiinitiators. Each step is idempotent so that the teardown
+// resumes where it left off after a requeue or an operator restart. A
+// container config that can not be read does not hold the deletion back,
+// the targets are then left in ceph, as they are when no gateway becomes
+// ready within the teardown timeout.
+func (m *IscsiGatewayManager) Finalize(
+	ctx context.Context,
+	instance *iscsigateway.Iscsigateway) Result {
+
+	m.logger.Info(
+		"Tearing down IscsiGateway",
+		"IscsiGateway.Namespace", instance.Namespace,
+		"IscsiGateway.Name", instance.Name,
+	)
+
+	configMap, planner, err := m.getTeardownPlanner(ctx, instance)
+	if isInvalidConfig(err) {
+		m.recorder.Eventf(instance,
+			EventWarning,
+			ReasonTeardownFailed,
+			"%s, leaving the targets and disks in the gateway configuration", err)
+		configMap = nil
+		planner = pln.New(pln.InstanceConfiguration{
+			Iscsigateway: instance,
+			GlobalConfig: m.cfg,
+		}, nil)
+		err = nil
+	}
+	if err != nil {
+		return Result{err: err}
+	}
+	if configMap != nil {
+		if result := m.drainSessions(ctx, configMap, planner); result.Yield() {
+			return result
+		}
+		if result := m.removeTargets(ctx, configMap, planner); result.Yield() {
+			return result
+		}
+	}
+	if result := m.stopGateways(ctx, planner); result.Yield() {
+		return result
+	}
+	if result := m.releaseTcmuRunner(ctx, planner); result.Yield() {
+		return result
+	}
+	if result := m.releaseInitiators(ctx, instance); result.Yield() {
+		return result
+	}
+
+	m.logger.Info("Remove finalizer")
+	controllerutil.RemoveFinalizer(instance, gatewayfinalizer)
+	err = m.client.Update(ctx, instance)
+	if err != nil {
+		return Result{err: err}
+	}
+	return Done
+}
+
+// getTeardownPlanner returns the ConfigMap of the gateway and a planner
+// over its container config. The ConfigMap is nil if the gateway was never
+// configured, in which case there is nothing to remove from ceph.
+func (m *IscsiGatewayManager) getTeardownPlanner(
+	ctx context.Context,
+	ig *iscsigateway.Iscsigateway) (*corev1.ConfigMap, *pln.Planner, error) {
+
+	instanceConfig := pln.InstanceConfiguration{
+		Iscsigateway: ig,
+		GlobalConfig: m.cfg,
+	}
+	configMap := &corev1.ConfigMap{}
+	err := m.client.Get(ctx, types.NamespacedName{
+		Namespace: ig.Namespace,
+		Name:      ig.Name,
+	}, configMap)
+	if errors.IsNotFound(err) {
+		return nil, pln.New(instanceConfig, nil), nil
+	}
+	if err != nil {
+		m.logger.Error(err, "Failed to get ConfigMap")
+		return nil, nil, err
+	}
+	cc, err := getContainerConfig(configMap)
+	if err != nil {
+		m.logger.Error(err, "Unable to read iscsi container config")
+		return nil, nil, err
+	}
+	return configMap, pln.New(instanceConfig, cc), nil
+}
+
+// drainSessions removes every host from the container config and gives
+// the initiators the drain timeout to log out.
+func (m *IscsiGatewayManager) drainSessions(
+	ctx context.Context,
+	configMap *corev1.ConfigMap,
+	planner *pln.Planner) Result {
+
+	if planner.Drain() {
+		if err := setContainerConfig(configMap, planner.ConfigState); err != nil {
+			return Result{err: err}
+		}
+		err := m.markTeardownStep(ctx, configMap, drainStartedAnnotation)
+		if err != nil {
+			return Result{err: err}
+		}
+		m.recorder.Eventf(planner.Iscsigateway,
+			EventNormal,
+			ReasonDrainingSessions,
+			"Removed all hosts, waiting %s for initiators to log out",
+			planner.DrainTimeout())
+		return RequeueAfter(planner.DrainTimeout())
+	}
+
+	started, found := configMap.Annotations[drainStartedAnnotation]
+	if !found {
+		// no host was ever configured
+		return Done
+	}
+	startTime, err := time.Parse(time.RFC3339, started)
+	if err != nil {
+		m.logger.Error(err, "Invalid drain start time, not waiting",
+			"ConfigMap.Name", configMap.Name)
+		return Done
+	}
+	if remaining := time.Until(startTime.Add(planner.DrainTimeout())); remaining > 0 {
+		return RequeueAfter(remaining)
+	}
+	return Done
+}
+
+// stopGateways deletes the stateful set and waits for the gateway pods to
+// terminate so that nothing serves the target while it is removed.
+func (m *IscsiGatewayManager) stopGateways(
+	ctx context.Context,
+	planner *pln.Planner) Result {
+
+	ns := planner.Iscsigateway.Namespace
+	statefulset, err := m.getExistingStatefulSet(ctx, planner, ns)
+	if err != nil {
+		return Result{err: err}
+	}
+	if statefulset != nil {
+		if statefulset.GetDeletionTimestamp() == nil {
+			err = m.client.Delete(ctx, statefulset,
+				rtclient.PropagationPolicy(metav1.DeletePropagationForeground))
+			if err != nil && !errors.IsNotFound(err) {
+				m.logger.Error(
+					err,
+					"Failed to delete StatefulSet",
+					"StatefulSet.Namespace", statefulset.Namespace,
+					"StatefulSet.Name", statefulset.Name,
+				)
+				return Result{err: err}
+			}
+			m.recorder.Eventf(planner.Iscsigateway,
+				EventNormal,
+				ReasonStoppedGateways,
+				"Deleted stateful set %s", statefulset.Name)
+		}
+		return RequeueAfter(teardownPollInterval)
+	}
+
+	pods := &corev1.PodList{}
+	err = m.client.List(ctx, pods,
+		rtclient.InNamespace(ns),
+		rtclient.MatchingLabels(labelsForIscsiServer(planner.InstanceName())))
+	if err != nil {
+		m.logger.Error(err, "Failed to list gateway pods")
+		return Result{err: err}
+	}
+	if len(pods.Items) > 0 {
+		m.logger.Info("Waiting for gateway pods to terminate",
+			"Pods", len(pods.Items))
+		return RequeueAfter(teardownPollInterval)
+	}
+	return Done
+}
+
+// markTeardownStep records the completion of a teardown step on the
+// ConfigMap under the annotation key.
+func (m *IscsiGatewayManager) markTeardownStep(
+	ctx context.Context,
+	configMap *corev1.ConfigMap,
+	key string) error {
+
+	if configMap.Annotations == nil {
+		configMap.Annotations = map[string]string{}
+	}
+	configMap.Annotations[key] = time.Now().UTC().Format(time.RFC3339)
+	err := m.client.Update(ctx, configMap)
+	if err != nil {
+		m.logger.Error(
+			err,
+			"failed to update ConfigMap",
+			"ConfigMap.Namespace", configMap.Namespace,
+			"ConfigMap.Name", configMap.Name,
+		)
+	}
+	return err
+}
+
+// removeTargets deletes the targets, and the disks with the Delete
+// policy, from the gateway configuration stored in ceph through the API of
+// a ready gateway. The other disks are dropped from the configuration,
+// their images are kept. This runs before the gateways are stopped, as
+// the API is served by the gateways, and only once: the completion is
+// recorded on the ConfigMap. Without a ready gateway the removal is
+// retried until the teardown timeout passes or the
+// SkipTargetRemovalAnnotation is set, the targets are then left in the
+// configuration and a warning is reported.
+func (m *IscsiGatewayManager) removeTargets(
+	ctx context.Context,
+	configMap *corev1.ConfigMap,
+	planner *pln.Planner) Result {
+
+	if _, found := configMap.Annotations[targetsRemovedAnnotation]; found {
+		return Done
+	}
+	ig := planner.Iscsigateway
+	targets := planner.TargetNames()
+	gateways, err := m.readyGatewayPods(ctx, planner)
+	if err != nil {
+		return Result{err: err}
+	}
+	if len(gateways) == 0 {
+		var reason string
+		switch {
+		case planner.SkipTargetRemoval():
+			reason = "as requested by the " +
+				iscsigateway.SkipTargetRemovalAnnotation + " annotation"
+		case teardownTimedOut(planner):
+			reason = fmt.Sprintf("no gateway became ready within %s",
+				planner.TeardownTimeout())
+		default:
+			m.logger.Info("Waiting for a ready gateway to remove the targets")
+			return RequeueAfter(teardownPollInterval)
+		}
+		err := m.markTeardownStep(ctx, configMap, targetsRemovedAnnotation)
+		if err != nil {
+			return Result{err: err}
+		}
+		m.recorder.Eventf(ig,
+			EventWarning,
+			ReasonTeardownFailed,
+			"Leaving targets %s in the gateway configuration, %s",
+			strings.Join(targets, ", "), reason)
+		return Done
+	}
+	api, err := m.gatewayApi(ctx, planner, gateways[0])
+	if err != nil {
+		m.logger.Error(err, "Unable to reach gateway API", "Gateway", gateways[0])
+		return RequeueAfter(teardownPollInterval)
+	}
+
+	purge := planner.TeardownPurge()
+	m.recorder.Eventf(ig,
+		EventNormal,
+		ReasonTeardownStarted,
+		"Removing targets %s, purging disks %v",
+		strings.Join(targets, ", "), purge)
+	fail := func(err error) Result {
+		m.recorder.Eventf(ig,
+			EventWarning,
+			ReasonTeardownFailed,
+			"Failed to remove targets %s through gateway %s: %s",
+			strings.Join(targets, ", "), gateways[0], err)
+		return Result{err: err}
+	}
+	for _, target := range targets {
+		err := api.DeleteTarget(ctx, target)
+		if err != nil && !rbdapi.IsNotFound(err) {
+			return fail(err)
+		}
+	}
+	for _, key := range purge {
+		pool, image, _ := strings.Cut(key, "/")
+		err := api.PurgeDisk(ctx, pool, image)
+		if err != nil && !rbdapi.IsNotFound(err) {
+			return fail(err)
+		}
+	}
+	for _, key := range planner.TeardownRetain() {
+		pool, image, _ := strings.Cut(key, "/")
+		err := api.DeleteDisk(ctx, pool, image)
+		if err != nil && !rbdapi.IsNotFound(err) {
+			return fail(err)
+		}
+	}
+	if err := m.markTeardownStep(ctx, configMap, targetsRemovedAnnotation); err != nil {
+		return Result{err: err}
+	}
+	m.recorder.Eventf(ig,
+		EventNormal,
+		ReasonRemovedTargets,
+		"Removed targets %s", strings.Join(targets, ", "))
+	return Done
+}
+
+// teardownTimedOut returns true once the gateway has been deleted for
+// longer than the teardown timeout.
+func teardownTimedOut(planner *pln.Planner) bool {
+	deleted := planner.Iscsigateway.GetDeletionTimestamp()
+	if deleted == nil {
+		return false
+	}
+	return time.Since(deleted.Time) > planner.TeardownTimeout()
+}
+
+// releaseTcmuRunner drops the reference of the gateway on the tcmu-runner
+// daemon set, deleting it once no gateway references it anymore and
+// narrowing its placement to the remaining gateways otherwise.
+func (m *IscsiGatewayManager) releaseTcmuRunner(
+	ctx context.Context,
+	planner *pln.Planner) Result {
+
+	ig := planner.Iscsigateway
+	ds, err := m.getExistingDaemonset(ctx, tcmuDaemonSet, ig)
+	if err != nil {
+		return Result{err: err}
+	}
+	if ds == nil {
+		return Done
+	}
+
+	refs := []metav1.OwnerReference{}
+	for _, ref := range ds.GetOwnerReferences() {
+		if ref.UID != ig.UID {
+			refs = append(refs, ref)
+		}
+	}
+	if len(refs) == 0 {
+		err = m.client.Delete(ctx, ds,
+			rtclient.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !errors.IsNotFound(err) {
+			m.logger.Error(
+				err,
+				"Failed to delete DaemonSet",
+				"DaemonSet.Namespace", ds.Namespace,
+				"DaemonSet.Name", ds.Name,
+			)
+			return Result{err: err}
+		}
+		m.recorder.Eventf(ig,
+			EventNormal,
+			ReasonRemovedTcmuRunner,
+			"Deleted daemon set %s", ds.Name)
+		return Done
+	}
+	if len(refs) == len(ds.GetOwnerReferences()) {
+		return Done
+	}
+
+	gateways, err := m.listGateways(ctx, ig.Namespace)
+	if err != nil {
+		return Result{err: err}
+	}
+	source, err := m.tcmuRunnerSource(ctx, planner, ds, gateways)
+	if err != nil {
+		return Result{err: err}
+	}
+	ds.SetOwnerReferences(refs)
+	syncDaemonSet(ds, buildDaemonset(ctx, tcmuDaemonSet, source, gateways))
+	err = m.client.Update(ctx, ds)
+	if err != nil {
+		m.logger.Error(
+			err,
+			"Failed to update DaemonSet",
+			"DaemonSet.Namespace", ds.Namespace,
+			"DaemonSet.Name", ds.Name,
+		)
+		return Result{err: err}
+	}
+	m.recorder.Eventf(ig,
+		EventNormal,
+		ReasonReleasedTcmuRunner,
+		"Released daemon set %s, still used by %d gateways",
+		ds.Name, len(refs))
+	return Done
+}
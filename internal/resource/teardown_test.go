@@ -0,0 +1,190 @@
+package resource
+
+import (
+	"context"
+	"time"
+
+	"github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	iscsigateway "github.com/Erichorng/iscsi-operator/api/v1alpha1"
+	pln "github.com/Erichorng/iscsi-operator/internal/planner"
+	"github.com/Erichorng/iscsi-operator/internal/rbdapi"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	rtclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = ginkgo.Describe("Teardown", func() {
+	var (
+		ctx       context.Context
+		recorder  *record.FakeRecorder
+		api       *rbdapi.FakeServer
+		ig        *iscsigateway.Iscsigateway
+		planner   *pln.Planner
+		configMap *corev1.ConfigMap
+		m         *IscsiGatewayManager
+	)
+
+	ginkgo.BeforeEach(func() {
+		ctx = context.Background()
+		recorder = record.NewFakeRecorder(10)
+		api = rbdapi.NewFakeServer("admin", "secret")
+		ig = testGateway()
+		ig.Finalizers = []string{gatewayfinalizer}
+		ig.Spec.Storage[0].Disks = append(ig.Spec.Storage[0].Disks,
+			iscsigateway.IscsiDiskSpec{
+				DiskName:       "d2",
+				DiskSize:       "1Gi",
+				DeletionPolicy: iscsigateway.DiskDeletionDelete,
+			})
+		planner = testPlanner(ig)
+
+		client := api.Client()
+		for _, target := range planner.TargetNames() {
+			Expect(client.CreateTarget(ctx, target)).To(Succeed())
+		}
+		Expect(client.CreateDisk(ctx, "rbd", "d1", "1G", true)).To(Succeed())
+		Expect(client.CreateDisk(ctx, "rbd", "d2", "1G", true)).To(Succeed())
+	})
+
+	// setup starts the manager with the gateway, its ConfigMap holding the
+	// container config of the planner, and the objects.
+	setup := func(objs ...rtclient.Object) {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "gw", Namespace: "storage"},
+			Data:       map[string]string{},
+		}
+		Expect(setContainerConfig(configMap, planner.ConfigState)).To(Succeed())
+		m = testManager(recorder, append(objs, ig, configMap)...)
+		m.cfg.DrainTimeout = "0s"
+		useGatewayApi(m, api)
+		Expect(m.client.Get(ctx, rtclient.ObjectKeyFromObject(ig), ig)).To(Succeed())
+		Expect(m.client.Get(ctx, rtclient.ObjectKeyFromObject(configMap), configMap)).To(Succeed())
+	}
+
+	// targetsRemoved returns true if the ConfigMap records the removal of
+	// the targets.
+	targetsRemoved := func() bool {
+		stored := &corev1.ConfigMap{}
+		Expect(m.client.Get(ctx, rtclient.ObjectKeyFromObject(configMap), stored)).To(Succeed())
+		_, found := stored.Annotations[targetsRemovedAnnotation]
+		return found
+	}
+
+	ginkgo.Describe("removing the targets", func() {
+		ginkgo.It("deletes the targets and purges the disks with the Delete policy", func() {
+			setup(readyPod(planner, "gw-0"))
+			Expect(m.removeTargets(ctx, configMap, planner)).To(Equal(Done))
+			cfg := api.Config()
+			Expect(cfg.Targets).To(BeEmpty())
+			Expect(cfg.Disks).To(BeEmpty())
+			Expect(api.Requests()).To(ContainElements(
+				"DELETE /api/disk/rbd/d1", "DELETE /api/disk/rbd/d2"))
+			Expect(recorder.Events).To(Receive(ContainSubstring(ReasonTeardownStarted)))
+			Expect(recorder.Events).To(Receive(ContainSubstring(ReasonRemovedTargets)))
+			Expect(targetsRemoved()).To(BeTrue())
+		})
+
+		ginkgo.It("only removes the targets once", func() {
+			setup(readyPod(planner, "gw-0"))
+			Expect(m.removeTargets(ctx, configMap, planner)).To(Equal(Done))
+			requests := len(api.Requests())
+			Expect(m.removeTargets(ctx, configMap, planner)).To(Equal(Done))
+			Expect(api.Requests()).To(HaveLen(requests))
+		})
+
+		ginkgo.It("tolerates targets and disks already removed", func() {
+			api.SetConfig(rbdapi.NewConfig())
+			setup(readyPod(planner, "gw-0"))
+			Expect(m.removeTargets(ctx, configMap, planner)).To(Equal(Done))
+		})
+
+		ginkgo.It("reports a failure of the API", func() {
+			setup(readyPod(planner, "gw-0"))
+			api.FailNext("/api/disk/rbd/d2", 1)
+			result := m.removeTargets(ctx, configMap, planner)
+			Expect(result.Yield()).To(BeTrue())
+			Expect(result.Err()).To(HaveOccurred())
+			Expect(recorder.Events).To(Receive(ContainSubstring(ReasonTeardownStarted)))
+			Expect(recorder.Events).To(Receive(ContainSubstring(ReasonTeardownFailed)))
+			Expect(targetsRemoved()).To(BeFalse())
+		})
+
+		ginkgo.It("waits for a ready gateway", func() {
+			setup()
+			now := metav1.Now()
+			ig.DeletionTimestamp = &now
+			Expect(m.removeTargets(ctx, configMap, planner)).To(
+				Equal(RequeueAfter(teardownPollInterval)))
+			Expect(recorder.Events).NotTo(Receive())
+			Expect(targetsRemoved()).To(BeFalse())
+		})
+
+		ginkgo.DescribeTable("leaving the targets without a ready gateway",
+			func(prepare func()) {
+				setup()
+				prepare()
+				Expect(m.removeTargets(ctx, configMap, planner)).To(Equal(Done))
+				Expect(api.Config().Targets).NotTo(BeEmpty())
+				Expect(recorder.Events).To(Receive(ContainSubstring(ReasonTeardownFailed)))
+				Expect(targetsRemoved()).To(BeTrue())
+			},
+			ginkgo.Entry("once the teardown timed out", func() {
+				deleted := metav1.NewTime(time.Now().Add(-11 * time.Minute))
+				ig.DeletionTimestamp = &deleted
+			}),
+			ginkgo.Entry("when asked to", func() {
+				ig.Annotations = map[string]string{
+					iscsigateway.SkipTargetRemovalAnnotation: "true",
+				}
+			}),
+		)
+	})
+
+	ginkgo.It("drains, removes the targets and drops the finalizer", func() {
+		gateway := readyPod(planner, "gw-0")
+		setup(gateway)
+
+		// the hosts are removed first
+		Expect(m.Finalize(ctx, ig)).To(Equal(Requeue))
+		Expect(recorder.Events).To(Receive(ContainSubstring(ReasonDrainingSessions)))
+		Expect(api.Config().Targets).NotTo(BeEmpty())
+
+		// the targets are removed while the gateways run, then the
+		// gateways are waited for
+		Expect(m.Finalize(ctx, ig)).To(Equal(RequeueAfter(teardownPollInterval)))
+		Expect(api.Config().Targets).To(BeEmpty())
+		Expect(ig.Finalizers).To(ConsistOf(gatewayfinalizer))
+
+		Expect(recorder.Events).To(Receive(ContainSubstring(ReasonTeardownStarted)))
+		Expect(recorder.Events).To(Receive(ContainSubstring(ReasonRemovedTargets)))
+
+		// nothing is reported again once the gateways are gone
+		Expect(m.client.Delete(ctx, gateway)).To(Succeed())
+		Expect(m.Finalize(ctx, ig)).To(Equal(Done))
+		Expect(ig.Finalizers).To(BeEmpty())
+		Expect(recorder.Events).NotTo(Receive())
+	})
+
+	ginkgo.It("drops the finalizer of a gateway never configured", func() {
+		m = testManager(recorder, ig)
+		Expect(m.client.Get(ctx, rtclient.ObjectKeyFromObject(ig), ig)).To(Succeed())
+		Expect(m.Finalize(ctx, ig)).To(Equal(Done))
+		Expect(ig.Finalizers).To(BeEmpty())
+	})
+
+	ginkgo.It("does not hold the deletion back on an invalid config", func() {
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "gw", Namespace: "storage"},
+			Data:       map[string]string{ConfigJSONKey: "{"},
+		}
+		m = testManager(recorder, ig, configMap)
+		Expect(m.client.Get(ctx, rtclient.ObjectKeyFromObject(ig), ig)).To(Succeed())
+		Expect(m.Finalize(ctx, ig)).To(Equal(Done))
+		Expect(ig.Finalizers).To(BeEmpty())
+		Expect(recorder.Events).To(Receive(ContainSubstring(ReasonTeardownFailed)))
+		Expect(api.Config().Targets).NotTo(BeEmpty())
+	})
+})
@@ -2,6 +2,7 @@ package resource
 
 import (
 	"fmt"
+	"sort"
 
 	pln "github.com/Erichorng/iscsi-operator/internal/planner"
 	corev1 "k8s.io/api/core/v1"
@@ -49,7 +50,7 @@ func newVolKeeper() *volKeeper {
 func configVolumeAndMount(pl *pln.Planner) volMount {
 	var vmnt volMount
 	configMapSrc := &corev1.ConfigMapVolumeSource{}
-	configMapSrc.Name = pl.InstanceName() //the same as the containerconfig name
+	configMapSrc.Name = pl.ContainerConfigName()
 	vmnt.volume = corev1.Volume{
 		Name: configMapName,
 		VolumeSource: corev1.VolumeSource{
@@ -64,6 +65,25 @@ func configVolumeAndMount(pl *pln.Planner) volMount {
 	return vmnt
 }
 
+func authVolumeAndMount(pl *pln.Planner) volMount {
+	var vmnt volMount
+	secretSrc := &corev1.SecretVolumeSource{}
+	secretSrc.SecretName = authSecretName(pl)
+	vmnt.volume = corev1.Volume{
+		Name: authSecretVolName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: secretSrc,
+		},
+	}
+	vmnt.mount = corev1.VolumeMount{
+		MountPath: pl.AuthMountPath(),
+		Name:      authSecretVolName,
+		ReadOnly:  true,
+	}
+	vmnt.tag = volMountTag(0x0)
+	return vmnt
+}
+
 func iscsiStateVolumeAndMount(pl *pln.Planner) volMount {
 	var vmnt volMount
 	vmnt.volume = corev1.Volume{
@@ -82,14 +102,59 @@ func iscsiStateVolumeAndMount(pl *pln.Planner) volMount {
 	return vmnt
 }
 
+// cephVolumeAndMount projects the ceph.conf and keyring, of the ceph
+// ConfigMap or generated by the operator, along with the generated
+// iscsi-gateway.cfg and, in secure mode, the certificate of the API into
+// the ceph configuration directory.
 func cephVolumeAndMount(pl *pln.Planner) volMount {
 	var vmnt volMount
-	configMapSrc := &corev1.ConfigMapVolumeSource{}
-	configMapSrc.Name = pl.CephConfigName() // ceph config name
+	optional := true
+	var cephSrc corev1.VolumeProjection
+	if pl.CephGenerated() {
+		cephSrc.Secret = &corev1.SecretProjection{
+			LocalObjectReference: corev1.LocalObjectReference{
+				Name: pl.CephSecretName(),
+			},
+			Items: []corev1.KeyToPath{
+				{Key: pln.CephConfFile, Path: pln.CephConfFile},
+				{Key: pln.CephKeyringFile, Path: pln.CephKeyringFile},
+			},
+		}
+	} else {
+		configMapSrc := &corev1.ConfigMapProjection{}
+		configMapSrc.Name = pl.CephConfigName() // ceph config name
+		configMapSrc.Items = cephConfigItems(pl)
+		cephSrc.ConfigMap = configMapSrc
+	}
+	sources := []corev1.VolumeProjection{
+		cephSrc,
+		{Secret: &corev1.SecretProjection{
+			LocalObjectReference: corev1.LocalObjectReference{
+				Name: pl.GatewayConfigSecretName(),
+			},
+			Items: []corev1.KeyToPath{
+				{Key: pln.GatewayConfigFile, Path: pln.GatewayConfigFile},
+			},
+			// the teardown may run for a gateway that never got as far
+			// as creating the secret
+			Optional: &optional,
+		}},
+	}
+	if name := pl.ApiTLSSecretName(); name != "" {
+		sources = append(sources, corev1.VolumeProjection{
+			Secret: &corev1.SecretProjection{
+				LocalObjectReference: corev1.LocalObjectReference{Name: name},
+				Items: []corev1.KeyToPath{
+					{Key: corev1.TLSCertKey, Path: pln.GatewayCertFile},
+					{Key: corev1.TLSPrivateKeyKey, Path: pln.GatewayKeyFile},
+				},
+			},
+		})
+	}
 	vmnt.volume = corev1.Volume{
 		Name: cephVolName,
 		VolumeSource: corev1.VolumeSource{
-			ConfigMap: configMapSrc,
+			Projected: &corev1.ProjectedVolumeSource{Sources: sources},
 		},
 	}
 	vmnt.mount = corev1.VolumeMount{
@@ -100,6 +165,30 @@ func cephVolumeAndMount(pl *pln.Planner) volMount {
 	return vmnt
 }
 
+// cephConfigItems returns the keys of the ceph ConfigMap to project, all
+// of them but an iscsi-gateway.cfg, which is generated by the operator.
+// Every key is projected if the ConfigMap is not known.
+func cephConfigItems(pl *pln.Planner) []corev1.KeyToPath {
+	if pl.CephConfig == nil {
+		return nil
+	}
+	keys := []string{}
+	for k := range pl.CephConfig.Data {
+		keys = append(keys, k)
+	}
+	for k := range pl.CephConfig.BinaryData {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	items := []corev1.KeyToPath{}
+	for _, k := range keys {
+		if k != pln.GatewayConfigFile {
+			items = append(items, corev1.KeyToPath{Key: k, Path: k})
+		}
+	}
+	return items
+}
+
 func devVolumeAndMount(pl *pln.Planner) volMount {
 	var vmnt volMount
 	hostpathtype := corev1.HostPathDirectory